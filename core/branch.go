@@ -20,12 +20,10 @@ func NewBranch(name string, rootDir string) *Branch {
 }
 
 func (branch *Branch) Read() {
-	content, err := ioutil.ReadFile(branch.rootDir + "/.git/refs/heads/" + branch.name)
-	if err != nil {
-		return
+	// the branch may be loose or packed
+	if sha1, ok := ReadRef(branch.rootDir, "refs/heads/"+branch.name); ok {
+		branch.commitSHA1 = sha1
 	}
-
-	branch.commitSHA1 = string(content[:len(content)-1])
 }
 
 func (branch *Branch) Commit() string {
@@ -41,7 +39,7 @@ func (branch *Branch) Write() {
 		fmt.Printf("Error: branch can not be created without any commit")
 		return
 	}
-	err := ioutil.WriteFile(branch.rootDir+"/.git/refs/heads/"+branch.name, []byte(branch.commitSHA1+"\n"), 0644)
+	err := ioutil.WriteFile(GitDir(branch.rootDir)+"/refs/heads/"+branch.name, []byte(branch.commitSHA1+"\n"), 0644)
 	if err != nil {
 		log.Fatal(err)
 	}
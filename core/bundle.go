@@ -0,0 +1,567 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A bundle holds part of a repository in a single file, so that it can be
+// moved where there is no network: a header lists the commits a repository
+// needs to have for the bundle to be unbundled in it, its prerequisites,
+// and the refs it holds, and a pack of the objects follows. Version 3 adds
+// capabilities, e.g. "@object-format=sha1", before them.
+//
+//	# v2 git bundle
+//	-<sha1> <subject of the prerequisite commit>
+//	<sha1> <ref name>
+//	<empty line>
+//	<pack>
+
+var bundleSignatures = map[int]string{2: "# v2 git bundle\n", 3: "# v3 git bundle\n"}
+
+// BundlePrerequisite is a commit a bundle does not hold the history of.
+type BundlePrerequisite struct {
+	SHA1    string
+	Comment string
+}
+
+// Bundle is the header of a bundle file.
+type Bundle struct {
+	Path          string
+	Version       int
+	Capabilities  map[string]string
+	Prerequisites []*BundlePrerequisite
+	Refs          []*Ref
+	packOffset    int64
+}
+
+// IsBundle tells whether the file at path is a bundle.
+func IsBundle(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	signature := make([]byte, len(bundleSignatures[2]))
+	if _, err := io.ReadFull(file, signature); err != nil {
+		return false
+	}
+	for _, known := range bundleSignatures {
+		if string(signature) == known {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadBundle reads the header of the bundle at path.
+func ReadBundle(path string) (*Bundle, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	bundle := &Bundle{Path: path, Capabilities: make(map[string]string)}
+	not_a_bundle := errors.New("'" + path + "' does not look like a v2 or v3 bundle file")
+
+	signature, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, not_a_bundle
+	}
+	for version, known := range bundleSignatures {
+		if signature == known {
+			bundle.Version = version
+		}
+	}
+	if bundle.Version == 0 {
+		return nil, not_a_bundle
+	}
+	offset := int64(len(signature))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, errors.New(path + ": the bundle header is truncated")
+		}
+		offset += int64(len(line))
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			break
+		}
+		switch {
+		case line[0] == '@' && bundle.Version == 3:
+			name, value := line[1:], ""
+			if equal_index := strings.Index(name, "="); equal_index != -1 {
+				name, value = name[:equal_index], name[equal_index+1:]
+			}
+			bundle.Capabilities[name] = value
+		case line[0] == '-':
+			fields := strings.SplitN(line[1:], " ", 2)
+			if !isValidSHA1Name(fields[0]) {
+				return nil, errors.New(path + ": unrecognized header: " + line)
+			}
+			prerequisite := &BundlePrerequisite{SHA1: fields[0]}
+			if len(fields) == 2 {
+				prerequisite.Comment = fields[1]
+			}
+			bundle.Prerequisites = append(bundle.Prerequisites, prerequisite)
+		default:
+			fields := strings.SplitN(line, " ", 2)
+			if len(fields) != 2 || !isValidSHA1Name(fields[0]) {
+				return nil, errors.New(path + ": unrecognized header: " + line)
+			}
+			bundle.Refs = append(bundle.Refs, &Ref{fields[1], fields[0]})
+		}
+	}
+	if format, ok := bundle.Capabilities["object-format"]; ok && format != "sha1" {
+		return nil, errors.New(path + ": unsupported object format '" + format + "'")
+	}
+	for name := range bundle.Capabilities {
+		if name != "object-format" {
+			return nil, errors.New(path + ": unknown capability '" + name + "'")
+		}
+	}
+	bundle.packOffset = offset
+	return bundle, nil
+}
+
+// openPack opens the bundle file at the start of its pack.
+func (bundle *Bundle) openPack() (*os.File, error) {
+	file, err := os.Open(bundle.Path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(bundle.packOffset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// checkPack makes sure that the pack of the bundle is whole, by its
+// checksum.
+func (bundle *Bundle) checkPack() error {
+	pack, err := bundle.openPack()
+	if err != nil {
+		return err
+	}
+	defer pack.Close()
+	content, err := io.ReadAll(pack)
+	if err != nil {
+		return err
+	}
+	if len(content) < 12+20 || !bytes.HasPrefix(content, []byte("PACK")) {
+		return errors.New(bundle.Path + ": the pack of the bundle is truncated")
+	}
+	checksum := sha1.Sum(content[:len(content)-20])
+	if !bytes.Equal(checksum[:], content[len(content)-20:]) {
+		return errors.New(bundle.Path + ": the pack of the bundle has a bad checksum")
+	}
+	return nil
+}
+
+// missingPrerequisites lists the prerequisites of the bundle which the
+// repository at rootDir does not have.
+func (bundle *Bundle) missingPrerequisites(rootDir string) []*BundlePrerequisite {
+	missing := make([]*BundlePrerequisite, 0)
+	for _, prerequisite := range bundle.Prerequisites {
+		if _, err := PeelObject(rootDir, prerequisite.SHA1, "commit"); err != nil {
+			missing = append(missing, prerequisite)
+		}
+	}
+	return missing
+}
+
+// unbundle adds the objects of the bundle to the repository at rootDir,
+// which needs to have its prerequisites.
+func (bundle *Bundle) unbundle(rootDir string) error {
+	if missing := bundle.missingPrerequisites(rootDir); len(missing) != 0 {
+		message := "Repository lacks these prerequisite commits:"
+		for _, prerequisite := range missing {
+			message += "\n" + prerequisite.SHA1
+		}
+		return errors.New(message)
+	}
+	pack, err := bundle.openPack()
+	if err != nil {
+		return err
+	}
+	defer pack.Close()
+	return storePack(rootDir, pack)
+}
+
+// bundleRevisions reads the revisions a bundle is made of, as rev-list
+// takes them: "<rev>", "^<rev>" and "<rev>..<rev>", and "--all",
+// "--branches", "--tags" and "--remotes" for the refs of a kind. It returns
+// the refs to put in the bundle, and the objects whose history is in it and
+// those whose history is not.
+func bundleRevisions(rootDir string, args []string) ([]*Ref, []string, []string, error) {
+	refs := make([]*Ref, 0)
+	included := make([]string, 0)
+	excluded := make([]string, 0)
+	seen := make(map[string]bool)
+	add_ref := func(name string, sha1_name string) {
+		included = append(included, sha1_name)
+		if !seen[name] {
+			refs = append(refs, &Ref{name, sha1_name})
+		}
+		seen[name] = true
+	}
+	add_revision := func(revision string) error {
+		sha1_name, err := ResolveRevision(rootDir, revision)
+		if err != nil {
+			return err
+		}
+		if name := fullRefName(rootDir, revision); name != "" {
+			add_ref(name, sha1_name)
+		} else {
+			included = append(included, sha1_name)
+		}
+		return nil
+	}
+	for _, arg := range args {
+		prefix := map[string]string{"--all": "refs/", "--branches": "refs/heads/", "--tags": "refs/tags/", "--remotes": "refs/remotes/"}[arg]
+		switch {
+		case prefix != "":
+			for _, ref := range ListRefs(rootDir) {
+				if strings.HasPrefix(ref.Name, prefix) {
+					add_ref(ref.Name, ref.SHA1)
+				}
+			}
+			if head_sha1, ok := ReadRef(rootDir, "HEAD"); ok && arg == "--all" {
+				add_ref("HEAD", head_sha1)
+			}
+		case strings.HasPrefix(arg, "-"):
+			return nil, nil, nil, errors.New("unsupported option '" + arg + "'")
+		case strings.HasPrefix(arg, "^"):
+			sha1_name, err := ResolveRevision(rootDir, arg[1:])
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			excluded = append(excluded, sha1_name)
+		case strings.Contains(arg, ".."):
+			dots_index := strings.Index(arg, "..")
+			from, to := arg[:dots_index], arg[dots_index+2:]
+			if from == "" {
+				from = "HEAD"
+			}
+			if to == "" {
+				to = "HEAD"
+			}
+			sha1_name, err := ResolveRevision(rootDir, from)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			excluded = append(excluded, sha1_name)
+			if err := add_revision(to); err != nil {
+				return nil, nil, nil, err
+			}
+		default:
+			if err := add_revision(arg); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+	}
+	return refs, included, excluded, nil
+}
+
+// fullRefName returns the full name of the ref a revision names, e.g.
+// "refs/heads/master" for "master", or "" if it is not the name of a ref.
+func fullRefName(rootDir string, revision string) string {
+	if revision == "HEAD" {
+		return revision
+	}
+	for _, candidate := range []string{revision, "refs/" + revision, "refs/tags/" + revision, "refs/heads/" + revision, "refs/remotes/" + revision, "refs/remotes/" + revision + "/HEAD"} {
+		if !strings.HasPrefix(candidate, "refs/") {
+			continue
+		}
+		if _, ok := ReadRef(rootDir, candidate); ok {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// bundleBoundary returns the commits a bundle of the history of included
+// but not of excluded starts from: the parents of its commits which it
+// leaves out.
+func bundleBoundary(rootDir string, included []string, excluded []string) ([]string, error) {
+	left_out := make(map[string]bool)
+	pending := make([]string, 0)
+	for _, sha1_name := range excluded {
+		if commit_sha1, err := PeelObject(rootDir, sha1_name, "commit"); err == nil {
+			pending = append(pending, commit_sha1)
+		}
+	}
+	for len(pending) != 0 {
+		sha1_name := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if left_out[sha1_name] {
+			continue
+		}
+		left_out[sha1_name] = true
+		commit := NewCommitObject(rootDir)
+		if err := commit.Load(sha1_name); err != nil {
+			return nil, err
+		}
+		pending = append(pending, commit.historyParents()...)
+	}
+
+	boundary := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, sha1_name := range included {
+		if commit_sha1, err := PeelObject(rootDir, sha1_name, "commit"); err == nil {
+			pending = append(pending, commit_sha1)
+		}
+	}
+	for len(pending) != 0 {
+		sha1_name := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[sha1_name] {
+			continue
+		}
+		seen[sha1_name] = true
+		if left_out[sha1_name] {
+			boundary = append(boundary, sha1_name)
+			continue
+		}
+		commit := NewCommitObject(rootDir)
+		if err := commit.Load(sha1_name); err != nil {
+			return nil, err
+		}
+		pending = append(pending, commit.historyParents()...)
+	}
+	sort.Strings(boundary)
+	return boundary, nil
+}
+
+// WriteBundle writes a bundle of the given version of the refs of the
+// repository at rootDir, with the objects reachable from included but not
+// from excluded.
+func WriteBundle(writer io.Writer, rootDir string, version int, refs []*Ref, included []string, excluded []string) error {
+	if bundleSignatures[version] == "" {
+		return fmt.Errorf("unsupported bundle version %d", version)
+	}
+	boundary, err := bundleBoundary(rootDir, included, excluded)
+	if err != nil {
+		return err
+	}
+	objects, err := objectsToSend(rootDir, included, boundary, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	header := bundleSignatures[version]
+	if version == 3 {
+		header += "@object-format=sha1\n"
+	}
+	for _, sha1_name := range boundary {
+		commit := NewCommitObject(rootDir)
+		if err := commit.Load(sha1_name); err != nil {
+			return err
+		}
+		subject, _ := splitCommitMessage(commit.message)
+		header += "-" + sha1_name + " " + subject + "\n"
+	}
+	for _, ref := range refs {
+		header += ref.SHA1 + " " + ref.Name + "\n"
+	}
+	if _, err := io.WriteString(writer, header+"\n"); err != nil {
+		return err
+	}
+	return WritePack(writer, objects)
+}
+
+// openBundle reads the header of a bundle, exiting if it is not one.
+func (regit *ReGit) openBundle(file string) *Bundle {
+	bundle, err := ReadBundle(file)
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+	return bundle
+}
+
+// BundleCreate writes a bundle of the refs and history args name, as
+// rev-list takes them, to file, or to the standard output for "-".
+func (regit *ReGit) BundleCreate(file string, version int, args []string) {
+	refs, included, excluded, err := bundleRevisions(regit.RootDir, args)
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+	if len(refs) == 0 {
+		fmt.Println("Error: Refusing to create empty bundle.")
+		os.Exit(1)
+	}
+	out := os.Stdout
+	if file != "-" {
+		if out, err = os.Create(file); err != nil {
+			fmt.Println("Error: " + err.Error())
+			os.Exit(1)
+		}
+	}
+	writer := bufio.NewWriter(out)
+	err = WriteBundle(writer, regit.RootDir, version, refs, included, excluded)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if file != "-" {
+		if close_err := out.Close(); err == nil {
+			err = close_err
+		}
+	}
+	if err != nil {
+		if file != "-" {
+			os.Remove(file)
+		}
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+}
+
+// printBundleRefs prints the refs of a bundle, or those of names if any are
+// given, one "<sha1> <ref name>" per line.
+func printBundleRefs(bundle *Bundle, names []string) {
+	for _, ref := range bundle.Refs {
+		wanted := len(names) == 0
+		for _, name := range names {
+			wanted = wanted || ref.Name == name
+		}
+		if wanted {
+			fmt.Println(ref.SHA1 + " " + ref.Name)
+		}
+	}
+}
+
+// BundleVerify checks that a bundle is whole and that the repository has
+// its prerequisites, so that it can be unbundled, and describes it.
+func (regit *ReGit) BundleVerify(file string) {
+	bundle := regit.openBundle(file)
+	if err := bundle.checkPack(); err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+	if missing := bundle.missingPrerequisites(regit.RootDir); len(missing) != 0 {
+		fmt.Println("error: Repository lacks these prerequisite commits:")
+		for _, prerequisite := range missing {
+			fmt.Println("error: " + prerequisite.SHA1)
+		}
+		os.Exit(1)
+	}
+
+	fmt.Println(file + " is okay")
+	if len(bundle.Refs) == 1 {
+		fmt.Println("The bundle contains this ref:")
+	} else {
+		fmt.Printf("The bundle contains these %d refs:\n", len(bundle.Refs))
+	}
+	printBundleRefs(bundle, nil)
+	switch len(bundle.Prerequisites) {
+	case 0:
+		fmt.Println("The bundle records a complete history.")
+	case 1:
+		fmt.Println("The bundle requires this ref:")
+	default:
+		fmt.Printf("The bundle requires these %d refs:\n", len(bundle.Prerequisites))
+	}
+	for _, prerequisite := range bundle.Prerequisites {
+		fmt.Println(prerequisite.SHA1)
+	}
+	fmt.Println("The bundle uses this hash algorithm: sha1")
+}
+
+// BundleListHeads prints the refs of a bundle, or those of names.
+func (regit *ReGit) BundleListHeads(file string, names []string) {
+	printBundleRefs(regit.openBundle(file), names)
+}
+
+// BundleUnbundle adds the objects of a bundle to the repository, without
+// updating any ref, and prints its refs, or those of names, for them to be
+// updated.
+func (regit *ReGit) BundleUnbundle(file string, names []string) {
+	bundle := regit.openBundle(file)
+	if err := bundle.unbundle(regit.RootDir); err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+	printBundleRefs(bundle, names)
+}
+
+// bundlePath finds the bundle a URL names, given as a path, which is
+// relative to the working tree, or as a file:// URL.
+func (regit *ReGit) bundlePath(url string) (string, bool) {
+	path := strings.TrimPrefix(url, "file://")
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(regit.RootDir, path)
+	}
+	if info, err := os.Stat(path); err != nil || info.IsDir() || !IsBundle(path) {
+		return "", false
+	}
+	return filepath.Clean(path), true
+}
+
+// bundleTransport fetches from a bundle, as from a repository which has the
+// refs of the bundle. Everything it holds is unbundled at once.
+type bundleTransport struct {
+	bundle *Bundle
+}
+
+func newBundleTransport(path string) (*bundleTransport, error) {
+	bundle, err := ReadBundle(path)
+	if err != nil {
+		return nil, err
+	}
+	return &bundleTransport{bundle}, nil
+}
+
+// Refs shows the refs of the bundle. Without a HEAD, its master branch, or
+// else its first one, is taken as the current branch.
+func (transport *bundleTransport) Refs(for_push bool) (*RemoteRefs, error) {
+	if for_push {
+		return nil, errors.New("a bundle can not be pushed to")
+	}
+	remote_refs := &RemoteRefs{Peeled: make(map[string]string)}
+	for _, ref := range transport.bundle.Refs {
+		if ref.Name == "HEAD" {
+			remote_refs.HeadSHA1 = ref.SHA1
+		} else {
+			remote_refs.Refs = append(remote_refs.Refs, ref)
+		}
+	}
+	for _, ref := range remote_refs.Refs {
+		if !strings.HasPrefix(ref.Name, "refs/heads/") || remote_refs.HeadSHA1 != "" && ref.SHA1 != remote_refs.HeadSHA1 {
+			continue
+		}
+		if remote_refs.Head == "" || ref.Name == "refs/heads/master" {
+			remote_refs.Head = strings.TrimPrefix(ref.Name, "refs/heads/")
+		}
+	}
+	if remote_refs.HeadSHA1 == "" && remote_refs.Head != "" {
+		remote_refs.HeadSHA1, _ = remote_refs.Lookup("refs/heads/" + remote_refs.Head)
+	}
+	return remote_refs, nil
+}
+
+func (transport *bundleTransport) Fetch(rootDir string, wants []string, deepen *Deepen, filter *ObjectFilter) error {
+	if deepen != nil {
+		return errors.New("a bundle can not be fetched from with a shallow history")
+	}
+	checkFilterSupport(filter, false)
+	if len(missingObjects(rootDir, wants)) == 0 {
+		return nil
+	}
+	return transport.bundle.unbundle(rootDir)
+}
+
+func (transport *bundleTransport) Push(rootDir string, updates []*refUpdate) (map[string]string, error) {
+	return nil, errors.New("a bundle can not be pushed to")
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestBundleSource makes a repository whose master has two commits, and
+// returns them.
+func newTestBundleSource(t *testing.T) (string, string, string) {
+	isolateHome(t)
+	source := t.TempDir()
+	chdir(t, source)
+	newTestRepository(t, source)
+	first := commitFile(t, source, "a.txt", "a\n")
+	second := commitFile(t, source, "b.txt", "b\n")
+	return source, first, second
+}
+
+// writeTestBundle writes a bundle of the revisions args names to a file.
+func writeTestBundle(t *testing.T, rootDir string, version int, args []string) string {
+	refs, included, excluded, err := bundleRevisions(rootDir, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := new(bytes.Buffer)
+	if err := WriteBundle(content, rootDir, version, refs, included, excluded); err != nil {
+		t.Fatal(err)
+	}
+	path := t.TempDir() + "/test.bundle"
+	if err := ioutil.WriteFile(path, content.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBundle(t *testing.T) {
+	source, _, second := newTestBundleSource(t)
+	for _, version := range []int{2, 3} {
+		path := writeTestBundle(t, source, version, []string{"master"})
+		if !IsBundle(path) {
+			t.Fatalf("v%d: the file is not a bundle", version)
+		}
+		bundle, err := ReadBundle(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []*Ref{{"refs/heads/master", second}}; bundle.Version != version || !reflect.DeepEqual(bundle.Refs, want) || len(bundle.Prerequisites) != 0 {
+			t.Errorf("v%d: the bundle is %+v", version, bundle)
+		}
+		if err := bundle.checkPack(); err != nil {
+			t.Errorf("v%d: %v", version, err)
+		}
+		destination := newTestObjectStore(t)
+		if err := bundle.unbundle(destination); err != nil {
+			t.Fatal(err)
+		}
+		if report := NewFsck(destination).Run(false); !ObjectExists(destination, second) || report.HasErrors() {
+			t.Errorf("v%d: the unbundled objects are not whole: %v", version, report.Problems)
+		}
+	}
+
+	content, _ := ioutil.ReadFile(writeTestBundle(t, source, 2, []string{"master"}))
+	corrupt := t.TempDir() + "/corrupt.bundle"
+	content[len(content)-1] ^= 0xff
+	ioutil.WriteFile(corrupt, content, 0644)
+	if bundle, err := ReadBundle(corrupt); err != nil || bundle.checkPack() == nil {
+		t.Error("the corrupt bundle was taken as whole")
+	}
+	if IsBundle(source + "/a.txt") {
+		t.Error("a.txt is a bundle")
+	}
+}
+
+func TestBundlePrerequisites(t *testing.T) {
+	source, first, second := newTestBundleSource(t)
+	path := writeTestBundle(t, source, 2, []string{first + "..master"})
+	bundle, err := ReadBundle(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []*BundlePrerequisite{{first, "change a.txt"}}; !reflect.DeepEqual(bundle.Prerequisites, want) {
+		t.Errorf("the prerequisites are %+v", bundle.Prerequisites)
+	}
+
+	// the bundle can only be unbundled where the first commit is
+	destination := newTestObjectStore(t)
+	err = bundle.unbundle(destination)
+	if want := "Repository lacks these prerequisite commits:\n" + first; err == nil || err.Error() != want {
+		t.Errorf("unbundling without the prerequisite gave %v", err)
+	}
+	copyMissingObjects(source, destination, []string{first})
+	if err := bundle.unbundle(destination); err != nil {
+		t.Fatal(err)
+	}
+	if !ObjectExists(destination, second) {
+		t.Error("the second commit was not unbundled")
+	}
+
+	output := captureOutput(t, func() {
+		NewReGit(source).BundleVerify(path)
+	})
+	if !strings.Contains(output, path+" is okay\n") || !strings.Contains(output, "The bundle requires this ref:\n"+first+"\n") {
+		t.Errorf("verify printed %q", output)
+	}
+	output = captureOutput(t, func() {
+		NewReGit(source).BundleListHeads(path, nil)
+	})
+	if output != second+" refs/heads/master\n" {
+		t.Errorf("list-heads printed %q", output)
+	}
+}
+
+func TestCloneBundle(t *testing.T) {
+	source, _, second := newTestBundleSource(t)
+	path := writeTestBundle(t, source, 2, []string{"--all"})
+	parent := t.TempDir()
+	captureOutput(t, func() {
+		NewReGit(parent).Clone(path, "clone", false, "", nil, nil)
+	})
+	clone := parent + "/clone"
+	if sha1_name := testRef(t, clone, "refs/remotes/origin/master"); sha1_name != second {
+		t.Errorf("origin/master is %s, want %s", sha1_name, second)
+	}
+	if content, err := ioutil.ReadFile(clone + "/b.txt"); err != nil || string(content) != "b\n" {
+		t.Errorf("b.txt is %q, %v", content, err)
+	}
+}
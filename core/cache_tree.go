@@ -0,0 +1,163 @@
+package core
+
+import (
+	"bytes"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The cache tree extension ("TREE") records the tree objects the index would
+// produce, so that directories which did not change since the last commit do
+// not have to be rebuilt.
+//
+// Every node is stored as the following fields, followed by its subtrees:
+//   - NUL-terminated path component (empty for the root tree)
+//   - ASCII decimal number of index entries covered by the tree, -1 if the
+//     node is invalid
+//   - a space
+//   - ASCII decimal number of subtrees
+//   - a newline
+//   - 20-byte object name of the tree, only present for valid nodes
+type CacheTree struct {
+	Name           string
+	EntryCount     int
+	Subtrees       []*CacheTree
+	HashedFilename []byte
+}
+
+func NewCacheTree(name string) *CacheTree {
+	cache_tree := new(CacheTree)
+	cache_tree.Name = name
+	cache_tree.EntryCount = -1
+	cache_tree.Subtrees = make([]*CacheTree, 0)
+	return cache_tree
+}
+
+func ParseCacheTree(data []byte) *CacheTree {
+	cache_tree, rest := parse_cache_tree_node(data)
+	if len(rest) != 0 {
+		log.Fatal("Error: cache tree extension is broken")
+	}
+	return cache_tree
+}
+
+func parse_cache_tree_node(data []byte) (*CacheTree, []byte) {
+	name_end_index := bytes.IndexByte(data, byte(0))
+	if name_end_index == -1 {
+		log.Fatal("Error: cache tree extension is broken")
+	}
+	cache_tree := NewCacheTree(string(data[:name_end_index]))
+	data = data[name_end_index+1:]
+
+	line_end_index := bytes.IndexByte(data, '\n')
+	if line_end_index == -1 {
+		log.Fatal("Error: cache tree extension is broken")
+	}
+	counts := strings.Split(string(data[:line_end_index]), " ")
+	if len(counts) != 2 {
+		log.Fatal("Error: cache tree extension is broken")
+	}
+	entry_count, err := strconv.Atoi(counts[0])
+	if err != nil {
+		log.Fatal("Error: cache tree extension is broken")
+	}
+	subtree_count, err := strconv.Atoi(counts[1])
+	if err != nil {
+		log.Fatal("Error: cache tree extension is broken")
+	}
+	data = data[line_end_index+1:]
+
+	cache_tree.EntryCount = entry_count
+	if entry_count >= 0 {
+		if len(data) < 20 {
+			log.Fatal("Error: cache tree extension is broken")
+		}
+		cache_tree.HashedFilename = data[:20]
+		data = data[20:]
+	}
+
+	for i := 0; i < subtree_count; i++ {
+		var subtree *CacheTree
+		subtree, data = parse_cache_tree_node(data)
+		cache_tree.Subtrees = append(cache_tree.Subtrees, subtree)
+	}
+	return cache_tree, data
+}
+
+func (cache_tree *CacheTree) Serialize() []byte {
+	var buf bytes.Buffer
+	cache_tree.write(&buf)
+	return buf.Bytes()
+}
+
+func (cache_tree *CacheTree) write(buf *bytes.Buffer) {
+	buf.WriteString(cache_tree.Name + "\000")
+	buf.WriteString(strconv.Itoa(cache_tree.EntryCount) + " " + strconv.Itoa(len(cache_tree.Subtrees)) + "\n")
+	if cache_tree.IsValid() {
+		buf.Write(cache_tree.HashedFilename)
+	}
+	for _, subtree := range cache_tree.Subtrees {
+		subtree.write(buf)
+	}
+}
+
+func (cache_tree *CacheTree) IsValid() bool {
+	return cache_tree.EntryCount >= 0
+}
+
+func (cache_tree *CacheTree) Subtree(name string) *CacheTree {
+	for _, subtree := range cache_tree.Subtrees {
+		if subtree.Name == name {
+			return subtree
+		}
+	}
+	return nil
+}
+
+// Git keeps subtrees ordered by name length first, then by name.
+func (cache_tree *CacheTree) sortSubtrees() {
+	sort.Slice(cache_tree.Subtrees, func(i, j int) bool {
+		a := cache_tree.Subtrees[i].Name
+		b := cache_tree.Subtrees[j].Name
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+}
+
+// Invalidate marks the root tree and every directory leading to path as out of date.
+func (cache_tree *CacheTree) Invalidate(path string) {
+	cache_tree.EntryCount = -1
+	cache_tree.HashedFilename = nil
+
+	splitted_path := strings.Split(path, "/")
+	current_tree := cache_tree
+	for _, dir_name := range splitted_path[:len(splitted_path)-1] {
+		current_tree = current_tree.Subtree(dir_name)
+		if current_tree == nil {
+			return
+		}
+		current_tree.EntryCount = -1
+		current_tree.HashedFilename = nil
+	}
+}
+
+// ValidAncestor returns the outermost directory containing path whose cached
+// tree is still valid, along with the object name of that tree.
+func (cache_tree *CacheTree) ValidAncestor(path string) (string, []byte, bool) {
+	splitted_path := strings.Split(path, "/")
+	current_tree := cache_tree
+	for i, dir_name := range splitted_path[:len(splitted_path)-1] {
+		current_tree = current_tree.Subtree(dir_name)
+		if current_tree == nil {
+			return "", nil, false
+		}
+		if current_tree.IsValid() {
+			return strings.Join(splitted_path[:i+1], "/"), current_tree.HashedFilename, true
+		}
+	}
+	return "", nil, false
+}
@@ -0,0 +1,307 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// localRepository finds the repository a URL names, given as a path, which
+// is relative to the working tree, or as a file:// URL.
+func (regit *ReGit) localRepository(url string) (string, error) {
+	path := strings.TrimPrefix(url, "file://")
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(regit.RootDir, path)
+	}
+	path = filepath.Clean(path)
+	// "repo/.git" names the repository of the working tree "repo"
+	if filepath.Base(path) == ".git" && !IsBareRepository(path) {
+		path = filepath.Dir(path)
+	}
+	if _, err := os.Stat(GitDir(path) + "/HEAD"); err != nil {
+		return "", errors.New("repository '" + url + "' does not exist")
+	}
+	return path, nil
+}
+
+// the directory a clone is made in when none is given, named after the
+// repository like git's: "repo" for "/path/to/repo.git", "host:repo.git" or
+// "repo.bundle", "repo.git" if bare
+func cloneDirectory(source string, bare bool) string {
+	name := filepath.Base(source)
+	name = strings.TrimSuffix(name[strings.LastIndex(name, ":")+1:], ".git")
+	name = strings.TrimSuffix(name, ".bundle")
+	if bare {
+		return name + ".git"
+	}
+	return name
+}
+
+// copyObjects copies the loose objects and the packs of one repository into
+// another, hardlinking them when link is set and the file system allows it.
+func copyObjects(source string, destination string, link bool) error {
+	objects_dir := GitDir(source) + "/objects"
+	return filepath.Walk(objects_dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relative_path := filepath.ToSlash(path[len(objects_dir):])
+		if info.IsDir() {
+			if path != objects_dir && relative_path != "/pack" && len(info.Name()) != 2 {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(GitDir(destination)+"/objects"+relative_path, 0755)
+		}
+		target := GitDir(destination) + "/objects" + relative_path
+		if path == objects_dir+"/"+info.Name() {
+			// files directly under objects/ are not objects
+			return nil
+		}
+		if link && os.Link(path, target) == nil {
+			return nil
+		}
+		return copyFile(path, target)
+	})
+}
+
+func copyFile(source string, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0444)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// the branch HEAD of a repository points to, if any
+func headBranch(rootDir string) string {
+	content, err := ioutil.ReadFile(GitDir(rootDir) + "/HEAD")
+	if err != nil {
+		return ""
+	}
+	value := strings.TrimSpace(string(content))
+	if !strings.HasPrefix(value, "ref:") {
+		return ""
+	}
+	return strings.TrimPrefix(strings.TrimSpace(value[len("ref:"):]), "refs/heads/")
+}
+
+// Clone makes a copy of a repository in directory: the objects of a local
+// one are hardlinked, or copied for a file:// URL, and those of a remote one
+// fetched. Its branches become refs/remotes/origin/*, and the branch its
+// HEAD points to, or branch if given, is checked out. A bare clone has no
+// working tree and keeps the branches as they are. With deepen, the clone is
+// shallow and has only the branch checked out, along with the tags in its
+// history; it is ignored for paths, as by git, whose objects are linked.
+// With filter, the clone is partial: the objects it omits are left out, and
+// fetched from the repository once they are needed.
+func (regit *ReGit) Clone(repository string, directory string, bare bool, branch string, deepen *Deepen, filter *ObjectFilter) {
+	transport, err := regit.openTransport(repository)
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+	url := repository
+	local, is_local := transport.(*localTransport)
+	if is_local && !strings.HasPrefix(repository, "file://") {
+		url = local.path
+		if deepen != nil {
+			fmt.Println("warning: --depth is ignored in local clones; use file:// instead.")
+			deepen = nil
+		}
+		if filter != nil {
+			fmt.Println("warning: --filter is ignored in local clones; use file:// instead.")
+			filter = nil
+		}
+	}
+	if bundle, ok := transport.(*bundleTransport); ok {
+		url = bundle.bundle.Path
+	}
+	if directory == "" {
+		directory = cloneDirectory(strings.TrimSuffix(url, "/"), bare)
+	}
+	destination := directory
+	if !filepath.IsAbs(destination) {
+		destination = filepath.Join(regit.RootDir, destination)
+	}
+	if files, err := ioutil.ReadDir(destination); err == nil && len(files) != 0 {
+		fmt.Println("Error: destination path '" + directory + "' already exists and is not an empty directory.")
+		os.Exit(1)
+	}
+
+	remote_refs, err := transport.Refs(false)
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+	remote_head := remote_refs.Head
+	refs := remote_refs.Refs
+	values := make(map[string]string)
+	for _, ref := range refs {
+		values[ref.Name] = ref.SHA1
+	}
+	if branch == "" {
+		branch = remote_head
+	} else if values["refs/heads/"+branch] == "" && values["refs/tags/"+branch] == "" {
+		fmt.Println("Error: Remote branch " + branch + " not found in upstream origin")
+		os.Exit(1)
+	}
+
+	if bare {
+		fmt.Println("Cloning into bare repository '" + directory + "'...")
+	} else {
+		fmt.Println("Cloning into '" + directory + "'...")
+	}
+	git_dir := destination
+	if !bare {
+		git_dir += "/.git"
+	}
+	for _, dir := range []string{"/objects", "/refs/heads", "/refs/tags"} {
+		if err := os.MkdirAll(git_dir+dir, 0755); err != nil {
+			fmt.Println("Error: could not create " + git_dir + dir + ": " + err.Error())
+			os.Exit(1)
+		}
+	}
+	// with HEAD in place, a bare repository is recognized as one
+	head := "ref: refs/heads/" + branch + "\n"
+	if branch == "" {
+		// the HEAD of the repository is detached
+		head = remote_refs.HeadSHA1 + "\n"
+	}
+	if err := ioutil.WriteFile(git_dir+"/HEAD", []byte(head), 0644); err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+	if deepen != nil {
+		// only the branch to check out is cloned
+		single_branch := make([]*Ref, 0)
+		for _, ref := range refs {
+			if ref.Name == "refs/heads/"+branch || ref.Name == "refs/tags/"+branch && values["refs/heads/"+branch] == "" {
+				single_branch = append(single_branch, ref)
+			}
+		}
+		refs = single_branch
+	}
+	// the config comes first, so that the objects of a partial clone are
+	// kept as they are fetched from its promisor remote
+	format_version := "0"
+	if filter != nil {
+		// as git writes it for extensions.partialClone
+		format_version = "1"
+	}
+	config := "[core]\n\trepositoryformatversion = " + format_version + "\n\tfilemode = true\n"
+	if bare {
+		config += "\tbare = true\n"
+		config += "[remote \"origin\"]\n\turl = " + url + "\n"
+	} else {
+		config += "\tbare = false\n\tlogallrefupdates = true\n"
+		fetch := "+refs/heads/*:refs/remotes/origin/*"
+		if deepen != nil && values["refs/heads/"+branch] != "" {
+			fetch = "+refs/heads/" + branch + ":refs/remotes/origin/" + branch
+		}
+		config += "[remote \"origin\"]\n\turl = " + url + "\n\tfetch = " + fetch + "\n"
+	}
+	if filter != nil {
+		config += "\tpromisor = true\n\tpartialclonefilter = " + filter.Spec + "\n"
+	}
+	if !bare && values["refs/heads/"+branch] != "" {
+		config += "[branch \"" + branch + "\"]\n\tremote = origin\n\tmerge = refs/heads/" + branch + "\n"
+	}
+	if filter != nil {
+		config += "[extensions]\n\tpartialclone = origin\n"
+	}
+	if err := ioutil.WriteFile(git_dir+"/config", []byte(config), 0644); err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+
+	if is_local && deepen == nil && filter == nil {
+		err = copyObjects(local.path, destination, !strings.HasPrefix(url, "file://"))
+		if err == nil && IsShallowRepository(local.path) {
+			err = copyFile(GitDir(local.path)+"/shallow", git_dir+"/shallow")
+		}
+	} else if len(refs) != 0 || remote_refs.HeadSHA1 != "" {
+		wants := make([]string, 0, len(refs))
+		for _, ref := range refs {
+			wants = append(wants, ref.SHA1)
+		}
+		if remote_refs.HeadSHA1 != "" && (deepen == nil || branch == "") {
+			wants = append(wants, remote_refs.HeadSHA1)
+		}
+		err = transport.Fetch(destination, wants, deepen, filter)
+	}
+	if err != nil {
+		fmt.Println("Error: could not copy the objects: " + err.Error())
+		os.Exit(1)
+	}
+	if deepen != nil {
+		// with the tags which came along
+		for _, ref := range remote_refs.Refs {
+			if strings.HasPrefix(ref.Name, "refs/tags/") && ref.Name != "refs/tags/"+branch && ObjectExists(destination, ref.SHA1) {
+				refs = append(refs, ref)
+			}
+		}
+	}
+
+	for _, ref := range refs {
+		name := ref.Name
+		switch {
+		case strings.HasPrefix(name, "refs/tags/"):
+		case strings.HasPrefix(name, "refs/heads/") && !bare:
+			name = "refs/remotes/origin/" + strings.TrimPrefix(name, "refs/heads/")
+		case strings.HasPrefix(name, "refs/heads/"):
+		default:
+			continue
+		}
+		if err := WriteRef(destination, name, ref.SHA1); err != nil {
+			fmt.Println("Error: " + err.Error())
+			os.Exit(1)
+		}
+	}
+	if len(refs) == 0 {
+		fmt.Println("warning: You appear to have cloned an empty repository.")
+	}
+	if !bare && values["refs/heads/"+remote_head] != "" {
+		if err := ioutil.WriteFile(git_dir+"/refs/remotes/origin/HEAD", []byte("ref: refs/remotes/origin/"+remote_head+"\n"), 0644); err != nil {
+			fmt.Println("Error: " + err.Error())
+			os.Exit(1)
+		}
+	}
+
+	commit_sha1 := values["refs/heads/"+branch]
+	switch {
+	case commit_sha1 != "" && !bare:
+		if err := WriteRef(destination, "refs/heads/"+branch, commit_sha1); err != nil {
+			fmt.Println("Error: " + err.Error())
+			os.Exit(1)
+		}
+	case commit_sha1 == "" && values["refs/tags/"+branch] != "":
+		// a tag is checked out on a detached HEAD
+		sha1_name, err := PeelObject(destination, values["refs/tags/"+branch], "commit")
+		if err != nil {
+			fmt.Println("Error: " + err.Error())
+			os.Exit(1)
+		}
+		commit_sha1 = sha1_name
+		NewHEAD(destination).PointsTo(commit_sha1, false)
+	case branch == "":
+		commit_sha1, _ = ReadRef(destination, "HEAD")
+	}
+	if bare || commit_sha1 == "" {
+		return
+	}
+	clone := NewReGit(destination)
+	clone.resetToTree(clone.commitTree(commit_sha1))
+}
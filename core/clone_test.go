@@ -0,0 +1,106 @@
+package core
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCloneDirectory(t *testing.T) {
+	for _, test := range []struct {
+		source string
+		bare   bool
+		want   string
+	}{
+		{"/path/to/repo", false, "repo"},
+		{"/path/to/repo.git", false, "repo"},
+		{"/path/to/repo", true, "repo.git"},
+	} {
+		if directory := cloneDirectory(test.source, test.bare); directory != test.want {
+			t.Errorf("%s is cloned into %q, want %q", test.source, directory, test.want)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	isolateHome(t)
+	source := t.TempDir()
+	chdir(t, source)
+	newTestRepository(t, source)
+	first := commitFile(t, source, "a.txt", "a\n")
+	WriteRef(source, "refs/tags/v1.0", first)
+	second := commitFile(t, source, "b.txt", "b\n")
+
+	parent := t.TempDir()
+	regit := NewReGit(parent)
+	captureOutput(t, func() {
+		regit.Clone("file://"+source, "", false, "", nil, nil)
+	})
+	destination := filepath.Join(parent, filepath.Base(source))
+	if IsBareRepository(destination) {
+		t.Fatal("the clone is bare")
+	}
+	for name, want := range map[string]string{
+		"HEAD":                       second,
+		"refs/heads/master":          second,
+		"refs/remotes/origin/master": second,
+		"refs/tags/v1.0":             first,
+	} {
+		if sha1_name := testRef(t, destination, name); sha1_name != want {
+			t.Errorf("%s is %s in the clone, want %s", name, sha1_name, want)
+		}
+	}
+	if content, _ := ioutil.ReadFile(destination + "/b.txt"); string(content) != "b\n" {
+		t.Errorf("b.txt is %q in the clone", content)
+	}
+	config, _ := ioutil.ReadFile(destination + "/.git/config")
+	for _, line := range []string{"url = file://" + source, "merge = refs/heads/master"} {
+		if !strings.Contains(string(config), line) {
+			t.Errorf("the config of the clone has no %q:\n%s", line, config)
+		}
+	}
+
+	captureOutput(t, func() {
+		regit.Clone(source, "tagged", false, "v1.0", nil, nil)
+	})
+	if _, err := ioutil.ReadFile(parent + "/tagged/b.txt"); err == nil {
+		t.Error("the clone of v1.0 has b.txt")
+	}
+	if head, _ := ioutil.ReadFile(parent + "/tagged/.git/HEAD"); strings.TrimSpace(string(head)) != first {
+		t.Errorf("the HEAD of the clone of v1.0 is %q", head)
+	}
+
+	captureOutput(t, func() {
+		regit.Clone(source, "bare.git", true, "", nil, nil)
+	})
+	if !IsBareRepository(parent + "/bare.git") {
+		t.Fatal("the bare clone is not bare")
+	}
+	if sha1_name := testRef(t, parent+"/bare.git", "refs/heads/master"); sha1_name != second {
+		t.Errorf("master is %s in the bare clone, want %s", sha1_name, second)
+	}
+	if !ObjectExists(parent+"/bare.git", first) {
+		t.Error("the objects were not copied to the bare clone")
+	}
+}
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("hello, world\n")
+	// sizes 13 and 13, copy 7 bytes from 0, insert "there", copy 1 byte from 12
+	delta := []byte{13, 13, 0x90, 7, 5, 't', 'h', 'e', 'r', 'e', 0x91, 12, 1}
+	content, err := applyDelta(base, delta)
+	if err != nil || string(content) != "hello, there\n" {
+		t.Errorf("the delta gave %q, %v", content, err)
+	}
+	for _, broken := range [][]byte{
+		{12, 14, 0x90, 7},    // wrong base size
+		{13, 14, 0x90, 7},    // too short a result
+		{13, 2, 0x91, 12, 2}, // copies past the base
+		{13, 3, 5, 'a', 'b'}, // inserts past the delta
+	} {
+		if _, err := applyDelta(base, broken); err == nil {
+			t.Errorf("the broken delta %v was applied", broken)
+		}
+	}
+}
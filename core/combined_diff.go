@@ -0,0 +1,470 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+)
+
+// A combined diff is made the way git's combine-diff does: every line of
+// the merge result records the parents it is not in, and the lines lost
+// from the parents hang on the result line they were before. The lost lines
+// of every parent are merged with those of the parents before it, so that a
+// line lost from several parents is shown once.
+
+// a line of the merge result, or the end of it
+type combinedLine struct {
+	text        string
+	added       uint64 // the parents the line is not in, as a bit mask
+	lost        []*lostLine
+	parentLines []int // the line number of every parent this line is at
+	shown       bool  // in a hunk, as a change or as context
+	noPreDelete bool  // context before a hunk, whose lost lines are not shown
+}
+
+// a line lost from the parents in parentMask
+type lostLine struct {
+	text       string
+	parentMask uint64
+}
+
+// combineLines lines up the result of a merge with what every parent lost
+// or added. It returns a line for every line of the result, and two more
+// for the lines lost at the end and the line numbers past it.
+func combineLines(parent_lines [][]string, result_lines []string) []*combinedLine {
+	lines := make([]*combinedLine, len(result_lines)+2)
+	for i := range lines {
+		lines[i] = &combinedLine{parentLines: make([]int, len(parent_lines))}
+		if i < len(result_lines) {
+			lines[i].text = result_lines[i]
+		}
+	}
+
+	for n, old_lines := range parent_lines {
+		parent_mask := uint64(1) << uint(n)
+		// the lines lost from this parent, by the result line they were before
+		parent_lost := make(map[int][]*lostLine)
+		ops := diffLines(old_lines, result_lines)
+		for i := 0; i < len(ops); i++ {
+			if ops[i].kind == ' ' {
+				continue
+			}
+			// the lost lines of a change hang on its first result line
+			bucket := ops[i].newIndex
+			for ; i < len(ops) && ops[i].kind != ' '; i++ {
+				if ops[i].kind == '-' {
+					parent_lost[bucket] = append(parent_lost[bucket], &lostLine{old_lines[ops[i].oldIndex], parent_mask})
+				} else {
+					lines[ops[i].newIndex].added |= parent_mask
+				}
+			}
+		}
+
+		parent_line := 1
+		for i := 0; i <= len(result_lines); i++ {
+			line := lines[i]
+			line.parentLines[n] = parent_line
+			line.lost = coalesceLostLines(line.lost, parent_lost[i], parent_mask)
+			for _, lost := range line.lost {
+				if lost.parentMask&parent_mask != 0 {
+					parent_line++
+				}
+			}
+			if i < len(result_lines) && line.added&parent_mask == 0 {
+				parent_line++
+			}
+		}
+		lines[len(result_lines)+1].parentLines[n] = parent_line
+	}
+	return lines
+}
+
+// coalesceLostLines merges the lines a parent lost into those lost from the
+// parents before it, by their longest common subsequence: a line they share
+// is lost from this parent too, and the other lines of the parent go after
+// the last shared line before them.
+func coalesceLostLines(base []*lostLine, parent_lost []*lostLine, parent_mask uint64) []*lostLine {
+	if len(parent_lost) == 0 {
+		return base
+	}
+	if len(base) == 0 {
+		return parent_lost
+	}
+
+	const (
+		fromBase = iota
+		fromParent
+		fromBoth
+	)
+	lcs := make([][]int, len(base)+1)
+	directions := make([][]int, len(base)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(parent_lost)+1)
+		directions[i] = make([]int, len(parent_lost)+1)
+		directions[i][0] = fromBase
+	}
+	for j := 1; j <= len(parent_lost); j++ {
+		directions[0][j] = fromParent
+	}
+	for i := 1; i <= len(base); i++ {
+		for j := 1; j <= len(parent_lost); j++ {
+			switch {
+			case strings.TrimSuffix(base[i-1].text, "\n") == strings.TrimSuffix(parent_lost[j-1].text, "\n"):
+				lcs[i][j] = lcs[i-1][j-1] + 1
+				directions[i][j] = fromBoth
+			case lcs[i][j-1] >= lcs[i-1][j]:
+				lcs[i][j] = lcs[i][j-1]
+				directions[i][j] = fromParent
+			default:
+				lcs[i][j] = lcs[i-1][j]
+				directions[i][j] = fromBase
+			}
+		}
+	}
+
+	// walk back from the end, putting every line of the parent which is
+	// not shared right after the base line reached
+	coalesced := append([]*lostLine(nil), base...)
+	i, j := len(base), len(parent_lost)
+	for i != 0 || j != 0 {
+		switch directions[i][j] {
+		case fromBoth:
+			base[i-1].parentMask |= parent_mask
+			i--
+			j--
+		case fromParent:
+			coalesced = append(coalesced[:i], append([]*lostLine{parent_lost[j-1]}, coalesced[i:]...)...)
+			j--
+		default:
+			i--
+		}
+	}
+	return coalesced
+}
+
+// interesting tells whether a line is a change: it is not in some of the
+// parents, or lines of some of them were lost before it.
+func (line *combinedLine) interesting() bool {
+	return line.added != 0 || len(line.lost) != 0
+}
+
+// adjustHunkTail moves the end of a hunk, end being its first line which is
+// not a change, before its last line when the line only has lost lines:
+// that line is printed all the same and serves as context.
+func adjustHunkTail(lines []*combinedLine, start int, end int) int {
+	if start+1 <= end && lines[end-1].added == 0 {
+		end--
+	}
+	return end
+}
+
+// findShownLine returns the first line from i on which is shown, or not
+// shown when shown is false, or len(lines)-1 when there is none.
+func findShownLine(lines []*combinedLine, i int, shown bool) int {
+	for i < len(lines)-1 && lines[i].shown != shown {
+		i++
+	}
+	return i
+}
+
+// markCombinedHunks marks the lines shown by the dense combined diff of
+// --cc. Like git, a hunk is left out when the result only differs from the
+// parents in one way, and takes the content of one of them: all of its
+// changes are against the same parents, which are not all of them. It tells
+// whether anything is left to show.
+func markCombinedHunks(lines []*combinedLine, parent_count int) bool {
+	all_mask := uint64(1)<<uint(parent_count) - 1
+	// the last result line is followed by the one holding the lines lost
+	// at the end; the one past it only holds line numbers
+	count := len(lines) - 2
+	for _, line := range lines[:count+1] {
+		line.shown = line.interesting()
+	}
+
+	for i := 0; i <= count; {
+		for i <= count && !lines[i].shown {
+			i++
+		}
+		if i > count {
+			break
+		}
+		// changes separated by less than the context make one hunk
+		start := i
+		j := i + 1
+		for ; j <= count; j++ {
+			if lines[j].shown {
+				continue
+			}
+			lookahead := adjustHunkTail(lines, start, j) + diffContextLines
+			if lookahead > count+1 {
+				lookahead = count + 1
+			}
+			continues := false
+			for lookahead > 0 {
+				lookahead--
+				if lookahead < j {
+					break
+				}
+				if lines[lookahead].shown {
+					continues = true
+					break
+				}
+			}
+			if !continues {
+				break
+			}
+			j = lookahead
+		}
+		end := j
+
+		var same_mask uint64
+		interesting := false
+		for k := start; k < end && !interesting; k++ {
+			masks := make([]uint64, 0, len(lines[k].lost)+1)
+			if lines[k].added != 0 {
+				masks = append(masks, lines[k].added)
+			}
+			for _, lost := range lines[k].lost {
+				masks = append(masks, lost.parentMask)
+			}
+			for _, mask := range masks {
+				if same_mask == 0 {
+					same_mask = mask
+				} else if same_mask != mask {
+					interesting = true
+					break
+				}
+			}
+		}
+		if !interesting && same_mask != all_mask {
+			for k := start; k < end; k++ {
+				lines[k].shown = false
+			}
+		}
+		i = end
+	}
+
+	return addCombinedContext(lines)
+}
+
+// addCombinedContext marks the context lines around the shown changes,
+// joining the hunks which are close to each other. It tells whether any
+// line is shown.
+func addCombinedContext(lines []*combinedLine) bool {
+	count := len(lines) - 2
+	i := findShownLine(lines, 0, true)
+	if i > count {
+		return false
+	}
+	for i <= count {
+		j := i - diffContextLines
+		if j < 0 {
+			j = 0
+		}
+		for ; j < i; j++ {
+			if !lines[j].shown {
+				lines[j].noPreDelete = true
+			}
+			lines[j].shown = true
+		}
+
+		for {
+			// the changes and context up to i are shown; look for the end
+			// of the hunk, and the next change after it
+			j = findShownLine(lines, i, false)
+			if j > count {
+				return true
+			}
+			k := findShownLine(lines, j, true)
+			j = adjustHunkTail(lines, i, j)
+			if k < j+diffContextLines {
+				for ; j < k; j++ {
+					lines[j].shown = true
+				}
+				i = k
+				continue
+			}
+			i = k
+			k = j + diffContextLines
+			if k > count+1 {
+				k = count + 1
+			}
+			for ; j < k; j++ {
+				lines[j].shown = true
+			}
+			break
+		}
+	}
+	return true
+}
+
+// isFunctionNameLine tells whether a line may be shown as the function name
+// of a hunk: like git's default, a line which starts with a letter, '_' or
+// '$'.
+func isFunctionNameLine(line string) bool {
+	if line == "" {
+		return false
+	}
+	c := line[0]
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || c == '$'
+}
+
+// combinedFunctionName returns the part of a line which git shows after the
+// range of a combined hunk: the line up to its last non-blank character
+// among the first 40, which is left out.
+func combinedFunctionName(line string) string {
+	end := 0
+	for i := 0; i < 40 && i < len(line) && line[i] != '\n'; i++ {
+		if line[i] != ' ' && line[i] != '\t' && line[i] != '\r' {
+			end = i
+		}
+	}
+	return line[:end]
+}
+
+// writeCombinedHunks writes the hunks of the dense combined diff of a merge
+// result against the content of its parents.
+func writeCombinedHunks(builder *strings.Builder, parent_contents [][]byte, result_content []byte, colors *DiffColors) {
+	parent_lines := make([][]string, len(parent_contents))
+	for i, content := range parent_contents {
+		parent_lines[i] = splitLines(content)
+	}
+	lines := combineLines(parent_lines, splitLines(result_content))
+	parent_count := len(parent_contents)
+	if !markCombinedHunks(lines, parent_count) {
+		return
+	}
+
+	count := len(lines) - 2
+	at_signs := strings.Repeat("@", parent_count+1)
+	for i := 0; ; {
+		// the function name is the last such line since the previous hunk
+		function_name := ""
+		for i <= count && !lines[i].shown {
+			if isFunctionNameLine(lines[i].text) {
+				function_name = lines[i].text
+			}
+			i++
+		}
+		if i > count {
+			break
+		}
+		end := i + 1
+		for end <= count && lines[end].shown {
+			end++
+		}
+		result_count := end - i
+		if end > count {
+			// the line holding the lines lost at the end is not in the result
+			result_count--
+		}
+
+		header := at_signs
+		for n := 0; n < parent_count; n++ {
+			start := lines[i].parentLines[n]
+			header += " -" + strconv.Itoa(start) + "," + strconv.Itoa(lines[end].parentLines[n]-start)
+		}
+		header += " +" + strconv.Itoa(i+1) + "," + strconv.Itoa(result_count) + " " + at_signs
+		builder.WriteString(colors.Frag + header + colors.Reset)
+		if name := combinedFunctionName(function_name); name != "" {
+			builder.WriteString(" " + name)
+		}
+		builder.WriteString("\n")
+
+		for ; i < end; i++ {
+			line := lines[i]
+			if !line.noPreDelete {
+				for _, lost := range line.lost {
+					marks := make([]byte, parent_count)
+					for n := range marks {
+						marks[n] = ' '
+						if lost.parentMask&(1<<uint(n)) != 0 {
+							marks[n] = '-'
+						}
+					}
+					writeDiffLine(builder, colors.Old, colors.Reset, string(marks), lost.text)
+				}
+			}
+			if i == count {
+				break
+			}
+			marks := make([]byte, parent_count)
+			for n := range marks {
+				marks[n] = ' '
+				if line.added&(1<<uint(n)) != 0 {
+					marks[n] = '+'
+				}
+			}
+			color, reset := "", ""
+			if line.added != 0 {
+				color, reset = colors.New, colors.Reset
+			}
+			writeDiffLine(builder, color, reset, string(marks), line.text)
+		}
+		i = end
+	}
+}
+
+// WriteCombinedPatch writes the "diff --cc" patch of a file of a merge which
+// differs from all of the parents. parent_changes holds the change of the
+// file against every parent, in order.
+func (regit *ReGit) WriteCombinedPatch(builder *strings.Builder, parent_changes []*TreeChange, colors *DiffColors) {
+	meta := func(line string) {
+		builder.WriteString(colors.Meta + line + colors.Reset + "\n")
+	}
+	result := parent_changes[0]
+
+	parent_modes := make([]string, len(parent_changes))
+	parent_abbreviations := make([]string, len(parent_changes))
+	parent_contents := make([][]byte, len(parent_changes))
+	same_modes := true
+	for i, change := range parent_changes {
+		parent_modes[i] = formatMode(change.OldMode)
+		parent_abbreviations[i] = "0000000"
+		if change.OldSHA1 != "" {
+			parent_abbreviations[i] = abbreviate(change.OldSHA1)
+		}
+		parent_contents[i] = regit.diffContent(change.OldMode, change.OldSHA1)
+		if change.OldMode != result.NewMode {
+			same_modes = false
+		}
+	}
+	result_mode := formatMode(result.NewMode)
+	result_abbreviation := "0000000"
+	if result.NewSHA1 != "" {
+		result_abbreviation = abbreviate(result.NewSHA1)
+	}
+	result_content := regit.diffContent(result.NewMode, result.NewSHA1)
+
+	index_line := "index " + strings.Join(parent_abbreviations, ",") + ".." + result_abbreviation
+	binary := isBinary(result_content)
+	for _, content := range parent_contents {
+		binary = binary || isBinary(content)
+	}
+	if binary {
+		meta("diff --cc " + result.Path)
+		meta(index_line)
+		builder.WriteString("Binary files differ\n")
+		return
+	}
+
+	hunks_builder := new(strings.Builder)
+	writeCombinedHunks(hunks_builder, parent_contents, result_content, colors)
+	// files whose changes all come verbatim from one parent are left out
+	if hunks_builder.Len() == 0 && same_modes && result.NewSHA1 != "" {
+		return
+	}
+	old_path, new_path := "a/"+result.Path, "b/"+result.Path
+	if result.NewSHA1 == "" {
+		new_path = "/dev/null"
+	}
+	meta("diff --cc " + result.Path)
+	if result.NewSHA1 == "" {
+		meta("deleted file mode " + strings.Join(parent_modes, ","))
+	} else if !same_modes {
+		meta("mode " + strings.Join(parent_modes, ",") + ".." + result_mode)
+	}
+	meta(index_line)
+	meta("--- " + old_path)
+	meta("+++ " + new_path)
+	builder.WriteString(hunks_builder.String())
+}
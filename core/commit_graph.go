@@ -1,20 +1,20 @@
 package core
 
 import (
-	"bytes"
+	"container/heap"
 	"encoding/hex"
 	"fmt"
-	"log"
-	"os"
-	"os/exec"
+	"io"
+	"sort"
 	"strings"
+	"time"
 )
 
 type CommitGraph struct {
-	graph          *Graph
-	rootCommitName string
-	commits        map[string]*CommitObject
-	rootDir        string
+	graph           *Graph
+	rootCommitNames []string
+	commits         map[string]*CommitObject
+	rootDir         string
 }
 
 func NewCommitGraph(root_commit *CommitObject, rootDir string) *CommitGraph {
@@ -22,7 +22,7 @@ func NewCommitGraph(root_commit *CommitObject, rootDir string) *CommitGraph {
 	cg.graph = NewGraph()
 	root_commit_sha1 := hex.EncodeToString(root_commit.Obj.HashedFilename)
 	cg.graph.AddNode("commit", root_commit_sha1, root_commit.Obj.HashedFilename)
-	cg.rootCommitName = root_commit_sha1
+	cg.rootCommitNames = []string{root_commit_sha1}
 	cg.commits = make(map[string]*CommitObject)
 	cg.commits[root_commit_sha1] = root_commit
 	cg.rootDir = rootDir
@@ -30,18 +30,40 @@ func NewCommitGraph(root_commit *CommitObject, rootDir string) *CommitGraph {
 	return cg
 }
 
-func (cg *CommitGraph) LoadAllCommits() []*CommitObject {
-	root_commit_node, _ := cg.graph.LookUpNode(cg.rootCommitName)
+// AddRootCommit adds another commit to start walking the history from, e.g.
+// the tip of another branch.
+func (cg *CommitGraph) AddRootCommit(root_commit *CommitObject) {
+	root_commit_sha1 := hex.EncodeToString(root_commit.Obj.HashedFilename)
+	if _, ok := cg.commits[root_commit_sha1]; ok {
+		return
+	}
+	cg.graph.AddNode("commit", root_commit_sha1, root_commit.Obj.HashedFilename)
+	cg.rootCommitNames = append(cg.rootCommitNames, root_commit_sha1)
+	cg.commits[root_commit_sha1] = root_commit
+}
+
+func (cg *CommitGraph) rootCommitNodes() []*GraphNode {
+	nodes := make([]*GraphNode, 0, len(cg.rootCommitNames))
+	for _, name := range cg.rootCommitNames {
+		node, _ := cg.graph.LookUpNode(name)
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
 
+func (cg *CommitGraph) LoadAllCommits() []*CommitObject {
 	all_commits := make([]*CommitObject, 0)
-	cg.graph.BFS(root_commit_node, func(node *GraphNode) {
+	cg.graph.MultiSourceBFS(cg.rootCommitNodes(), func(node *GraphNode) {
 		current_commit := cg.commits[node.name]
 		all_commits = append(all_commits, current_commit)
 
-		for _, parent_sha1 := range current_commit.parents {
-			parent_commit := NewCommitObject(cg.rootDir)
-			parent_commit.ReadFromExistingObject(parent_sha1)
-			cg.commits[parent_sha1] = parent_commit
+		for _, parent_sha1 := range current_commit.historyParents() {
+			parent_commit, ok := cg.commits[parent_sha1]
+			if !ok {
+				parent_commit = NewCommitObject(cg.rootDir)
+				parent_commit.ReadFromExistingObject(parent_sha1)
+				cg.commits[parent_sha1] = parent_commit
+			}
 			cg.graph.AddNode("commit", parent_sha1, parent_commit.Obj.HashedFilename)
 			cg.graph.AddEdge(node.name, parent_sha1)
 		}
@@ -52,29 +74,406 @@ func (cg *CommitGraph) LoadAllCommits() []*CommitObject {
 	return all_commits
 }
 
-func (cg *CommitGraph) PrintCommitLogs() {
+// LoadCommitsUntil walks the history like LoadAllCommits, but does not go
+// past the commits for which stop returns true, which are left out.
+func (cg *CommitGraph) LoadCommitsUntil(stop func(sha1_name string) bool) []*CommitObject {
+	commits := make([]*CommitObject, 0)
+	cg.graph.MultiSourceBFS(cg.rootCommitNodes(), func(node *GraphNode) {
+		current_commit := cg.commits[node.name]
+		commits = append(commits, current_commit)
+
+		for _, parent_sha1 := range current_commit.historyParents() {
+			if stop(parent_sha1) {
+				continue
+			}
+			parent_commit, ok := cg.commits[parent_sha1]
+			if !ok {
+				parent_commit = NewCommitObject(cg.rootDir)
+				parent_commit.ReadFromExistingObject(parent_sha1)
+				cg.commits[parent_sha1] = parent_commit
+			}
+			cg.graph.AddNode("commit", parent_sha1, parent_commit.Obj.HashedFilename)
+			cg.graph.AddEdge(node.name, parent_sha1)
+		}
+	}, func(node *GraphNode) {
+		// nothing to do
+	})
+	return commits
+}
+
+// LoadCommits walks the history like LoadAllCommits. When paths are given,
+// only the commits which change one of them are returned, and a commit with
+// the same content as one of its parents for those paths is only followed
+// through that parent.
+func (cg *CommitGraph) LoadCommits(paths []string) []*CommitObject {
+	if len(paths) == 0 {
+		return cg.LoadAllCommits()
+	}
+	commits := make([]*CommitObject, 0)
+	cg.WalkCommits(paths, func(commit *CommitObject) bool {
+		commits = append(commits, commit)
+		return true
+	})
+	return commits
+}
+
+// WalkCommits calls visit with the commits LoadCommits returns, the most
+// recently committed first, until visit returns false. The history past that
+// point is not read.
+func (cg *CommitGraph) WalkCommits(paths []string, visit func(*CommitObject) bool) {
+	path_entries := make(map[string][]string)
+	entries_of := func(commit *CommitObject) []string {
+		sha1_name := hex.EncodeToString(commit.Obj.HashedFilename)
+		if entries, ok := path_entries[sha1_name]; ok {
+			return entries
+		}
+		// the object each path names in the tree of the commit, empty if it does not exist
+		entries := make([]string, len(paths))
+		for i, path := range paths {
+			entries[i], _ = resolveTreePath(cg.rootDir, commit.tree, strings.Trim(path, "/"), sha1_name)
+		}
+		path_entries[sha1_name] = entries
+		return entries
+	}
+	same_entries := func(a []string, b []string) bool {
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+	load_commit := func(sha1_name string) *CommitObject {
+		commit, ok := cg.commits[sha1_name]
+		if !ok {
+			commit = NewCommitObject(cg.rootDir)
+			commit.ReadFromExistingObject(sha1_name)
+			cg.commits[sha1_name] = commit
+		}
+		return commit
+	}
+
+	queued := make(map[string]bool)
+	date_queue := &commitDateQueue{}
+	for _, name := range cg.rootCommitNames {
+		queued[name] = true
+		heap.Push(date_queue, cg.commits[name])
+	}
+	for date_queue.Len() != 0 {
+		current_commit := heap.Pop(date_queue).(*CommitObject)
+		current_sha1 := hex.EncodeToString(current_commit.Obj.HashedFilename)
+
+		parents := current_commit.historyParents()
+		followed_parents := parents
+		is_shown := true
+		if len(paths) != 0 {
+			current_entries := entries_of(current_commit)
+			for _, parent_sha1 := range parents {
+				if same_entries(current_entries, entries_of(load_commit(parent_sha1))) {
+					followed_parents = []string{parent_sha1}
+					is_shown = false
+					break
+				}
+			}
+			if is_shown && len(parents) == 0 {
+				// a root commit is only shown if it adds one of the paths
+				is_shown = false
+				for _, entry := range current_entries {
+					if entry != "" {
+						is_shown = true
+					}
+				}
+			}
+		}
+		if is_shown && !visit(current_commit) {
+			return
+		}
+
+		for _, parent_sha1 := range followed_parents {
+			parent_commit := load_commit(parent_sha1)
+			cg.graph.AddNode("commit", parent_sha1, parent_commit.Obj.HashedFilename)
+			cg.graph.AddEdge(current_sha1, parent_sha1)
+			if !queued[parent_sha1] {
+				queued[parent_sha1] = true
+				heap.Push(date_queue, parent_commit)
+			}
+		}
+	}
+}
+
+func commitDate(commit *CommitObject) time.Time {
+	return ParseSignature(commit.committer).When
+}
+
+// SortCommits orders commits so that no parent is shown before all of its
+// children. With the "topo" order, commits of one line of history are kept
+// together; otherwise commits are ordered by their commit date as far as the
+// first rule allows. Only the edges of the loaded graph are considered.
+func (cg *CommitGraph) SortCommits(commits []*CommitObject, order string) []*CommitObject {
+	in_set := make(map[string]bool)
+	for _, commit := range commits {
+		in_set[hex.EncodeToString(commit.Obj.HashedFilename)] = true
+	}
+	rewritten_parents := cg.rewriteParents(in_set)
+
+	// the number of children of every commit which are not shown yet
+	children_count := make(map[string]int)
+	for _, parents := range rewritten_parents {
+		for _, parent := range parents {
+			children_count[parent]++
+		}
+	}
+
+	ready := make([]*CommitObject, 0)
+	for _, commit := range commits {
+		if children_count[hex.EncodeToString(commit.Obj.HashedFilename)] == 0 {
+			ready = append(ready, commit)
+		}
+	}
+	// tips are shown from the most recent one
+	sort.SliceStable(ready, func(i, j int) bool {
+		return commitDate(ready[i]).After(commitDate(ready[j]))
+	})
+	// ready works as a stack for the "topo" order, so the tips are reversed
+	if order == "topo" {
+		for i, j := 0, len(ready)-1; i < j; i, j = i+1, j-1 {
+			ready[i], ready[j] = ready[j], ready[i]
+		}
+	}
+
+	sorted_commits := make([]*CommitObject, 0, len(commits))
+	date_queue := &commitDateQueue{}
+	if order != "topo" {
+		for _, commit := range ready {
+			heap.Push(date_queue, commit)
+		}
+	}
+	for {
+		var commit *CommitObject
+		if order == "topo" {
+			if len(ready) == 0 {
+				break
+			}
+			commit = ready[len(ready)-1]
+			ready = ready[:len(ready)-1]
+		} else {
+			if date_queue.Len() == 0 {
+				break
+			}
+			commit = heap.Pop(date_queue).(*CommitObject)
+		}
+		sorted_commits = append(sorted_commits, commit)
+
+		for _, parent := range rewritten_parents[hex.EncodeToString(commit.Obj.HashedFilename)] {
+			children_count[parent]--
+			if children_count[parent] != 0 {
+				continue
+			}
+			if order == "topo" {
+				ready = append(ready, cg.commits[parent])
+			} else {
+				heap.Push(date_queue, cg.commits[parent])
+			}
+		}
+	}
+	return sorted_commits
+}
+
+// a priority queue of commits, the most recently committed first; commits
+// with the same date come out in the order they were pushed
+type commitDateQueue struct {
+	commits   []*CommitObject
+	sequences []int
+	pushed    int
+}
+
+func (queue *commitDateQueue) Len() int {
+	return len(queue.commits)
+}
+
+func (queue *commitDateQueue) Less(i, j int) bool {
+	date_i, date_j := commitDate(queue.commits[i]), commitDate(queue.commits[j])
+	if date_i.Equal(date_j) {
+		return queue.sequences[i] < queue.sequences[j]
+	}
+	return date_i.After(date_j)
+}
+
+func (queue *commitDateQueue) Swap(i, j int) {
+	queue.commits[i], queue.commits[j] = queue.commits[j], queue.commits[i]
+	queue.sequences[i], queue.sequences[j] = queue.sequences[j], queue.sequences[i]
+}
+
+func (queue *commitDateQueue) Push(element interface{}) {
+	queue.commits = append(queue.commits, element.(*CommitObject))
+	queue.sequences = append(queue.sequences, queue.pushed)
+	queue.pushed++
+}
+
+func (queue *commitDateQueue) Pop() interface{} {
+	last := len(queue.commits) - 1
+	commit := queue.commits[last]
+	queue.commits = queue.commits[:last]
+	queue.sequences = queue.sequences[:last]
+	return commit
+}
+
+// rewriteParents maps every commit in the set to its nearest ancestors in
+// the set, following the edges of the loaded graph through the commits
+// which are left out.
+func (cg *CommitGraph) rewriteParents(in_set map[string]bool) map[string][]string {
+	// the nearest ancestors in the set of commits left out
+	memo := make(map[string][]string)
+	var nearest_ancestors func(name string) []string
+	nearest_ancestors = func(name string) []string {
+		if ancestors, ok := memo[name]; ok {
+			return ancestors
+		}
+		memo[name] = nil // guards against cycles in a broken history
+		ancestors := make([]string, 0)
+		node, ok := cg.graph.LookUpNode(name)
+		if ok {
+			for list := node.list; list != nil; list = list.next {
+				if in_set[list.name] {
+					ancestors = appendUnique(ancestors, list.name)
+				} else {
+					for _, ancestor := range nearest_ancestors(list.name) {
+						ancestors = appendUnique(ancestors, ancestor)
+					}
+				}
+			}
+		}
+		memo[name] = ancestors
+		return ancestors
+	}
+
+	rewritten_parents := make(map[string][]string)
+	for name := range in_set {
+		// a commit in the set is its own nearest ancestor, so look at its edges
+		parents := make([]string, 0)
+		node, _ := cg.graph.LookUpNode(name)
+		for list := node.list; list != nil; list = list.next {
+			if in_set[list.name] {
+				parents = appendUnique(parents, list.name)
+			} else {
+				for _, ancestor := range nearest_ancestors(list.name) {
+					parents = appendUnique(parents, ancestor)
+				}
+			}
+		}
+		rewritten_parents[name] = parents
+	}
+	return rewritten_parents
+}
+
+func appendUnique(names []string, name string) []string {
+	for _, existing_name := range names {
+		if existing_name == name {
+			return names
+		}
+	}
+	return append(names, name)
+}
+
+// formatCommitLog formats a commit in the layout of options: on one line,
+// with a format, or by default with its header and the indented message.
+func formatCommitLog(commit *CommitObject, options *LogOptions) string {
+	if options.OneLine {
+		subject, _ := splitCommitMessage(commit.message)
+		return options.color("yellow") + abbreviate(hex.EncodeToString(commit.Obj.HashedFilename)) + options.color("reset") + " " + subject + "\n"
+	}
+	if options.Format != "" {
+		return FormatCommit(commit, options.Format, options) + "\n"
+	}
+
 	msg_content_builder := new(strings.Builder)
-	all_commits := cg.LoadAllCommits()
+	// print in yellow
+	msg_content_builder.WriteString(options.color("yellow") + "commit " + hex.EncodeToString(commit.Obj.HashedFilename) + options.color("reset") + "\n")
+	if parents := commit.historyParents(); len(parents) > 1 {
+		abbreviated_parents := make([]string, len(parents))
+		for i, parent := range parents {
+			abbreviated_parents[i] = abbreviate(parent)
+		}
+		msg_content_builder.WriteString("Merge: " + strings.Join(abbreviated_parents, " ") + "\n")
+	}
+	author := ParseSignature(commit.author)
+	msg_content_builder.WriteString(fmt.Sprintln("Author: " + author.Identity()))
+	msg_content_builder.WriteString(fmt.Sprintln("Date:   " + author.FormatDate(options.DateMode)))
+	msg_content_builder.WriteString("\n")
+	for _, line := range strings.Split(commit.message, "\n") {
+		msg_content_builder.WriteString(fmt.Sprintf("    %s\n", line))
+	}
+	msg_content_builder.WriteString("\n")
+	return msg_content_builder.String()
+}
+
+// writeLogGraph draws the graph of the history next to the shown commits.
+func (cg *CommitGraph) writeLogGraph(out io.Writer, all_commits []*CommitObject, shown_commits []*CommitObject, options *LogOptions) {
+	// the edges to the commits past the limit are drawn all the same
+	in_set := make(map[string]bool)
+	for _, commit := range all_commits {
+		if options.Matches(commit) {
+			in_set[hex.EncodeToString(commit.Obj.HashedFilename)] = true
+		}
+	}
+	rewritten_parents := cg.rewriteParents(in_set)
+	// the default format ends every commit with an empty line, which the
+	// graph draws between the commits instead
+	separate := !options.OneLine && options.Format == ""
+	log_graph := NewLogGraph(separate)
+	for _, commit := range shown_commits {
+		sha1_name := hex.EncodeToString(commit.Obj.HashedFilename)
+		text := strings.TrimSuffix(formatCommitLog(commit, options), "\n")
+		if separate {
+			text = strings.TrimSuffix(text, "\n")
+		}
+		for _, line := range log_graph.Render(sha1_name, rewritten_parents[sha1_name], strings.Split(text, "\n")) {
+			io.WriteString(out, line+"\n")
+		}
+	}
+}
+
+// PrintCommitLogs writes the history to out, usually a Pager. In the
+// default order the walk stops once options.MaxCount commits are shown; the
+// other orders need the whole history to be loaded first.
+func (cg *CommitGraph) PrintCommitLogs(out io.Writer, options *LogOptions) {
+	if options.Order == "" && !options.Graph {
+		shown_count := 0
+		cg.WalkCommits(options.Paths, func(commit *CommitObject) bool {
+			if options.MaxCount >= 0 && shown_count >= options.MaxCount {
+				return false
+			}
+			if options.Matches(commit) {
+				shown_count++
+				io.WriteString(out, formatCommitLog(commit, options))
+			}
+			return options.MaxCount < 0 || shown_count < options.MaxCount
+		})
+		return
+	}
 
+	order := options.Order
+	// like git, drawing the graph implies the topological order
+	if order == "" {
+		order = "topo"
+	}
+	all_commits := cg.SortCommits(cg.LoadCommits(options.Paths), order)
+
+	shown_commits := make([]*CommitObject, 0)
 	for _, commit := range all_commits {
-		// print in yellow
-		msg_content_builder.WriteString(fmt.Sprintf("\033[33mcommit %s\033[0m\n", hex.EncodeToString(commit.Obj.HashedFilename)))
-		msg_content_builder.WriteString(fmt.Sprintln("Author:  " + commit.author))
-		msg_content_builder.WriteString(fmt.Sprintln("Committer:  " + commit.committer))
-		msg_content_builder.WriteString("\n")
-		for _, line := range strings.Split(commit.message, "\n") {
-			msg_content_builder.WriteString(fmt.Sprintf("\t%s\n", line))
-		}
-		msg_content_builder.WriteString("\n")
-	}
-
-	// invoke the 'less' command to print the output
-	reader := bytes.NewReader([]byte(msg_content_builder.String()))
-	less_cmd := exec.Command("less")
-	less_cmd.Stdin = reader
-	less_cmd.Stdout = os.Stdout
-	err := less_cmd.Run()
-	if err != nil {
-		log.Fatal(err)
+		if options.MaxCount >= 0 && len(shown_commits) >= options.MaxCount {
+			break
+		}
+		if options.Matches(commit) {
+			shown_commits = append(shown_commits, commit)
+		}
+	}
+
+	if options.Graph {
+		cg.writeLogGraph(out, all_commits, shown_commits, options)
+		return
+	}
+	for _, commit := range shown_commits {
+		io.WriteString(out, formatCommitLog(commit, options))
 	}
 }
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestMerge makes the history
+//
+//	R - A1 - A2 - M
+//	  \          /
+//	   B1 ------
+//
+// committed in the order R, A1, B1, A2, M, and returns the repository and
+// the names of the commits by their message.
+func newTestMerge(t *testing.T) (string, map[string]string) {
+	rootDir := newTestObjectStore(t)
+	tree := writeTestObject(t, rootDir, "tree", "")
+	names := make(map[string]string)
+	commit := func(message string, date int, parents ...string) {
+		parent_sha1s := make([]string, 0)
+		for _, parent := range parents {
+			parent_sha1s = append(parent_sha1s, names[parent])
+		}
+		content := strings.Replace(testCommitContent(tree, parent_sha1s, message), "1700000000", strconv.Itoa(1700000000+date), -1)
+		names[message] = writeTestObject(t, rootDir, "commit", content)
+	}
+	commit("R", 1)
+	commit("A1", 2, "R")
+	commit("B1", 3, "R")
+	commit("A2", 4, "A1")
+	commit("M", 5, "A2", "B1")
+	return rootDir, names
+}
+
+func commitMessages(commits []*CommitObject) []string {
+	messages := make([]string, 0, len(commits))
+	for _, commit := range commits {
+		messages = append(messages, commit.message)
+	}
+	return messages
+}
+
+func TestWalkCommitsOrder(t *testing.T) {
+	rootDir, names := newTestMerge(t)
+	cg := NewCommitGraph(newTestCommit(t, rootDir, names["M"]), rootDir)
+	visited := make([]*CommitObject, 0)
+	cg.WalkCommits(nil, func(commit *CommitObject) bool {
+		visited = append(visited, commit)
+		return true
+	})
+	if messages := commitMessages(visited); !reflect.DeepEqual(messages, []string{"M", "A2", "B1", "A1", "R"}) {
+		t.Errorf("walked %q, want the most recent commits first", messages)
+	}
+}
+
+func TestSortCommits(t *testing.T) {
+	rootDir, names := newTestMerge(t)
+	cg := NewCommitGraph(newTestCommit(t, rootDir, names["M"]), rootDir)
+	commits := cg.LoadAllCommits()
+
+	if messages := commitMessages(cg.SortCommits(commits, "topo")); !reflect.DeepEqual(messages, []string{"M", "B1", "A2", "A1", "R"}) {
+		t.Errorf("the topo order is %q", messages)
+	}
+	if messages := commitMessages(cg.SortCommits(commits, "date")); !reflect.DeepEqual(messages, []string{"M", "A2", "B1", "A1", "R"}) {
+		t.Errorf("the date order is %q", messages)
+	}
+}
+
+func TestLogGraph(t *testing.T) {
+	log_graph := NewLogGraph(false)
+	lines := make([]string, 0)
+	for _, commit := range [][]string{{"M", "A", "B"}, {"B", "R"}, {"A", "R"}, {"R"}} {
+		lines = append(lines, log_graph.Render(commit[0], commit[1:], []string{commit[0]})...)
+	}
+	want := []string{
+		"*   M",
+		"|\\",
+		"| * B",
+		"* | A",
+		"|/",
+		"* R",
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("rendered\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestPrintCommitLogs(t *testing.T) {
+	rootDir, names := newTestMerge(t)
+	print_logs := func(options *LogOptions) string {
+		cg := NewCommitGraph(newTestCommit(t, rootDir, names["M"]), rootDir)
+		out := new(strings.Builder)
+		options.Format = "%s"
+		cg.PrintCommitLogs(out, options)
+		return out.String()
+	}
+
+	options := NewLogOptions()
+	options.MaxCount = 2
+	if output := print_logs(options); output != "M\nA2\n" {
+		t.Errorf("log -n 2 printed %q", output)
+	}
+	options = NewLogOptions()
+	options.MaxCount = 3
+	options.Order = "topo"
+	if output := print_logs(options); output != "M\nB1\nA2\n" {
+		t.Errorf("log --topo-order -n 3 printed %q", output)
+	}
+	options = NewLogOptions()
+	options.Graph = true
+	if output := print_logs(options); output != "*   M\n|\\\n| * B1\n* | A2\n* | A1\n|/\n* R\n" {
+		t.Errorf("log --graph printed %q", output)
+	}
+}
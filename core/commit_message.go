@@ -0,0 +1,173 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+type CommitOptions struct {
+	Message    string
+	HasMessage bool   // whether the message was given with -m or -F; otherwise an editor is launched
+	Amend      bool   // replace HEAD instead of adding a commit on top of it
+	AllowEmpty bool   // allow a commit with the same tree as its parent
+	Author     string // "name <email>" overriding the configured identity, empty for none
+	NoVerify   bool   // skip the pre-commit and commit-msg hooks
+}
+
+func NewCommitOptions() *CommitOptions {
+	return new(CommitOptions)
+}
+
+const commitMessageHelp = `
+# Please enter the commit message for your changes. Lines starting
+# with '#' will be ignored, and an empty message aborts the commit.
+`
+
+// CleanupMessage tidies a message the way git does before committing it:
+// trailing whitespace is removed from every line, runs of blank lines are
+// squeezed into one, and blank lines at the start and end are dropped. With
+// strip_comments set, lines starting with '#' are removed first.
+func CleanupMessage(message string, strip_comments bool) string {
+	lines := make([]string, 0)
+	blank_lines := 0
+	for _, line := range strings.Split(message, "\n") {
+		if strip_comments && strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimRight(line, " \t\r\v\f")
+		if line == "" {
+			blank_lines++
+			continue
+		}
+		if blank_lines != 0 && len(lines) != 0 {
+			lines = append(lines, "")
+		}
+		blank_lines = 0
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// editorCommand picks the editor like git does: GIT_EDITOR, then
+// core.editor, then VISUAL, then EDITOR, then vi.
+func (regit *ReGit) editorCommand() string {
+	if command := os.Getenv("GIT_EDITOR"); command != "" {
+		return command
+	}
+	if command := regit.Config["core.editor"]; command != "" {
+		return command
+	}
+	if command := os.Getenv("VISUAL"); command != "" {
+		return command
+	}
+	if command := os.Getenv("EDITOR"); command != "" {
+		return command
+	}
+	return "vi"
+}
+
+// sequenceEditorCommand picks the editor of the todo list of `rebase -i`:
+// GIT_SEQUENCE_EDITOR, then sequence.editor, then the usual editor.
+func (regit *ReGit) sequenceEditorCommand() string {
+	if command := os.Getenv("GIT_SEQUENCE_EDITOR"); command != "" {
+		return command
+	}
+	if command := regit.Config["sequence.editor"]; command != "" {
+		return command
+	}
+	return regit.editorCommand()
+}
+
+// LaunchEditor lets the user edit a file, and returns an error if the editor
+// fails. The editor ":" leaves the file as it is.
+func (regit *ReGit) LaunchEditor(path string) error {
+	return regit.launchEditor(regit.editorCommand(), path)
+}
+
+func (regit *ReGit) launchEditor(command string, path string) error {
+	if command == ":" {
+		return nil
+	}
+	// the command may have arguments of its own, e.g. "code --wait"
+	cmd := exec.Command("sh", "-c", command+` "$@"`, command, path)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return errors.New("there was a problem with the editor '" + command + "'")
+	}
+	return nil
+}
+
+// prepareCommitMessage writes the message to COMMIT_EDITMSG, where the
+// prepare-commit-msg hook, the user if no message was given, and the
+// commit-msg hook get to change it in turn, and returns the final message.
+// source and source_sha1 tell the prepare-commit-msg hook where the message
+// comes from, e.g. "commit" and the name of the amended commit.
+func (regit *ReGit) prepareCommitMessage(options *CommitOptions, initial_message string, source string, source_sha1 string) string {
+	path := GitDir(regit.RootDir) + "/COMMIT_EDITMSG"
+	use_editor := !options.HasMessage
+	content := initial_message
+	if options.HasMessage {
+		content = options.Message
+		source, source_sha1 = "message", ""
+	}
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	if use_editor {
+		content += commitMessageHelp
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		fmt.Println("Error: could not write '" + path + "'")
+		os.Exit(1)
+	}
+
+	hook_args := []string{path}
+	if source != "" {
+		hook_args = append(hook_args, source)
+		if source_sha1 != "" {
+			hook_args = append(hook_args, source_sha1)
+		}
+	}
+	if err := regit.runHook("prepare-commit-msg", regit.commitHookEnv(use_editor), hook_args...); err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+	if use_editor {
+		if err := regit.LaunchEditor(path); err != nil {
+			fmt.Println("Error: " + err.Error())
+			fmt.Println("Please supply the message using the -m option.")
+			os.Exit(1)
+		}
+	}
+	if !options.NoVerify {
+		if err := regit.runHook("commit-msg", regit.commitHookEnv(use_editor), path); err != nil {
+			fmt.Println("Error: " + err.Error())
+			os.Exit(1)
+		}
+	}
+
+	edited_content, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Println("Error: could not read '" + path + "'")
+		os.Exit(1)
+	}
+	// comments are only removed from messages the user edited, like git's
+	// default cleanup mode
+	return CleanupMessage(string(edited_content), use_editor)
+}
+
+// ParseAuthor checks an identity given as "name <email>".
+func ParseAuthor(author string) (string, string, error) {
+	email_start_index := strings.Index(author, "<")
+	email_end_index := strings.LastIndex(author, ">")
+	if email_start_index == -1 || email_end_index != len(author)-1 || email_end_index < email_start_index {
+		return "", "", errors.New("--author '" + author + "' is not 'Name <email>'")
+	}
+	return strings.TrimSpace(author[:email_start_index]), author[email_start_index+1 : email_end_index], nil
+}
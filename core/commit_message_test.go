@@ -0,0 +1,82 @@
+package core
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCleanupMessage(t *testing.T) {
+	message := "\n\nsubject  \n\n\n# a comment\nbody\t\n\n"
+	if cleaned := CleanupMessage(message, false); cleaned != "subject\n\n# a comment\nbody" {
+		t.Errorf("CleanupMessage kept comments as %q", cleaned)
+	}
+	if cleaned := CleanupMessage(message, true); cleaned != "subject\n\nbody" {
+		t.Errorf("CleanupMessage stripped comments into %q", cleaned)
+	}
+}
+
+func TestParseAuthor(t *testing.T) {
+	name, email, err := ParseAuthor("Jane Doe <jane@example.com>")
+	if err != nil || name != "Jane Doe" || email != "jane@example.com" {
+		t.Errorf("ParseAuthor = %q, %q, %v", name, email, err)
+	}
+	for _, author := range []string{"Jane Doe", "Jane <jane@example.com> x", "> Jane <"} {
+		if _, _, err := ParseAuthor(author); err == nil {
+			t.Errorf("%q was accepted", author)
+		}
+	}
+}
+
+func TestCommitAmend(t *testing.T) {
+	isolateHome(t)
+	rootDir := t.TempDir()
+	regit := newTestRepository(t, rootDir)
+
+	stageFile(t, rootDir, "a.txt", "a\n")
+	captureOutput(t, func() {
+		regit.Commmit(&CommitOptions{Message: "first\n", HasMessage: true, Author: "Jane Doe <jane@example.com>"})
+	})
+	first := testRef(t, rootDir, "HEAD")
+
+	stageFile(t, rootDir, "b.txt", "b\n")
+	output := captureOutput(t, func() {
+		regit.Commmit(&CommitOptions{Message: "first, amended", HasMessage: true, Amend: true})
+	})
+	amended := testRef(t, rootDir, "HEAD")
+	if amended == first {
+		t.Fatal("HEAD was not amended")
+	}
+	if output != "[commit ("+amended+") amended] first, amended\n" {
+		t.Errorf("commit --amend printed %q", output)
+	}
+	commit := newTestCommit(t, rootDir, amended)
+	if len(commit.parents) != 0 {
+		t.Errorf("the amended root commit has the parents %q", commit.parents)
+	}
+	if sig := ParseSignature(commit.author); sig.Identity() != "Jane Doe <jane@example.com>" {
+		t.Errorf("the amended commit is by %q, want the author of the original", sig.Identity())
+	}
+}
+
+func TestCommitWithEditor(t *testing.T) {
+	isolateHome(t)
+	rootDir := t.TempDir()
+	regit := newTestRepository(t, rootDir)
+	setEnv(t, "GIT_EDITOR", `printf 'from the editor\n# dropped\n' >`)
+
+	stageFile(t, rootDir, "a.txt", "a\n")
+	captureOutput(t, func() {
+		regit.Commmit(&CommitOptions{})
+	})
+	if commit := newTestCommit(t, rootDir, testRef(t, rootDir, "HEAD")); commit.message != "from the editor" {
+		t.Errorf("the message is %q", commit.message)
+	}
+
+	// an empty tree change is allowed with --allow-empty
+	captureOutput(t, func() {
+		regit.Commmit(&CommitOptions{Message: "empty", HasMessage: true, AllowEmpty: true})
+	})
+	if _, err := os.Stat(rootDir + "/.git/COMMIT_EDITMSG"); err != nil {
+		t.Errorf("COMMIT_EDITMSG is not written: %v", err)
+	}
+}
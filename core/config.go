@@ -0,0 +1,177 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ReadConfigFile parses a git config file into config, where every variable
+// is stored under "section.key" or "section.subsection.key". Section and key
+// names are case-insensitive and stored in lower case, subsection names keep
+// their case. A missing file is not an error.
+func ReadConfigFile(path string, config map[string]string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return ParseConfig(content, config)
+}
+
+func ParseConfig(content []byte, config map[string]string) error {
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	line_number := 0
+	for scanner.Scan() {
+		line_number++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			var ok bool
+			section, line, ok = parseConfigSectionHeader(line)
+			if !ok {
+				return errors.New("config: syntax error on line " + strconv.Itoa(line_number))
+			}
+			if line == "" {
+				continue
+			}
+		}
+
+		if section == "" {
+			return errors.New("config: variable outside of any section on line " + strconv.Itoa(line_number))
+		}
+
+		key_index := strings.Index(line, "=")
+		// a variable without a value is a boolean true
+		if key_index == -1 {
+			config[section+"."+strings.ToLower(line)] = "true"
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:key_index]))
+		config[section+"."+key] = parse_config_value(line[key_index+1:])
+	}
+	return scanner.Err()
+}
+
+// parseConfigSectionHeader parses a line starting with a section header, and
+// returns the name of the section, e.g. "remote.origin" for
+// `[remote "origin"]`, and the rest of the line.
+func parseConfigSectionHeader(line string) (string, string, bool) {
+	section_end_index := strings.Index(line, "]")
+	if section_end_index == -1 {
+		return "", "", false
+	}
+	header := strings.TrimSpace(line[1:section_end_index])
+	section := strings.ToLower(header)
+	// [section "subsection"]
+	if quote_index := strings.Index(header, "\""); quote_index != -1 {
+		subsection := strings.TrimSuffix(header[quote_index+1:], "\"")
+		section = strings.ToLower(strings.TrimSpace(header[:quote_index])) + "." + subsection
+	}
+	return section, strings.TrimSpace(line[section_end_index+1:]), true
+}
+
+// AddConfigSection appends a section, named like "remote.origin", with its
+// variables to the config file at path.
+func AddConfigSection(path string, name string, variables [][2]string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	header := "[" + name + "]"
+	if dot_index := strings.Index(name, "."); dot_index != -1 {
+		header = "[" + name[:dot_index] + " \"" + name[dot_index+1:] + "\"]"
+	}
+	if len(content) != 0 && content[len(content)-1] != '\n' {
+		content = append(content, '\n')
+	}
+	content = append(content, header+"\n"...)
+	for _, variable := range variables {
+		content = append(content, "\t"+variable[0]+" = "+variable[1]+"\n"...)
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+// RemoveConfigSection deletes every section named like "remote.origin", with
+// their variables, from the config file at path.
+func RemoveConfigSection(path string, name string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	kept := make([]string, 0, len(lines))
+	in_section := false
+	for _, line := range lines {
+		trimmed_line := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed_line, "[") {
+			section, _, ok := parseConfigSectionHeader(trimmed_line)
+			in_section = ok && section == name
+		}
+		if !in_section {
+			kept = append(kept, line)
+		}
+	}
+	return ioutil.WriteFile(path, []byte(strings.Join(kept, "")), 0644)
+}
+
+func parse_config_value(value string) string {
+	// drop trailing comments which are not inside quotes
+	in_quotes := false
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '\\':
+			i++
+		case c == '"':
+			in_quotes = !in_quotes
+		case !in_quotes && (c == '#' || c == ';'):
+			value = value[:i]
+		}
+	}
+	value = strings.TrimSpace(value)
+
+	// quotes are dropped and escapes decoded in a single pass, so that the
+	// result of one escape is never read as part of another
+	var decoded strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c == '"' {
+			continue
+		}
+		if c != '\\' || i+1 == len(value) {
+			decoded.WriteByte(c)
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n':
+			decoded.WriteByte('\n')
+		case 't':
+			decoded.WriteByte('\t')
+		case 'b':
+			decoded.WriteByte('\b')
+		case '"', '\\':
+			decoded.WriteByte(value[i])
+		default:
+			decoded.WriteByte('\\')
+			decoded.WriteByte(value[i])
+		}
+	}
+	return decoded.String()
+}
+
+// IsConfigTrue interprets a config value the way git interprets booleans.
+func IsConfigTrue(value string) bool {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package core
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	content := `# a comment
+[core]
+	verifyObjects = true ; a trailing comment
+	bare
+[User]
+	name = "A U Thor" # quoted, with a comment
+	email = author@example.com
+[remote "Origin"]
+	url = /srv/repo.git
+[alias]
+	escaped = "a\"b\\c\td\ne"
+	backslash = a\\nb
+	hash = "not # a comment"
+`
+	config := make(map[string]string)
+	if err := ParseConfig([]byte(content), config); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"core.verifyobjects": "true",
+		"core.bare":          "true",
+		"user.name":          "A U Thor",
+		"user.email":         "author@example.com",
+		"remote.Origin.url":  "/srv/repo.git",
+		"alias.escaped":      "a\"b\\c\td\ne",
+		"alias.backslash":    `a\nb`,
+		"alias.hash":         "not # a comment",
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("parsed %q, want %q", config, want)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	for _, content := range []string{"[core\n\tbare = true\n", "bare = true\n"} {
+		if err := ParseConfig([]byte(content), make(map[string]string)); err == nil {
+			t.Errorf("%q was parsed without an error", content)
+		}
+	}
+}
+
+func TestAddAndRemoveConfigSection(t *testing.T) {
+	path := t.TempDir() + "/config"
+	if err := ioutil.WriteFile(path, []byte("[core]\n\tbare = false"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddConfigSection(path, "remote.origin", [][2]string{{"url", "/srv/repo.git"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddConfigSection(path, "branch.master", [][2]string{{"remote", "origin"}}); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadFile(path)
+	want := "[core]\n\tbare = false\n[remote \"origin\"]\n\turl = /srv/repo.git\n[branch \"master\"]\n\tremote = origin\n"
+	if string(content) != want {
+		t.Errorf("the config is %q, want %q", content, want)
+	}
+
+	if err := RemoveConfigSection(path, "remote.origin"); err != nil {
+		t.Fatal(err)
+	}
+	content, _ = ioutil.ReadFile(path)
+	want = "[core]\n\tbare = false\n[branch \"master\"]\n\tremote = origin\n"
+	if string(content) != want {
+		t.Errorf("the config is %q after the removal, want %q", content, want)
+	}
+}
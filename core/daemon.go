@@ -0,0 +1,67 @@
+package core
+
+import (
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+)
+
+// ServeDaemon listens on address, e.g. ":9418", and serves fetches of the
+// repositories under dir with the git:// protocol, as `git daemon` does,
+// until it fails. Only repositories with a git-daemon-export-ok file are
+// served, unless export_all is set; pushes are not accepted.
+func ServeDaemon(address string, dir string, export_all bool) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	log.Println("Serving " + dir + " on " + listener.Addr().String())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go serveDaemonConnection(conn, dir, export_all)
+	}
+}
+
+// serveDaemonConnection reads the request a client starts with, e.g.
+// "git-upload-pack /repo.git\0host=localhost\0", and runs the service.
+func serveDaemonConnection(conn net.Conn, dir string, export_all bool) {
+	defer conn.Close()
+	pkt_reader := NewPktReader(conn)
+	request, err := pkt_reader.ReadPkt()
+	if err != nil {
+		return
+	}
+	command := string(request)
+	if nul_index := strings.IndexByte(command, 0); nul_index != -1 {
+		command = command[:nul_index]
+	}
+	command = strings.TrimSuffix(command, "\n")
+	space_index := strings.Index(command, " ")
+	if space_index == -1 {
+		io.WriteString(conn, PktLine("ERR invalid request"))
+		return
+	}
+	service, path := command[:space_index], command[space_index+1:]
+	if service != "git-upload-pack" {
+		io.WriteString(conn, PktLine("ERR service not enabled: '"+strings.TrimPrefix(service, "git-")+"'"))
+		return
+	}
+	rootDir, ok := servedRepository(dir, path)
+	if ok && !export_all {
+		_, err := os.Stat(GitDir(rootDir) + "/git-daemon-export-ok")
+		ok = err == nil
+	}
+	if !ok {
+		io.WriteString(conn, PktLine("ERR access denied or repository not exported: "+path))
+		return
+	}
+	log.Println(conn.RemoteAddr().String() + ": " + service + " " + path)
+	if err := UploadPack(rootDir, pkt_reader.reader, conn, false, false); err != nil {
+		log.Println(rootDir + ": " + err.Error())
+	}
+}
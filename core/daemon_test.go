@@ -0,0 +1,86 @@
+package core
+
+import (
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+// newTestDaemon serves the repositories under dir with the git:// protocol
+// for the length of a test, and returns the URL of dir.
+func newTestDaemon(t *testing.T, dir string, export_all bool) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveDaemonConnection(conn, dir, export_all)
+		}
+	}()
+	return "git://" + listener.Addr().String()
+}
+
+func TestDaemonCloneAndFetch(t *testing.T) {
+	isolateHome(t)
+	dir := t.TempDir()
+	newTestRepository(t, dir+"/origin")
+	first := commitFile(t, dir+"/origin", "a.txt", "a\n")
+	if err := ioutil.WriteFile(dir+"/origin/.git/git-daemon-export-ok", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	url := newTestDaemon(t, dir, false)
+
+	captureOutput(t, func() {
+		NewReGit(dir).Clone(url+"/origin", "clone", false, "", nil, nil)
+	})
+	if sha1_name := testRef(t, dir+"/clone", "HEAD"); sha1_name != first {
+		t.Errorf("the cloned HEAD is %s, want %s", sha1_name, first)
+	}
+	if content, _ := ioutil.ReadFile(dir + "/clone/a.txt"); string(content) != "a\n" {
+		t.Errorf("a.txt is %q in the clone", content)
+	}
+
+	second := commitFile(t, dir+"/origin", "b.txt", "b\n")
+	captureOutput(t, func() {
+		NewReGit(dir+"/clone").Fetch("origin", nil, nil)
+	})
+	if sha1_name := testRef(t, dir+"/clone", "refs/remotes/origin/master"); sha1_name != second {
+		t.Errorf("the fetched origin/master is %s, want %s", sha1_name, second)
+	}
+}
+
+func TestDaemonRefusals(t *testing.T) {
+	isolateHome(t)
+	dir := t.TempDir()
+	newTestRepository(t, dir+"/origin")
+	commitFile(t, dir+"/origin", "a.txt", "a\n")
+
+	transport, err := newGitTransport(newTestDaemon(t, dir, false) + "/origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.Refs(false); err == nil || !strings.Contains(err.Error(), "not exported") {
+		t.Errorf("the repository which is not exported gave %v", err)
+	}
+
+	transport, _ = newGitTransport(newTestDaemon(t, dir, true) + "/origin")
+	if _, err := transport.Refs(false); err != nil {
+		t.Errorf("the exported repository gave %v", err)
+	}
+	if _, err := transport.Refs(true); err == nil || !strings.Contains(err.Error(), "service not enabled") {
+		t.Errorf("a push gave %v", err)
+	}
+	transport, _ = newGitTransport(newTestDaemon(t, dir, true) + "/../outside")
+	if _, err := transport.Refs(false); err == nil {
+		t.Error("a path out of the served directory was served")
+	}
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var dateUnits = map[string]time.Duration{
+	"second": time.Second,
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+	"week":   7 * 24 * time.Hour,
+	"month":  30 * 24 * time.Hour,
+	"year":   365 * 24 * time.Hour,
+}
+
+// ParseExpiryDate understands the dates accepted by options such as
+// `prune --expire`: "now", "never", relative dates like "2.weeks.ago" or
+// "3 days ago", and the absolute dates of ParseDate.
+func ParseExpiryDate(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	switch strings.ToLower(value) {
+	case "now", "all":
+		return now, nil
+	case "never", "false":
+		return time.Time{}, nil
+	}
+
+	fields := strings.FieldsFunc(strings.ToLower(value), func(c rune) bool {
+		return c == '.' || c == ' '
+	})
+	if len(fields) == 3 && fields[2] == "ago" {
+		amount, err := strconv.Atoi(fields[0])
+		if err == nil {
+			unit, ok := dateUnits[strings.TrimSuffix(fields[1], "s")]
+			if ok {
+				return now.Add(-time.Duration(amount) * unit), nil
+			}
+		}
+	}
+	return ParseDate(value)
+}
+
+// the absolute dates git accepts; those without a timezone are taken as local time
+var dateLayouts = []string{
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05 -07:00",
+	"2006-01-02 15:04:05Z07:00",
+	"Mon Jan 2 15:04:05 2006 -0700",
+	"Mon, 2 Jan 2006 15:04:05",
+	"2 Jan 2006 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006.01.02 15:04:05",
+	"01/02/2006 15:04:05",
+	"Mon Jan 2 15:04:05 2006",
+	"2006-01-02",
+	"2006.01.02",
+	"01/02/2006",
+}
+
+// ParseDate understands the date formats git accepts for GIT_AUTHOR_DATE and
+// GIT_COMMITTER_DATE: git's internal format "<unix timestamp> <timezone>"
+// (optionally prefixed with '@'), a bare unix timestamp, RFC 2822 and
+// ISO 8601. The timezone of the date is kept so that it is recorded in the
+// commit.
+func ParseDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+
+	fields := strings.Fields(strings.TrimPrefix(value, "@"))
+	if len(fields) == 1 || len(fields) == 2 {
+		if timestamp, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+			date := time.Unix(timestamp, 0).UTC()
+			if len(fields) == 2 {
+				if zone, ok := parseTimezone(fields[1]); ok {
+					return date.In(zone), nil
+				}
+			} else if strings.HasPrefix(value, "@") || timestamp >= 100000000 {
+				// like git, a bare number of 9 digits or more is a timestamp
+				return date, nil
+			}
+		}
+	}
+
+	for _, layout := range dateLayouts {
+		if date, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, errors.New("invalid date '" + value + "'")
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseExpiryDate(t *testing.T) {
+	now := time.Date(2023, 7, 22, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"now", now},
+		{"never", time.Time{}},
+		{"2.weeks.ago", now.Add(-14 * 24 * time.Hour)},
+		{"3 days ago", now.Add(-3 * 24 * time.Hour)},
+		{"1.hour.ago", now.Add(-time.Hour)},
+		{"2023-07-01", time.Date(2023, 7, 1, 0, 0, 0, 0, time.Local)},
+		{"2023-07-01 10:30:00", time.Date(2023, 7, 1, 10, 30, 0, 0, time.Local)},
+		{"2023-07-01T10:30:00+02:00", time.Date(2023, 7, 1, 8, 30, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		got, err := ParseExpiryDate(test.value, now)
+		if err != nil {
+			t.Errorf("%q: %v", test.value, err)
+		} else if !got.Equal(test.want) {
+			t.Errorf("%q is %v, want %v", test.value, got, test.want)
+		}
+	}
+	for _, value := range []string{"yesterday-ish", "2.fortnights.ago"} {
+		if _, err := ParseExpiryDate(value, now); err == nil {
+			t.Errorf("%q was parsed", value)
+		}
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		value  string
+		unix   int64
+		offset string
+	}{
+		{"1700000000 +0200", 1700000000, "+0200"},
+		{"@1700000000 -07:00", 1700000000, "-0700"},
+		{"@1700000000", 1700000000, "+0000"},
+		{"1700000000", 1700000000, "+0000"},
+		{"Tue, 14 Nov 2023 22:13:20 +0000", 1700000000, "+0000"},
+		{"2023-11-14T23:13:20+01:00", 1700000000, "+0100"},
+		{"2023-11-14 17:13:20 -0500", 1700000000, "-0500"},
+	}
+	for _, test := range tests {
+		date, err := ParseDate(test.value)
+		if err != nil {
+			t.Errorf("%q: %v", test.value, err)
+			continue
+		}
+		if date.Unix() != test.unix || date.Format("-0700") != test.offset {
+			t.Errorf("%q is %d %s, want %d %s", test.value, date.Unix(), date.Format("-0700"), test.unix, test.offset)
+		}
+	}
+
+	date, err := ParseDate("2023-11-14 22:13:20")
+	if want := time.Date(2023, 11, 14, 22, 13, 20, 0, time.Local); err != nil || !date.Equal(want) {
+		t.Errorf("a date without timezone is %v, %v, want %v", date, err, want)
+	}
+	for _, value := range []string{"12345", "1700000000 0200", "yesterday-ish"} {
+		if _, err := ParseDate(value); err == nil {
+			t.Errorf("%q was parsed", value)
+		}
+	}
+}
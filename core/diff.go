@@ -0,0 +1,429 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// the number of unchanged lines shown around changes
+const diffContextLines = 3
+
+// one line of an edit script: kind is ' ' for a line both texts have, '-'
+// for a line only the old text has, and '+' for one only the new text has
+type diffOp struct {
+	kind     byte
+	oldIndex int
+	newIndex int
+}
+
+// diffLines computes the shortest edit script turning old_lines into
+// new_lines with Myers' algorithm, then moves ambiguous changes where git
+// puts them.
+func diffLines(old_lines []string, new_lines []string) []diffOp {
+	n, m := len(old_lines), len(new_lines)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	// the furthest reaching paths before every step, to walk back from the end
+	trace := make([][]int, 0)
+
+	found := false
+	for d := 0; d <= max && !found; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && old_lines[x] == new_lines[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, max)
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var previous_k int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			previous_k = k + 1
+		} else {
+			previous_k = k - 1
+		}
+		previous_x := v[offset+previous_k]
+		previous_y := previous_x - previous_k
+		for x > previous_x && y > previous_y {
+			x--
+			y--
+			ops = append(ops, diffOp{' ', x, y})
+		}
+		if d > 0 {
+			if x == previous_x {
+				ops = append(ops, diffOp{'+', x, previous_y})
+			} else {
+				ops = append(ops, diffOp{'-', previous_x, y})
+			}
+		}
+		x, y = previous_x, previous_y
+	}
+
+	old_changed := make([]bool, n)
+	new_changed := make([]bool, m)
+	for _, op := range ops {
+		switch op.kind {
+		case '-':
+			old_changed[op.oldIndex] = true
+		case '+':
+			new_changed[op.newIndex] = true
+		}
+	}
+	compactChanges(old_lines, old_changed, new_changed)
+	compactChanges(new_lines, new_changed, old_changed)
+
+	// rebuild the script, with the deletions of every change before its insertions
+	ops = ops[:0]
+	x, y = 0, 0
+	for x < n || y < m {
+		switch {
+		case x < n && old_changed[x]:
+			ops = append(ops, diffOp{'-', x, y})
+			x++
+		case y < m && new_changed[y]:
+			ops = append(ops, diffOp{'+', x, y})
+			y++
+		default:
+			ops = append(ops, diffOp{' ', x, y})
+			x++
+			y++
+		}
+	}
+	return ops
+}
+
+// a run of changed lines of one side of a diff, empty between two unchanged lines
+type diffGroup struct {
+	start int
+	end   int
+}
+
+func firstDiffGroup(changed []bool) diffGroup {
+	end := 0
+	for end < len(changed) && changed[end] {
+		end++
+	}
+	return diffGroup{0, end}
+}
+
+func (group *diffGroup) next(changed []bool) bool {
+	if group.end == len(changed) {
+		return false
+	}
+	group.start = group.end + 1
+	group.end = group.start
+	for group.end < len(changed) && changed[group.end] {
+		group.end++
+	}
+	return true
+}
+
+func (group *diffGroup) previous(changed []bool) bool {
+	if group.start == 0 {
+		return false
+	}
+	group.end = group.start - 1
+	group.start = group.end
+	for group.start > 0 && changed[group.start-1] {
+		group.start--
+	}
+	return true
+}
+
+// slideDown moves a group one line down if the line after it equals its
+// first line, merging it with the group that follows if they meet.
+func (group *diffGroup) slideDown(lines []string, changed []bool) bool {
+	if group.end == len(lines) || lines[group.start] != lines[group.end] {
+		return false
+	}
+	changed[group.start] = false
+	changed[group.end] = true
+	group.start++
+	group.end++
+	for group.end < len(changed) && changed[group.end] {
+		group.end++
+	}
+	return true
+}
+
+func (group *diffGroup) slideUp(lines []string, changed []bool) bool {
+	if group.start == 0 || lines[group.start-1] != lines[group.end-1] {
+		return false
+	}
+	group.start--
+	group.end--
+	changed[group.start] = true
+	changed[group.end] = false
+	for group.start > 0 && changed[group.start-1] {
+		group.start--
+	}
+	return true
+}
+
+// compactChanges moves ambiguous changes of one side of a diff to the same
+// place git does: every group of changed lines slides as far down as it
+// can, unless it can line up with a change on the other side.
+func compactChanges(lines []string, changed []bool, other_changed []bool) {
+	group := firstDiffGroup(changed)
+	other_group := firstDiffGroup(other_changed)
+	for {
+		if group.end != group.start {
+			var earliest_end int
+			end_matching_other := -1
+			for {
+				group_size := group.end - group.start
+				// groups merge while sliding, in which case they slide again
+				for group.slideUp(lines, changed) {
+					other_group.previous(other_changed)
+				}
+				earliest_end = group.end
+				if other_group.end > other_group.start {
+					end_matching_other = group.end
+				}
+				for group.slideDown(lines, changed) {
+					other_group.next(other_changed)
+					if other_group.end > other_group.start {
+						end_matching_other = group.end
+					}
+				}
+				if group_size == group.end-group.start {
+					break
+				}
+			}
+			if group.end != earliest_end && end_matching_other != -1 {
+				for other_group.end == other_group.start {
+					group.slideUp(lines, changed)
+					other_group.previous(other_changed)
+				}
+			}
+		}
+		if !group.next(changed) {
+			break
+		}
+		other_group.next(other_changed)
+	}
+}
+
+// splitLines splits content into lines which keep their "\n", so that a
+// last line without one differs from the same line with one.
+func splitLines(content []byte) []string {
+	lines := make([]string, 0)
+	for len(content) != 0 {
+		line_end_index := bytes.IndexByte(content, '\n')
+		if line_end_index == -1 {
+			lines = append(lines, string(content))
+			break
+		}
+		lines = append(lines, string(content[:line_end_index+1]))
+		content = content[line_end_index+1:]
+	}
+	return lines
+}
+
+// like git, content with a NUL byte near its start is taken as binary
+func isBinary(content []byte) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+	return bytes.IndexByte(content, 0) != -1
+}
+
+// "start,count", where the count is left out if it is 1, and the start is
+// the line before the hunk if the hunk has no lines on this side
+func formatHunkRange(start int, count int) string {
+	if count == 0 {
+		return strconv.Itoa(start-1) + ",0"
+	}
+	if count == 1 {
+		return strconv.Itoa(start)
+	}
+	return strconv.Itoa(start) + "," + strconv.Itoa(count)
+}
+
+// hunkFunctionName finds the line shown after the range of a hunk starting
+// at line index: like git's default, the closest line before it which starts
+// with a letter, '_' or '$'.
+func hunkFunctionName(lines []string, index int) string {
+	for i := index - 1; i >= 0; i-- {
+		line := lines[i]
+		if line == "" {
+			continue
+		}
+		c := line[0]
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || c == '$' {
+			line = strings.TrimRight(line, " \t\r\n")
+			if len(line) > 80 {
+				line = line[:80]
+			}
+			return line
+		}
+	}
+	return ""
+}
+
+// diffHunks groups the changes of an edit script, along with their context,
+// into [start, end) ranges of ops. Changes whose contexts overlap or touch
+// share a hunk.
+func diffHunks(ops []diffOp, is_change func(op diffOp) bool) [][2]int {
+	hunks := make([][2]int, 0)
+	for i := 0; i < len(ops); i++ {
+		if !is_change(ops[i]) {
+			continue
+		}
+		start := i - diffContextLines
+		if start < 0 {
+			start = 0
+		}
+		end := i + 1 + diffContextLines
+		if len(hunks) != 0 && start <= hunks[len(hunks)-1][1] {
+			hunks[len(hunks)-1][1] = end
+		} else {
+			hunks = append(hunks, [2]int{start, end})
+		}
+	}
+	for i := range hunks {
+		if hunks[i][1] > len(ops) {
+			hunks[i][1] = len(ops)
+		}
+	}
+	return hunks
+}
+
+// DiffColors are the escape sequences used by patches, all empty when
+// colors are off.
+type DiffColors struct {
+	Meta  string
+	Frag  string
+	Old   string
+	New   string
+	Reset string
+}
+
+func NewDiffColors(color bool) *DiffColors {
+	if !color {
+		return new(DiffColors)
+	}
+	return &DiffColors{"\033[1m", "\033[36m", "\033[31m", "\033[32m", "\033[0m"}
+}
+
+func writeDiffLine(builder *strings.Builder, color string, reset string, prefix string, line string) {
+	builder.WriteString(color + prefix + strings.TrimSuffix(line, "\n") + reset + "\n")
+	if !strings.HasSuffix(line, "\n") {
+		builder.WriteString("\\ No newline at end of file\n")
+	}
+}
+
+// writeUnifiedHunks writes the hunks of a unified diff between two texts.
+func writeUnifiedHunks(builder *strings.Builder, old_content []byte, new_content []byte, colors *DiffColors) {
+	old_lines := splitLines(old_content)
+	new_lines := splitLines(new_content)
+	ops := diffLines(old_lines, new_lines)
+
+	for _, hunk := range diffHunks(ops, func(op diffOp) bool { return op.kind != ' ' }) {
+		first := ops[hunk[0]]
+		old_count, new_count := 0, 0
+		for _, op := range ops[hunk[0]:hunk[1]] {
+			if op.kind != '+' {
+				old_count++
+			}
+			if op.kind != '-' {
+				new_count++
+			}
+		}
+		header := "@@ -" + formatHunkRange(first.oldIndex+1, old_count) + " +" + formatHunkRange(first.newIndex+1, new_count) + " @@"
+		builder.WriteString(colors.Frag + header + colors.Reset)
+		if function_name := hunkFunctionName(old_lines, first.oldIndex); function_name != "" {
+			builder.WriteString(" " + function_name)
+		}
+		builder.WriteString("\n")
+
+		for _, op := range ops[hunk[0]:hunk[1]] {
+			switch op.kind {
+			case ' ':
+				writeDiffLine(builder, "", "", " ", old_lines[op.oldIndex])
+			case '-':
+				writeDiffLine(builder, colors.Old, colors.Reset, "-", old_lines[op.oldIndex])
+			case '+':
+				writeDiffLine(builder, colors.New, colors.Reset, "+", new_lines[op.newIndex])
+			}
+		}
+	}
+}
+
+// the content a tree entry stands for in a patch: blobs are compared by
+// content and submodules by the commit they point to
+func (regit *ReGit) diffContent(mode string, sha1_name string) []byte {
+	if sha1_name == "" {
+		return nil
+	}
+	if mode == "160000" {
+		return []byte("Subproject commit " + sha1_name + "\n")
+	}
+	blob := NewBlobObject(regit.RootDir)
+	blob.ReadFromExistingObject(sha1_name)
+	return blob.Obj.content
+}
+
+// WritePatch writes the "diff --git" patch of a change.
+func (regit *ReGit) WritePatch(builder *strings.Builder, change *TreeChange, colors *DiffColors) {
+	meta := func(line string) {
+		builder.WriteString(colors.Meta + line + colors.Reset + "\n")
+	}
+	old_path, new_path := "a/"+change.Path, "b/"+change.Path
+	meta("diff --git " + old_path + " " + new_path)
+
+	old_mode, new_mode := formatMode(change.OldMode), formatMode(change.NewMode)
+	old_abbreviation, new_abbreviation := abbreviate(change.OldSHA1), abbreviate(change.NewSHA1)
+	index_line := "index " + old_abbreviation + ".." + new_abbreviation
+	switch {
+	case change.OldSHA1 == "":
+		meta("new file mode " + new_mode)
+		old_path = "/dev/null"
+		index_line = "index 0000000.." + new_abbreviation
+	case change.NewSHA1 == "":
+		meta("deleted file mode " + old_mode)
+		new_path = "/dev/null"
+		index_line = "index " + old_abbreviation + "..0000000"
+	case old_mode != new_mode:
+		meta("old mode " + old_mode)
+		meta("new mode " + new_mode)
+	default:
+		index_line += " " + new_mode
+	}
+	if change.OldSHA1 == change.NewSHA1 {
+		// only the mode changed
+		return
+	}
+	meta(index_line)
+
+	old_content := regit.diffContent(change.OldMode, change.OldSHA1)
+	new_content := regit.diffContent(change.NewMode, change.NewSHA1)
+	if isBinary(old_content) || isBinary(new_content) {
+		builder.WriteString(fmt.Sprintf("Binary files %s and %s differ\n", old_path, new_path))
+		return
+	}
+	meta("--- " + old_path)
+	meta("+++ " + new_path)
+	writeUnifiedHunks(builder, old_content, new_content, colors)
+}
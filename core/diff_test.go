@@ -0,0 +1,50 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedHunks(t *testing.T) {
+	builder := new(strings.Builder)
+	writeUnifiedHunks(builder, []byte("a\nb\nc\n"), []byte("a\nB\nc\nd\n"), NewDiffColors(false))
+	want := "@@ -1,3 +1,4 @@\n a\n-b\n+B\n c\n+d\n"
+	if builder.String() != want {
+		t.Errorf("the patch is\n%s\nwant\n%s", builder.String(), want)
+	}
+}
+
+func TestCombinedHunks(t *testing.T) {
+	builder := new(strings.Builder)
+	writeCombinedHunks(builder, [][]byte{[]byte("a\nb\nc\n"), []byte("a\nx\nc\n")}, []byte("a\ny\nc\n"), NewDiffColors(false))
+	want := "@@@ -1,3 -1,3 +1,3 @@@\n  a\n- b\n -x\n++y\n  c\n"
+	if builder.String() != want {
+		t.Errorf("the combined patch is\n%s\nwant\n%s", builder.String(), want)
+	}
+
+	// --cc leaves out the hunks where the result is taken from one parent
+	builder.Reset()
+	writeCombinedHunks(builder, [][]byte{[]byte("a\nb\nc\n"), []byte("a\nB\nc\n")}, []byte("a\nB\nc\n"), NewDiffColors(false))
+	if builder.String() != "" {
+		t.Errorf("a change taken from one parent gives\n%s", builder.String())
+	}
+}
+
+func TestDiffTreesAndPatch(t *testing.T) {
+	rootDir, first, second := newTestHistory(t)
+	regit := &ReGit{RootDir: rootDir, Config: map[string]string{}}
+	first_commit := newTestCommit(t, rootDir, first)
+	second_commit := newTestCommit(t, rootDir, second)
+
+	changes := regit.DiffTrees(first_commit.tree, second_commit.tree)
+	if len(changes) != 1 || changes[0].Path != "dir/b.txt" || changes[0].OldSHA1 != "" || changes[0].NewMode != "100644" {
+		t.Fatalf("the changes are %+v, want dir/b.txt added", changes)
+	}
+
+	patch := regit.commitPatch(second_commit, NewDiffColors(false))
+	for _, want := range []string{"diff --git a/dir/b.txt b/dir/b.txt\n", "new file mode 100644\n", "--- /dev/null\n+++ b/dir/b.txt\n@@ -0,0 +1 @@\n+b\n"} {
+		if !strings.Contains(patch, want) {
+			t.Errorf("the patch\n%s\ndoes not contain %q", patch, want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+)
+
+// the width diffstats are fitted in
+const diffStatWidth = 80
+
+// a line of a diffstat
+type diffStatFile struct {
+	path     string
+	added    int
+	deleted  int
+	binary   bool
+	old_size int
+	new_size int
+}
+
+func pluralCount(count int, singular string, plural string) string {
+	if count == 1 {
+		return strconv.Itoa(count) + " " + singular
+	}
+	return strconv.Itoa(count) + " " + plural
+}
+
+// like git, a non-empty count is never scaled down to nothing
+func scaleDiffStat(count int, width int, max_change int) int {
+	if count == 0 {
+		return 0
+	}
+	return 1 + count*(width-1)/max_change
+}
+
+// WriteDiffStat writes the summary of changes `git diff --stat` prints: for
+// every file, the number of changed lines with a graph of additions and
+// deletions, fitted in 80 columns like git's, then the totals.
+func (regit *ReGit) WriteDiffStat(builder *strings.Builder, changes []*TreeChange, colors *DiffColors) {
+	files := make([]*diffStatFile, 0, len(changes))
+	name_width, max_change := 0, 0
+	has_binary := false
+	for _, change := range changes {
+		old_content := regit.diffContent(change.OldMode, change.OldSHA1)
+		new_content := regit.diffContent(change.NewMode, change.NewSHA1)
+		file := &diffStatFile{path: change.Path, old_size: len(old_content), new_size: len(new_content)}
+		if isBinary(old_content) || isBinary(new_content) {
+			file.binary = true
+			has_binary = true
+		} else {
+			for _, op := range diffLines(splitLines(old_content), splitLines(new_content)) {
+				switch op.kind {
+				case '+':
+					file.added++
+				case '-':
+					file.deleted++
+				}
+			}
+			if file.added+file.deleted > max_change {
+				max_change = file.added + file.deleted
+			}
+		}
+		if len(file.path) > name_width {
+			name_width = len(file.path)
+		}
+		files = append(files, file)
+	}
+
+	number_width := len(strconv.Itoa(max_change))
+	if has_binary && number_width < 3 {
+		number_width = 3
+	}
+	graph_width := max_change
+	if name_width+number_width+6+graph_width > diffStatWidth {
+		if graph_width > diffStatWidth*3/8-number_width-6 {
+			graph_width = diffStatWidth*3/8 - number_width - 6
+			if graph_width < 6 {
+				graph_width = 6
+			}
+		}
+		if name_width > diffStatWidth-number_width-6-graph_width {
+			name_width = diffStatWidth - number_width - 6 - graph_width
+		} else {
+			graph_width = diffStatWidth - number_width - 6 - name_width
+		}
+	}
+
+	total_added, total_deleted := 0, 0
+	for _, file := range files {
+		name := file.path
+		if len(name) > name_width {
+			name = "..." + name[len(name)-name_width+3:]
+		}
+		builder.WriteString(" " + name + strings.Repeat(" ", name_width-len(name)) + " |")
+		if file.binary {
+			builder.WriteString(" Bin " + strconv.Itoa(file.old_size) + " -> " + strconv.Itoa(file.new_size) + " bytes\n")
+			continue
+		}
+		total_added += file.added
+		total_deleted += file.deleted
+		count := strconv.Itoa(file.added + file.deleted)
+		builder.WriteString(" " + strings.Repeat(" ", number_width-len(count)) + count)
+		added, deleted := file.added, file.deleted
+		if max_change > graph_width {
+			added = scaleDiffStat(added, graph_width, max_change)
+			deleted = scaleDiffStat(deleted, graph_width, max_change)
+		}
+		if added+deleted != 0 {
+			builder.WriteString(" ")
+			if added != 0 {
+				builder.WriteString(colors.New + strings.Repeat("+", added) + colors.Reset)
+			}
+			if deleted != 0 {
+				builder.WriteString(colors.Old + strings.Repeat("-", deleted) + colors.Reset)
+			}
+		}
+		builder.WriteString("\n")
+	}
+
+	builder.WriteString(" " + pluralCount(len(files), "file", "files") + " changed")
+	if total_added != 0 || total_deleted == 0 {
+		builder.WriteString(", " + pluralCount(total_added, "insertion(+)", "insertions(+)"))
+	}
+	if total_deleted != 0 || total_added == 0 {
+		builder.WriteString(", " + pluralCount(total_deleted, "deletion(-)", "deletions(-)"))
+	}
+	builder.WriteString("\n")
+}
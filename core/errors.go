@@ -0,0 +1,25 @@
+package core
+
+// ChecksumError reports a file whose content does not hash to the SHA-1 it
+// is supposed to have, e.g. a corrupted loose object or index file.
+type ChecksumError struct {
+	File     string
+	Expected string
+	Actual   string
+}
+
+func (err *ChecksumError) Error() string {
+	return err.File + " is corrupt: expected SHA-1 " + err.Expected + ", actual SHA-1 " + err.Actual
+}
+
+// ObjectError reports an object that can not be read or parsed.
+type ObjectError struct {
+	File   string
+	SHA1   string
+	Typ    string
+	Reason string
+}
+
+func (err *ObjectError) Error() string {
+	return err.Typ + " object '" + err.SHA1 + "' (" + err.File + ") " + err.Reason
+}
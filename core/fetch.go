@@ -0,0 +1,388 @@
+package core
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// copyMissingObjects copies the objects reachable from tips which the
+// repository at to does not have, from the repository at from.
+func copyMissingObjects(from string, to string, tips []string) error {
+	return walkMissingObjects(from, tips, func(sha1_name string) bool {
+		return ObjectExists(to, sha1_name)
+	}, func(obj *GitObject) {
+		NewGitObject(to, obj.typ, obj.content).WriteToFile()
+	})
+}
+
+// walkMissingObjects passes to emit the objects of the repository at rootDir
+// reachable from tips that have does not know of, every object after those
+// it points to. Like git's, it takes a commit which have knows to come with
+// all of its history.
+func walkMissingObjects(rootDir string, tips []string, have func(sha1_name string) bool, emit func(obj *GitObject)) error {
+	var commit_graph *CommitGraph
+	later := make([]*GitObject, 0) // tags, once what they point to is there
+	pending := append([]string{}, tips...)
+	for len(pending) != 0 {
+		sha1_name := pending[0]
+		pending = pending[1:]
+		if have(sha1_name) {
+			continue
+		}
+		obj, err := ReadObject(rootDir, sha1_name)
+		if err != nil {
+			return err
+		}
+		switch obj.typ {
+		case "commit":
+			commit := NewCommitObject(rootDir)
+			if err := commit.Load(sha1_name); err != nil {
+				return err
+			}
+			if commit_graph == nil {
+				commit_graph = NewCommitGraph(commit, rootDir)
+			} else {
+				commit_graph.AddRootCommit(commit)
+			}
+		case "tree":
+			if err := walkMissingTree(rootDir, sha1_name, have, emit); err != nil {
+				return err
+			}
+		case "tag":
+			tag := NewTagObject(rootDir)
+			if err := tag.Load(sha1_name); err != nil {
+				return err
+			}
+			pending = append(pending, tag.object)
+			later = append(later, obj)
+		default:
+			emit(obj)
+		}
+	}
+
+	if commit_graph != nil {
+		commits := commit_graph.LoadCommitsUntil(have)
+		// parents first
+		for i := len(commits) - 1; i >= 0; i-- {
+			if err := walkMissingTree(rootDir, commits[i].tree, have, emit); err != nil {
+				return err
+			}
+			emit(&commits[i].Obj)
+		}
+	}
+	for i := len(later) - 1; i >= 0; i-- {
+		emit(later[i])
+	}
+	return nil
+}
+
+// walkMissingTree passes a tree to emit, after the trees and blobs in it,
+// leaving out those that have knows of.
+func walkMissingTree(rootDir string, tree_sha1 string, have func(sha1_name string) bool, emit func(obj *GitObject)) error {
+	if have(tree_sha1) {
+		return nil
+	}
+	tree := NewTreeObject(rootDir)
+	if err := tree.Load(tree_sha1); err != nil {
+		return err
+	}
+	for _, entry := range tree.Entries {
+		sha1_name := hex.EncodeToString(entry.HashedFilename)
+		switch entry.Type() {
+		case "tree":
+			if err := walkMissingTree(rootDir, sha1_name, have, emit); err != nil {
+				return err
+			}
+		case "blob":
+			if have(sha1_name) {
+				continue
+			}
+			blob, err := ReadObject(rootDir, sha1_name)
+			if err != nil {
+				return err
+			}
+			emit(blob)
+		}
+		// gitlinks name commits of other repositories
+	}
+	emit(&tree.Obj)
+	return nil
+}
+
+// the width of the summary of a ref update, e.g. "1234abc..5678def"
+const refSummaryWidth = 2*7 + 3
+
+// refUpdate is a ref which fetch or push points to a new object.
+type refUpdate struct {
+	Src     string // the name of the ref where it comes from
+	Dst     string // the name of the ref it updates; empty if none
+	OldSHA1 string
+	NewSHA1 string
+	Force   bool
+
+	Flag    byte // ' ' fast-forward, '+' forced, '*' new, '-' deleted, '=' up to date, '!' rejected
+	Summary string
+	Reason  string
+}
+
+// isAncestor tells whether a commit is in the history of another, both of
+// which are in the repository.
+func (regit *ReGit) isAncestor(ancestor_sha1 string, sha1_name string) bool {
+	if !ObjectExists(regit.RootDir, ancestor_sha1) {
+		return false
+	}
+	commit_sha1, err := PeelObject(regit.RootDir, sha1_name, "commit")
+	if err != nil {
+		return false
+	}
+	return regit.ancestors(commit_sha1)[ancestor_sha1]
+}
+
+// classify decides whether an update may be made: it is a fast-forward, or
+// it is forced. It returns false if it is rejected.
+func (regit *ReGit) classify(update *refUpdate) bool {
+	switch {
+	case update.NewSHA1 == "":
+		update.Flag, update.Summary = '-', "[deleted]"
+	case update.OldSHA1 == "":
+		update.Flag, update.Summary = '*', "[new ref]"
+		if strings.HasPrefix(update.Dst, "refs/heads/") || strings.HasPrefix(update.Dst, "refs/remotes/") {
+			update.Summary = "[new branch]"
+		} else if strings.HasPrefix(update.Dst, "refs/tags/") {
+			update.Summary = "[new tag]"
+		}
+	case update.OldSHA1 == update.NewSHA1:
+		update.Flag, update.Summary = '=', "[up to date]"
+	case regit.isAncestor(update.OldSHA1, update.NewSHA1):
+		update.Flag, update.Summary = ' ', abbreviate(update.OldSHA1)+".."+abbreviate(update.NewSHA1)
+	case update.Force:
+		update.Flag, update.Summary = '+', abbreviate(update.OldSHA1)+"..."+abbreviate(update.NewSHA1)
+		update.Reason = "forced update"
+	case !ObjectExists(regit.RootDir, update.OldSHA1):
+		// what the ref points to has to be fetched first
+		update.Flag, update.Summary, update.Reason = '!', "[rejected]", "fetch first"
+	default:
+		update.Flag, update.Summary, update.Reason = '!', "[rejected]", "non-fast-forward"
+	}
+	return update.Flag != '!'
+}
+
+// remoteRepository finds the remote of a name, or of a path or URL given
+// instead of the name of a remote, and opens a transport to its repository.
+func (regit *ReGit) remoteRepository(name string) (*Remote, Transport) {
+	var remote *Remote
+	if _, ok := regit.Config["remote."+name+".url"]; ok {
+		remote = regit.Remote(name)
+	} else {
+		remote = &Remote{URL: name}
+	}
+	transport, err := regit.openTransport(remote.URL)
+	if err != nil {
+		if remote.Name == "" {
+			fmt.Println("Error: '" + name + "' does not appear to be a git repository")
+		} else {
+			fmt.Println("Error: " + err.Error())
+		}
+		os.Exit(1)
+	}
+	return remote, transport
+}
+
+// fetchHeadLine is a line of .git/FETCH_HEAD, which records what was fetched
+// for a later merge.
+func fetchHeadLine(update *refUpdate, for_merge bool, url string) string {
+	if update.Src == "HEAD" {
+		return update.NewSHA1 + "\t\t" + url + "\n"
+	}
+	kind := ""
+	if strings.HasPrefix(update.Src, "refs/heads/") {
+		kind = "branch "
+	} else if strings.HasPrefix(update.Src, "refs/tags/") {
+		kind = "tag "
+	}
+	merge := ""
+	if !for_merge {
+		merge = "not-for-merge"
+	}
+	return update.NewSHA1 + "\t" + merge + "\t" + kind + "'" + shortRefName(update.Src) + "' of " + url + "\n"
+}
+
+// expandRemoteRef turns a short ref name given on the command line into the
+// full name of one of the refs of a remote.
+func expandRemoteRef(remote_refs *RemoteRefs, name string) (string, error) {
+	if strings.HasPrefix(name, "refs/") || name == "HEAD" {
+		return name, nil
+	}
+	for _, candidate := range []string{"refs/heads/" + name, "refs/tags/" + name} {
+		if _, ok := remote_refs.Lookup(candidate); ok {
+			return candidate, nil
+		}
+	}
+	return "", errors.New("couldn't find remote ref " + name)
+}
+
+// Fetch copies the objects of the branches of a remote that are missing
+// here, then updates the remote-tracking refs its fetch refspec maps them to,
+// or the refs given by refspecs. Tags pointing to fetched commits come along.
+// An update which is not a fast-forward is rejected unless the refspec is
+// forced. With deepen, the history fetched is shallow, or made deeper in a
+// repository which is shallow already. The filter of a partial clone applies
+// to fetches from its promisor remote.
+func (regit *ReGit) Fetch(name string, refspecs []string, deepen *Deepen) {
+	if deepen != nil && deepen.Depth == InfiniteDepth && !IsShallowRepository(regit.RootDir) {
+		fmt.Println("Error: --unshallow on a complete repository does not make sense")
+		os.Exit(1)
+	}
+	remote, transport := regit.remoteRepository(name)
+	remote_refs, err := transport.Refs(false)
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+	var filter *ObjectFilter
+	if spec, ok := regit.Config["remote."+remote.Name+".partialclonefilter"]; ok && remote.Name == PromisorRemote(regit.RootDir) {
+		if filter, err = ParseObjectFilter(spec); err != nil {
+			fmt.Println("Error: " + err.Error())
+			os.Exit(1)
+		}
+	}
+
+	head := NewHEAD(regit.RootDir)
+	head.Read()
+	merge_ref := ""
+	if head.PointsToBranch && regit.Config["branch."+head.Content+".remote"] == remote.Name {
+		merge_ref = regit.Config["branch."+head.Content+".merge"]
+	}
+
+	updates := make([]*refUpdate, 0)
+	for_merge := make(map[*refUpdate]bool)
+	if len(refspecs) == 0 {
+		if remote.Fetch == nil {
+			update := &refUpdate{Src: "HEAD", NewSHA1: remote_refs.HeadSHA1}
+			updates = append(updates, update)
+			for_merge[update] = true
+		}
+		for _, ref := range remote_refs.Refs {
+			if remote.Fetch == nil {
+				break
+			}
+			if dst, ok := remote.Fetch.Match(ref.Name); ok {
+				update := &refUpdate{Src: ref.Name, Dst: dst, NewSHA1: ref.SHA1, Force: remote.Fetch.Force}
+				updates = append(updates, update)
+				for_merge[update] = ref.Name == merge_ref
+			}
+		}
+	}
+	for _, spec := range refspecs {
+		refspec := ParseRefspec(spec)
+		src, err := expandRemoteRef(remote_refs, refspec.Src)
+		if err != nil {
+			fmt.Println("Error: " + err.Error())
+			os.Exit(1)
+		}
+		sha1_name, _ := remote_refs.Lookup(src)
+		dst := refspec.Dst
+		if dst != "" && !strings.HasPrefix(dst, "refs/") {
+			dst = "refs/heads/" + dst
+		}
+		if dst == "" && remote.Fetch != nil {
+			// the remote-tracking ref is updated as well
+			dst, _ = remote.Fetch.Match(src)
+			refspec.Force = refspec.Force || remote.Fetch.Force
+		}
+		update := &refUpdate{Src: src, Dst: dst, NewSHA1: sha1_name, Force: refspec.Force}
+		updates = append(updates, update)
+		for_merge[update] = true
+	}
+
+	tips := make([]string, 0, len(updates))
+	for _, update := range updates {
+		tips = append(tips, update.NewSHA1)
+	}
+	if err := transport.Fetch(regit.RootDir, tips, deepen, filter); err != nil {
+		fmt.Println("Error: could not fetch the objects: " + err.Error())
+		os.Exit(1)
+	}
+	// tags of the remote which point to what is here now come along
+	if len(refspecs) == 0 && remote.Fetch != nil {
+		tips = tips[:0]
+		for _, ref := range remote_refs.Refs {
+			if !strings.HasPrefix(ref.Name, "refs/tags/") {
+				continue
+			}
+			if _, ok := ReadRef(regit.RootDir, ref.Name); ok {
+				continue
+			}
+			target, ok := remote_refs.Peeled[ref.Name]
+			if !ok {
+				target = ref.SHA1
+			}
+			if ObjectExists(regit.RootDir, target) {
+				updates = append(updates, &refUpdate{Src: ref.Name, Dst: ref.Name, NewSHA1: ref.SHA1})
+				tips = append(tips, ref.SHA1)
+			}
+		}
+		if err := transport.Fetch(regit.RootDir, tips, nil, filter); err != nil {
+			fmt.Println("Error: could not fetch the objects: " + err.Error())
+			os.Exit(1)
+		}
+	}
+
+	ref_width := 10
+	for _, update := range updates {
+		if update.Dst != "" && len(shortRefName(update.Src)) > ref_width {
+			ref_width = len(shortRefName(update.Src))
+		}
+	}
+	rejected := false
+	printed := false
+	for _, update := range updates {
+		if update.Dst == "" {
+			continue
+		}
+		update.OldSHA1, _ = ReadRef(regit.RootDir, update.Dst)
+		if !regit.classify(update) {
+			rejected = true
+		} else if update.Flag != '=' {
+			if err := WriteRef(regit.RootDir, update.Dst, update.NewSHA1); err != nil {
+				fmt.Println("Error: " + err.Error())
+				os.Exit(1)
+			}
+		}
+		if update.Flag == '=' {
+			continue
+		}
+		if !printed {
+			fmt.Println("From " + remote.URL)
+			printed = true
+		}
+		src := shortRefName(update.Src)
+		line := " " + string(update.Flag) + " " + update.Summary + strings.Repeat(" ", refSummaryWidth-len(update.Summary)) +
+			" " + src + strings.Repeat(" ", ref_width-len(src)) + " -> " + shortRefName(update.Dst)
+		if update.Reason != "" {
+			line += "  (" + update.Reason + ")"
+		}
+		fmt.Println(line)
+	}
+
+	// like git's, the lines for merging come first
+	fetch_head := ""
+	for _, merge := range []bool{true, false} {
+		for _, update := range updates {
+			if for_merge[update] == merge {
+				fetch_head += fetchHeadLine(update, merge, remote.URL)
+			}
+		}
+	}
+	if err := ioutil.WriteFile(GitDir(regit.RootDir)+"/FETCH_HEAD", []byte(fetch_head), 0644); err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+	if rejected {
+		os.Exit(1)
+	}
+}
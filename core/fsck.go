@@ -0,0 +1,335 @@
+package core
+
+import (
+	"encoding/hex"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A problem found by fsck. Kind is one of "missing", "broken-link", "error",
+// "warning", "dangling" and "unreachable"; only the first three make the
+// repository invalid.
+type FsckProblem struct {
+	Kind    string `json:"kind"`
+	Type    string `json:"type"`
+	SHA1    string `json:"sha1"`
+	Message string `json:"message,omitempty"`
+}
+
+func (problem *FsckProblem) IsError() bool {
+	return problem.Kind == "missing" || problem.Kind == "broken-link" || problem.Kind == "error"
+}
+
+func (problem *FsckProblem) String() string {
+	switch problem.Kind {
+	case "error", "warning":
+		if problem.SHA1 == "" {
+			return problem.Kind + " in " + problem.Type + ": " + problem.Message
+		}
+		return problem.Kind + " in " + problem.Type + " " + problem.SHA1 + ": " + problem.Message
+	case "broken-link":
+		return "broken link from " + problem.Type + " " + problem.SHA1 + "\n              to " + problem.Message
+	}
+	return problem.Kind + " " + problem.Type + " " + problem.SHA1
+}
+
+type FsckReport struct {
+	ObjectsChecked int            `json:"objects_checked"`
+	Problems       []*FsckProblem `json:"problems"`
+}
+
+func (report *FsckReport) HasErrors() bool {
+	for _, problem := range report.Problems {
+		if problem.IsError() {
+			return true
+		}
+	}
+	return false
+}
+
+// an edge of the object graph, from a commit/tree/tag to an object it names
+type fsckLink struct {
+	sha1 string
+	typ  string
+}
+
+type Fsck struct {
+	rootDir string
+	types   map[string]string     // type of every object found in the store
+	links   map[string][]fsckLink // objects named by every parsed object
+	report  *FsckReport
+}
+
+func NewFsck(rootDir string) *Fsck {
+	fsck := new(Fsck)
+	fsck.rootDir = rootDir
+	fsck.types = make(map[string]string)
+	fsck.links = make(map[string][]fsckLink)
+	fsck.report = new(FsckReport)
+	fsck.report.Problems = make([]*FsckProblem, 0)
+	return fsck
+}
+
+var fsckIdentityPattern = regexp.MustCompile(`^[^<>\n]+ <[^<>\n]*> [0-9]+ [+-][0-9]{4}$`)
+
+var fsckValidModes = map[string]string{
+	"100644": "blob",
+	"100755": "blob",
+	"120000": "blob",
+	"40000":  "tree",
+	"160000": "commit",
+}
+
+func (fsck *Fsck) addProblem(kind string, typ string, sha1Name string, message string) {
+	fsck.report.Problems = append(fsck.report.Problems, &FsckProblem{kind, typ, sha1Name, message})
+}
+
+// Run checks every object in the store, then walks the object graph from all
+// refs, reflogs and the index to find missing, dangling and unreachable objects.
+// Unreachable objects which are not dangling are reported only when
+// show_unreachable is true. The objects which those of the promisor packs of
+// a partial clone point to are not missing, but left out.
+func (fsck *Fsck) Run(show_unreachable bool) *FsckReport {
+	for _, sha1_name := range ListObjects(fsck.rootDir) {
+		fsck.checkObject(sha1_name)
+	}
+
+	reachable := fsck.reachableObjects(fsck.checkRoots())
+
+	// an unreachable object is dangling if no other object names it
+	referenced := make(map[string]bool)
+	for _, links := range fsck.links {
+		for _, link := range links {
+			referenced[link.sha1] = true
+		}
+	}
+	unreachable := make([]string, 0)
+	for sha1_name := range fsck.types {
+		if !reachable[sha1_name] {
+			unreachable = append(unreachable, sha1_name)
+		}
+	}
+	sort.Strings(unreachable)
+	for _, sha1_name := range unreachable {
+		if show_unreachable {
+			fsck.addProblem("unreachable", fsck.types[sha1_name], sha1_name, "")
+		} else if !referenced[sha1_name] {
+			fsck.addProblem("dangling", fsck.types[sha1_name], sha1_name, "")
+		}
+	}
+	return fsck.report
+}
+
+func (fsck *Fsck) checkObject(sha1_name string) {
+	fsck.report.ObjectsChecked++
+	obj, err := ReadObject(fsck.rootDir, sha1_name)
+	if err != nil {
+		fsck.types[sha1_name] = "unknown"
+		fsck.addProblem("error", "unknown", sha1_name, err.Error())
+		return
+	}
+	fsck.types[sha1_name] = obj.typ
+
+	switch obj.typ {
+	case "blob":
+	case "tree":
+		tree := NewTreeObject(fsck.rootDir)
+		tree.Obj = *obj
+		if err := tree.parse(); err != nil {
+			fsck.addProblem("error", "tree", sha1_name, err.Error())
+			return
+		}
+		fsck.checkTree(sha1_name, tree)
+	case "commit":
+		commit := NewCommitObject(fsck.rootDir)
+		commit.Obj = *obj
+		if err := commit.parse(); err != nil {
+			fsck.addProblem("error", "commit", sha1_name, err.Error())
+			return
+		}
+		fsck.checkCommit(sha1_name, commit)
+	case "tag":
+		tag := NewTagObject(fsck.rootDir)
+		tag.Obj = *obj
+		if err := tag.parse(); err != nil {
+			fsck.addProblem("error", "tag", sha1_name, err.Error())
+			return
+		}
+		fsck.checkTag(sha1_name, tag)
+	default:
+		fsck.addProblem("error", obj.typ, sha1_name, "unknown object type '"+obj.typ+"'")
+	}
+}
+
+// Tree entries are sorted by name, where the name of a subtree is compared as if it ended with '/'.
+func treeEntrySortKey(entry *TreeEntry) string {
+	if entry.FileType == "40000" || entry.FileType == "040000" {
+		return entry.FileName + "/"
+	}
+	return entry.FileName
+}
+
+func (fsck *Fsck) checkTree(sha1_name string, tree *TreeObject) {
+	has_zero_padded_mode := false
+	names := make(map[string]bool)
+	for i, entry := range tree.Entries {
+		if entry.FileName == "" || entry.FileName == "." || entry.FileName == ".." || strings.EqualFold(entry.FileName, ".git") || strings.Contains(entry.FileName, "/") {
+			fsck.addProblem("error", "tree", sha1_name, "invalid entry name '"+entry.FileName+"'")
+		}
+		if names[entry.FileName] {
+			fsck.addProblem("error", "tree", sha1_name, "duplicateEntries: contains duplicate file entries")
+		}
+		names[entry.FileName] = true
+		if i > 0 && treeEntrySortKey(tree.Entries[i-1]) > treeEntrySortKey(entry) {
+			fsck.addProblem("error", "tree", sha1_name, "treeNotSorted: not properly sorted")
+		}
+
+		mode := entry.FileType
+		if mode == "040000" {
+			has_zero_padded_mode = true
+			mode = "40000"
+		}
+		typ, ok := fsckValidModes[mode]
+		if !ok {
+			fsck.addProblem("error", "tree", sha1_name, "badFilemode: contains bad file mode '"+entry.FileType+"'")
+			continue
+		}
+		// gitlinks name commits of other repositories
+		if typ == "commit" {
+			continue
+		}
+		fsck.links[sha1_name] = append(fsck.links[sha1_name], fsckLink{hex.EncodeToString(entry.HashedFilename), typ})
+	}
+	if has_zero_padded_mode {
+		fsck.addProblem("warning", "tree", sha1_name, "zeroPaddedFilemode: contains zero-padded file modes")
+	}
+}
+
+func isValidSHA1Name(sha1_name string) bool {
+	_, err := hex.DecodeString(sha1_name)
+	return err == nil && len(sha1_name) == 40
+}
+
+func (fsck *Fsck) checkCommit(sha1_name string, commit *CommitObject) {
+	if !isValidSHA1Name(commit.tree) {
+		fsck.addProblem("error", "commit", sha1_name, "badTreeSha1: invalid 'tree' line format - bad sha1")
+	} else {
+		fsck.links[sha1_name] = append(fsck.links[sha1_name], fsckLink{commit.tree, "tree"})
+	}
+	// the parents of a shallow commit are not in the repository
+	is_shallow := ReadShallow(fsck.rootDir)[sha1_name]
+	for _, parent := range commit.parents {
+		if !isValidSHA1Name(parent) {
+			fsck.addProblem("error", "commit", sha1_name, "badParentSha1: invalid 'parent' line format - bad sha1")
+			continue
+		}
+		if !is_shallow {
+			fsck.links[sha1_name] = append(fsck.links[sha1_name], fsckLink{parent, "commit"})
+		}
+	}
+	if !fsckIdentityPattern.MatchString(commit.author) {
+		fsck.addProblem("error", "commit", sha1_name, "badAuthor: invalid author line '"+commit.author+"'")
+	}
+	if !fsckIdentityPattern.MatchString(commit.committer) {
+		fsck.addProblem("error", "commit", sha1_name, "badCommitter: invalid committer line '"+commit.committer+"'")
+	}
+}
+
+func (fsck *Fsck) checkTag(sha1_name string, tag *TagObject) {
+	if !isValidSHA1Name(tag.object) {
+		fsck.addProblem("error", "tag", sha1_name, "badObjectSha1: invalid 'object' line format - bad sha1")
+	} else {
+		fsck.links[sha1_name] = append(fsck.links[sha1_name], fsckLink{tag.object, tag.objectType})
+	}
+	if tag.tagger == "" {
+		fsck.addProblem("warning", "tag", sha1_name, "missingTaggerEntry: invalid format - expected 'tagger' line")
+	} else if !fsckIdentityPattern.MatchString(tag.tagger) {
+		fsck.addProblem("error", "tag", sha1_name, "badTagger: invalid tagger line '"+tag.tagger+"'")
+	}
+}
+
+// RootObjects returns every object named by HEAD, the refs, the reflogs and the index.
+// When the index is corrupt, the objects named by the rest are returned
+// along with the error.
+func RootObjects(rootDir string) (map[string]string, error) {
+	roots := make(map[string]string)
+	if sha1_name, ok := ReadRef(rootDir, "HEAD"); ok {
+		roots[sha1_name] = "HEAD"
+	}
+	for _, ref := range ListRefs(rootDir) {
+		roots[ref.SHA1] = ref.Name
+	}
+	null_sha1 := strings.Repeat("0", 40)
+	for _, name := range ListReflogs(rootDir) {
+		for _, entry := range ReadReflog(rootDir, name) {
+			for _, sha1_name := range []string{entry.OldSHA1, entry.NewSHA1} {
+				if sha1_name != null_sha1 {
+					if _, ok := roots[sha1_name]; !ok {
+						roots[sha1_name] = name + "@{reflog}"
+					}
+				}
+			}
+		}
+	}
+	index := NewIndex(rootDir)
+	if err := index.Read(); err != nil {
+		return roots, err
+	}
+	for _, entry := range index.Entries() {
+		roots[hex.EncodeToString(entry.Obj_name)] = "index"
+	}
+	if cache_tree := index.CacheTree(); cache_tree != nil && cache_tree.IsValid() {
+		roots[hex.EncodeToString(cache_tree.HashedFilename)] = "index"
+	}
+	return roots, nil
+}
+
+func (fsck *Fsck) checkRoots() []string {
+	roots, err := RootObjects(fsck.rootDir)
+	if err != nil {
+		fsck.addProblem("error", "index", "", err.Error())
+	}
+	root_names := make([]string, 0, len(roots))
+	for sha1_name, source := range roots {
+		if _, ok := fsck.types[sha1_name]; !ok {
+			fsck.addProblem("error", "ref", sha1_name, source+": invalid sha1 pointer "+sha1_name)
+			continue
+		}
+		root_names = append(root_names, sha1_name)
+	}
+	sort.Strings(root_names)
+	return root_names
+}
+
+func (fsck *Fsck) reachableObjects(roots []string) map[string]bool {
+	reachable := make(map[string]bool)
+	missing := make(map[string]bool)
+	stack := append([]string{}, roots...)
+	for len(stack) != 0 {
+		sha1_name := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if reachable[sha1_name] {
+			continue
+		}
+		reachable[sha1_name] = true
+		for _, link := range fsck.links[sha1_name] {
+			if _, ok := fsck.types[link.sha1]; !ok {
+				if isPromisorObject(fsck.rootDir, sha1_name) {
+					continue
+				}
+				fsck.addProblem("broken-link", fsck.types[sha1_name], sha1_name, link.typ+" "+link.sha1)
+				if !missing[link.sha1] {
+					missing[link.sha1] = true
+					fsck.addProblem("missing", link.typ, link.sha1, "")
+				}
+				continue
+			}
+			if fsck.types[link.sha1] != link.typ {
+				fsck.addProblem("error", fsck.types[sha1_name], sha1_name, "names "+link.sha1+" as a "+link.typ+", but it is a "+fsck.types[link.sha1])
+			}
+			stack = append(stack, link.sha1)
+		}
+	}
+	return reachable
+}
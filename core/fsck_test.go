@@ -0,0 +1,51 @@
+package core
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func fsckProblems(report *FsckReport) string {
+	lines := make([]string, 0, len(report.Problems))
+	for _, problem := range report.Problems {
+		lines = append(lines, problem.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestFsck(t *testing.T) {
+	rootDir := newTestObjectStore(t)
+	blob := writeTestObject(t, rootDir, "blob", "a\n")
+	tree := writeTestObject(t, rootDir, "tree", testTreeEntry(t, "100644", "a.txt", blob))
+	commit := writeTestObject(t, rootDir, "commit", testCommitContent(tree, nil, "first\n"))
+	writeTestRef(t, rootDir, "refs/heads/master", commit)
+
+	report := NewFsck(rootDir).Run(false)
+	if len(report.Problems) != 0 || report.ObjectsChecked != 3 {
+		t.Errorf("checked %d objects and found:\n%s", report.ObjectsChecked, fsckProblems(report))
+	}
+
+	dangling := writeTestObject(t, rootDir, "blob", "dangling\n")
+	missing := strings.Repeat("1", 40)
+	child := writeTestObject(t, rootDir, "commit", testCommitContent(tree, []string{commit, missing}, "second\n"))
+	writeTestRef(t, rootDir, "refs/heads/topic", child)
+	// a corrupt index is reported, and the rest is checked all the same
+	if err := ioutil.WriteFile(rootDir+"/.git/index", []byte("not an index, but long enough to hold a header"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report = NewFsck(rootDir).Run(false)
+	want := strings.Join([]string{
+		"error in index: invalid index signature",
+		"broken link from commit " + child + "\n              to commit " + missing,
+		"missing commit " + missing,
+		"dangling blob " + dangling,
+	}, "\n")
+	if got := fsckProblems(report); got != want {
+		t.Errorf("found:\n%s\nwant:\n%s", got, want)
+	}
+	if !report.HasErrors() {
+		t.Errorf("the report has no errors")
+	}
+}
@@ -120,14 +120,22 @@ func (graph *Graph) dfs_visit(node *GraphNode, before_callback func(*GraphNode),
 }
 
 func (graph *Graph) BFS(source_node *GraphNode, before_callback func(*GraphNode), after_callback func(*GraphNode)) {
+	graph.MultiSourceBFS([]*GraphNode{source_node}, before_callback, after_callback)
+}
+
+func (graph *Graph) MultiSourceBFS(source_nodes []*GraphNode, before_callback func(*GraphNode), after_callback func(*GraphNode)) {
 	// Initialization
 	for i := 0; i < len(graph.adjacencyList); i++ {
 		graph.adjacencyList[i].color = White
 	}
-	source_node.color = Gray
 
 	queue := NewQueue(50)
-	queue.Enqueue(source_node)
+	for _, source_node := range source_nodes {
+		if source_node.color == White {
+			source_node.color = Gray
+			queue.Enqueue(source_node)
+		}
+	}
 	for !queue.IsEmpty() {
 		item := queue.Dequeue()
 		node := item.(*GraphNode)
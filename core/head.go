@@ -18,7 +18,7 @@ func NewHEAD(rootDir string) *HEAD {
 	return head
 }
 func (head *HEAD) Read() {
-	content, err := ioutil.ReadFile(head.rootDir + "/.git/HEAD")
+	content, err := ioutil.ReadFile(GitDir(head.rootDir) + "/HEAD")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -53,7 +53,7 @@ func (head *HEAD) PointsTo(name string, isBranchName bool) {
 	} else {
 		content = head.Content
 	}
-	err := ioutil.WriteFile(head.rootDir+"/.git/HEAD", []byte(content), 0644)
+	err := ioutil.WriteFile(GitDir(head.rootDir)+"/HEAD", []byte(content), 0644)
 	if err != nil {
 		log.Fatal(err)
 	}
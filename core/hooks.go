@@ -0,0 +1,64 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// hooksDir returns the directory hooks are looked up in: core.hooksPath,
+// relative to the top of the working tree, or .git/hooks.
+func (regit *ReGit) hooksDir() string {
+	hooks_path := regit.Config["core.hookspath"]
+	if hooks_path == "" {
+		return GitDir(regit.RootDir) + "/hooks"
+	}
+	if strings.HasPrefix(hooks_path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			hooks_path = home + hooks_path[1:]
+		}
+	}
+	if !filepath.IsAbs(hooks_path) {
+		hooks_path = regit.RootDir + "/" + hooks_path
+	}
+	return hooks_path
+}
+
+// runHook runs a hook the way git does: from the top of the working tree,
+// with args, without stdin, and with its output sent to stderr. A hook which
+// does not exist is skipped; one which is not executable is skipped with a
+// hint. The returned error tells whether the hook failed.
+func (regit *ReGit) runHook(name string, env []string, args ...string) error {
+	path := regit.hooksDir() + "/" + name
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return nil
+	}
+	if info.Mode()&0111 == 0 {
+		fmt.Fprintln(os.Stderr, "hint: The '"+path+"' hook was ignored because it's not set as executable.")
+		return nil
+	}
+
+	cmd := exec.Command(path, args...)
+	cmd.Dir = regit.RootDir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return errors.New("the " + name + " hook failed")
+	}
+	return nil
+}
+
+// the environment of the hooks run while committing; GIT_EDITOR tells them
+// whether the user gets to edit the message
+func (regit *ReGit) commitHookEnv(use_editor bool) []string {
+	env := []string{"GIT_INDEX_FILE=" + GitDir(regit.RootDir) + "/index"}
+	if !use_editor {
+		env = append(env, "GIT_EDITOR=:")
+	}
+	return env
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func writeTestHook(t *testing.T, dir string, name string, script string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dir+"/"+name, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunHook(t *testing.T) {
+	isolateHome(t)
+	rootDir := t.TempDir()
+	regit := newTestRepository(t, rootDir)
+
+	if err := regit.runHook("pre-commit", nil); err != nil {
+		t.Errorf("a missing hook failed: %v", err)
+	}
+	writeTestHook(t, rootDir+"/.git/hooks", "pre-commit", "exit 1\n")
+	if err := regit.runHook("pre-commit", nil); err == nil {
+		t.Errorf("a failing hook did not fail")
+	}
+	if err := os.Chmod(rootDir+"/.git/hooks/pre-commit", 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := regit.runHook("pre-commit", nil); err != nil {
+		t.Errorf("a hook which is not executable was run: %v", err)
+	}
+
+	// hooks run from the top of the working tree with the arguments and environment given
+	regit.Config["core.hookspath"] = "my-hooks"
+	writeTestHook(t, rootDir+"/my-hooks", "post-checkout", `echo "$1 $GIT_TEST_VALUE" > output`+"\n")
+	if err := regit.runHook("post-checkout", []string{"GIT_TEST_VALUE=value"}, "argument"); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := ioutil.ReadFile(rootDir + "/output"); err != nil || string(content) != "argument value\n" {
+		t.Errorf("the hook wrote %q, %v", content, err)
+	}
+}
+
+func TestCommitHooks(t *testing.T) {
+	isolateHome(t)
+	rootDir := t.TempDir()
+	regit := newTestRepository(t, rootDir)
+	writeTestHook(t, rootDir+"/.git/hooks", "commit-msg", `printf '\nSigned-off-by: A U Thor\n' >> "$1"`+"\n")
+	writeTestHook(t, rootDir+"/.git/hooks", "prepare-commit-msg", `echo "$2" > prepare-source`+"\n")
+
+	stageFile(t, rootDir, "a.txt", "a\n")
+	captureOutput(t, func() {
+		regit.Commmit(&CommitOptions{Message: "subject", HasMessage: true})
+	})
+	if commit := newTestCommit(t, rootDir, testRef(t, rootDir, "HEAD")); commit.message != "subject\n\nSigned-off-by: A U Thor" {
+		t.Errorf("the message is %q", commit.message)
+	}
+	if content, _ := ioutil.ReadFile(rootDir + "/prepare-source"); string(content) != "message\n" {
+		t.Errorf("prepare-commit-msg got the source %q", content)
+	}
+
+	// --no-verify skips commit-msg
+	stageFile(t, rootDir, "b.txt", "b\n")
+	captureOutput(t, func() {
+		regit.Commmit(&CommitOptions{Message: "unsigned", HasMessage: true, NoVerify: true})
+	})
+	if commit := newTestCommit(t, rootDir, testRef(t, rootDir, "HEAD")); commit.message != "unsigned" {
+		t.Errorf("the message with --no-verify is %q", commit.message)
+	}
+}
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// httpServer serves the repositories under a directory with git's smart
+// HTTP protocol, so that `git clone http://host/<repository>` and pushes
+// work against it. Only protocol v0 is spoken; clients asking for v2 fall
+// back to it.
+type httpServer struct {
+	dir string
+	// pushes are received one at a time
+	push_lock sync.Mutex
+}
+
+// ServeHTTP listens on address, e.g. ":8080", and serves the repositories
+// under dir until it fails.
+func ServeHTTP(address string, dir string) error {
+	server := &httpServer{dir: dir}
+	log.Println("Serving " + dir + " on " + address)
+	return http.ListenAndServe(address, server)
+}
+
+// servedRepository finds the repository a path names under the served
+// directory dir, which it may not lead out of. Like git's servers, "repo"
+// finds "repo.git" too.
+func servedRepository(dir string, url_path string) (string, bool) {
+	base := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+url_path)))
+	for _, rootDir := range []string{base, base + ".git"} {
+		if filepath.Base(rootDir) == ".git" && !IsBareRepository(rootDir) {
+			rootDir = filepath.Dir(rootDir)
+		}
+		if _, err := os.Stat(GitDir(rootDir) + "/HEAD"); err == nil {
+			return rootDir, true
+		}
+	}
+	return "", false
+}
+
+// receivePackEnabled tells whether a repository accepts pushes, which its
+// config may turn off with http.receivepack = false.
+func receivePackEnabled(rootDir string) bool {
+	config := make(map[string]string)
+	if err := ReadConfigFile(GitDir(rootDir)+"/config", config); err != nil {
+		return false
+	}
+	value, ok := config["http.receivepack"]
+	return !ok || IsConfigTrue(value)
+}
+
+func (server *httpServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	var repository_path, service string
+	switch {
+	case request.Method == "GET" && strings.HasSuffix(request.URL.Path, "/info/refs"):
+		repository_path = strings.TrimSuffix(request.URL.Path, "/info/refs")
+		service = request.URL.Query().Get("service")
+	case request.Method == "POST" && strings.HasSuffix(request.URL.Path, "/git-upload-pack"):
+		repository_path = strings.TrimSuffix(request.URL.Path, "/git-upload-pack")
+		service = "git-upload-pack"
+	case request.Method == "POST" && strings.HasSuffix(request.URL.Path, "/git-receive-pack"):
+		repository_path = strings.TrimSuffix(request.URL.Path, "/git-receive-pack")
+		service = "git-receive-pack"
+	default:
+		http.NotFound(writer, request)
+		return
+	}
+	rootDir, ok := servedRepository(server.dir, repository_path)
+	if !ok {
+		http.NotFound(writer, request)
+		return
+	}
+	switch service {
+	case "git-upload-pack":
+	case "git-receive-pack":
+		if !receivePackEnabled(rootDir) {
+			http.Error(writer, "pushing is disabled", http.StatusForbidden)
+			return
+		}
+	default:
+		// the dumb protocol is not served
+		http.Error(writer, "only the smart HTTP protocol is supported", http.StatusForbidden)
+		return
+	}
+	serve := UploadPack
+	if service == "git-receive-pack" {
+		serve = ReceivePack
+		server.push_lock.Lock()
+		defer server.push_lock.Unlock()
+	}
+
+	writer.Header().Set("Cache-Control", "no-cache")
+	if request.Method == "GET" {
+		advertisement := new(bytes.Buffer)
+		advertisement.WriteString(PktLine("# service="+service+"\n") + pktFlush)
+		if err := serve(rootDir, nil, advertisement, true, true); err != nil {
+			log.Println(rootDir + ": " + err.Error())
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/x-"+service+"-advertisement")
+		writer.Write(advertisement.Bytes())
+		return
+	}
+
+	var body io.Reader = request.Body
+	if request.Header.Get("Content-Encoding") == "gzip" {
+		gzip_reader, err := gzip.NewReader(request.Body)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gzip_reader.Close()
+		body = gzip_reader
+	}
+	writer.Header().Set("Content-Type", "application/x-"+service+"-result")
+	if err := serve(rootDir, body, writer, true, false); err != nil {
+		log.Println(rootDir + ": " + err.Error())
+	}
+}
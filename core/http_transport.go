@@ -0,0 +1,285 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// the agent regit-go announces to servers
+const userAgent = "regit-go/1.0"
+
+// the most commits a fetch tells the server it has
+const maxHaves = 256
+
+// httpTransport speaks git's smart HTTP protocol: the refs are advertised by
+// GET <url>/info/refs?service=<service>, and the service is run by POST
+// <url>/<service>. Fetching uses protocol v2 unless version is 0 or the
+// server does not know it; pushing always uses protocol v0.
+type httpTransport struct {
+	url          string
+	client       *http.Client
+	version      int
+	capabilities map[string]string // of the last advertisement
+	push_refs    *RemoteRefs
+}
+
+func newHTTPTransport(url string, version int) *httpTransport {
+	transport := new(httpTransport)
+	transport.url = strings.TrimSuffix(url, "/")
+	transport.client = http.DefaultClient
+	transport.version = version
+	return transport
+}
+
+func (transport *httpTransport) request(method string, path string, content_type string, body []byte) (io.ReadCloser, error) {
+	request, err := http.NewRequest(method, transport.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", userAgent)
+	if content_type != "" {
+		request.Header.Set("Content-Type", content_type)
+	}
+	if transport.version == 2 && !strings.Contains(path, "receive-pack") {
+		request.Header.Set("Git-Protocol", "version=2")
+	}
+	response, err := transport.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	switch response.StatusCode {
+	case http.StatusOK:
+		return response.Body, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		response.Body.Close()
+		return nil, errors.New("Authentication failed for '" + transport.url + "'")
+	case http.StatusNotFound:
+		response.Body.Close()
+		return nil, errors.New("repository '" + transport.url + "' not found")
+	}
+	response.Body.Close()
+	return nil, errors.New("unable to access '" + transport.url + "': the server answered " + response.Status)
+}
+
+// parseCapabilities reads the capabilities of an advertisement, e.g.
+// "side-band-64k symref=HEAD:refs/heads/master".
+func parseCapabilities(capabilities map[string]string, line string) {
+	for _, capability := range strings.Fields(line) {
+		name, value := capability, ""
+		if equal_index := strings.Index(capability, "="); equal_index != -1 {
+			name, value = capability[:equal_index], capability[equal_index+1:]
+		}
+		// several symrefs may be given; only the one of HEAD is kept
+		if name == "symref" && !strings.HasPrefix(value, "HEAD:") {
+			continue
+		}
+		capabilities[name] = value
+	}
+}
+
+// Refs reads the advertisement of the service. In protocol v2 it lists the
+// capabilities of the server only, so the refs are asked for with ls-refs.
+func (transport *httpTransport) Refs(for_push bool) (*RemoteRefs, error) {
+	service := "git-upload-pack"
+	if for_push {
+		service = "git-receive-pack"
+	}
+	body, err := transport.request("GET", "/info/refs?service="+service, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	pkt_reader := NewPktReader(body)
+	transport.capabilities = make(map[string]string)
+
+	lines, err := pkt_reader.ReadLines()
+	if err != nil {
+		return nil, errors.New("dumb HTTP protocol is not supported: " + err.Error())
+	}
+	if len(lines) == 1 && strings.HasPrefix(lines[0], "# service=") {
+		if lines, err = pkt_reader.ReadLines(); err != nil {
+			return nil, err
+		}
+	}
+	if len(lines) != 0 && lines[0] == "version 2" {
+		for _, line := range lines[1:] {
+			parseCapabilities(transport.capabilities, line)
+		}
+		return transport.listRefs()
+	}
+	if len(lines) != 0 && lines[0] == "version 1" {
+		lines = lines[1:]
+	}
+	transport.version = 0
+
+	remote_refs, err := parseAdvertisement(lines, transport.capabilities)
+	if err != nil {
+		return nil, err
+	}
+	if for_push {
+		transport.push_refs = remote_refs
+	}
+	return remote_refs, nil
+}
+
+// v2Command runs a protocol v2 command with its arguments.
+func (transport *httpTransport) v2Command(command string, arguments []string) (io.ReadCloser, error) {
+	request := PktLine("command="+command+"\n") + PktLine("agent="+userAgent+"\n") + pktDelim
+	for _, argument := range arguments {
+		request += PktLine(argument + "\n")
+	}
+	request += pktFlush
+	return transport.request("POST", "/git-upload-pack", "application/x-git-upload-pack-request", []byte(request))
+}
+
+// listRefs lists the branches and tags of the remote with the ls-refs
+// command of protocol v2.
+func (transport *httpTransport) listRefs() (*RemoteRefs, error) {
+	arguments := []string{"symrefs", "peel", "ref-prefix HEAD", "ref-prefix refs/heads/", "ref-prefix refs/tags/"}
+	if strings.Contains(" "+transport.capabilities["ls-refs"]+" ", " unborn ") {
+		arguments = append(arguments, "unborn")
+	}
+	body, err := transport.v2Command("ls-refs", arguments)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	lines, err := NewPktReader(body).ReadLines()
+	if err != nil {
+		return nil, err
+	}
+
+	remote_refs := &RemoteRefs{Peeled: make(map[string]string)}
+	for _, line := range lines {
+		fields := strings.Split(line, " ")
+		if len(fields) < 2 {
+			return nil, errors.New("protocol error: unexpected ref line " + strconv.Quote(line))
+		}
+		for _, attribute := range fields[2:] {
+			if strings.HasPrefix(attribute, "symref-target:") && fields[1] == "HEAD" {
+				remote_refs.Head = strings.TrimPrefix(attribute[len("symref-target:"):], "refs/heads/")
+			} else if strings.HasPrefix(attribute, "peeled:") {
+				remote_refs.Peeled[fields[1]] = attribute[len("peeled:"):]
+			}
+		}
+		switch {
+		case fields[0] == "unborn":
+		case fields[1] == "HEAD":
+			remote_refs.HeadSHA1 = fields[0]
+		default:
+			remote_refs.Refs = append(remote_refs.Refs, &Ref{fields[1], fields[0]})
+		}
+	}
+	return remote_refs, nil
+}
+
+// Fetch asks for the objects of wants, telling the server which commits the
+// repository has so that it can leave out what they point to, and unpacks
+// the pack it sends.
+func (transport *httpTransport) Fetch(rootDir string, wants []string, deepen *Deepen, filter *ObjectFilter) error {
+	wants = fetchWants(rootDir, wants, deepen)
+	if len(wants) == 0 {
+		return nil
+	}
+	if transport.capabilities == nil {
+		if _, err := transport.Refs(false); err != nil {
+			return err
+		}
+	}
+	_, shallow_supported := transport.capabilities["shallow"]
+	_, filter_supported := transport.capabilities["filter"]
+	if transport.version == 2 {
+		shallow_supported = strings.Contains(" "+transport.capabilities["fetch"]+" ", " shallow ")
+		filter_supported = strings.Contains(" "+transport.capabilities["fetch"]+" ", " filter ")
+	}
+	if err := checkShallowSupport(rootDir, deepen, shallow_supported); err != nil {
+		return err
+	}
+	filter = checkFilterSupport(filter, filter_supported)
+	haves := haveCommits(rootDir, maxHaves)
+
+	var body io.ReadCloser
+	var err error
+	if transport.version == 2 {
+		arguments := []string{"ofs-delta", "no-progress", "include-tag"}
+		for _, want := range wants {
+			arguments = append(arguments, "want "+want)
+		}
+		arguments = append(arguments, shallowArguments(rootDir, deepen)...)
+		if filter != nil {
+			arguments = append(arguments, "filter "+filter.Spec)
+		}
+		for _, have := range haves {
+			arguments = append(arguments, "have "+have)
+		}
+		arguments = append(arguments, "done")
+		if body, err = transport.v2Command("fetch", arguments); err != nil {
+			return err
+		}
+	} else {
+		request := fetchRequest(rootDir, transport.capabilities, wants, haves, deepen, filter)
+		if body, err = transport.request("POST", "/git-upload-pack", "application/x-git-upload-pack-request", []byte(request)); err != nil {
+			return err
+		}
+	}
+	defer body.Close()
+
+	pkt_reader := NewPktReader(body)
+	if transport.version != 2 {
+		return readPack(rootDir, pkt_reader, transport.capabilities, deepen)
+	}
+	// but for the shallow commits, the sections before the pack are of no
+	// use once "done" is sent
+	var shallow, unshallow []string
+	for {
+		line, err := pkt_reader.ReadLine()
+		if err == errPktDelim || err == errPktFlush {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if line == "packfile" {
+			break
+		}
+		if line == "shallow-info" {
+			shallow, unshallow, err = readShallowInfo(pkt_reader)
+		} else {
+			_, err = pkt_reader.ReadLines()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err = storePack(rootDir, newSideBandReader(pkt_reader, os.Stderr)); err != nil {
+		return err
+	}
+	return UpdateShallow(rootDir, shallow, unshallow)
+}
+
+// Push sends the commands to update the refs of the remote, followed by a
+// pack of the objects it is missing, and reads the report of what it did.
+func (transport *httpTransport) Push(rootDir string, updates []*refUpdate) (map[string]string, error) {
+	remote_refs := transport.push_refs
+	if remote_refs == nil {
+		var err error
+		if remote_refs, err = transport.Refs(true); err != nil {
+			return nil, err
+		}
+	}
+	request, err := pushRequest(rootDir, remote_refs, transport.capabilities, updates)
+	if err != nil {
+		return nil, err
+	}
+	body, err := transport.request("POST", "/git-receive-pack", "application/x-git-receive-pack-request", request)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return readPushReport(body, transport.capabilities)
+}
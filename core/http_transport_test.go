@@ -0,0 +1,199 @@
+package core
+
+import (
+	"io/ioutil"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// newGitHTTPBackend serves the repositories under dir with git's own
+// http-backend, which speaks protocol v0 and v2. The test is skipped without
+// git.
+func newGitHTTPBackend(t *testing.T, dir string) *httptest.Server {
+	git_path, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is not installed")
+	}
+	server := httptest.NewServer(&cgi.Handler{
+		Path: git_path,
+		Args: []string{"http-backend"},
+		Env:  []string{"GIT_PROJECT_ROOT=" + dir, "GIT_HTTP_EXPORT_ALL=1"},
+	})
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newRegitHTTPServer serves the repositories under dir with httpServer,
+// which speaks protocol v0 only.
+func newRegitHTTPServer(t *testing.T, dir string) *httptest.Server {
+	server := httptest.NewServer(&httpServer{dir: dir})
+	t.Cleanup(server.Close)
+	return server
+}
+
+// the servers the transport is tested against, by name
+var testHTTPServers = map[string]func(*testing.T, string) *httptest.Server{
+	"git":   newGitHTTPBackend,
+	"regit": newRegitHTTPServer,
+}
+
+// useProtocolVersion sets protocol.version in the ~/.gitconfig of a test,
+// whose HOME has been isolated.
+func useProtocolVersion(t *testing.T, version string) {
+	if err := ioutil.WriteFile(os.Getenv("HOME")+"/.gitconfig", []byte("[protocol]\n\tversion = "+version+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHTTPRefs(t *testing.T) {
+	for name, newServer := range testHTTPServers {
+		t.Run(name, func(t *testing.T) {
+			isolateHome(t)
+			dir := t.TempDir()
+			newTestRepository(t, dir+"/origin")
+			master := commitFile(t, dir+"/origin", "a.txt", "a\n")
+			server := newServer(t, dir)
+			// git's http-backend refuses pushes from anonymous users
+			ioutil.WriteFile(dir+"/origin/.git/config", []byte("[http]\n\treceivepack = true\n"), 0644)
+
+			for _, version := range []int{0, 2} {
+				for _, for_push := range []bool{false, true} {
+					transport := newHTTPTransport(server.URL+"/origin", version)
+					remote_refs, err := transport.Refs(for_push)
+					if err != nil {
+						t.Fatal(err)
+					}
+					// pushing always uses protocol v0, and so does fetching
+					// from a server which only knows it
+					want := version
+					if for_push || name == "regit" {
+						want = 0
+					}
+					if transport.version != want {
+						t.Errorf("v%d, for_push=%v: the transport uses v%d", version, for_push, transport.version)
+					}
+					if sha1_name, ok := remote_refs.Lookup("refs/heads/master"); !ok || sha1_name != master {
+						t.Errorf("v%d, for_push=%v: refs/heads/master is %q, want %s", version, for_push, sha1_name, master)
+					}
+					if !for_push && (remote_refs.Head != "master" || remote_refs.HeadSHA1 != master) {
+						t.Errorf("v%d: HEAD is %q at %q, want master at %s", version, remote_refs.Head, remote_refs.HeadSHA1, master)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPRefsV2Capabilities(t *testing.T) {
+	isolateHome(t)
+	dir := t.TempDir()
+	newTestRepository(t, dir+"/origin")
+	commitFile(t, dir+"/origin", "a.txt", "a\n")
+	transport := newHTTPTransport(newGitHTTPBackend(t, dir).URL+"/origin", 2)
+	if _, err := transport.Refs(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := transport.capabilities["ls-refs"]; !ok {
+		t.Errorf("the v2 capabilities %v lack ls-refs", transport.capabilities)
+	}
+}
+
+func TestHTTPCloneFetchPush(t *testing.T) {
+	for _, test := range []struct {
+		server  string
+		version string
+	}{{"git", "0"}, {"git", "2"}, {"regit", "2"}} {
+		t.Run(test.server+"/v"+test.version, func(t *testing.T) {
+			isolateHome(t)
+			useProtocolVersion(t, test.version)
+			dir := t.TempDir()
+			newTestRepository(t, dir+"/origin")
+			first := commitFile(t, dir+"/origin", "a.txt", "a\n")
+			server := testHTTPServers[test.server](t, dir)
+			// git's http-backend refuses pushes from anonymous users
+			ioutil.WriteFile(dir+"/origin/.git/config", []byte("[http]\n\treceivepack = true\n"), 0644)
+
+			captureOutput(t, func() {
+				NewReGit(dir).Clone(server.URL+"/origin", "clone", false, "", nil, nil)
+			})
+			clone_dir := dir + "/clone"
+			if sha1_name := testRef(t, clone_dir, "refs/remotes/origin/master"); sha1_name != first {
+				t.Errorf("the cloned origin/master is %s, want %s", sha1_name, first)
+			}
+			if sha1_name := testRef(t, clone_dir, "HEAD"); sha1_name != first {
+				t.Errorf("the cloned HEAD is %s, want %s", sha1_name, first)
+			}
+			if content, err := ioutil.ReadFile(clone_dir + "/a.txt"); err != nil || string(content) != "a\n" {
+				t.Errorf("a.txt was not checked out: %q, %v", content, err)
+			}
+
+			second := commitFile(t, dir+"/origin", "b.txt", "b\n")
+			captureOutput(t, func() {
+				NewReGit(clone_dir).Fetch("origin", nil, nil)
+			})
+			if sha1_name := testRef(t, clone_dir, "refs/remotes/origin/master"); sha1_name != second {
+				t.Errorf("the fetched origin/master is %s, want %s", sha1_name, second)
+			}
+			if !ObjectExists(clone_dir, second) {
+				t.Errorf("commit %s was not fetched", second)
+			}
+
+			pushed := commitFile(t, clone_dir, "c.txt", "c\n")
+			captureOutput(t, func() {
+				NewReGit(clone_dir).Push("origin", []string{"master:topic"}, false)
+			})
+			if sha1_name := testRef(t, dir+"/origin", "refs/heads/topic"); sha1_name != pushed {
+				t.Errorf("the pushed topic is %s, want %s", sha1_name, pushed)
+			}
+			if !ObjectExists(dir+"/origin", pushed) {
+				t.Errorf("commit %s was not pushed", pushed)
+			}
+			if sha1_name := testRef(t, clone_dir, "refs/remotes/origin/topic"); sha1_name != pushed {
+				t.Errorf("origin/topic is %s, want %s", sha1_name, pushed)
+			}
+		})
+	}
+}
+
+// TestHTTPPushRejectsNonFastForward pushes a branch which has diverged from
+// the one of the remote. Push exits when an update is rejected, so it is run
+// by the test binary in a process of its own.
+func TestHTTPPushRejectsNonFastForward(t *testing.T) {
+	if clone_dir := os.Getenv("REGIT_TEST_PUSH_DIR"); clone_dir != "" {
+		NewReGit(clone_dir).Push("origin", nil, false)
+		return
+	}
+	isolateHome(t)
+	dir := t.TempDir()
+	newTestRepository(t, dir+"/origin")
+	commitFile(t, dir+"/origin", "a.txt", "a\n")
+	server := newRegitHTTPServer(t, dir)
+	captureOutput(t, func() {
+		NewReGit(dir).Clone(server.URL+"/origin", "clone", false, "", nil, nil)
+	})
+	clone_dir := dir + "/clone"
+
+	// both move on from the commit they share
+	remote_master := commitFile(t, dir+"/origin", "b.txt", "b\n")
+	captureOutput(t, func() {
+		NewReGit(clone_dir).Fetch("origin", nil, nil)
+	})
+	commitFile(t, clone_dir, "c.txt", "c\n")
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHTTPPushRejectsNonFastForward$")
+	cmd.Env = append(os.Environ(), "REGIT_TEST_PUSH_DIR="+clone_dir)
+	output, err := cmd.CombinedOutput()
+	if exit_error, ok := err.(*exec.ExitError); !ok || exit_error.ExitCode() != 1 {
+		t.Fatalf("push exited with %v, want status 1:\n%s", err, output)
+	}
+	if !strings.Contains(string(output), " ! [rejected]") || !strings.Contains(string(output), "master -> master (non-fast-forward)") {
+		t.Errorf("push did not report the rejection:\n%s", output)
+	}
+	if sha1_name := testRef(t, dir+"/origin", "refs/heads/master"); sha1_name != remote_master {
+		t.Errorf("the remote master moved to %s, want %s", sha1_name, remote_master)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"crypto/sha1"
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"io/ioutil"
 	"log"
 	"os"
@@ -96,11 +98,21 @@ func (entry *IndexEntry) Stage() uint16 {
 	return (entry.Flags >> 12) & 0x03
 }
 
+// An extension regit does not understand. It is kept verbatim so that
+// saving the index does not drop data written by other tools.
+type IndexExtension struct {
+	signature []byte
+	data      []byte
+}
+
 type Index struct {
-	header   IndexHeader
-	entries  []*IndexEntry
-	checksum []byte
-	rootDir  string
+	header      IndexHeader
+	entries     []*IndexEntry
+	cacheTree   *CacheTree
+	resolveUndo []*ResolveUndoEntry
+	extensions  []*IndexExtension
+	checksum    []byte
+	rootDir     string
 }
 
 func NewIndex(rootDir string) *Index {
@@ -122,6 +134,24 @@ func (index *Index) Entries() []*IndexEntry {
 	return index.entries
 }
 
+func (index *Index) CacheTree() *CacheTree {
+	return index.cacheTree
+}
+
+func (index *Index) SetCacheTree(cache_tree *CacheTree) {
+	index.cacheTree = cache_tree
+}
+
+// return true if some paths still have entries in stage 1-3
+func (index *Index) HasUnmergedEntries() bool {
+	for _, entry := range index.entries {
+		if entry.Stage() != 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func (index *Index) read_number_in_network_byte_order(content []byte, num interface{}) {
 	buf := bytes.NewBuffer(content)
 	err := binary.Read(buf, binary.BigEndian, num)
@@ -140,21 +170,27 @@ func (index *Index) transform_number_to_network_bytes(n interface{}) ([]byte, er
 	return buf.Bytes(), nil
 }
 
-func (index *Index) Read() {
-	content, err := ioutil.ReadFile(index.rootDir + "/.git/index")
+// Read reads the index file, if there is one. A corrupt index, e.g. one
+// whose checksum does not match, is returned as an error, a *ChecksumError
+// for the checksum.
+func (index *Index) Read() error {
+	content, err := ioutil.ReadFile(GitDir(index.rootDir) + "/index")
 	// index file is empty now
 	if err != nil {
-		return
+		return nil
+	}
+	if len(content) < 12+sha1.Size {
+		return errors.New("index file is too small")
 	}
 	index.header.signature = content[:4]
 	if !bytes.Equal(index.header.signature, []byte("DIRC")) {
-		log.Fatal("Invalid index signature")
+		return errors.New("invalid index signature")
 	}
 
 	var version_num uint32
 	index.read_number_in_network_byte_order(content[4:8], &version_num)
 	if version_num != 2 {
-		log.Fatal("Unknown index version")
+		return errors.New("unknown index version")
 	}
 	index.header.version_number = content[4:8]
 
@@ -182,7 +218,7 @@ func (index *Index) Read() {
 		buf := bytes.NewBuffer(content[current_index+62:])
 		entry.Path, err = buf.ReadBytes(byte(0))
 		if err != nil {
-			log.Fatal(err)
+			return errors.New("index entry is broken")
 		}
 
 		trailing_null_byte_count := 0
@@ -198,7 +234,69 @@ func (index *Index) Read() {
 		current_index = current_index + 62 + len(entry.Path) + trailing_null_byte_count
 		index.entries = append(index.entries, entry)
 	}
+
+	// extensions are stored between the last entry and the trailing checksum:
+	// a 4-byte signature, a 32-bit size and the extension data
+	for len(content)-current_index > sha1.Size {
+		if len(content)-current_index < 8+sha1.Size {
+			return errors.New("index extension is broken")
+		}
+		signature := content[current_index : current_index+4]
+		var size uint32
+		index.read_number_in_network_byte_order(content[current_index+4:current_index+8], &size)
+		data_start_index := current_index + 8
+		if data_start_index+int(size) > len(content)-sha1.Size {
+			return errors.New("index extension '" + string(signature) + "' is broken")
+		}
+		index.readExtension(signature, content[data_start_index:data_start_index+int(size)])
+		current_index = data_start_index + int(size)
+	}
 	index.checksum = content[current_index:]
+
+	return index.verifyChecksum(content[:current_index])
+}
+
+// The index ends with the SHA-1 of everything before it. An all-zero
+// checksum is written by git when index.skipHash is enabled.
+func (index *Index) verifyChecksum(content []byte) error {
+	if bytes.Equal(index.checksum, make([]byte, sha1.Size)) {
+		return nil
+	}
+	actual_checksum := sha1.Sum(content)
+	if !bytes.Equal(actual_checksum[:], index.checksum) {
+		return &ChecksumError{GitDir(index.rootDir) + "/index", hex.EncodeToString(index.checksum), hex.EncodeToString(actual_checksum[:])}
+	}
+	return nil
+}
+
+func (index *Index) readExtension(signature []byte, data []byte) {
+	switch string(signature) {
+	case "TREE":
+		index.cacheTree = ParseCacheTree(data)
+	case "REUC":
+		index.resolveUndo = ParseResolveUndo(data)
+	default:
+		// extensions whose signature starts with 'A'..'Z' are optional and
+		// may be ignored, any other one is required to read the index
+		if signature[0] < 'A' || signature[0] > 'Z' {
+			log.Fatal("Error: index uses '" + string(signature) + "' extension, which regit does not understand")
+		}
+		extension := new(IndexExtension)
+		extension.signature = signature
+		extension.data = data
+		index.extensions = append(index.extensions, extension)
+	}
+}
+
+func (index *Index) appendExtension(content []byte, signature string, data []byte) []byte {
+	size, err := index.transform_number_to_network_bytes(uint32(len(data)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	content = append(content, []byte(signature)...)
+	content = append(content, size...)
+	content = append(content, data...)
+	return content
 }
 
 func (index *Index) Save() {
@@ -246,10 +344,20 @@ func (index *Index) Save() {
 		}
 	}
 
+	if index.cacheTree != nil {
+		content = index.appendExtension(content, "TREE", index.cacheTree.Serialize())
+	}
+	if len(index.resolveUndo) != 0 {
+		content = index.appendExtension(content, "REUC", SerializeResolveUndo(index.resolveUndo))
+	}
+	for _, extension := range index.extensions {
+		content = index.appendExtension(content, string(extension.signature), extension.data)
+	}
+
 	checksum := sha1.Sum(content)
 	content = append(content, checksum[:]...)
 
-	err = ioutil.WriteFile(index.rootDir+"/.git/index", content, 0644)
+	err = ioutil.WriteFile(GitDir(index.rootDir)+"/index", content, 0644)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -264,28 +372,96 @@ func (index *Index) hasEntry(path_name []byte) int {
 	return -1
 }
 
+// Entries with the same name are sorted by their stage field.
 func (index *Index) sortEntries() {
-	keys := make([]string, index.header.entries_count)
-	keys_to_index_map := make(map[string]int)
-	var i uint32
-	for i = 0; i < index.header.entries_count; i++ {
-		keys[i] = string(index.entries[i].Path)
-		keys_to_index_map[string(index.entries[i].Path)] = int(i)
+	sort.SliceStable(index.entries, func(i, j int) bool {
+		compared := bytes.Compare(index.entries[i].Path, index.entries[j].Path)
+		if compared != 0 {
+			return compared < 0
+		}
+		return index.entries[i].Stage() < index.entries[j].Stage()
+	})
+}
+
+// Remove the stage 1-3 entries of path_name and remember them in the
+// resolve-undo extension, so that the conflict can be re-opened later.
+func (index *Index) resolveConflict(path_name []byte) {
+	undo := NewResolveUndoEntry(string(path_name[:len(path_name)-1]))
+	remaining_entries := make([]*IndexEntry, 0, len(index.entries))
+	for _, entry := range index.entries {
+		stage := entry.Stage()
+		if stage != 0 && bytes.Equal(entry.Path, path_name) {
+			undo.Modes[stage-1] = entry.Mode
+			undo.Obj_names[stage-1] = entry.Obj_name
+			continue
+		}
+		remaining_entries = append(remaining_entries, entry)
+	}
+	if len(remaining_entries) == len(index.entries) {
+		return
 	}
-	sort.Strings(keys)
+	index.entries = remaining_entries
+	index.header.entries_count = uint32(len(index.entries))
 
-	sortedEntries := make([]*IndexEntry, index.header.entries_count)
-	for i = 0; i < index.header.entries_count; i++ {
-		sortedEntries[i] = index.entries[keys_to_index_map[keys[i]]]
+	for i, existing_undo := range index.resolveUndo {
+		if existing_undo.Path == undo.Path {
+			index.resolveUndo[i] = undo
+			return
+		}
 	}
-	for i = 0; i < index.header.entries_count; i++ {
-		index.entries[i] = sortedEntries[i]
+	index.resolveUndo = append(index.resolveUndo, undo)
+}
+
+// Unresolve puts the stage 1-3 entries recorded by the resolve-undo extension
+// back in place of the stage 0 entries of path_names.
+func (index *Index) Unresolve(path_names []string) []string {
+	unresolved_path_names := make([]string, 0)
+	for _, path_name := range path_names {
+		undo_index := -1
+		for i, undo := range index.resolveUndo {
+			if undo.Path == path_name {
+				undo_index = i
+				break
+			}
+		}
+		if undo_index == -1 {
+			continue
+		}
+		undo := index.resolveUndo[undo_index]
+		index.resolveUndo = append(index.resolveUndo[:undo_index], index.resolveUndo[undo_index+1:]...)
+
+		if i := index.hasEntry([]byte(path_name + "\000")); i != -1 {
+			index.entries = append(index.entries[:i], index.entries[i+1:]...)
+		}
+		for stage := 0; stage < 3; stage++ {
+			if undo.Modes[stage] == 0 {
+				continue
+			}
+			entry := new(IndexEntry)
+			entry.Mode = undo.Modes[stage]
+			entry.Obj_name = undo.Obj_names[stage]
+			entry.Flags = uint16(stage+1) << 12
+			if len(path_name) < 0xfff {
+				entry.Flags |= uint16(len(path_name))
+			} else {
+				entry.Flags |= 0xfff
+			}
+			entry.Path = []byte(path_name + "\000")
+			index.entries = append(index.entries, entry)
+		}
+		if index.cacheTree != nil {
+			index.cacheTree.Invalidate(path_name)
+		}
+		unresolved_path_names = append(unresolved_path_names, path_name)
 	}
+	index.header.entries_count = uint32(len(index.entries))
+	index.sortEntries()
+	return unresolved_path_names
 }
 
 func (index *Index) WriteEntries(path_names []string, object_ids [][]byte) {
 	for i, path := range path_names {
-		file, err := os.Open(path)
+		file, err := os.Open(index.rootDir + "/" + path)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -316,6 +492,11 @@ func (index *Index) WriteEntries(path_names []string, object_ids [][]byte) {
 		// path is nul-terminated
 		entry.Path = []byte(path + "\000")
 
+		index.resolveConflict(entry.Path)
+		if index.cacheTree != nil {
+			index.cacheTree.Invalidate(path)
+		}
+
 		// if the entry is existing
 		if i := index.hasEntry(entry.Path); i != -1 {
 			index.entries[i] = entry
@@ -336,10 +517,34 @@ func (index *Index) WriteEmptyStatEntries(path_names []string, object_ids [][]by
 		index.entries = append(index.entries, entry)
 	}
 	index.header.entries_count = uint32(len(index.entries))
+	index.cacheTree = nil
+	index.sortEntries()
+}
+
+// WriteStageEntry adds an entry without stat data, so that it is checked
+// against the working tree next time, at a stage: 0 for a merged path, or 1
+// (the common ancestor), 2 (ours) or 3 (theirs) for a conflicted one.
+func (index *Index) WriteStageEntry(path_name string, mode uint32, object_id []byte, stage uint16) {
+	entry := new(IndexEntry)
+	entry.Mode = mode
+	entry.Obj_name = object_id
+	entry.Flags = stage << 12
+	if len(path_name) < 0xfff {
+		entry.Flags |= uint16(len(path_name))
+	} else {
+		entry.Flags |= 0xfff
+	}
+	entry.Path = []byte(path_name + "\000")
+	index.entries = append(index.entries, entry)
+	index.header.entries_count = uint32(len(index.entries))
+	index.cacheTree = nil
 	index.sortEntries()
 }
 
 func (index *Index) ClearEntries() {
 	index.entries = nil
 	index.entries = make([]*IndexEntry, 0)
+	index.header.entries_count = 0
+	index.cacheTree = nil
+	index.resolveUndo = nil
 }
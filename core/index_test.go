@@ -0,0 +1,152 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testObjectName(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 20)
+}
+
+func TestCacheTreeRoundTrip(t *testing.T) {
+	cache_tree := NewCacheTree("")
+	cache_tree.EntryCount = 3
+	cache_tree.HashedFilename = testObjectName(1)
+	valid := NewCacheTree("lib")
+	valid.EntryCount = 2
+	valid.HashedFilename = testObjectName(2)
+	invalid := NewCacheTree("doc")
+	cache_tree.Subtrees = append(cache_tree.Subtrees, valid, invalid)
+
+	parsed := ParseCacheTree(cache_tree.Serialize())
+	if !reflect.DeepEqual(parsed, cache_tree) {
+		t.Errorf("parsed %+v, want %+v", parsed, cache_tree)
+	}
+	if name, sha1_name, ok := parsed.ValidAncestor("lib/a/b.go"); !ok || name != "lib" || !bytes.Equal(sha1_name, testObjectName(2)) {
+		t.Errorf("ValidAncestor(lib/a/b.go) = %q, %x, %v", name, sha1_name, ok)
+	}
+	parsed.Invalidate("lib/b.go")
+	if parsed.IsValid() || parsed.Subtree("lib").IsValid() {
+		t.Errorf("Invalidate(lib/b.go) left the root or lib valid")
+	}
+}
+
+func TestResolveUndoRoundTrip(t *testing.T) {
+	entries := []*ResolveUndoEntry{
+		{Path: "a.txt", Modes: [3]uint32{0100644, 0100644, 0100755}, Obj_names: [3][]byte{testObjectName(1), testObjectName(2), testObjectName(3)}},
+		{Path: "dir/added.txt", Modes: [3]uint32{0, 0100644, 0100644}, Obj_names: [3][]byte{nil, testObjectName(4), testObjectName(5)}},
+	}
+	parsed := ParseResolveUndo(SerializeResolveUndo(entries))
+	if !reflect.DeepEqual(parsed, entries) {
+		t.Errorf("parsed %+v, want %+v", parsed, entries)
+	}
+}
+
+// TestIndexExtensions saves an index with a cache tree, a resolved conflict
+// and an extension regit does not know, reads it back and re-opens the
+// conflict.
+func TestIndexExtensions(t *testing.T) {
+	rootDir := t.TempDir()
+	if err := os.Mkdir(rootDir+"/.git", 0755); err != nil {
+		t.Fatal(err)
+	}
+	index := NewIndex(rootDir)
+	index.WriteEmptyStatEntries(
+		[]string{"a.txt", "a.txt", "a.txt", "b.txt"},
+		[][]byte{testObjectName(1), testObjectName(2), testObjectName(3), testObjectName(4)},
+		[]uint16{1, 2, 3, 0})
+	for _, entry := range index.Entries() {
+		entry.Mode = 0100644
+	}
+	index.resolveConflict([]byte("a.txt\000"))
+	index.WriteEmptyStatEntries([]string{"a.txt"}, [][]byte{testObjectName(5)}, []uint16{0})
+	cache_tree := NewCacheTree("")
+	cache_tree.EntryCount = 2
+	cache_tree.HashedFilename = testObjectName(6)
+	index.SetCacheTree(cache_tree)
+	index.extensions = append(index.extensions, &IndexExtension{[]byte("ZTST"), []byte("kept as it is")})
+	index.Save()
+
+	index = NewIndex(rootDir)
+	if err := index.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(index.CacheTree(), cache_tree) {
+		t.Errorf("cache tree %+v, want %+v", index.CacheTree(), cache_tree)
+	}
+	if len(index.extensions) != 1 || string(index.extensions[0].signature) != "ZTST" || string(index.extensions[0].data) != "kept as it is" {
+		t.Errorf("the unknown extension was not kept: %+v", index.extensions)
+	}
+	if index.HasUnmergedEntries() {
+		t.Fatal("the conflict of a.txt is not resolved")
+	}
+
+	unresolved := index.Unresolve([]string{"a.txt", "b.txt"})
+	if !reflect.DeepEqual(unresolved, []string{"a.txt"}) {
+		t.Errorf("Unresolve re-opened %q, want only a.txt", unresolved)
+	}
+	stages := make([]uint16, 0)
+	for _, entry := range index.Entries() {
+		if string(entry.Path) == "a.txt\000" {
+			stages = append(stages, entry.Stage())
+			if !bytes.Equal(entry.Obj_name, testObjectName(byte(entry.Stage()))) {
+				t.Errorf("stage %d of a.txt is %x", entry.Stage(), entry.Obj_name)
+			}
+		}
+	}
+	if !reflect.DeepEqual(stages, []uint16{1, 2, 3}) {
+		t.Errorf("a.txt has stages %v, want 1, 2 and 3", stages)
+	}
+	if index.CacheTree().IsValid() {
+		t.Errorf("the cache tree is still valid after re-opening a conflict")
+	}
+}
+
+func TestIndexChecksum(t *testing.T) {
+	rootDir := t.TempDir()
+	if err := os.Mkdir(rootDir+"/.git", 0755); err != nil {
+		t.Fatal(err)
+	}
+	index := NewIndex(rootDir)
+	index.WriteEmptyStatEntries([]string{"a.txt"}, [][]byte{testObjectName(1)}, []uint16{0})
+	index.Save()
+
+	content, err := ioutil.ReadFile(rootDir + "/.git/index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the object name of the first entry
+	content[12+40] ^= 0xff
+	if err := ioutil.WriteFile(rootDir+"/.git/index", content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = NewIndex(rootDir).Read()
+	if _, ok := err.(*ChecksumError); !ok {
+		t.Fatalf("reading a corrupt index returned %v, want a *ChecksumError", err)
+	}
+	if strings.HasPrefix(err.Error(), "Error: ") {
+		t.Errorf("the error %q carries the prefix of the command line", err)
+	}
+
+	// git writes an all-zero checksum with index.skipHash
+	copy(content[len(content)-sha1.Size:], make([]byte, sha1.Size))
+	if err := ioutil.WriteFile(rootDir+"/.git/index", content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewIndex(rootDir).Read(); err != nil {
+		t.Errorf("reading an index without checksum returned %v", err)
+	}
+
+	if err := ioutil.WriteFile(rootDir+"/.git/index", []byte("DIRC"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewIndex(rootDir).Read(); err == nil {
+		t.Errorf("reading a truncated index did not fail")
+	}
+}
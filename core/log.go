@@ -0,0 +1,203 @@
+package core
+
+import (
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type LogOptions struct {
+	OneLine  bool
+	Format   string // the format of --pretty=format:<format>, empty for the default layout
+	MaxCount int    // negative for no limit
+	Since    time.Time
+	Until    time.Time
+	Author   *regexp.Regexp
+	Grep     *regexp.Regexp
+	Paths    []string // only show commits which change one of these paths
+	Graph    bool
+	Order    string // "topo", "date", or empty for the default order
+	All      bool   // start from every ref and HEAD instead of a single revision
+	DateMode string // the mode of --date, see FormatDate
+	Color    bool   // whether colors are printed, see ReGit.UseColor
+}
+
+func NewLogOptions() *LogOptions {
+	options := new(LogOptions)
+	options.MaxCount = -1
+	options.Paths = make([]string, 0)
+	return options
+}
+
+// whether the commit passes the --since, --until, --author and --grep filters
+func (options *LogOptions) Matches(commit *CommitObject) bool {
+	committer_date := ParseSignature(commit.committer).When
+	if !options.Since.IsZero() && committer_date.Before(options.Since) {
+		return false
+	}
+	if !options.Until.IsZero() && committer_date.After(options.Until) {
+		return false
+	}
+	if options.Author != nil && !options.Author.MatchString(commit.author) {
+		return false
+	}
+	if options.Grep != nil && !options.Grep.MatchString(commit.message) {
+		return false
+	}
+	return true
+}
+
+// The subject is the first paragraph of the message joined into a single
+// line, the body is everything after it.
+func splitCommitMessage(message string) (string, string) {
+	message = strings.TrimLeft(message, "\n")
+	paragraph_end_index := strings.Index(message, "\n\n")
+	if paragraph_end_index == -1 {
+		return strings.Join(strings.Fields(message), " "), ""
+	}
+	subject := strings.Join(strings.Fields(message[:paragraph_end_index]), " ")
+	body := strings.TrimLeft(message[paragraph_end_index+2:], "\n")
+	if body != "" && !strings.HasSuffix(body, "\n") {
+		body += "\n"
+	}
+	return subject, body
+}
+
+func abbreviate(sha1_name string) string {
+	if len(sha1_name) > 7 {
+		return sha1_name[:7]
+	}
+	return sha1_name
+}
+
+var formatColors = map[string]string{
+	"red":    "\033[31m",
+	"green":  "\033[32m",
+	"yellow": "\033[33m",
+	"blue":   "\033[34m",
+	"reset":  "\033[0m",
+}
+
+// the escape sequence switching to a color, or nothing if colors are off
+func (options *LogOptions) color(name string) string {
+	if !options.Color {
+		return ""
+	}
+	return formatColors[name]
+}
+
+// FormatCommit expands the placeholders of `log --pretty=format:` for a commit:
+//
+//	%H %h   commit hash, abbreviated commit hash
+//	%T %t   tree hash, abbreviated tree hash
+//	%P %p   parent hashes, abbreviated parent hashes
+//	%an %ae %ad %at  author name, email, date (in date_mode) and UNIX timestamp
+//	%ar %ai %aI %aD  author date, relative, ISO 8601, strict ISO 8601 and RFC 2822
+//	%cn %ce %cd %ct  committer name, email, date (in date_mode) and UNIX timestamp
+//	%cr %ci %cI %cD  committer date, relative, ISO 8601, strict ISO 8601 and RFC 2822
+//	%s %b %B         subject, body and raw message
+//	%n %%            newline and a literal '%'
+//	%Cred %Cgreen %Cblue %Creset %C(<color>)  switch colors, if options.Color is set
+//
+// Dates are shown in options.DateMode.
+func FormatCommit(commit *CommitObject, format string, options *LogOptions) string {
+	sha1_name := hex.EncodeToString(commit.Obj.HashedFilename)
+	author := ParseSignature(commit.author)
+	committer := ParseSignature(commit.committer)
+	subject, body := splitCommitMessage(commit.message)
+
+	builder := new(strings.Builder)
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			builder.WriteByte(format[i])
+			continue
+		}
+		rest := format[i+1:]
+		placeholder := ""
+		expansion := ""
+		switch {
+		case strings.HasPrefix(rest, "C("):
+			color_end_index := strings.Index(rest, ")")
+			if color_end_index != -1 {
+				placeholder = rest[:color_end_index+1]
+				expansion = options.color(rest[2:color_end_index])
+			}
+		case strings.HasPrefix(rest, "Creset"):
+			placeholder, expansion = "Creset", options.color("reset")
+		case strings.HasPrefix(rest, "Cred"):
+			placeholder, expansion = "Cred", options.color("red")
+		case strings.HasPrefix(rest, "Cgreen"):
+			placeholder, expansion = "Cgreen", options.color("green")
+		case strings.HasPrefix(rest, "Cblue"):
+			placeholder, expansion = "Cblue", options.color("blue")
+		case len(rest) >= 2 && (rest[0] == 'a' || rest[0] == 'c'):
+			sig := author
+			if rest[0] == 'c' {
+				sig = committer
+			}
+			placeholder = rest[:2]
+			switch rest[1] {
+			case 'n':
+				expansion = sig.Name
+			case 'e':
+				expansion = sig.Email
+			case 'd':
+				expansion = sig.FormatDate(options.DateMode)
+			case 't':
+				expansion = sig.FormatDate("unix")
+			case 'r':
+				expansion = sig.FormatDate("relative")
+			case 'i':
+				expansion = sig.FormatDate("iso")
+			case 'I':
+				expansion = sig.FormatDate("iso-strict")
+			case 'D':
+				expansion = sig.FormatDate("rfc")
+			default:
+				placeholder = ""
+			}
+		default:
+			placeholder = rest[:1]
+			switch rest[0] {
+			case 'H':
+				expansion = sha1_name
+			case 'h':
+				expansion = abbreviate(sha1_name)
+			case 'T':
+				expansion = commit.tree
+			case 't':
+				expansion = abbreviate(commit.tree)
+			case 'P':
+				expansion = strings.Join(commit.historyParents(), " ")
+			case 'p':
+				parents := commit.historyParents()
+				abbreviated_parents := make([]string, len(parents))
+				for j, parent := range parents {
+					abbreviated_parents[j] = abbreviate(parent)
+				}
+				expansion = strings.Join(abbreviated_parents, " ")
+			case 's':
+				expansion = subject
+			case 'b':
+				expansion = body
+			case 'B':
+				expansion = commit.message + "\n"
+			case 'n':
+				expansion = "\n"
+			case '%':
+				expansion = "%"
+			default:
+				placeholder = ""
+			}
+		}
+		if placeholder == "" {
+			// unknown placeholders are printed as they are
+			builder.WriteByte(format[i])
+			continue
+		}
+		builder.WriteString(expansion)
+		i += len(placeholder)
+	}
+	return builder.String()
+}
@@ -0,0 +1,543 @@
+package core
+
+import (
+	"strings"
+)
+
+// LogGraph draws the lines of history printed next to the commits by
+// `log --graph`, the way git's graph.c does. Every column follows one line
+// of history and waits for the commit expected next on it. The edges of a
+// merge and the columns which join or move left are drawn over several
+// rows, so that no two edges ever share a cell.
+//
+// The mapping tells, for every cell of a row, which column of the next row
+// the edge in it leads to, or -1 for an empty cell.
+type LogGraph struct {
+	commit          string
+	parents         []string
+	state           int
+	prevState       int
+	columns         []string
+	newColumns      []string
+	mapping         []int
+	oldMapping      []int
+	width           int
+	expansionRow    int
+	commitIndex     int
+	prevCommitIndex int
+	mergeLayout     int // 0 when the first parent is left of the merge, 1 when it is below it
+	edgesAdded      int
+	prevEdgesAdded  int
+	separate        bool // an empty line separates the commits
+	shownCommit     bool
+}
+
+// the rows a commit is drawn with
+const (
+	logGraphPadding = iota
+	logGraphSkip
+	logGraphPreCommit
+	logGraphCommit
+	logGraphPostMerge
+	logGraphCollapsing
+)
+
+var logGraphMergeChars = []byte{'/', '|', '\\'}
+
+// NewLogGraph makes a graph for commits which are separated by an empty
+// line when separate is true, as in the default format of log.
+func NewLogGraph(separate bool) *LogGraph {
+	lg := new(LogGraph)
+	lg.columns = make([]string, 0)
+	lg.newColumns = make([]string, 0)
+	lg.mapping = make([]int, 0)
+	lg.oldMapping = make([]int, 0)
+	lg.separate = separate
+	return lg
+}
+
+func indexOfName(names []string, name string) int {
+	for i, existing_name := range names {
+		if existing_name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Render returns the lines printed for a commit, whose parents must be
+// rendered after it: the rows which make room for the edges of an octopus
+// merge, the commit line, marked with '*', and the rows which lead its
+// parents and the other columns to their next position. The lines of text
+// are printed next to the graph, the first one on the commit line.
+func (lg *LogGraph) Render(sha1_name string, parents []string, text []string) []string {
+	lg.update(sha1_name, parents)
+
+	lines := make([]string, 0)
+	if lg.separate && lg.shownCommit {
+		lines = append(lines, strings.TrimRight(lg.paddingLine(), " "))
+	}
+	lg.shownCommit = true
+	for {
+		row, is_commit_line := lg.nextLine()
+		if is_commit_line {
+			if len(text) != 0 {
+				row += text[0]
+				text = text[1:]
+			}
+			lines = append(lines, strings.TrimRight(row, " "))
+			break
+		}
+		lines = append(lines, strings.TrimRight(row, " "))
+	}
+	for _, line := range text {
+		row, _ := lg.nextLine()
+		lines = append(lines, strings.TrimRight(row+line, " "))
+	}
+	for lg.state != logGraphPadding {
+		row, _ := lg.nextLine()
+		lines = append(lines, strings.TrimRight(row, " "))
+	}
+	return lines
+}
+
+func (lg *LogGraph) setState(state int) {
+	lg.prevState = lg.state
+	lg.state = state
+}
+
+// update makes the commit the one drawn next.
+func (lg *LogGraph) update(sha1_name string, parents []string) {
+	lg.commit = sha1_name
+	lg.parents = parents
+	lg.prevCommitIndex = lg.commitIndex
+	lg.updateColumns()
+	lg.expansionRow = 0
+
+	// unlike setState, the state the previous commit ended with is kept
+	switch {
+	case lg.state != logGraphPadding:
+		lg.state = logGraphSkip
+	case lg.needsPreCommitLine():
+		lg.state = logGraphPreCommit
+	default:
+		lg.state = logGraphCommit
+	}
+}
+
+// updateColumns makes the columns of the next row those of the row after
+// the previous commit, and finds the columns of the row after this one: the
+// parents of the commit take its column, and columns waiting for the same
+// commit are joined.
+func (lg *LogGraph) updateColumns() {
+	lg.columns, lg.newColumns = lg.newColumns, lg.columns[:0]
+
+	mapping_size := 2 * (len(lg.columns) + len(lg.parents))
+	lg.mapping = make([]int, mapping_size)
+	for i := range lg.mapping {
+		lg.mapping[i] = -1
+	}
+	for len(lg.oldMapping) < mapping_size {
+		lg.oldMapping = append(lg.oldMapping, -1)
+	}
+
+	lg.width = 0
+	lg.prevEdgesAdded = lg.edgesAdded
+	lg.edgesAdded = 0
+
+	seen_this := false
+	for i := 0; i <= len(lg.columns); i++ {
+		var column_commit string
+		if i == len(lg.columns) {
+			if seen_this {
+				break
+			}
+			column_commit = lg.commit
+		} else {
+			column_commit = lg.columns[i]
+		}
+
+		if column_commit == lg.commit {
+			seen_this = true
+			lg.commitIndex = i
+			lg.mergeLayout = -1
+			for _, parent := range lg.parents {
+				lg.insertIntoNewColumns(parent, i)
+			}
+			// the commit takes up a column even without parents
+			if len(lg.parents) == 0 {
+				lg.width += 2
+			}
+		} else {
+			lg.insertIntoNewColumns(column_commit, -1)
+		}
+	}
+
+	for len(lg.mapping) > 1 && lg.mapping[len(lg.mapping)-1] < 0 {
+		lg.mapping = lg.mapping[:len(lg.mapping)-1]
+	}
+}
+
+// insertIntoNewColumns adds a column for a commit to the next row, unless
+// there is one for it already, and maps the edge leading to it. index is
+// the column of the commit being drawn when the commit is one of its
+// parents, or -1.
+func (lg *LogGraph) insertIntoNewColumns(sha1_name string, index int) {
+	i := indexOfName(lg.newColumns, sha1_name)
+	if i == -1 {
+		lg.newColumns = append(lg.newColumns, sha1_name)
+		i = len(lg.newColumns) - 1
+	}
+
+	var mapping_index int
+	switch {
+	case len(lg.parents) > 1 && index != -1 && lg.mergeLayout == -1:
+		// the first parent of a merge: the edges of the merge lean to the
+		// right when it is left of the merge, and start below it otherwise
+		distance := index - i
+		shift := 1
+		if distance > 1 {
+			shift = 2*distance - 3
+		}
+		if distance > 0 {
+			lg.mergeLayout = 0
+		} else {
+			lg.mergeLayout = 1
+		}
+		lg.edgesAdded = len(lg.parents) + lg.mergeLayout - 2
+		mapping_index = lg.width + (lg.mergeLayout-1)*shift
+		lg.width += 2 * lg.mergeLayout
+	case lg.edgesAdded > 0 && lg.width >= 2 && i == lg.mapping[lg.width-2]:
+		// a parent found in the last column the merge added: both edges
+		// join at once
+		mapping_index = lg.width - 2
+		lg.edgesAdded = -1
+	default:
+		mapping_index = lg.width
+		lg.width += 2
+	}
+	lg.mapping[mapping_index] = i
+}
+
+// mappingAt returns the column a cell of a mapping leads to, which is -1
+// past its end.
+func mappingAt(mapping []int, i int) int {
+	if i < len(mapping) {
+		return mapping[i]
+	}
+	return -1
+}
+
+func (lg *LogGraph) isMappingCorrect() bool {
+	for i, target := range lg.mapping {
+		if target >= 0 && target != i/2 {
+			return false
+		}
+	}
+	return true
+}
+
+// the parents of an octopus merge drawn with dashes on its commit line
+func (lg *LogGraph) dashedParentCount() int {
+	return len(lg.parents) + lg.mergeLayout - 3
+}
+
+func (lg *LogGraph) needsPreCommitLine() bool {
+	return len(lg.parents) >= 3 &&
+		lg.commitIndex < len(lg.columns)-1 &&
+		lg.expansionRow < 2*lg.dashedParentCount()
+}
+
+// nextLine returns the next row of the graph, padded to its width, and
+// whether it is the commit line.
+func (lg *LogGraph) nextLine() (string, bool) {
+	row := new(strings.Builder)
+	is_commit_line := false
+	switch lg.state {
+	case logGraphPadding:
+		lg.writePaddingLine(row)
+	case logGraphSkip:
+		lg.writeSkipLine(row)
+	case logGraphPreCommit:
+		lg.writePreCommitLine(row)
+	case logGraphCommit:
+		lg.writeCommitLine(row)
+		is_commit_line = true
+	case logGraphPostMerge:
+		lg.writePostMergeLine(row)
+	case logGraphCollapsing:
+		lg.writeCollapsingLine(row)
+	}
+	return lg.pad(row.String()), is_commit_line
+}
+
+func (lg *LogGraph) pad(row string) string {
+	if len(row) < lg.width {
+		return row + strings.Repeat(" ", lg.width-len(row))
+	}
+	return row
+}
+
+// paddingLine returns the row printed between two commits, which leaves
+// every line of history where it is.
+func (lg *LogGraph) paddingLine() string {
+	if lg.state != logGraphCommit {
+		row, _ := lg.nextLine()
+		return row
+	}
+	row := new(strings.Builder)
+	for _, column := range lg.columns {
+		row.WriteByte('|')
+		if column == lg.commit && len(lg.parents) > 2 {
+			row.WriteString(strings.Repeat(" ", 2*(len(lg.parents)-2)))
+		} else {
+			row.WriteByte(' ')
+		}
+	}
+	lg.prevState = logGraphPadding
+	return lg.pad(row.String())
+}
+
+func (lg *LogGraph) writePaddingLine(row *strings.Builder) {
+	for range lg.newColumns {
+		row.WriteString("| ")
+	}
+}
+
+func (lg *LogGraph) writeSkipLine(row *strings.Builder) {
+	row.WriteString("...")
+	if lg.needsPreCommitLine() {
+		lg.setState(logGraphPreCommit)
+	} else {
+		lg.setState(logGraphCommit)
+	}
+}
+
+// writePreCommitLine makes room for the edges of an octopus merge by moving
+// the columns right of it, one cell per row.
+func (lg *LogGraph) writePreCommitLine(row *strings.Builder) {
+	seen_this := false
+	for i, column := range lg.columns {
+		switch {
+		case column == lg.commit:
+			seen_this = true
+			row.WriteByte('|')
+			row.WriteString(strings.Repeat(" ", lg.expansionRow))
+		case seen_this && lg.expansionRow == 0:
+			if lg.prevState == logGraphPostMerge && lg.prevCommitIndex < i {
+				row.WriteByte('\\')
+			} else {
+				row.WriteByte('|')
+			}
+		case seen_this:
+			row.WriteByte('\\')
+		default:
+			row.WriteByte('|')
+		}
+		row.WriteByte(' ')
+	}
+
+	lg.expansionRow++
+	if !lg.needsPreCommitLine() {
+		lg.setState(logGraphCommit)
+	}
+}
+
+func (lg *LogGraph) writeCommitLine(row *strings.Builder) {
+	seen_this := false
+	for i := 0; i <= len(lg.columns); i++ {
+		var column_commit string
+		if i == len(lg.columns) {
+			if seen_this {
+				break
+			}
+			column_commit = lg.commit
+		} else {
+			column_commit = lg.columns[i]
+		}
+
+		switch {
+		case column_commit == lg.commit:
+			seen_this = true
+			row.WriteByte('*')
+			if len(lg.parents) > 2 {
+				dashed_parents := lg.dashedParentCount()
+				for j := 0; j < dashed_parents; j++ {
+					if j == dashed_parents-1 {
+						row.WriteString("-.")
+					} else {
+						row.WriteString("--")
+					}
+				}
+			}
+		case seen_this && lg.edgesAdded > 1:
+			row.WriteByte('\\')
+		case seen_this && lg.edgesAdded == 1:
+			// a merge whose edge fits right of it only if the columns
+			// were already moved by the rows after the previous merge
+			if lg.prevState == logGraphPostMerge && lg.prevEdgesAdded > 0 && lg.prevCommitIndex < i {
+				row.WriteByte('\\')
+			} else {
+				row.WriteByte('|')
+			}
+		case lg.prevState == logGraphCollapsing && mappingAt(lg.oldMapping, 2*i+1) == i && mappingAt(lg.mapping, 2*i) < i:
+			row.WriteByte('/')
+		default:
+			row.WriteByte('|')
+		}
+		row.WriteByte(' ')
+	}
+
+	switch {
+	case len(lg.parents) > 1:
+		lg.setState(logGraphPostMerge)
+	case lg.isMappingCorrect():
+		lg.setState(logGraphPadding)
+	default:
+		lg.setState(logGraphCollapsing)
+	}
+}
+
+// writePostMergeLine draws the edges from a merge to its parents.
+func (lg *LogGraph) writePostMergeLine(row *strings.Builder) {
+	seen_this := false
+	parent_column := false
+	for i := 0; i <= len(lg.columns); i++ {
+		var column_commit string
+		if i == len(lg.columns) {
+			if seen_this {
+				break
+			}
+			column_commit = lg.commit
+		} else {
+			column_commit = lg.columns[i]
+		}
+
+		switch {
+		case column_commit == lg.commit:
+			seen_this = true
+			char_index := lg.mergeLayout
+			for j := range lg.parents {
+				row.WriteByte(logGraphMergeChars[char_index])
+				if char_index == 2 {
+					if lg.edgesAdded > 0 || j < len(lg.parents)-1 {
+						row.WriteByte(' ')
+					}
+				} else {
+					char_index++
+				}
+			}
+			if lg.edgesAdded == 0 {
+				row.WriteByte(' ')
+			}
+		case seen_this:
+			if lg.edgesAdded > 0 {
+				row.WriteByte('\\')
+			} else {
+				row.WriteByte('|')
+			}
+			row.WriteByte(' ')
+		default:
+			row.WriteByte('|')
+			if lg.mergeLayout != 0 || i != lg.commitIndex-1 {
+				if parent_column {
+					row.WriteByte('_')
+				} else {
+					row.WriteByte(' ')
+				}
+			}
+		}
+
+		if column_commit == lg.parents[0] {
+			parent_column = true
+		}
+	}
+
+	if lg.isMappingCorrect() {
+		lg.setState(logGraphPadding)
+	} else {
+		lg.setState(logGraphCollapsing)
+	}
+}
+
+// writeCollapsingLine moves every edge which is right of its column one
+// cell left; an edge which has to cross another one is drawn with '_'
+// instead, so that they never meet.
+func (lg *LogGraph) writeCollapsingLine(row *strings.Builder) {
+	used_horizontal := false
+	horizontal_edge := -1
+	horizontal_edge_target := -1
+
+	lg.mapping, lg.oldMapping = lg.oldMapping[:len(lg.mapping)], lg.mapping
+	for i := range lg.mapping {
+		lg.mapping[i] = -1
+	}
+
+	for i := range lg.mapping {
+		target := lg.oldMapping[i]
+		if target < 0 {
+			continue
+		}
+
+		switch {
+		case target*2 == i:
+			// already in its column
+			lg.mapping[i] = target
+		case lg.mapping[i-1] < 0:
+			// nothing is left of it: move left by one
+			lg.mapping[i-1] = target
+			if horizontal_edge == -1 {
+				horizontal_edge = i
+				horizontal_edge_target = target
+				for j := target*2 + 3; j < i-2; j += 2 {
+					lg.mapping[j] = target
+				}
+			}
+		case lg.mapping[i-1] == target:
+			// joins the edge left of it, which leads to the same commit
+		default:
+			// crosses the edge left of it
+			lg.mapping[i-2] = target
+			if horizontal_edge == -1 {
+				horizontal_edge_target = target
+				horizontal_edge = i - 1
+				for j := target*2 + 3; j < i-2; j += 2 {
+					lg.mapping[j] = target
+				}
+			}
+		}
+	}
+
+	copy(lg.oldMapping, lg.mapping)
+
+	// the mapping may have become one cell shorter
+	if lg.mapping[len(lg.mapping)-1] < 0 {
+		lg.mapping = lg.mapping[:len(lg.mapping)-1]
+	}
+
+	for i, target := range lg.mapping {
+		switch {
+		case target < 0:
+			row.WriteByte(' ')
+		case target*2 == i:
+			row.WriteByte('|')
+		case target == horizontal_edge_target && i != horizontal_edge-1:
+			// only the first segment of the horizontal edge goes on
+			// to the next row
+			if i != target*2+3 {
+				lg.mapping[i] = -1
+			}
+			used_horizontal = true
+			row.WriteByte('_')
+		default:
+			if used_horizontal && i < horizontal_edge {
+				lg.mapping[i] = -1
+			}
+			row.WriteByte('/')
+		}
+	}
+
+	if lg.isMappingCorrect() {
+		lg.setState(logGraphPadding)
+	}
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"os"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func newTestCommit(t *testing.T, rootDir string, sha1_name string) *CommitObject {
+	commit := NewCommitObject(rootDir)
+	commit.ReadFromExistingObject(sha1_name)
+	return commit
+}
+
+func TestFormatCommit(t *testing.T) {
+	rootDir, first, second := newTestHistory(t)
+	commit := newTestCommit(t, rootDir, second)
+
+	formatted := FormatCommit(commit, "%h %p %an <%ae> %at%n%s|%b|%%|%x", NewLogOptions())
+	want := second[:7] + " " + first[:7] + " A U Thor <author@example.com> 1700000000\nsecond||%|%x"
+	if formatted != want {
+		t.Errorf("FormatCommit = %q, want %q", formatted, want)
+	}
+	options := NewLogOptions()
+	if formatted := FormatCommit(commit, "%C(red)%H%Creset", options); formatted != second {
+		t.Errorf("FormatCommit without colors = %q", formatted)
+	}
+	options.Color = true
+	if formatted := FormatCommit(commit, "%C(red)%H%Creset", options); formatted != "\033[31m"+second+"\033[0m" {
+		t.Errorf("FormatCommit with colors = %q", formatted)
+	}
+	options.DateMode = "short"
+	if formatted := FormatCommit(commit, "%ad", options); formatted != "2023-11-14" {
+		t.Errorf("FormatCommit with --date=short = %q", formatted)
+	}
+}
+
+func TestSplitCommitMessage(t *testing.T) {
+	subject, body := splitCommitMessage("\nfirst line\nsecond line\n\n\nbody")
+	if subject != "first line second line" || body != "body\n" {
+		t.Errorf("splitCommitMessage = %q, %q", subject, body)
+	}
+}
+
+func TestLogOptionsMatches(t *testing.T) {
+	rootDir, _, second := newTestHistory(t)
+	commit := newTestCommit(t, rootDir, second)
+
+	options := NewLogOptions()
+	if !options.Matches(commit) {
+		t.Errorf("the default options do not match")
+	}
+	options.Since = time.Unix(1700000001, 0)
+	if options.Matches(commit) {
+		t.Errorf("a commit before --since matches")
+	}
+	options = NewLogOptions()
+	options.Until = time.Unix(1700000000, 0)
+	options.Author = regexp.MustCompile("Thor")
+	options.Grep = regexp.MustCompile("^sec")
+	if !options.Matches(commit) {
+		t.Errorf("a commit passing --until, --author and --grep does not match")
+	}
+	options.Grep = regexp.MustCompile("first")
+	if options.Matches(commit) {
+		t.Errorf("a commit failing --grep matches")
+	}
+}
+
+// TestWalkCommitsStops checks that the history is not read past the commit
+// where the walk is stopped.
+func TestWalkCommitsStops(t *testing.T) {
+	rootDir, first, second := newTestHistory(t)
+	if err := os.Remove(rootDir + "/.git/objects/" + first[:2] + "/" + first[2:]); err != nil {
+		t.Fatal(err)
+	}
+
+	cg := NewCommitGraph(newTestCommit(t, rootDir, second), rootDir)
+	visited := make([]string, 0)
+	cg.WalkCommits(nil, func(commit *CommitObject) bool {
+		visited = append(visited, commit.message)
+		return false
+	})
+	if len(visited) != 1 || visited[0] != "second" {
+		t.Errorf("visited %q, want only the second commit", visited)
+	}
+}
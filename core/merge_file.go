@@ -0,0 +1,153 @@
+package core
+
+import (
+	"strings"
+)
+
+// a change one side of a merge made to the base: the lines of the base in
+// [baseStart, baseEnd) were replaced with lines
+type mergeChange struct {
+	baseStart int
+	baseEnd   int
+	lines     []string
+}
+
+// mergeChanges lists the changes turning base_lines into other_lines.
+func mergeChanges(base_lines []string, other_lines []string) []*mergeChange {
+	changes := make([]*mergeChange, 0)
+	var change *mergeChange
+	for _, op := range diffLines(base_lines, other_lines) {
+		if op.kind == ' ' {
+			change = nil
+			continue
+		}
+		if change == nil {
+			change = &mergeChange{op.oldIndex, op.oldIndex, make([]string, 0)}
+			changes = append(changes, change)
+		}
+		if op.kind == '-' {
+			change.baseEnd = op.oldIndex + 1
+		} else {
+			change.lines = append(change.lines, other_lines[op.newIndex])
+		}
+	}
+	return changes
+}
+
+// sideLines returns the lines one side has in place of the base lines in
+// [start, end), given the changes it made there.
+func sideLines(base_lines []string, changes []*mergeChange, start int, end int) []string {
+	lines := make([]string, 0)
+	position := start
+	for _, change := range changes {
+		lines = append(lines, base_lines[position:change.baseStart]...)
+		lines = append(lines, change.lines...)
+		position = change.baseEnd
+	}
+	return append(lines, base_lines[position:end]...)
+}
+
+// conflict markers start their own line, even after a last line without "\n"
+func writeConflictLines(builder *strings.Builder, lines []string) {
+	for _, line := range lines {
+		builder.WriteString(line)
+	}
+	if len(lines) != 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		builder.WriteString("\n")
+	}
+}
+
+// MergeFile merges the changes made from base to ours and from base to
+// theirs. Changes of both sides which overlap or touch conflict, unless they
+// are the same; the lines of such conflicts are written between markers
+// labeled with our_label and their_label, leaving out the lines both sides
+// start and end with. It returns the merged content and the number of
+// conflicts.
+func MergeFile(base []byte, ours []byte, theirs []byte, our_label string, their_label string) ([]byte, int) {
+	base_lines := splitLines(base)
+	our_changes := mergeChanges(base_lines, splitLines(ours))
+	their_changes := mergeChanges(base_lines, splitLines(theirs))
+
+	builder := new(strings.Builder)
+	conflicts := 0
+	position := 0
+	i, j := 0, 0
+	for i < len(our_changes) || j < len(their_changes) {
+		start := len(base_lines)
+		if i < len(our_changes) {
+			start = our_changes[i].baseStart
+		}
+		if j < len(their_changes) && their_changes[j].baseStart < start {
+			start = their_changes[j].baseStart
+		}
+
+		// gather the changes of both sides which overlap or touch
+		end := start
+		our_group, their_group := make([]*mergeChange, 0), make([]*mergeChange, 0)
+		for {
+			if i < len(our_changes) && our_changes[i].baseStart <= end {
+				our_group = append(our_group, our_changes[i])
+				if our_changes[i].baseEnd > end {
+					end = our_changes[i].baseEnd
+				}
+				i++
+				continue
+			}
+			if j < len(their_changes) && their_changes[j].baseStart <= end {
+				their_group = append(their_group, their_changes[j])
+				if their_changes[j].baseEnd > end {
+					end = their_changes[j].baseEnd
+				}
+				j++
+				continue
+			}
+			break
+		}
+
+		for _, line := range base_lines[position:start] {
+			builder.WriteString(line)
+		}
+		position = end
+		our_lines := sideLines(base_lines, our_group, start, end)
+		their_lines := sideLines(base_lines, their_group, start, end)
+		if len(their_group) == 0 || strings.Join(our_lines, "") == strings.Join(their_lines, "") {
+			for _, line := range our_lines {
+				builder.WriteString(line)
+			}
+			continue
+		}
+		if len(our_group) == 0 {
+			for _, line := range their_lines {
+				builder.WriteString(line)
+			}
+			continue
+		}
+
+		// the lines both sides start and end with are not part of the conflict
+		prefix := 0
+		for prefix < len(our_lines) && prefix < len(their_lines) && our_lines[prefix] == their_lines[prefix] {
+			prefix++
+		}
+		suffix := 0
+		for suffix < len(our_lines)-prefix && suffix < len(their_lines)-prefix &&
+			our_lines[len(our_lines)-1-suffix] == their_lines[len(their_lines)-1-suffix] {
+			suffix++
+		}
+		for _, line := range our_lines[:prefix] {
+			builder.WriteString(line)
+		}
+		builder.WriteString("<<<<<<< " + our_label + "\n")
+		writeConflictLines(builder, our_lines[prefix:len(our_lines)-suffix])
+		builder.WriteString("=======\n")
+		writeConflictLines(builder, their_lines[prefix:len(their_lines)-suffix])
+		builder.WriteString(">>>>>>> " + their_label + "\n")
+		for _, line := range our_lines[len(our_lines)-suffix:] {
+			builder.WriteString(line)
+		}
+		conflicts++
+	}
+	for _, line := range base_lines[position:] {
+		builder.WriteString(line)
+	}
+	return []byte(builder.String()), conflicts
+}
@@ -0,0 +1,23 @@
+package core
+
+import "testing"
+
+func TestMergeFile(t *testing.T) {
+	base := []byte("1\n2\n3\n4\n5\n")
+	merged, conflicts := MergeFile(base, []byte("one\n2\n3\n4\n5\n"), []byte("1\n2\n3\n4\nfive\n"), "ours", "theirs")
+	if conflicts != 0 || string(merged) != "one\n2\n3\n4\nfive\n" {
+		t.Errorf("merging separate changes gave %q with %d conflicts", merged, conflicts)
+	}
+
+	merged, conflicts = MergeFile(base, []byte("1\n2\nthree\n4\n5\n"), []byte("1\n2\nTHREE\n4\n5\n"), "ours", "theirs")
+	want := "1\n2\n<<<<<<< ours\nthree\n=======\nTHREE\n>>>>>>> theirs\n4\n5\n"
+	if conflicts != 1 || string(merged) != want {
+		t.Errorf("merging overlapping changes gave %q with %d conflicts, want %q", merged, conflicts, want)
+	}
+
+	// the same change on both sides is no conflict
+	merged, conflicts = MergeFile(base, []byte("1\n2\nthree\n4\n5\n"), []byte("1\n2\nthree\n4\n5\n"), "ours", "theirs")
+	if conflicts != 0 || string(merged) != "1\n2\nthree\n4\n5\n" {
+		t.Errorf("merging the same change gave %q with %d conflicts", merged, conflicts)
+	}
+}
@@ -0,0 +1,393 @@
+package core
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// a file of a tree
+type treeFile struct {
+	Mode string
+	SHA1 string
+}
+
+func sameTreeFile(a *treeFile, b *treeFile) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Mode == b.Mode && a.SHA1 == b.SHA1
+}
+
+// flattenTree returns the files of a tree and of its subtrees, by path.
+func (regit *ReGit) flattenTree(tree_sha1 string) map[string]*treeFile {
+	files := make(map[string]*treeFile)
+	regit.flattenSubtree(tree_sha1, "", files)
+	return files
+}
+
+func (regit *ReGit) flattenSubtree(tree_sha1 string, prefix string, files map[string]*treeFile) {
+	for name, entry := range regit.readTreeEntries(tree_sha1) {
+		sha1_name := hex.EncodeToString(entry.HashedFilename)
+		if entry.Type() == "tree" {
+			regit.flattenSubtree(sha1_name, prefix+name+"/", files)
+		} else {
+			files[prefix+name] = &treeFile{entry.FileType, sha1_name}
+		}
+	}
+}
+
+// treeMerge is the result of a three-way merge of trees: the files which
+// merged cleanly, and the conflicted ones with their base, our and their
+// versions (stages 1, 2 and 3 of the index) along with what is left of them
+// in the working tree.
+type treeMerge struct {
+	Files     map[string]*treeFile
+	Conflicts map[string][3]*treeFile
+	Worktree  map[string][]byte
+	Messages  []string
+}
+
+// mergeTrees merges the changes made from base_tree to our_tree and from
+// base_tree to their_tree. A file changed on both sides has its content
+// merged, with conflicts marked by our_label and their_label; a file deleted
+// on one side and changed on the other is a conflict too.
+func (regit *ReGit) mergeTrees(base_tree string, our_tree string, their_tree string, our_label string, their_label string) *treeMerge {
+	merge := &treeMerge{
+		make(map[string]*treeFile),
+		make(map[string][3]*treeFile),
+		make(map[string][]byte),
+		make([]string, 0),
+	}
+	base_files := regit.flattenTree(base_tree)
+	our_files := regit.flattenTree(our_tree)
+	their_files := regit.flattenTree(their_tree)
+
+	paths := make([]string, 0)
+	for _, files := range []map[string]*treeFile{base_files, our_files, their_files} {
+		for path := range files {
+			if _, ok := merge.Files[path]; !ok {
+				merge.Files[path] = nil
+				paths = append(paths, path)
+			}
+		}
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		base, ours, theirs := base_files[path], our_files[path], their_files[path]
+		var result *treeFile
+		switch {
+		case sameTreeFile(ours, theirs), sameTreeFile(base, theirs):
+			result = ours
+		case sameTreeFile(base, ours):
+			result = theirs
+		case ours == nil || theirs == nil:
+			deleted_label, modified_label, modified := our_label, their_label, theirs
+			if theirs == nil {
+				deleted_label, modified_label, modified = their_label, our_label, ours
+			}
+			merge.Messages = append(merge.Messages, "CONFLICT (modify/delete): "+path+" deleted in "+deleted_label+
+				" and modified in "+modified_label+".  Version "+modified_label+" of "+path+" left in tree.")
+			merge.Conflicts[path] = [3]*treeFile{base, ours, theirs}
+			merge.Worktree[path] = regit.readBlobOrExit(modified.SHA1)
+		default:
+			result = regit.mergeTreeFile(merge, path, base, ours, theirs, our_label, their_label)
+		}
+		if result == nil {
+			delete(merge.Files, path)
+		} else {
+			merge.Files[path] = result
+		}
+	}
+	return merge
+}
+
+// mergeTreeFile merges the content of a file both sides changed, and returns
+// the merged file, or nil if it conflicts.
+func (regit *ReGit) mergeTreeFile(merge *treeMerge, path string, base *treeFile, ours *treeFile, theirs *treeFile, our_label string, their_label string) *treeFile {
+	merge.Messages = append(merge.Messages, "Auto-merging "+path)
+	kind := "content"
+	var base_content []byte
+	mode := ours.Mode
+	if base == nil {
+		kind = "add/add"
+	} else {
+		base_content = regit.readBlobOrExit(base.SHA1)
+		if ours.Mode == base.Mode {
+			mode = theirs.Mode
+		}
+	}
+	our_content := regit.readBlobOrExit(ours.SHA1)
+	their_content := regit.readBlobOrExit(theirs.SHA1)
+
+	conflicts := 1
+	content := our_content
+	if isBinary(base_content) || isBinary(our_content) || isBinary(their_content) {
+		merge.Messages = append(merge.Messages, "warning: Cannot merge binary files: "+path+" ("+our_label+" vs. "+their_label+")")
+	} else {
+		content, conflicts = MergeFile(base_content, our_content, their_content, our_label, their_label)
+	}
+	if conflicts == 0 && ours.Mode != theirs.Mode && (base == nil || ours.Mode != base.Mode && theirs.Mode != base.Mode) {
+		// both sides changed the mode differently
+		conflicts = 1
+	}
+	if conflicts == 0 {
+		blob := NewGitObject(regit.RootDir, "blob", content)
+		blob.WriteToFile()
+		return &treeFile{mode, hex.EncodeToString(blob.HashedFilename)}
+	}
+	merge.Messages = append(merge.Messages, "CONFLICT ("+kind+"): Merge conflict in "+path)
+	merge.Conflicts[path] = [3]*treeFile{base, ours, theirs}
+	merge.Worktree[path] = content
+	return nil
+}
+
+func (regit *ReGit) readBlobOrExit(sha1_name string) []byte {
+	return regit.readObjectOrExit(sha1_name).content
+}
+
+// the name of the blob a file of the working tree would be stored as, or ""
+// if there is no such file
+func (regit *ReGit) worktreeFileSHA1(path string) string {
+	content, err := ioutil.ReadFile(regit.RootDir + "/" + path)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(NewGitObject(regit.RootDir, "blob", content).Hash())
+}
+
+// checkMergeable makes sure applying a merge loses no work: the index must
+// match HEAD, and the files the merge changes must not have changed in the
+// working tree.
+func (regit *ReGit) checkMergeable(head_files map[string]*treeFile, merge *treeMerge, action string) {
+	index := NewIndex(regit.RootDir)
+	regit.readIndexOrExit(index)
+	if index.HasUnmergedEntries() {
+		fmt.Println("Error: you need to resolve your current index first")
+		os.Exit(1)
+	}
+	clean := len(index.Entries()) == len(head_files)
+	for _, entry := range index.Entries() {
+		head_file := head_files[string(entry.Path[:len(entry.Path)-1])]
+		if head_file == nil || head_file.SHA1 != hex.EncodeToString(entry.Obj_name) {
+			clean = false
+		}
+	}
+	if !clean {
+		fmt.Println("Error: your local changes would be overwritten by " + action + ".")
+		fmt.Println("hint: commit your changes or stash them to proceed.")
+		os.Exit(1)
+	}
+
+	changed_files := make([]string, 0)
+	for _, path := range mergeChangedPaths(head_files, merge) {
+		head_sha1 := ""
+		if head_file := head_files[path]; head_file != nil {
+			head_sha1 = head_file.SHA1
+		}
+		if regit.worktreeFileSHA1(path) != head_sha1 {
+			changed_files = append(changed_files, path)
+		}
+	}
+	if len(changed_files) != 0 {
+		fmt.Println("Error: your local changes to the following files would be overwritten by " + action + ":")
+		for _, path := range changed_files {
+			fmt.Println("\t" + path)
+		}
+		fmt.Println("Please commit your changes or stash them before you " + action + ".")
+		os.Exit(1)
+	}
+}
+
+// the paths whose content differs between the files of HEAD and a merge
+func mergeChangedPaths(head_files map[string]*treeFile, merge *treeMerge) []string {
+	paths := make([]string, 0)
+	for path, head_file := range head_files {
+		if _, conflicted := merge.Conflicts[path]; !conflicted && !sameTreeFile(head_file, merge.Files[path]) {
+			paths = append(paths, path)
+		}
+	}
+	for path, file := range merge.Files {
+		if head_files[path] == nil && file != nil {
+			paths = append(paths, path)
+		}
+	}
+	for path := range merge.Conflicts {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// writeWorktreeFile writes a file of the working tree with the permissions
+// of mode, creating its directories.
+func (regit *ReGit) writeWorktreeFile(path string, mode string, content []byte) {
+	full_path := regit.RootDir + "/" + path
+	if err := os.MkdirAll(filepath.Dir(full_path), 0755); err != nil {
+		log.Fatal(err)
+	}
+	os.Remove(full_path)
+	if mode == "120000" {
+		if err := os.Symlink(string(content), full_path); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	var perm os.FileMode = 0644
+	if mode == "100755" {
+		perm = 0755
+	}
+	if err := ioutil.WriteFile(full_path, content, perm); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// removeWorktreeFile deletes a file of the working tree, and the directories
+// it leaves empty.
+func (regit *ReGit) removeWorktreeFile(path string) {
+	os.Remove(regit.RootDir + "/" + path)
+	for dir := filepath.Dir(path); dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+		if os.Remove(regit.RootDir+"/"+dir) != nil {
+			break
+		}
+	}
+}
+
+// applyMerge writes a merge to the working tree and the index, where
+// conflicted files get their stages. old_files are the files the index had,
+// which are deleted if the merge does not keep them; files whose name is in
+// old_files with the same content are left as they are.
+func (regit *ReGit) applyMerge(old_files map[string]*treeFile, merge *treeMerge) {
+	for path := range old_files {
+		_, conflicted := merge.Conflicts[path]
+		if merge.Files[path] == nil && !conflicted {
+			regit.removeWorktreeFile(path)
+		}
+	}
+	blobs := make([]string, 0)
+	for path, file := range merge.Files {
+		if !sameTreeFile(old_files[path], file) {
+			blobs = append(blobs, file.SHA1)
+		}
+	}
+	regit.fetchPromisedObjectsOrExit(blobs)
+	paths := make([]string, 0, len(merge.Files))
+	for path, file := range merge.Files {
+		paths = append(paths, path)
+		if !sameTreeFile(old_files[path], file) {
+			regit.writeWorktreeFile(path, file.Mode, regit.readBlobOrExit(file.SHA1))
+		}
+	}
+	sort.Strings(paths)
+	object_ids := make([][]byte, len(paths))
+	for i, path := range paths {
+		object_ids[i], _ = hex.DecodeString(merge.Files[path].SHA1)
+	}
+
+	index := NewIndex(regit.RootDir)
+	regit.readIndexOrExit(index)
+	index.ClearEntries()
+	index.WriteEntries(paths, object_ids)
+	for path, stages := range merge.Conflicts {
+		mode := ""
+		for i, file := range stages {
+			if file == nil {
+				continue
+			}
+			mode = file.Mode
+			mode_value, _ := strconv.ParseUint(file.Mode, 8, 32)
+			object_id, _ := hex.DecodeString(file.SHA1)
+			index.WriteStageEntry(path, uint32(mode_value), object_id, uint16(i+1))
+		}
+		if stages[1] != nil {
+			mode = stages[1].Mode
+		}
+		regit.writeWorktreeFile(path, mode, merge.Worktree[path])
+	}
+	index.Save()
+}
+
+// resetToTree makes the index and the working tree match a tree, like
+// `git reset --hard`: every file is written again, and files of the index
+// which are not in the tree are deleted.
+func (regit *ReGit) resetToTree(tree_sha1 string) {
+	index := NewIndex(regit.RootDir)
+	regit.readIndexOrExit(index)
+	old_files := make(map[string]*treeFile)
+	for _, entry := range index.Entries() {
+		// no content is given, so that every file is written again
+		old_files[string(entry.Path[:len(entry.Path)-1])] = &treeFile{}
+	}
+	merge := &treeMerge{Files: regit.flattenTree(tree_sha1), Conflicts: make(map[string][3]*treeFile)}
+	regit.applyMerge(old_files, merge)
+}
+
+// checkoutTree moves the index and the working tree from one tree to
+// another, rewriting only the files which differ.
+func (regit *ReGit) checkoutTree(old_tree_sha1 string, new_tree_sha1 string) {
+	merge := &treeMerge{Files: regit.flattenTree(new_tree_sha1), Conflicts: make(map[string][3]*treeFile)}
+	regit.applyMerge(regit.flattenTree(old_tree_sha1), merge)
+}
+
+// checkCleanWorktree makes sure that neither the index nor the files of the
+// working tree differ from HEAD, before a command which rewrites both.
+func (regit *ReGit) checkCleanWorktree(action string) {
+	index := NewIndex(regit.RootDir)
+	regit.readIndexOrExit(index)
+	head_files := regit.flattenTree(regit.commitTree(regit.headCommit()))
+	staged := len(index.Entries()) != len(head_files)
+	unstaged := false
+	for _, entry := range index.Entries() {
+		path := string(entry.Path[:len(entry.Path)-1])
+		sha1_name := hex.EncodeToString(entry.Obj_name)
+		if head_files[path] == nil || head_files[path].SHA1 != sha1_name || entry.Stage() != 0 {
+			staged = true
+		}
+		if regit.worktreeFileSHA1(path) != sha1_name {
+			unstaged = true
+		}
+	}
+	if unstaged {
+		fmt.Println("Error: cannot " + action + ": You have unstaged changes.")
+	} else if staged {
+		fmt.Println("Error: cannot " + action + ": Your index contains uncommitted changes.")
+	}
+	if unstaged || staged {
+		fmt.Println("Please commit or stash them.")
+		os.Exit(1)
+	}
+}
+
+// headCommit returns the commit HEAD points to, or "" if the current branch
+// has no commits yet.
+func (regit *ReGit) headCommit() string {
+	sha1_name, _ := ReadRef(regit.RootDir, "HEAD")
+	return sha1_name
+}
+
+// updateHead moves the current branch, or HEAD itself if it is detached, to
+// a commit.
+func (regit *ReGit) updateHead(commit_sha1 string) {
+	head := NewHEAD(regit.RootDir)
+	head.Read()
+	if head.PointsToBranch {
+		branch := NewBranch(head.Content, regit.RootDir)
+		branch.SetCommit(commit_sha1)
+		branch.Write()
+	} else {
+		head.PointsTo(commit_sha1, false)
+	}
+}
+
+// a commit's subject, for messages about it
+func commitSubject(commit *CommitObject) string {
+	subject, _ := splitCommitMessage(commit.message)
+	return strings.TrimSpace(subject)
+}
@@ -21,11 +21,32 @@ type GitObject struct {
 	HashedFilename []byte
 }
 
-func (obj *GitObject) WriteToFile() {
+func NewGitObject(rootDir string, typ string, content []byte) *GitObject {
+	obj := new(GitObject)
+	obj.typ = typ
+	obj.content = content
+	obj.rootDir = rootDir
+	return obj
+}
+
+// the object as stored on disk before compression: "<type> <size>\0<content>"
+func (obj *GitObject) store() []byte {
 	header := []byte(obj.typ + " " + fmt.Sprint(len(obj.content)) + "\000")
 	store := make([]byte, len(header)+len(obj.content))
 	copy(store, header)
 	copy(store[len(header):], obj.content)
+	return store
+}
+
+// Hash computes the object name without writing the object.
+func (obj *GitObject) Hash() []byte {
+	sha1_byte := sha1.Sum(obj.store())
+	obj.HashedFilename = sha1_byte[:]
+	return obj.HashedFilename
+}
+
+func (obj *GitObject) WriteToFile() {
+	store := obj.store()
 	sha1_byte := sha1.Sum(store)
 	obj.HashedFilename = sha1_byte[:]
 	hashedFilenameStr := hex.EncodeToString(obj.HashedFilename[:])
@@ -36,7 +57,7 @@ func (obj *GitObject) WriteToFile() {
 	zlibWriter.Close()
 	compressed_content := buf.Bytes()
 
-	prefix_path := obj.rootDir + "/.git/objects/"
+	prefix_path := GitDir(obj.rootDir) + "/objects/"
 	os.Mkdir(prefix_path+hashedFilenameStr[:2], 0755)
 
 	err := ioutil.WriteFile(prefix_path+hashedFilenameStr[:2]+"/"+hashedFilenameStr[2:], compressed_content, 0644)
@@ -45,41 +66,158 @@ func (obj *GitObject) WriteToFile() {
 	}
 }
 
+// When true, every object read from disk is re-hashed and compared with the
+// name it was requested by. It is set from the core.verifyObjects config.
+var VerifyObjects = false
+
+func (obj *GitObject) path() string {
+	sha1_name := hex.EncodeToString(obj.HashedFilename)
+	return GitDir(obj.rootDir) + "/objects/" + sha1_name[:2] + "/" + sha1_name[2:]
+}
+
 func (obj *GitObject) readFromExistingObject() {
+	err := obj.load()
+	if err != nil {
+		log.Fatal("Error: " + err.Error())
+	}
+}
+
+// load reads the object named by obj.HashedFilename, checking that it has
+// the expected type and size.
+func (obj *GitObject) load() error {
 	sha1_name := hex.EncodeToString(obj.HashedFilename)
-	content, err := ioutil.ReadFile(obj.rootDir + "/.git/objects/" + sha1_name[:2] + "/" + sha1_name[2:])
+	path := obj.path()
+	if objectCache != nil {
+		if typ, content, ok := objectCache.Get(path); ok {
+			if obj.typ != "" && obj.typ != typ {
+				return &ObjectError{path, sha1_name, obj.typ, "has a wrong type"}
+			}
+			obj.typ = typ
+			obj.content = content
+			return nil
+		}
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return obj.loadPacked()
+	}
 	if err != nil {
-		log.Fatal(err)
+		return &ObjectError{path, sha1_name, obj.typ, "can not be read: " + err.Error()}
 	}
 
 	reader := bytes.NewReader(content)
 	decompressed_content_reader, err := zlib.NewReader(reader)
 	if err != nil {
-		log.Fatal(err)
+		return &ObjectError{path, sha1_name, obj.typ, "can not be decompressed: " + err.Error()}
 	}
 	decompressed_content, err := io.ReadAll(decompressed_content_reader)
 	if err != nil {
-		log.Fatal(err)
+		return &ObjectError{path, sha1_name, obj.typ, "can not be decompressed: " + err.Error()}
+	}
+
+	if VerifyObjects {
+		actual_sha1 := sha1.Sum(decompressed_content)
+		if !bytes.Equal(actual_sha1[:], obj.HashedFilename) {
+			return &ChecksumError{path, sha1_name, hex.EncodeToString(actual_sha1[:])}
+		}
 	}
 
 	header_end_index := bytes.IndexByte(decompressed_content, byte(0))
 	if header_end_index == -1 {
-		log.Fatal("Error: " + obj.typ + " object '" + sha1_name + "' is broken")
+		return &ObjectError{path, sha1_name, obj.typ, "is broken"}
 	}
-	if header_end_index+1 <= len([]byte(obj.typ+" ")) {
-		log.Fatal("Error: " + obj.typ + " object '" + sha1_name + "' header is broken")
+	// an object without a type accepts any type stored in the header
+	if obj.typ == "" {
+		type_end_index := bytes.IndexByte(decompressed_content[:header_end_index], ' ')
+		if type_end_index == -1 {
+			return &ObjectError{path, sha1_name, "unknown", "header is broken"}
+		}
+		obj.typ = string(decompressed_content[:type_end_index])
+	}
+	if !bytes.HasPrefix(decompressed_content, []byte(obj.typ+" ")) {
+		return &ObjectError{path, sha1_name, obj.typ, "has a wrong type"}
 	}
 
 	content_size_str := decompressed_content[len([]byte(obj.typ+" ")):header_end_index]
 	content_size, err := strconv.Atoi(string(content_size_str))
 	if err != nil {
-		log.Fatal("Error: " + obj.typ + " object '" + sha1_name + "' header is broken")
+		return &ObjectError{path, sha1_name, obj.typ, "header is broken"}
 	}
 	if len(decompressed_content)-(header_end_index+1) != content_size {
-		log.Fatal("Error: " + obj.typ + " object '" + sha1_name + "' is broken")
+		return &ObjectError{path, sha1_name, obj.typ, "is broken"}
 	}
 
 	obj.content = decompressed_content[header_end_index+1:]
+	if objectCache != nil {
+		objectCache.Add(path, obj.typ, obj.content)
+	}
+	return nil
+}
+
+// loadPacked reads the object from the packs, for objects which are not
+// stored loose. A partial clone fetches it if it is missing.
+func (obj *GitObject) loadPacked() error {
+	sha1_name := hex.EncodeToString(obj.HashedFilename)
+	path := obj.path()
+	typ, content, found, err := readPackedObject(obj.rootDir, obj.HashedFilename)
+	if !found {
+		if FetchMissingObjects && IsPartialClone(obj.rootDir) {
+			if err := FetchPromisedObjects(obj.rootDir, []string{sha1_name}); err != nil {
+				return &ObjectError{path, sha1_name, obj.typ, "can not be fetched from the promisor remote: " + err.Error()}
+			}
+			if ObjectExists(obj.rootDir, sha1_name) {
+				return obj.load()
+			}
+		}
+		return &ObjectError{path, sha1_name, obj.typ, "can not be read: no such object"}
+	}
+	if err != nil {
+		return &ObjectError{GitDir(obj.rootDir) + "/objects/pack", sha1_name, obj.typ, "can not be read from its pack: " + err.Error()}
+	}
+	if obj.typ != "" && obj.typ != typ {
+		return &ObjectError{path, sha1_name, obj.typ, "has a wrong type"}
+	}
+	obj.typ = typ
+	obj.content = content
+	if VerifyObjects {
+		actual_sha1 := sha1.Sum(obj.store())
+		if !bytes.Equal(actual_sha1[:], obj.HashedFilename) {
+			return &ChecksumError{path, sha1_name, hex.EncodeToString(actual_sha1[:])}
+		}
+	}
+	if objectCache != nil {
+		objectCache.Add(path, obj.typ, obj.content)
+	}
+	return nil
+}
+
+func (obj *GitObject) Type() string {
+	return obj.typ
+}
+
+func (obj *GitObject) Content() []byte {
+	return obj.content
+}
+
+func (obj *GitObject) loadByName(sha1Name string) error {
+	hashed_filename, err := hex.DecodeString(sha1Name)
+	if err != nil || len(hashed_filename) != sha1.Size {
+		return &ObjectError{GitDir(obj.rootDir) + "/objects", sha1Name, obj.typ, "is not a valid object name"}
+	}
+	obj.HashedFilename = hashed_filename
+	return obj.load()
+}
+
+// ReadObject reads an object without knowing its type in advance.
+func ReadObject(rootDir string, sha1Name string) (*GitObject, error) {
+	obj := new(GitObject)
+	obj.rootDir = rootDir
+	err := obj.loadByName(sha1Name)
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
 }
 
 type BlobObject struct {
@@ -102,12 +240,14 @@ func (blob *BlobObject) CreateFromFile(filename string) {
 }
 
 func (blob *BlobObject) ReadFromExistingObject(sha1Name string) {
-	hashed_filename, err := hex.DecodeString(sha1Name)
+	err := blob.Load(sha1Name)
 	if err != nil {
 		log.Fatal(err)
 	}
-	blob.Obj.HashedFilename = hashed_filename
-	blob.Obj.readFromExistingObject()
+}
+
+func (blob *BlobObject) Load(sha1Name string) error {
+	return blob.Obj.loadByName(sha1Name)
 }
 
 type TreeEntry struct {
@@ -116,6 +256,17 @@ type TreeEntry struct {
 	HashedFilename []byte
 }
 
+// Type returns the type of the object an entry names, based on its mode.
+func (entry *TreeEntry) Type() string {
+	switch entry.FileType {
+	case "40000", "040000":
+		return "tree"
+	case "160000":
+		return "commit"
+	}
+	return "blob"
+}
+
 type TreeObject struct {
 	Obj             GitObject
 	Entries         []*TreeEntry
@@ -143,20 +294,35 @@ func (tree *TreeObject) WriteEntries(entries []*TreeEntry) {
 }
 
 func (tree *TreeObject) ReadFromExistingObject(sha1Name string) {
-	hashed_filename, err := hex.DecodeString(sha1Name)
+	err := tree.Load(sha1Name)
 	if err != nil {
 		log.Fatal(err)
 	}
-	tree.Obj.HashedFilename = hashed_filename
-	tree.Obj.readFromExistingObject()
+}
 
+func (tree *TreeObject) Load(sha1Name string) error {
+	err := tree.Obj.loadByName(sha1Name)
+	if err != nil {
+		return err
+	}
+	return tree.parse()
+}
+
+// Every entry is stored as "<mode> <name>\0" followed by a 20-byte object name.
+func (tree *TreeObject) parse() error {
 	current_index := 0
 	for current_index < len(tree.Obj.content) {
 		first_part_end_index := bytes.Index(tree.Obj.content[current_index:], []byte("\000"))
+		if first_part_end_index == -1 || current_index+first_part_end_index+1+20 > len(tree.Obj.content) {
+			return &ObjectError{tree.Obj.path(), hex.EncodeToString(tree.Obj.HashedFilename), tree.Obj.typ, "has a truncated entry"}
+		}
 		first_part := tree.Obj.content[current_index : current_index+first_part_end_index]
 		second_part := tree.Obj.content[current_index+first_part_end_index+1 : current_index+first_part_end_index+1+20]
 
-		splitted_first_part := bytes.Split(first_part, []byte(" "))
+		splitted_first_part := bytes.SplitN(first_part, []byte(" "), 2)
+		if len(splitted_first_part) != 2 {
+			return &ObjectError{tree.Obj.path(), hex.EncodeToString(tree.Obj.HashedFilename), tree.Obj.typ, "has an entry without a mode"}
+		}
 		file_type := string(splitted_first_part[0])
 
 		file_name := splitted_first_part[1]
@@ -169,6 +335,7 @@ func (tree *TreeObject) ReadFromExistingObject(sha1Name string) {
 
 		current_index += first_part_end_index + 20 + 1
 	}
+	return nil
 }
 
 func (tree *TreeObject) RecursiveRead(sha1Name string) {
@@ -237,12 +404,13 @@ func (tree *TreeObject) FilesSHA1() [][]byte {
 }
 
 type CommitObject struct {
-	Obj       GitObject
-	tree      string
-	parents   []string
-	author    string
-	committer string
-	message   string
+	Obj          GitObject
+	tree         string
+	parents      []string
+	author       string
+	committer    string
+	extraHeaders string
+	message      string
 }
 
 func NewCommitObject(rootDir string) *CommitObject {
@@ -254,27 +422,44 @@ func NewCommitObject(rootDir string) *CommitObject {
 }
 
 func (commit *CommitObject) ReadFromExistingObject(sha1Name string) {
-	hashed_filename, err := hex.DecodeString(sha1Name)
+	err := commit.Load(sha1Name)
 	if err != nil {
 		log.Fatal(err)
 	}
+}
+
+func (commit *CommitObject) Load(sha1Name string) error {
+	err := commit.Obj.loadByName(sha1Name)
+	if err != nil {
+		return err
+	}
+	return commit.parse()
+}
 
-	commit.Obj.HashedFilename = hashed_filename
-	commit.Obj.readFromExistingObject()
-	lines := strings.Split(string(commit.Obj.content), "\n")
-	if len(lines) < 5 {
-		log.Fatal("Error: " + sha1Name + " is not a valid commit object")
+func (commit *CommitObject) parse() error {
+	sha1Name := hex.EncodeToString(commit.Obj.HashedFilename)
+	invalid := func(reason string) error {
+		return &ObjectError{commit.Obj.path(), sha1Name, commit.Obj.typ, reason}
+	}
+
+	header_end_index := bytes.Index(commit.Obj.content, []byte("\n\n"))
+	if header_end_index == -1 {
+		return invalid("has no message")
+	}
+	lines := strings.Split(string(commit.Obj.content[:header_end_index]), "\n")
+	if len(lines) < 3 {
+		return invalid("is not a valid commit object")
 	}
 	tree_line := lines[0]
 	if strings.Index(tree_line, "tree ") != 0 {
-		log.Fatal("Error: " + sha1Name + " is not a valid commit object")
+		return invalid("does not start with a tree line")
 	}
 	commit.SetTree(tree_line[len("tree "):])
 
 	next_not_parent_index := 1
 	parents := make([]string, 0)
 	for i := 1; i < len(lines); i++ {
-		if !strings.Contains(lines[i], "parent ") {
+		if !strings.HasPrefix(lines[i], "parent ") {
 			next_not_parent_index = i
 			break
 		}
@@ -282,21 +467,27 @@ func (commit *CommitObject) ReadFromExistingObject(sha1Name string) {
 	}
 	commit.SetParents(parents)
 
+	if next_not_parent_index+1 >= len(lines) {
+		return invalid("is missing the author or committer line")
+	}
 	author_line := lines[next_not_parent_index]
+	if !strings.HasPrefix(author_line, "author ") {
+		return invalid("is missing the author line")
+	}
 	commit.SetAuthor(author_line[len("author "):])
 
 	committer_line := lines[next_not_parent_index+1]
-	commit.SetCommitter(committer_line[len("committer "):])
-
-	// a '\n' character
-	if lines[next_not_parent_index+2] != "" {
-		log.Fatal("Error: " + sha1Name + " is not a valid commit object")
+	if !strings.HasPrefix(committer_line, "committer ") {
+		return invalid("is missing the committer line")
 	}
+	commit.SetCommitter(committer_line[len("committer "):])
 
-	message := strings.Join(lines[next_not_parent_index+3:], "\n")
-	commit.SetMessage(message)
+	// headers such as "encoding" or "gpgsig" are kept as they are
+	commit.extraHeaders = strings.Join(lines[next_not_parent_index+2:], "\n")
 
-	commit.GenerateContent()
+	message := string(commit.Obj.content[header_end_index+2:])
+	commit.SetMessage(strings.TrimSuffix(message, "\n"))
+	return nil
 }
 
 func (commit *CommitObject) SetTree(tree string) {
@@ -327,7 +518,76 @@ func (commit *CommitObject) GenerateContent() {
 	}
 	content = append(content, []byte("author "+commit.author+"\n")...)
 	content = append(content, []byte("committer "+commit.committer+"\n")...)
+	if commit.extraHeaders != "" {
+		content = append(content, []byte(commit.extraHeaders+"\n")...)
+	}
 	content = append(content, []byte("\n")...)
 	content = append(content, []byte(commit.message+"\n")...)
 	commit.Obj.content = content
 }
+
+type TagObject struct {
+	Obj        GitObject
+	object     string
+	objectType string
+	name       string
+	tagger     string
+	message    string
+}
+
+func NewTagObject(rootDir string) *TagObject {
+	tag := new(TagObject)
+	tag.Obj.typ = "tag"
+	tag.Obj.rootDir = rootDir
+	return tag
+}
+
+func (tag *TagObject) ReadFromExistingObject(sha1Name string) {
+	err := tag.Load(sha1Name)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (tag *TagObject) Load(sha1Name string) error {
+	err := tag.Obj.loadByName(sha1Name)
+	if err != nil {
+		return err
+	}
+	return tag.parse()
+}
+
+func (tag *TagObject) parse() error {
+	invalid := func(reason string) error {
+		return &ObjectError{tag.Obj.path(), hex.EncodeToString(tag.Obj.HashedFilename), tag.Obj.typ, reason}
+	}
+
+	header := string(tag.Obj.content)
+	header_end_index := strings.Index(header, "\n\n")
+	if header_end_index != -1 {
+		tag.message = strings.TrimSuffix(header[header_end_index+2:], "\n")
+		header = header[:header_end_index]
+	}
+	for _, line := range strings.Split(header, "\n") {
+		switch {
+		case strings.HasPrefix(line, "object "):
+			tag.object = line[len("object "):]
+		case strings.HasPrefix(line, "type "):
+			tag.objectType = line[len("type "):]
+		case strings.HasPrefix(line, "tag "):
+			tag.name = line[len("tag "):]
+		case strings.HasPrefix(line, "tagger "):
+			tag.tagger = line[len("tagger "):]
+		}
+	}
+	if tag.object == "" {
+		return invalid("is missing the object line")
+	}
+	if tag.objectType == "" {
+		return invalid("is missing the type line")
+	}
+	if tag.name == "" {
+		return invalid("is missing the tag line")
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"container/list"
+)
+
+// ObjectCache keeps recently read objects in memory, decompressed, and evicts
+// the least recently used ones once their total size exceeds maxSize bytes.
+type ObjectCache struct {
+	maxSize  int
+	size     int
+	elements map[string]*list.Element
+	order    *list.List // most recently used first
+}
+
+type cachedObject struct {
+	key     string
+	typ     string
+	content []byte
+}
+
+func NewObjectCache(max_size int) *ObjectCache {
+	cache := new(ObjectCache)
+	cache.maxSize = max_size
+	cache.elements = make(map[string]*list.Element)
+	cache.order = list.New()
+	return cache
+}
+
+func (cache *ObjectCache) Get(key string) (string, []byte, bool) {
+	element, ok := cache.elements[key]
+	if !ok {
+		return "", nil, false
+	}
+	cache.order.MoveToFront(element)
+	obj := element.Value.(*cachedObject)
+	return obj.typ, obj.content, true
+}
+
+func (cache *ObjectCache) Add(key string, typ string, content []byte) {
+	if _, ok := cache.elements[key]; ok {
+		return
+	}
+	// an object larger than the whole cache would only evict everything else
+	if len(content) > cache.maxSize {
+		return
+	}
+	cache.elements[key] = cache.order.PushFront(&cachedObject{key, typ, content})
+	cache.size += len(content)
+
+	for cache.size > cache.maxSize {
+		element := cache.order.Back()
+		obj := element.Value.(*cachedObject)
+		cache.order.Remove(element)
+		delete(cache.elements, obj.key)
+		cache.size -= len(obj.content)
+	}
+}
+
+// When set, objects are looked up in this cache before they are read from
+// disk. It is meant for long-running processes such as `cat-file --batch`.
+var objectCache *ObjectCache
+
+func EnableObjectCache(max_size int) {
+	objectCache = NewObjectCache(max_size)
+}
@@ -0,0 +1,55 @@
+package core
+
+import "testing"
+
+func TestObjectCacheEviction(t *testing.T) {
+	cache := NewObjectCache(10)
+	cache.Add("a", "blob", []byte("aaaa"))
+	cache.Add("b", "blob", []byte("bbbb"))
+	// a is used last, so b is the one to go
+	if _, _, ok := cache.Get("a"); !ok {
+		t.Fatal("a is not cached")
+	}
+	cache.Add("c", "tree", []byte("cccc"))
+	if _, _, ok := cache.Get("b"); ok {
+		t.Errorf("b was not evicted")
+	}
+	if typ, content, ok := cache.Get("c"); !ok || typ != "tree" || string(content) != "cccc" {
+		t.Errorf("c is cached as %q, %q, %v", typ, content, ok)
+	}
+	if _, _, ok := cache.Get("a"); !ok {
+		t.Errorf("a was evicted")
+	}
+
+	// an object larger than the cache is not kept
+	cache.Add("d", "blob", make([]byte, 11))
+	if _, _, ok := cache.Get("d"); ok {
+		t.Errorf("an object larger than the cache was kept")
+	}
+	if _, _, ok := cache.Get("c"); !ok {
+		t.Errorf("c was evicted for an object which is not kept")
+	}
+}
+
+func TestObjectCacheLoad(t *testing.T) {
+	rootDir := newTestObjectStore(t)
+	sha1_name := writeTestObject(t, rootDir, "blob", "a\n")
+	defer func() { objectCache = nil }()
+	EnableObjectCache(1024)
+	if _, err := ReadObject(rootDir, sha1_name); err != nil {
+		t.Fatal(err)
+	}
+	// the object is read from the cache, not from the disk, once it is cached
+	changed := NewGitObject(rootDir, "blob", []byte("a\n"))
+	changed.Hash()
+	writeRawObject(t, changed, "blob 2\000b\n")
+	obj, err := ReadObject(rootDir, sha1_name)
+	if err != nil || string(obj.content) != "a\n" {
+		t.Errorf("read %q, %v from the cache, want \"a\\n\"", obj.content, err)
+	}
+	tree := NewTreeObject(rootDir)
+	tree.Obj.HashedFilename = obj.HashedFilename
+	if err := tree.Obj.load(); err == nil {
+		t.Errorf("a cached blob was read as a tree")
+	}
+}
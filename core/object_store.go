@@ -0,0 +1,128 @@
+package core
+
+import (
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// ObjectExists reports whether the object is present in the object store.
+func ObjectExists(rootDir string, sha1Name string) bool {
+	if !isValidSHA1Name(sha1Name) {
+		return false
+	}
+	if _, err := os.Stat(GitDir(rootDir) + "/objects/" + sha1Name[:2] + "/" + sha1Name[2:]); err == nil {
+		return true
+	}
+	sha1_byte, _ := hex.DecodeString(sha1Name)
+	return packedObjectExists(rootDir, sha1_byte)
+}
+
+// ListLooseObjects returns the SHA-1 of every object stored under .git/objects/xx/.
+func ListLooseObjects(rootDir string) []string {
+	objects := make([]string, 0)
+	dirs, err := ioutil.ReadDir(GitDir(rootDir) + "/objects")
+	if err != nil {
+		return objects
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+		if _, err := hex.DecodeString(dir.Name()); err != nil {
+			continue
+		}
+		files, err := ioutil.ReadDir(GitDir(rootDir) + "/objects/" + dir.Name())
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			sha1_name := dir.Name() + file.Name()
+			if _, err := hex.DecodeString(sha1_name); err != nil || len(sha1_name) != 40 {
+				continue
+			}
+			objects = append(objects, sha1_name)
+		}
+	}
+	sort.Strings(objects)
+	return objects
+}
+
+// ReachableObjects walks the object graph from roots and returns the type
+// of every object it reaches. Blobs are not read. An object which is missing
+// or corrupt is an error, as what is reachable through it can not be known,
+// but for the objects a partial clone leaves out.
+func ReachableObjects(rootDir string, roots []string) (map[string]string, error) {
+	reachable := make(map[string]string)
+	partial_clone := IsPartialClone(rootDir)
+	type pending_object struct {
+		sha1_name string
+		typ       string
+	}
+	stack := make([]pending_object, 0, len(roots))
+	for _, root := range roots {
+		stack = append(stack, pending_object{root, ""})
+	}
+
+	for len(stack) != 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := reachable[current.sha1_name]; ok {
+			continue
+		}
+		if current.typ == "blob" {
+			reachable[current.sha1_name] = "blob"
+			continue
+		}
+
+		if !ObjectExists(rootDir, current.sha1_name) {
+			if partial_clone {
+				continue
+			}
+			return nil, errors.New("unable to read " + current.sha1_name)
+		}
+		obj, err := ReadObject(rootDir, current.sha1_name)
+		if err != nil {
+			return nil, err
+		}
+		reachable[current.sha1_name] = obj.typ
+		switch obj.typ {
+		case "commit":
+			commit := NewCommitObject(rootDir)
+			commit.Obj = *obj
+			if err := commit.parse(); err != nil {
+				return nil, err
+			}
+			stack = append(stack, pending_object{commit.tree, "tree"})
+			for _, parent := range commit.historyParents() {
+				stack = append(stack, pending_object{parent, "commit"})
+			}
+		case "tree":
+			tree := NewTreeObject(rootDir)
+			tree.Obj = *obj
+			if err := tree.parse(); err != nil {
+				return nil, err
+			}
+			for _, entry := range tree.Entries {
+				switch entry.FileType {
+				case "40000", "040000":
+					stack = append(stack, pending_object{hex.EncodeToString(entry.HashedFilename), "tree"})
+				case "160000":
+					// gitlinks name commits of other repositories
+				default:
+					stack = append(stack, pending_object{hex.EncodeToString(entry.HashedFilename), "blob"})
+				}
+			}
+		case "tag":
+			tag := NewTagObject(rootDir)
+			tag.Obj = *obj
+			if err := tag.parse(); err != nil {
+				return nil, err
+			}
+			stack = append(stack, pending_object{tag.object, tag.objectType})
+		}
+	}
+	return reachable, nil
+}
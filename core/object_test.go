@@ -0,0 +1,99 @@
+package core
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// writeRawObject writes store, a header and content, as the loose object
+// of the name given, whatever it hashes to.
+func writeRawObject(t *testing.T, obj *GitObject, store string) {
+	var buf bytes.Buffer
+	zlib_writer := zlib.NewWriter(&buf)
+	zlib_writer.Write([]byte(store))
+	zlib_writer.Close()
+	if err := ioutil.WriteFile(obj.path(), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyObjects(t *testing.T) {
+	rootDir := t.TempDir()
+	if err := os.MkdirAll(rootDir+"/.git/objects", 0755); err != nil {
+		t.Fatal(err)
+	}
+	blob := NewBlobObject(rootDir)
+	blob.Obj.content = []byte("hello\n")
+	blob.Obj.WriteToFile()
+	writeRawObject(t, &blob.Obj, "blob 6\000world\n")
+
+	defer func(verify_objects bool) { VerifyObjects = verify_objects }(VerifyObjects)
+	VerifyObjects = false
+	if err := blob.Obj.load(); err != nil || string(blob.Obj.content) != "world\n" {
+		t.Errorf("load without verification read %q, %v", blob.Obj.content, err)
+	}
+	VerifyObjects = true
+	err := blob.Obj.load()
+	if _, ok := err.(*ChecksumError); !ok {
+		t.Errorf("load with verification returned %v, want a *ChecksumError", err)
+	}
+
+	writeRawObject(t, &blob.Obj, "blob 7\000world\n")
+	VerifyObjects = false
+	if _, ok := blob.Obj.load().(*ObjectError); !ok {
+		t.Errorf("an object of the wrong size was read")
+	}
+	tree := NewTreeObject(rootDir)
+	tree.Obj.HashedFilename = blob.Obj.HashedFilename
+	if _, ok := tree.Obj.load().(*ObjectError); !ok {
+		t.Errorf("a blob was read as a tree")
+	}
+}
+
+// writeTestObject stores an object of type typ and returns its name.
+func writeTestObject(t *testing.T, rootDir string, typ string, content string) string {
+	obj := &GitObject{typ: typ, content: []byte(content), rootDir: rootDir}
+	obj.WriteToFile()
+	return hex.EncodeToString(obj.HashedFilename)
+}
+
+// testTreeEntry is an entry of the content of a tree object.
+func testTreeEntry(t *testing.T, mode string, name string, sha1_name string) string {
+	hashed_filename, err := hex.DecodeString(sha1_name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mode + " " + name + "\000" + string(hashed_filename)
+}
+
+// testCommitContent is the content of a commit object.
+func testCommitContent(tree string, parents []string, message string) string {
+	content := "tree " + tree + "\n"
+	for _, parent := range parents {
+		content += "parent " + parent + "\n"
+	}
+	signature := "A U Thor <author@example.com> 1700000000 +0000"
+	return content + "author " + signature + "\ncommitter " + signature + "\n\n" + message
+}
+
+// newTestObjectStore makes a repository without any object or ref.
+func newTestObjectStore(t *testing.T) string {
+	rootDir := t.TempDir()
+	for _, dir := range []string{"/.git/objects", "/.git/refs/heads"} {
+		if err := os.MkdirAll(rootDir+dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rootDir
+}
+
+// writeTestRef points a ref at an object.
+func writeTestRef(t *testing.T, rootDir string, name string, sha1_name string) {
+	if err := ioutil.WriteFile(rootDir+"/.git/"+name, []byte(sha1_name+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,631 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Objects may also be stored in packs, .git/objects/pack/pack-<sha1>.pack,
+// next to an index .idx file which maps their names to their offsets in the
+// pack. New objects are always written loose, and so are the objects of the
+// packs received from other repositories, but for those of a partial clone,
+// which are kept as they are.
+
+// the types of the objects stored in packs
+var packObjectTypes = map[byte]string{1: "commit", 2: "tree", 3: "blob", 4: "tag"}
+
+const (
+	packOfsDelta = 6 // a delta against the object at an earlier offset
+	packRefDelta = 7 // a delta against the object of a given name
+)
+
+// PackIndex is a version 2 pack index.
+type PackIndex struct {
+	PackPath string
+	fanout   [256]uint32
+	names    []byte // 20 bytes per object, sorted
+	offsets  []byte // 4 bytes per object
+	large    []byte // 8 bytes per offset which does not fit in 31 bits
+}
+
+var packIndexes = struct {
+	sync.Mutex
+	byPath map[string]*PackIndex
+}{byPath: make(map[string]*PackIndex)}
+
+// ReadPackIndex reads the .idx file at path.
+func ReadPackIndex(path string) (*PackIndex, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) < 8+256*4 || !bytes.HasPrefix(content, []byte("\377tOc")) || binary.BigEndian.Uint32(content[4:]) != 2 {
+		return nil, errors.New(path + ": unsupported pack index")
+	}
+	index := new(PackIndex)
+	index.PackPath = strings.TrimSuffix(path, ".idx") + ".pack"
+	for i := range index.fanout {
+		index.fanout[i] = binary.BigEndian.Uint32(content[8+i*4:])
+	}
+	count := int(index.fanout[255])
+	names_start := 8 + 256*4
+	offsets_start := names_start + count*20 + count*4
+	if len(content) < offsets_start+count*4+40 {
+		return nil, errors.New(path + ": pack index is truncated")
+	}
+	index.names = content[names_start : names_start+count*20]
+	index.offsets = content[offsets_start : offsets_start+count*4]
+	index.large = content[offsets_start+count*4 : len(content)-40]
+	return index, nil
+}
+
+// Count returns the number of objects in the pack.
+func (index *PackIndex) Count() int {
+	return int(index.fanout[255])
+}
+
+// Name returns the name of the i-th object, in sorted order.
+func (index *PackIndex) Name(i int) []byte {
+	return index.names[i*20 : i*20+20]
+}
+
+// Find returns the offset of an object in the pack.
+func (index *PackIndex) Find(sha1_name []byte) (int64, bool) {
+	low := 0
+	if sha1_name[0] > 0 {
+		low = int(index.fanout[sha1_name[0]-1])
+	}
+	high := int(index.fanout[sha1_name[0]])
+	i := low + sort.Search(high-low, func(i int) bool {
+		return bytes.Compare(index.Name(low+i), sha1_name) >= 0
+	})
+	if i == high || !bytes.Equal(index.Name(i), sha1_name) {
+		return 0, false
+	}
+	offset := binary.BigEndian.Uint32(index.offsets[i*4:])
+	if offset&0x80000000 == 0 {
+		return int64(offset), true
+	}
+	large_index := int(offset & 0x7fffffff)
+	return int64(binary.BigEndian.Uint64(index.large[large_index*8:])), true
+}
+
+// listPackIndexes returns the indexes of all the packs of a repository.
+func listPackIndexes(rootDir string) []*PackIndex {
+	pack_dir := GitDir(rootDir) + "/objects/pack"
+	files, err := ioutil.ReadDir(pack_dir)
+	if err != nil {
+		return nil
+	}
+	packIndexes.Lock()
+	defer packIndexes.Unlock()
+	indexes := make([]*PackIndex, 0)
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".idx") {
+			continue
+		}
+		path := pack_dir + "/" + file.Name()
+		index, ok := packIndexes.byPath[path]
+		if !ok {
+			index, err = ReadPackIndex(path)
+			if err != nil {
+				continue
+			}
+			packIndexes.byPath[path] = index
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes
+}
+
+// ListPackedObjects returns the SHA-1 of every object stored in packs.
+func ListPackedObjects(rootDir string) []string {
+	objects := make([]string, 0)
+	for _, index := range listPackIndexes(rootDir) {
+		for i := 0; i < index.Count(); i++ {
+			objects = append(objects, hex.EncodeToString(index.Name(i)))
+		}
+	}
+	sort.Strings(objects)
+	return objects
+}
+
+// ListObjects returns the SHA-1 of every object, loose or packed.
+func ListObjects(rootDir string) []string {
+	objects := ListLooseObjects(rootDir)
+	for _, sha1_name := range ListPackedObjects(rootDir) {
+		i := sort.SearchStrings(objects, sha1_name)
+		if i == len(objects) || objects[i] != sha1_name {
+			objects = append(objects, "")
+			copy(objects[i+1:], objects[i:])
+			objects[i] = sha1_name
+		}
+	}
+	return objects
+}
+
+func packedObjectExists(rootDir string, sha1_name []byte) bool {
+	for _, index := range listPackIndexes(rootDir) {
+		if _, ok := index.Find(sha1_name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// readPackedObject looks an object up in the packs of a repository and
+// returns its type and content.
+func readPackedObject(rootDir string, sha1_name []byte) (string, []byte, bool, error) {
+	for _, index := range listPackIndexes(rootDir) {
+		offset, ok := index.Find(sha1_name)
+		if !ok {
+			continue
+		}
+		pack, err := os.Open(index.PackPath)
+		if err != nil {
+			return "", nil, true, err
+		}
+		defer pack.Close()
+		typ, content, err := readPackEntry(rootDir, pack, offset)
+		return typ, content, true, err
+	}
+	return "", nil, false, nil
+}
+
+// readPackEntry reads the object at offset in a pack, resolving deltas.
+func readPackEntry(rootDir string, pack io.ReaderAt, offset int64) (string, []byte, error) {
+	header := make([]byte, 32)
+	n, err := pack.ReadAt(header, offset)
+	if n == 0 {
+		return "", nil, err
+	}
+	header = header[:n]
+
+	typ := (header[0] >> 4) & 7
+	size := int(header[0] & 15)
+	position := 1
+	for shift := uint(4); header[position-1]&0x80 != 0; shift += 7 {
+		if position == len(header) {
+			return "", nil, errors.New("pack entry header is broken")
+		}
+		size |= int(header[position]&0x7f) << shift
+		position++
+	}
+
+	var base_type string
+	var base []byte
+	switch typ {
+	case packOfsDelta:
+		// the distance back to the base, in git's big-endian varint
+		distance := int64(header[position] & 0x7f)
+		for header[position]&0x80 != 0 {
+			position++
+			distance = ((distance + 1) << 7) | int64(header[position]&0x7f)
+		}
+		position++
+		base_type, base, err = readPackEntry(rootDir, pack, offset-distance)
+		if err != nil {
+			return "", nil, err
+		}
+	case packRefDelta:
+		base_name := header[position : position+20]
+		position += 20
+		base_obj, err := ReadObject(rootDir, hex.EncodeToString(base_name))
+		if err != nil {
+			return "", nil, err
+		}
+		base_type, base = base_obj.typ, base_obj.content
+	default:
+		if packObjectTypes[typ] == "" {
+			return "", nil, errors.New("pack entry has an unknown type")
+		}
+	}
+
+	reader, err := zlib.NewReader(io.NewSectionReader(pack, offset+int64(position), 1<<62))
+	if err != nil {
+		return "", nil, err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return "", nil, err
+	}
+	if typ != packOfsDelta && typ != packRefDelta {
+		return packObjectTypes[typ], data, nil
+	}
+	content, err := applyDelta(base, data)
+	return base_type, content, err
+}
+
+// applyDelta rebuilds an object from its base and a delta, which copies
+// ranges of the base and inserts new data.
+func applyDelta(base []byte, delta []byte) ([]byte, error) {
+	broken := errors.New("delta is broken")
+	position := 0
+	read_size := func() int {
+		size := 0
+		for shift := uint(0); position < len(delta); shift += 7 {
+			b := delta[position]
+			position++
+			size |= int(b&0x7f) << shift
+			if b&0x80 == 0 {
+				break
+			}
+		}
+		return size
+	}
+	if read_size() != len(base) {
+		return nil, broken
+	}
+	result := make([]byte, 0, read_size())
+	for position < len(delta) {
+		instruction := delta[position]
+		position++
+		if instruction&0x80 == 0 {
+			// insert the next instruction bytes
+			if instruction == 0 || position+int(instruction) > len(delta) {
+				return nil, broken
+			}
+			result = append(result, delta[position:position+int(instruction)]...)
+			position += int(instruction)
+			continue
+		}
+		// copy from the base, with the offset and size bytes given by the bits
+		// of the instruction
+		offset, size := 0, 0
+		for i := uint(0); i < 7; i++ {
+			if instruction&(1<<i) == 0 {
+				continue
+			}
+			if position == len(delta) {
+				return nil, broken
+			}
+			if i < 4 {
+				offset |= int(delta[position]) << (8 * i)
+			} else {
+				size |= int(delta[position]) << (8 * (i - 4))
+			}
+			position++
+		}
+		if size == 0 {
+			size = 0x10000
+		}
+		if offset+size > len(base) {
+			return nil, broken
+		}
+		result = append(result, base[offset:offset+size]...)
+	}
+	if len(result) != cap(result) {
+		return nil, broken
+	}
+	return result, nil
+}
+
+// countingReader hashes and counts the bytes of a pack as they are read,
+// copying them to out if set. It reads them one at a time for zlib, which
+// would read ahead of the end of an object otherwise.
+type countingReader struct {
+	reader *bufio.Reader
+	hash   hash.Hash
+	offset int64
+	out    io.Writer
+}
+
+func (reader *countingReader) Read(buffer []byte) (int, error) {
+	n, err := reader.reader.Read(buffer)
+	reader.hash.Write(buffer[:n])
+	reader.offset += int64(n)
+	if reader.out != nil {
+		reader.out.Write(buffer[:n])
+	}
+	return n, err
+}
+
+func (reader *countingReader) ReadByte() (byte, error) {
+	b, err := reader.reader.ReadByte()
+	if err == nil {
+		reader.hash.Write([]byte{b})
+		reader.offset++
+		if reader.out != nil {
+			reader.out.Write([]byte{b})
+		}
+	}
+	return b, err
+}
+
+// a delta whose base has not been seen yet
+type pendingDelta struct {
+	offset    int64
+	base_sha1 string
+	delta     []byte
+}
+
+// UnpackObjects reads a pack from a stream, as it is sent by fetch and push,
+// and writes its objects loose into the repository at rootDir. Deltas may be
+// against objects which are not in the pack but in the repository, as in the
+// "thin" packs of the protocol. It returns the number of objects.
+func UnpackObjects(rootDir string, stream io.Reader) (int, error) {
+	count, _, err := readPackStream(stream, nil, func(sha1_name string) (*GitObject, error) {
+		return readExistingObject(rootDir, sha1_name)
+	}, func(offset int64, typ string, content []byte) string {
+		obj := NewGitObject(rootDir, typ, content)
+		obj.WriteToFile()
+		return hex.EncodeToString(obj.HashedFilename)
+	})
+	return count, err
+}
+
+// readPackStream reads a pack from a stream, copying it to out if set, and
+// passes every object to store with its offset, which returns its name.
+// The bases of deltas are read with load. It returns the number of objects
+// and the checksum of the pack.
+func readPackStream(stream io.Reader, out io.Writer, load func(sha1_name string) (*GitObject, error), store func(offset int64, typ string, content []byte) string) (int, []byte, error) {
+	reader := &countingReader{reader: bufio.NewReader(stream), hash: sha1.New(), out: out}
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return 0, nil, errors.New("pack has a truncated header")
+	}
+	version := binary.BigEndian.Uint32(header[4:])
+	if !bytes.HasPrefix(header, []byte("PACK")) || version != 2 && version != 3 {
+		return 0, nil, errors.New("pack has a bad header")
+	}
+	count := int(binary.BigEndian.Uint32(header[8:]))
+
+	names := make(map[int64]string) // by offset
+	resolve := func(offset int64, base *GitObject, delta []byte) error {
+		content, err := applyDelta(base.content, delta)
+		if err != nil {
+			return err
+		}
+		names[offset] = store(offset, base.typ, content)
+		return nil
+	}
+
+	pending := make([]*pendingDelta, 0)
+	for i := 0; i < count; i++ {
+		offset := reader.offset
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, nil, errors.New("pack is truncated")
+		}
+		typ := (b >> 4) & 7
+		size := int(b & 15)
+		for shift := uint(4); b&0x80 != 0; shift += 7 {
+			if b, err = reader.ReadByte(); err != nil {
+				return 0, nil, errors.New("pack is truncated")
+			}
+			size |= int(b&0x7f) << shift
+		}
+
+		base_sha1 := ""
+		switch typ {
+		case packOfsDelta:
+			b, err = reader.ReadByte()
+			distance := int64(b & 0x7f)
+			for err == nil && b&0x80 != 0 {
+				b, err = reader.ReadByte()
+				distance = ((distance + 1) << 7) | int64(b&0x7f)
+			}
+			if err != nil {
+				return 0, nil, errors.New("pack is truncated")
+			}
+			base_sha1 = names[offset-distance]
+			if base_sha1 == "" {
+				return 0, nil, errors.New("pack has a delta against an unknown offset")
+			}
+		case packRefDelta:
+			base_name := make([]byte, 20)
+			if _, err := io.ReadFull(reader, base_name); err != nil {
+				return 0, nil, errors.New("pack is truncated")
+			}
+			base_sha1 = hex.EncodeToString(base_name)
+		default:
+			if packObjectTypes[typ] == "" {
+				return 0, nil, errors.New("pack has an object of unknown type")
+			}
+		}
+
+		zlib_reader, err := zlib.NewReader(reader)
+		if err != nil {
+			return 0, nil, err
+		}
+		data, err := io.ReadAll(zlib_reader)
+		if err != nil || len(data) != size {
+			return 0, nil, errors.New("pack has a broken object at offset " + strconv.FormatInt(offset, 10))
+		}
+
+		if base_sha1 == "" {
+			names[offset] = store(offset, packObjectTypes[typ], data)
+		} else if base, err := load(base_sha1); err == nil {
+			if err := resolve(offset, base, data); err != nil {
+				return 0, nil, err
+			}
+		} else {
+			// the base may come later in the pack
+			pending = append(pending, &pendingDelta{offset, base_sha1, data})
+		}
+	}
+
+	for len(pending) != 0 {
+		unresolved := make([]*pendingDelta, 0)
+		for _, delta := range pending {
+			base, err := load(delta.base_sha1)
+			if err != nil {
+				unresolved = append(unresolved, delta)
+			} else if err := resolve(delta.offset, base, delta.delta); err != nil {
+				return 0, nil, err
+			}
+		}
+		if len(unresolved) == len(pending) {
+			return 0, nil, errors.New("pack has a delta against the missing object " + unresolved[0].base_sha1)
+		}
+		pending = unresolved
+	}
+
+	checksum := reader.hash.Sum(nil)
+	trailer := make([]byte, 20)
+	if _, err := io.ReadFull(reader.reader, trailer); err != nil || !bytes.Equal(trailer, checksum) {
+		return 0, nil, errors.New("pack has a bad checksum")
+	}
+	if out != nil {
+		if _, err := out.Write(trailer); err != nil {
+			return 0, nil, err
+		}
+	}
+	return count, checksum, nil
+}
+
+// IndexPack reads a pack from a stream and keeps it as it is in
+// .git/objects/pack, next to the index it writes for it, instead of writing
+// its objects loose. It returns the name of the pack, "pack-<checksum>".
+func IndexPack(rootDir string, stream io.Reader) (string, error) {
+	pack_dir := GitDir(rootDir) + "/objects/pack"
+	if err := os.MkdirAll(pack_dir, 0755); err != nil {
+		return "", err
+	}
+	file, err := ioutil.TempFile(pack_dir, "tmp_pack_")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	// the objects of the pack are kept until it is indexed, as the bases of
+	// its deltas
+	objects := make(map[string]*GitObject)
+	offsets := make(map[string]int64)
+	writer := bufio.NewWriter(file)
+	_, checksum, err := readPackStream(stream, writer, func(sha1_name string) (*GitObject, error) {
+		if obj, ok := objects[sha1_name]; ok {
+			return obj, nil
+		}
+		return readExistingObject(rootDir, sha1_name)
+	}, func(offset int64, typ string, content []byte) string {
+		obj := NewGitObject(rootDir, typ, content)
+		sha1_name := hex.EncodeToString(obj.Hash())
+		objects[sha1_name] = obj
+		offsets[sha1_name] = offset
+		return sha1_name
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		return "", err
+	}
+	content, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		return "", err
+	}
+	index := packIndexContent(content, offsets, checksum)
+
+	name := "pack-" + hex.EncodeToString(checksum)
+	if err := ioutil.WriteFile(pack_dir+"/"+name+".idx", index, 0444); err != nil {
+		return "", err
+	}
+	if err := os.Rename(file.Name(), pack_dir+"/"+name+".pack"); err != nil {
+		return "", err
+	}
+	os.Chmod(pack_dir+"/"+name+".pack", 0444)
+	return name, nil
+}
+
+// packIndexContent makes the version 2 index of a pack, given the offsets
+// of its objects by name.
+func packIndexContent(pack []byte, offsets map[string]int64, checksum []byte) []byte {
+	names := make([]string, 0, len(offsets))
+	ends := make([]int64, 0, len(offsets))
+	for sha1_name, offset := range offsets {
+		names = append(names, sha1_name)
+		ends = append(ends, offset)
+	}
+	sort.Strings(names)
+	// an object ends where the next one starts, or at the checksum
+	ends = append(ends, int64(len(pack)-20))
+	sort.Slice(ends, func(i, j int) bool { return ends[i] < ends[j] })
+
+	index := new(bytes.Buffer)
+	index.WriteString("\377tOc")
+	binary.Write(index, binary.BigEndian, uint32(2))
+	var fanout [256]uint32
+	for _, sha1_name := range names {
+		first, _ := hex.DecodeString(sha1_name[:2])
+		for i := int(first[0]); i < 256; i++ {
+			fanout[i]++
+		}
+	}
+	binary.Write(index, binary.BigEndian, fanout)
+	for _, sha1_name := range names {
+		sha1_byte, _ := hex.DecodeString(sha1_name)
+		index.Write(sha1_byte)
+	}
+	for _, sha1_name := range names {
+		offset := offsets[sha1_name]
+		end := ends[sort.Search(len(ends), func(i int) bool { return ends[i] > offset })]
+		binary.Write(index, binary.BigEndian, crc32.ChecksumIEEE(pack[offset:end]))
+	}
+	large := make([]uint64, 0)
+	for _, sha1_name := range names {
+		offset := offsets[sha1_name]
+		if offset < 0x80000000 {
+			binary.Write(index, binary.BigEndian, uint32(offset))
+			continue
+		}
+		binary.Write(index, binary.BigEndian, uint32(0x80000000|len(large)))
+		large = append(large, uint64(offset))
+	}
+	binary.Write(index, binary.BigEndian, large)
+	index.Write(checksum)
+	index_checksum := sha1.Sum(index.Bytes())
+	index.Write(index_checksum[:])
+	return index.Bytes()
+}
+
+// WritePack writes objects as a pack, without deltas.
+func WritePack(writer io.Writer, objects []*GitObject) error {
+	checksum := sha1.New()
+	out := io.MultiWriter(writer, checksum)
+	header := make([]byte, 12)
+	copy(header, "PACK")
+	binary.BigEndian.PutUint32(header[4:], 2)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(objects)))
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		var typ byte
+		for code, name := range packObjectTypes {
+			if name == obj.typ {
+				typ = code
+			}
+		}
+		size := len(obj.content)
+		entry_header := []byte{typ<<4 | byte(size&15)}
+		for size >>= 4; size != 0; size >>= 7 {
+			entry_header[len(entry_header)-1] |= 0x80
+			entry_header = append(entry_header, byte(size&0x7f))
+		}
+		var buf bytes.Buffer
+		zlib_writer := zlib.NewWriter(&buf)
+		zlib_writer.Write(obj.content)
+		zlib_writer.Close()
+		if _, err := out.Write(append(entry_header, buf.Bytes()...)); err != nil {
+			return err
+		}
+	}
+	_, err := writer.Write(checksum.Sum(nil))
+	return err
+}
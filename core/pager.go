@@ -0,0 +1,104 @@
+package core
+
+import (
+	"io"
+	"os"
+	"os/exec"
+)
+
+// IsTerminal reports whether file is a terminal rather than a pipe or a
+// regular file.
+func IsTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Pager is where long output such as logs goes to. It writes to the stdin
+// of a pager command, or straight to stdout if there is no pager to run.
+type Pager struct {
+	io.Writer
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+// StartPager runs command through the shell and sends the output to it. An
+// empty command, or "cat", writes to stdout directly.
+func StartPager(command string) *Pager {
+	pager := &Pager{Writer: os.Stdout}
+	if command == "" || command == "cat" {
+		return pager
+	}
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	// quit if the output fits on one screen, keep colors, and don't clear the screen
+	if _, ok := os.LookupEnv("LESS"); !ok {
+		cmd.Env = append(cmd.Env, "LESS=FRX")
+	}
+	if _, ok := os.LookupEnv("LV"); !ok {
+		cmd.Env = append(cmd.Env, "LV=-c")
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return pager
+	}
+	if err := cmd.Start(); err != nil {
+		return pager
+	}
+	pager.Writer = stdin
+	pager.cmd = cmd
+	pager.stdin = stdin
+	return pager
+}
+
+// Close waits until the user quits the pager.
+func (pager *Pager) Close() {
+	if pager.cmd == nil {
+		return
+	}
+	pager.stdin.Close()
+	pager.cmd.Wait()
+}
+
+// pagerCommand picks the pager like git does: GIT_PAGER, then core.pager,
+// then PAGER, then less. No pager is used if stdout is not a terminal, or
+// if the default less is not installed.
+func (regit *ReGit) pagerCommand() string {
+	if regit.NoPager || !IsTerminal(os.Stdout) {
+		return ""
+	}
+	if command, ok := os.LookupEnv("GIT_PAGER"); ok {
+		return command
+	}
+	if command, ok := regit.Config["core.pager"]; ok {
+		return command
+	}
+	if command, ok := os.LookupEnv("PAGER"); ok {
+		return command
+	}
+	if _, err := exec.LookPath("less"); err != nil {
+		return ""
+	}
+	return "less"
+}
+
+func (regit *ReGit) startPager() *Pager {
+	return StartPager(regit.pagerCommand())
+}
+
+// UseColor tells whether to print colors, following color.ui: "always",
+// "never", or "auto" (the default) which colors only terminals.
+func (regit *ReGit) UseColor() bool {
+	switch regit.Config["color.ui"] {
+	case "always":
+		return true
+	case "never", "false":
+		return false
+	}
+	return IsTerminal(os.Stdout)
+}
@@ -0,0 +1,37 @@
+package core
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestStartPager(t *testing.T) {
+	output_path := t.TempDir() + "/output"
+	pager := StartPager("cat > " + output_path)
+	io.WriteString(pager, "first\nsecond\n")
+	pager.Close()
+
+	content, err := ioutil.ReadFile(output_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "first\nsecond\n" {
+		t.Errorf("the pager got %q", content)
+	}
+}
+
+func TestPagerCommand(t *testing.T) {
+	regit := &ReGit{Config: map[string]string{"core.pager": "more"}}
+	// the output of the tests is not a terminal
+	if command := regit.pagerCommand(); command != "" {
+		t.Errorf("the pager %q is used without a terminal", command)
+	}
+	if regit.UseColor() {
+		t.Errorf("colors are used without a terminal")
+	}
+	regit.Config["color.ui"] = "always"
+	if !regit.UseColor() {
+		t.Errorf("colors are not used with color.ui=always")
+	}
+}
@@ -0,0 +1,229 @@
+package core
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A partial clone leaves out some of the objects of the repository it is
+// cloned from, as its filter says, and fetches them from there, its
+// promisor remote, once they are read. The remote is named by
+// extensions.partialClone in the config. The packs fetched into it are kept
+// as they are, with a .promisor file next to them, so that what their
+// objects point to may be missing.
+
+// ObjectFilter tells which objects a fetch leaves out: "blob:none" leaves
+// out all blobs, "blob:limit=<n>[kmg]" those of n bytes or more.
+type ObjectFilter struct {
+	Spec  string
+	limit int64 // -1 for blob:none
+}
+
+// ParseObjectFilter reads a filter as given to clone --filter.
+func ParseObjectFilter(spec string) (*ObjectFilter, error) {
+	if spec == "blob:none" {
+		return &ObjectFilter{spec, -1}, nil
+	}
+	if !strings.HasPrefix(spec, "blob:limit=") {
+		return nil, errors.New("invalid filter-spec '" + spec + "'")
+	}
+	value := spec[len("blob:limit="):]
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(value, "k"):
+		unit = 1 << 10
+	case strings.HasSuffix(value, "m"):
+		unit = 1 << 20
+	case strings.HasSuffix(value, "g"):
+		unit = 1 << 30
+	}
+	if unit != 1 {
+		value = value[:len(value)-1]
+	}
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit < 0 {
+		return nil, errors.New("invalid filter-spec '" + spec + "'")
+	}
+	return &ObjectFilter{spec, limit * unit}, nil
+}
+
+// omits tells whether the filter leaves an object out.
+func (filter *ObjectFilter) omits(obj *GitObject) bool {
+	return obj.typ == "blob" && (filter.limit == -1 || int64(len(obj.content)) >= filter.limit)
+}
+
+// filterObjects leaves out the objects a filter omits, but for those which
+// are wanted by name.
+func filterObjects(objects []*GitObject, filter *ObjectFilter, wants []string) []*GitObject {
+	wanted := make(map[string]bool)
+	for _, want := range wants {
+		wanted[want] = true
+	}
+	kept := make([]*GitObject, 0, len(objects))
+	for _, obj := range objects {
+		if !filter.omits(obj) || wanted[hex.EncodeToString(obj.Hash())] {
+			kept = append(kept, obj)
+		}
+	}
+	return kept
+}
+
+// checkFilterSupport drops the filter of a fetch from a server which does
+// not know about filters, which sends every object instead, as git does.
+func checkFilterSupport(filter *ObjectFilter, supported bool) *ObjectFilter {
+	if filter != nil && !supported {
+		os.Stderr.WriteString("warning: filtering not recognized by server, ignoring\n")
+		return nil
+	}
+	return filter
+}
+
+// PromisorRemote returns the remote a partial clone fetches its missing
+// objects from, which is "" for a complete repository.
+func PromisorRemote(rootDir string) string {
+	config := make(map[string]string)
+	if ReadConfigFile(GitDir(rootDir)+"/config", config) != nil {
+		return ""
+	}
+	return config["extensions.partialclone"]
+}
+
+// IsPartialClone tells whether a repository may miss objects which its
+// promisor remote has.
+func IsPartialClone(rootDir string) bool {
+	return PromisorRemote(rootDir) != ""
+}
+
+// storePack adds the objects of a pack a fetch receives to the repository:
+// they are written loose, or for a partial clone, the pack is kept as a
+// promisor pack.
+func storePack(rootDir string, pack io.Reader) error {
+	if !IsPartialClone(rootDir) {
+		_, err := UnpackObjects(rootDir, pack)
+		return err
+	}
+	name, err := IndexPack(rootDir, pack)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(GitDir(rootDir)+"/objects/pack/"+name+".promisor", nil, 0444)
+}
+
+// isPromisorObject tells whether an object is in a promisor pack, in which
+// case the objects it points to may be missing.
+func isPromisorObject(rootDir string, sha1_name string) bool {
+	sha1_byte, err := hex.DecodeString(sha1_name)
+	if err != nil {
+		return false
+	}
+	for _, index := range listPackIndexes(rootDir) {
+		if _, ok := index.Find(sha1_byte); !ok {
+			continue
+		}
+		if _, err := os.Stat(strings.TrimSuffix(index.PackPath, ".pack") + ".promisor"); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// When true, reading an object which a partial clone is missing fetches it
+// from the promisor remote. fsck and prune turn it off, as they look for
+// what is missing.
+var FetchMissingObjects = true
+
+// the objects which could not be fetched, by path of the repository, so
+// that they are not asked for again; and the repositories being fetched
+// into, which do not fetch what they miss meanwhile
+var (
+	promisorFailed  = make(map[string]map[string]bool)
+	promisorFetches = make(map[string]bool)
+	promisorMutex   sync.Mutex
+)
+
+// FetchPromisedObjects fetches the objects of a partial clone which are
+// missing from its promisor remote, all of them in a single request, e.g.
+// the blobs of the files a checkout writes. It does nothing for a complete
+// repository.
+func FetchPromisedObjects(rootDir string, sha1_names []string) error {
+	remote := PromisorRemote(rootDir)
+	if remote == "" || !FetchMissingObjects {
+		return nil
+	}
+	promisorMutex.Lock()
+	if promisorFetches[rootDir] {
+		promisorMutex.Unlock()
+		return nil
+	}
+	wants := make([]string, 0)
+	for _, sha1_name := range missingObjects(rootDir, sha1_names) {
+		if !promisorFailed[rootDir][sha1_name] {
+			wants = append(wants, sha1_name)
+		}
+	}
+	if len(wants) == 0 {
+		promisorMutex.Unlock()
+		return nil
+	}
+	promisorFetches[rootDir] = true
+	promisorMutex.Unlock()
+
+	err := fetchFromPromisor(rootDir, remote, wants)
+
+	promisorMutex.Lock()
+	defer promisorMutex.Unlock()
+	delete(promisorFetches, rootDir)
+	if promisorFailed[rootDir] == nil {
+		promisorFailed[rootDir] = make(map[string]bool)
+	}
+	for _, sha1_name := range missingObjects(rootDir, wants) {
+		promisorFailed[rootDir][sha1_name] = true
+	}
+	return err
+}
+
+// fetchingPromisedObjects tells whether a partial clone is fetching the
+// objects it is missing.
+func fetchingPromisedObjects(rootDir string) bool {
+	promisorMutex.Lock()
+	defer promisorMutex.Unlock()
+	return promisorFetches[rootDir]
+}
+
+// fetchPromisedObjectsOrExit fetches the objects a partial clone is missing
+// before a command reads them one by one.
+func (regit *ReGit) fetchPromisedObjectsOrExit(sha1_names []string) {
+	if err := FetchPromisedObjects(regit.RootDir, sha1_names); err != nil {
+		fmt.Println("Error: could not fetch the missing objects: " + err.Error())
+		os.Exit(1)
+	}
+}
+
+func fetchFromPromisor(rootDir string, remote string, wants []string) error {
+	regit := NewReGit(rootDir)
+	url, ok := regit.Config["remote."+remote+".url"]
+	if !ok {
+		return errors.New("promisor remote '" + remote + "' does not exist")
+	}
+	transport, err := regit.openTransport(url)
+	if err != nil {
+		return err
+	}
+	return transport.Fetch(rootDir, wants, nil, nil)
+}
+
+// readExistingObject reads an object which may be missing without fetching
+// it, even from the promisor remote of a partial clone.
+func readExistingObject(rootDir string, sha1_name string) (*GitObject, error) {
+	if !ObjectExists(rootDir, sha1_name) {
+		return nil, errors.New("object " + sha1_name + " is missing")
+	}
+	return ReadObject(rootDir, sha1_name)
+}
@@ -0,0 +1,116 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseObjectFilter(t *testing.T) {
+	tests := []struct {
+		spec  string
+		limit int64
+	}{
+		{"blob:none", -1},
+		{"blob:limit=0", 0},
+		{"blob:limit=100", 100},
+		{"blob:limit=2k", 2 << 10},
+		{"blob:limit=1m", 1 << 20},
+	}
+	for _, test := range tests {
+		filter, err := ParseObjectFilter(test.spec)
+		if err != nil || filter.Spec != test.spec || filter.limit != test.limit {
+			t.Errorf("%q gave %+v, %v", test.spec, filter, err)
+		}
+	}
+	for _, spec := range []string{"tree:0", "blob:limit=", "blob:limit=-1", "blob:limit=1x"} {
+		if _, err := ParseObjectFilter(spec); err == nil {
+			t.Errorf("%q was taken", spec)
+		}
+	}
+}
+
+func TestUploadPackFilter(t *testing.T) {
+	isolateHome(t)
+	rootDir, _, second := newTestHistory(t)
+	request := PktLine("want "+second+" ofs-delta\n") + PktLine("filter blob:none\n") + pktFlush + PktLine("done\n")
+	out := new(bytes.Buffer)
+	if err := UploadPack(rootDir, strings.NewReader(request), out, true, false); err != nil {
+		t.Fatal(err)
+	}
+	pkt_reader := NewPktReader(out)
+	if line, err := pkt_reader.ReadLine(); err != nil || line != "NAK" {
+		t.Fatalf("the server answered %q, %v", line, err)
+	}
+	destination := newTestObjectStore(t)
+	count, err := UnpackObjects(destination, pkt_reader.reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the two commits and their three trees, but neither blob
+	if count != 5 {
+		t.Errorf("the pack has %d objects, want 5", count)
+	}
+}
+
+// newTestPartialClone makes a bare partial clone without any blob of a
+// repository served over HTTP.
+func newTestPartialClone(t *testing.T) (string, string) {
+	isolateHome(t)
+	dir := t.TempDir()
+	newTestRepository(t, dir+"/origin")
+	commitFile(t, dir+"/origin", "a.txt", "a\n")
+	commitFile(t, dir+"/origin", "b.txt", "b\n")
+	server := newRegitHTTPServer(t, dir)
+	filter, err := ParseObjectFilter("blob:none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	captureOutput(t, func() {
+		NewReGit(dir).Clone(server.URL+"/origin", "clone.git", true, "", nil, filter)
+	})
+	return dir + "/origin", dir + "/clone.git"
+}
+
+func TestPartialClone(t *testing.T) {
+	_, clone := newTestPartialClone(t)
+	if remote := PromisorRemote(clone); remote != "origin" {
+		t.Fatalf("the promisor remote is %q", remote)
+	}
+	promisors, _ := filepath.Glob(clone + "/objects/pack/*.promisor")
+	if len(promisors) != 1 {
+		t.Errorf("the clone has the promisor files %v", promisors)
+	}
+	a_blob := hex.EncodeToString((&GitObject{typ: "blob", content: []byte("a\n")}).Hash())
+	if ObjectExists(clone, a_blob) {
+		t.Fatal("the blob was cloned")
+	}
+	// the blobs left out are not missing for fsck
+	FetchMissingObjects = false
+	report := NewFsck(clone).Run(false)
+	FetchMissingObjects = true
+	if report.HasErrors() {
+		t.Errorf("fsck found %v", report.Problems)
+	}
+
+	// reading the blob fetches it, though no ref points to it
+	obj, err := ReadObject(clone, a_blob)
+	if err != nil || string(obj.content) != "a\n" {
+		t.Fatalf("the blob reads %q, %v", obj, err)
+	}
+	if !ObjectExists(clone, a_blob) {
+		t.Error("the fetched blob was not kept")
+	}
+}
+
+func TestPartialCloneMissingObject(t *testing.T) {
+	_, clone := newTestPartialClone(t)
+	// the server does not send what its refs do not reach
+	unknown := strings.Repeat("1", 40)
+	_, err := ReadObject(clone, unknown)
+	if err == nil || !strings.Contains(err.Error(), "can not be fetched from the promisor remote") {
+		t.Errorf("reading %s gave %v", unknown, err)
+	}
+}
@@ -0,0 +1,179 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// The git protocols frame their messages as pkt-lines: four hex digits of
+// length, which counts themselves, followed by the data. "0000" is a flush
+// packet, which ends a list, and "0001" a delimiter packet, which separates
+// the sections of a protocol v2 message.
+
+const (
+	pktFlush = "0000"
+	pktDelim = "0001"
+	// the largest data a pkt-line can carry
+	pktMaxData = 65516
+)
+
+// the kinds of packets ReadPkt returns besides data
+var (
+	errPktFlush = errors.New("flush packet")
+	errPktDelim = errors.New("delimiter packet")
+)
+
+// PktLine frames data as a pkt-line.
+func PktLine(data string) string {
+	return fmt.Sprintf("%04x", len(data)+4) + data
+}
+
+// PktReader reads pkt-lines from a stream.
+type PktReader struct {
+	reader *bufio.Reader
+}
+
+func NewPktReader(reader io.Reader) *PktReader {
+	pkt_reader := new(PktReader)
+	if buffered, ok := reader.(*bufio.Reader); ok {
+		pkt_reader.reader = buffered
+	} else {
+		pkt_reader.reader = bufio.NewReader(reader)
+	}
+	return pkt_reader
+}
+
+// ReadPkt returns the data of the next pkt-line, or errPktFlush or
+// errPktDelim for the special packets.
+func (pkt_reader *PktReader) ReadPkt() ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(pkt_reader.reader, header); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	length, err := strconv.ParseUint(string(header), 16, 16)
+	if err != nil {
+		return nil, errors.New("protocol error: bad line length " + strconv.Quote(string(header)))
+	}
+	switch {
+	case length == 0:
+		return nil, errPktFlush
+	case length == 1:
+		return nil, errPktDelim
+	case length < 4:
+		return nil, errors.New("protocol error: bad line length " + strconv.Quote(string(header)))
+	}
+	data := make([]byte, length-4)
+	if _, err := io.ReadFull(pkt_reader.reader, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// ReadLine returns the next pkt-line as a string without its trailing newline.
+func (pkt_reader *PktReader) ReadLine() (string, error) {
+	data, err := pkt_reader.ReadPkt()
+	if err != nil {
+		return "", err
+	}
+	if len(data) != 0 && data[len(data)-1] == '\n' {
+		data = data[:len(data)-1]
+	}
+	return string(data), nil
+}
+
+// ReadLines returns the lines up to the next flush or delimiter packet.
+func (pkt_reader *PktReader) ReadLines() ([]string, error) {
+	lines := make([]string, 0)
+	for {
+		line, err := pkt_reader.ReadLine()
+		if err == errPktFlush || err == errPktDelim {
+			return lines, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+}
+
+// sideBandReader demultiplexes the side-band-64k stream of a response: band
+// 1 carries the data, band 2 progress messages, which are passed to
+// progress, and band 3 a fatal error. The stream ends with a flush packet.
+type sideBandReader struct {
+	pkt_reader *PktReader
+	progress   io.Writer
+	pending    []byte
+	done       bool
+}
+
+func newSideBandReader(pkt_reader *PktReader, progress io.Writer) *sideBandReader {
+	return &sideBandReader{pkt_reader: pkt_reader, progress: progress}
+}
+
+func (reader *sideBandReader) Read(buffer []byte) (int, error) {
+	for len(reader.pending) == 0 {
+		if reader.done {
+			return 0, io.EOF
+		}
+		data, err := reader.pkt_reader.ReadPkt()
+		if err == errPktFlush {
+			reader.done = true
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		switch data[0] {
+		case 1:
+			reader.pending = data[1:]
+		case 2:
+			if reader.progress != nil {
+				reader.progress.Write(append([]byte("remote: "), data[1:]...))
+			}
+		case 3:
+			return 0, errors.New("remote error: " + string(data[1:]))
+		default:
+			return 0, errors.New("protocol error: bad band #" + strconv.Itoa(int(data[0])))
+		}
+	}
+	n := copy(buffer, reader.pending)
+	reader.pending = reader.pending[n:]
+	return n, nil
+}
+
+// sideBandWriter sends data on a band of a side-band-64k stream, in packets
+// as large as the protocol allows.
+type sideBandWriter struct {
+	writer io.Writer
+	band   byte
+}
+
+func newSideBandWriter(writer io.Writer, band byte) *sideBandWriter {
+	return &sideBandWriter{writer: writer, band: band}
+}
+
+func (writer *sideBandWriter) Write(data []byte) (int, error) {
+	written := 0
+	for len(data) != 0 {
+		size := len(data)
+		if size > pktMaxData-1 {
+			size = pktMaxData - 1
+		}
+		packet := append([]byte(fmt.Sprintf("%04x", size+5)), writer.band)
+		if _, err := writer.writer.Write(append(packet, data[:size]...)); err != nil {
+			return written, err
+		}
+		written += size
+		data = data[size:]
+	}
+	return written, nil
+}
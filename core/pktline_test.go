@@ -0,0 +1,77 @@
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPktLine(t *testing.T) {
+	if line := PktLine("want 1234\n"); line != "000ewant 1234\n" {
+		t.Errorf("framed as %q", line)
+	}
+	pkt_reader := NewPktReader(strings.NewReader(PktLine("a\n") + PktLine("b") + pktDelim + PktLine("c\n") + pktFlush + "00x1"))
+	lines, err := pkt_reader.ReadLines()
+	if err != nil || !reflect.DeepEqual(lines, []string{"a", "b"}) {
+		t.Errorf("read %q, %v before the delimiter", lines, err)
+	}
+	lines, err = pkt_reader.ReadLines()
+	if err != nil || !reflect.DeepEqual(lines, []string{"c"}) {
+		t.Errorf("read %q, %v before the flush", lines, err)
+	}
+	if _, err := pkt_reader.ReadPkt(); err == nil {
+		t.Error("a bad line length was read")
+	}
+	if _, err := pkt_reader.ReadPkt(); err == nil {
+		t.Error("a packet was read past the end")
+	}
+}
+
+func TestSideBandReader(t *testing.T) {
+	stream := PktLine("\x01PACK") + PktLine("\x02counting objects\n") + PktLine("\x01data") + pktFlush
+	progress := new(bytes.Buffer)
+	content, err := ioutil.ReadAll(newSideBandReader(NewPktReader(strings.NewReader(stream)), progress))
+	if err != nil || string(content) != "PACKdata" {
+		t.Errorf("read %q, %v", content, err)
+	}
+	if progress.String() != "remote: counting objects\n" {
+		t.Errorf("the progress is %q", progress)
+	}
+
+	stream = PktLine("\x03access denied") + pktFlush
+	if _, err := ioutil.ReadAll(newSideBandReader(NewPktReader(strings.NewReader(stream)), nil)); err == nil || err.Error() != "remote error: access denied" {
+		t.Errorf("the error band gave %v", err)
+	}
+}
+
+// TestPackRoundTrip writes a pack of some objects, then unpacks it into
+// another repository.
+func TestPackRoundTrip(t *testing.T) {
+	isolateHome(t)
+	rootDir, first, second := newTestHistory(t)
+	objects := make([]*GitObject, 0)
+	for _, sha1_name := range []string{first, second} {
+		obj, err := ReadObject(rootDir, sha1_name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		objects = append(objects, obj)
+	}
+	pack := new(bytes.Buffer)
+	if err := WritePack(pack, objects); err != nil {
+		t.Fatal(err)
+	}
+
+	destination := newTestObjectStore(t)
+	count, err := UnpackObjects(destination, pack)
+	if err != nil || count != 2 {
+		t.Fatalf("unpacked %d objects, %v", count, err)
+	}
+	for _, sha1_name := range []string{first, second} {
+		if !ObjectExists(destination, sha1_name) {
+			t.Errorf("%s was not unpacked", sha1_name)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package core
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func (regit *ReGit) resolveRevisionOrExit(revision string) string {
+	sha1_name, err := ResolveRevision(regit.RootDir, revision)
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+	return sha1_name
+}
+
+func (regit *ReGit) readObjectOrExit(sha1_name string) *GitObject {
+	obj, err := ReadObject(regit.RootDir, sha1_name)
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+	return obj
+}
+
+// modes are printed with 6 digits, e.g. "040000" for trees
+func formatMode(mode string) string {
+	if len(mode) < 6 {
+		return strings.Repeat("0", 6-len(mode)) + mode
+	}
+	return mode
+}
+
+// "<mode> SP <type> SP <object> TAB <file>"
+func formatTreeEntry(entry *TreeEntry, path string) string {
+	return formatMode(entry.FileType) + " " + entry.Type() + " " + hex.EncodeToString(entry.HashedFilename) + "\t" + path
+}
+
+// CatFile prints information about an object: its type (-t), its size (-s),
+// its pretty-printed content (-p), or nothing but the exit status (-e).
+func (regit *ReGit) CatFile(option string, object string) {
+	sha1_name, err := ResolveRevision(regit.RootDir, object)
+	if option == "-e" {
+		if err != nil || !ObjectExists(regit.RootDir, sha1_name) {
+			os.Exit(1)
+		}
+		return
+	}
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+	obj := regit.readObjectOrExit(sha1_name)
+
+	switch option {
+	case "-t":
+		fmt.Println(obj.typ)
+	case "-s":
+		fmt.Println(len(obj.content))
+	case "-p":
+		if obj.typ != "tree" {
+			os.Stdout.Write(obj.content)
+			return
+		}
+		tree := NewTreeObject(regit.RootDir)
+		tree.Obj = *obj
+		if err := tree.parse(); err != nil {
+			fmt.Println("Error: " + err.Error())
+			os.Exit(1)
+		}
+		for _, entry := range tree.Entries {
+			fmt.Println(formatTreeEntry(entry, entry.FileName))
+		}
+	default:
+		fmt.Println("Error: unknown option '" + option + "'")
+		os.Exit(1)
+	}
+}
+
+// the default size of the object cache used by `cat-file --batch`
+const BatchObjectCacheSize = 64 * 1024 * 1024
+
+// CatFileBatch reads one object name per line from stdin and prints
+// "<sha1> SP <type> SP <size> LF <content> LF" for each of them, or
+// "<object> SP missing LF" if the object can not be found. With check_only
+// set, the content is left out. With all_objects set, every object in the
+// store is printed and stdin is not read. The output is flushed after every
+// object so that other processes can drive it interactively, unless buffer is set.
+func (regit *ReGit) CatFileBatch(check_only bool, all_objects bool, buffer bool, cache_size int) {
+	EnableObjectCache(cache_size)
+
+	writer := bufio.NewWriter(os.Stdout)
+	defer writer.Flush()
+	print_object := func(object string) {
+		sha1_name := strings.ToLower(object)
+		// full object names are taken as they are, which saves looking up refs
+		if !isValidSHA1Name(sha1_name) {
+			var err error
+			sha1_name, err = ResolveRevision(regit.RootDir, object)
+			if err != nil {
+				writer.WriteString(object + " missing\n")
+				return
+			}
+		}
+		obj, err := ReadObject(regit.RootDir, sha1_name)
+		if err != nil {
+			writer.WriteString(object + " missing\n")
+			return
+		}
+		writer.WriteString(sha1_name + " " + obj.typ + " " + strconv.Itoa(len(obj.content)) + "\n")
+		if !check_only {
+			writer.Write(obj.content)
+			writer.WriteString("\n")
+		}
+	}
+
+	if all_objects {
+		for _, sha1_name := range ListObjects(regit.RootDir) {
+			print_object(sha1_name)
+		}
+		return
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		print_object(scanner.Text())
+		if !buffer {
+			writer.Flush()
+		}
+	}
+}
+
+// HashObject prints the name of an object of type typ holding the content of
+// each file, or of stdin, and writes the objects into the store if write is true.
+func (regit *ReGit) HashObject(typ string, write bool, from_stdin bool, path_names []string) {
+	contents := make([][]byte, 0)
+	if from_stdin {
+		content, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		contents = append(contents, content)
+	}
+	for _, path_name := range path_names {
+		content, err := ioutil.ReadFile(path_name)
+		if err != nil {
+			fmt.Println("Error: could not open '" + path_name + "' for reading")
+			os.Exit(1)
+		}
+		contents = append(contents, content)
+	}
+
+	for _, content := range contents {
+		obj := NewGitObject(regit.RootDir, typ, content)
+		if write {
+			obj.WriteToFile()
+		} else {
+			obj.Hash()
+		}
+		fmt.Println(hex.EncodeToString(obj.HashedFilename))
+	}
+}
+
+// LsTree lists the entries of a tree-ish. With recursive set, subtrees are
+// listed instead of being shown, unless show_trees is also set. With long
+// set, the size of blobs is shown as well.
+func (regit *ReGit) LsTree(tree_ish string, recursive bool, show_trees bool, long bool, path_names []string) {
+	tree_sha1 := regit.resolveRevisionOrExit(tree_ish + "^{tree}")
+	regit.lsTree(tree_sha1, "", recursive, show_trees, long, path_names)
+}
+
+// whether path should be shown given the path names asked for, and whether
+// the directory at path contains any of them
+func matchPathNames(path string, path_names []string) (bool, bool) {
+	if len(path_names) == 0 {
+		return true, true
+	}
+	for _, path_name := range path_names {
+		path_name = strings.TrimSuffix(path_name, "/")
+		if path == path_name || strings.HasPrefix(path, path_name+"/") {
+			return true, true
+		}
+		if strings.HasPrefix(path_name, path+"/") {
+			return false, true
+		}
+	}
+	return false, false
+}
+
+func (regit *ReGit) lsTree(tree_sha1 string, prefix string, recursive bool, show_trees bool, long bool, path_names []string) {
+	tree := NewTreeObject(regit.RootDir)
+	tree.ReadFromExistingObject(tree_sha1)
+	for _, entry := range tree.Entries {
+		path := prefix + entry.FileName
+		matches, contains_matches := matchPathNames(path, path_names)
+		if !matches && !contains_matches {
+			continue
+		}
+
+		typ := entry.Type()
+		if typ == "tree" && (recursive || !matches) {
+			if show_trees && matches {
+				regit.printTreeEntry(entry, path, long)
+			}
+			regit.lsTree(hex.EncodeToString(entry.HashedFilename), path+"/", recursive, show_trees, long, path_names)
+			continue
+		}
+		if matches {
+			regit.printTreeEntry(entry, path, long)
+		}
+	}
+}
+
+func (regit *ReGit) printTreeEntry(entry *TreeEntry, path string, long bool) {
+	if !long {
+		fmt.Println(formatTreeEntry(entry, path))
+		return
+	}
+	size := "-"
+	if entry.Type() == "blob" {
+		blob := NewBlobObject(regit.RootDir)
+		blob.ReadFromExistingObject(hex.EncodeToString(entry.HashedFilename))
+		size = strconv.Itoa(len(blob.Obj.content))
+	}
+	fmt.Printf("%s %s %s %7s\t%s\n", formatMode(entry.FileType), entry.Type(), hex.EncodeToString(entry.HashedFilename), size, path)
+}
+
+// LsFiles prints the paths in the index, along with their mode, object name
+// and stage if show_stage is true.
+func (regit *ReGit) LsFiles(show_stage bool) {
+	index := NewIndex(regit.RootDir)
+	regit.readIndexOrExit(index)
+	for _, entry := range index.Entries() {
+		path := string(entry.Path[:len(entry.Path)-1])
+		if show_stage {
+			fmt.Printf("%06o %s %d\t%s\n", entry.Mode, hex.EncodeToString(entry.Obj_name), entry.Stage(), path)
+		} else {
+			fmt.Println(path)
+		}
+	}
+}
+
+// WriteTree writes the tree objects for the index and prints the name of the root tree.
+func (regit *ReGit) WriteTree() {
+	index := NewIndex(regit.RootDir)
+	regit.readIndexOrExit(index)
+	if index.HasUnmergedEntries() {
+		fmt.Println("Error: write-tree failed to write a tree because the index has unmerged entries")
+		os.Exit(1)
+	}
+	fmt.Println(hex.EncodeToString(regit.writeTree(index)))
+}
+
+// CommitTree creates a commit object for a tree and prints its name, without
+// updating any ref.
+func (regit *ReGit) CommitTree(tree_ish string, parents []string, message string) {
+	regit.checkIdentity()
+
+	commit := NewCommitObject(regit.RootDir)
+	commit.SetTree(regit.resolveRevisionOrExit(tree_ish + "^{tree}"))
+	parent_sha1s := make([]string, 0, len(parents))
+	for _, parent := range parents {
+		parent_sha1s = append(parent_sha1s, regit.resolveRevisionOrExit(parent+"^{commit}"))
+	}
+	commit.SetParents(parent_sha1s)
+	commit.SetAuthor(regit.signature("GIT_AUTHOR_DATE").String())
+	commit.SetCommitter(regit.signature("GIT_COMMITTER_DATE").String())
+	commit.SetMessage(message)
+	commit.GenerateContent()
+	commit.Obj.WriteToFile()
+	fmt.Println(hex.EncodeToString(commit.Obj.HashedFilename))
+}
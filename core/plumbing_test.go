@@ -0,0 +1,185 @@
+package core
+
+import (
+	"encoding/hex"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHashObject(t *testing.T) {
+	// the names git gives to the same objects
+	tests := []struct {
+		typ     string
+		content string
+		want    string
+	}{
+		{"blob", "hello\n", "ce013625030ba8dba906f756967f9e9ca394464a"},
+		{"blob", "", "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"},
+		{"tree", "", "4b825dc642cb6eb9a060e54bf8d69288fbee4904"},
+	}
+	for _, test := range tests {
+		obj := NewGitObject(t.TempDir(), test.typ, []byte(test.content))
+		if got := hex.EncodeToString(obj.Hash()); got != test.want {
+			t.Errorf("%s %q hashes to %s, want %s", test.typ, test.content, got, test.want)
+		}
+	}
+}
+
+// newTestHistory makes a repository whose master has two commits, the second
+// of which adds dir/b.txt, and returns them.
+func newTestHistory(t *testing.T) (string, string, string) {
+	rootDir := newTestObjectStore(t)
+	writeTestRef(t, rootDir, "HEAD", "ref: refs/heads/master")
+	a := writeTestObject(t, rootDir, "blob", "a\n")
+	b := writeTestObject(t, rootDir, "blob", "b\n")
+	first_tree := writeTestObject(t, rootDir, "tree", testTreeEntry(t, "100644", "a.txt", a))
+	dir := writeTestObject(t, rootDir, "tree", testTreeEntry(t, "100644", "b.txt", b))
+	second_tree := writeTestObject(t, rootDir, "tree", testTreeEntry(t, "100644", "a.txt", a)+testTreeEntry(t, "40000", "dir", dir))
+	first := writeTestObject(t, rootDir, "commit", testCommitContent(first_tree, nil, "first\n"))
+	second := writeTestObject(t, rootDir, "commit", testCommitContent(second_tree, []string{first}, "second\n"))
+	writeTestRef(t, rootDir, "refs/heads/master", second)
+	return rootDir, first, second
+}
+
+func TestResolveRevision(t *testing.T) {
+	rootDir, first, second := newTestHistory(t)
+	second_commit := NewCommitObject(rootDir)
+	second_commit.ReadFromExistingObject(second)
+	b := NewGitObject(rootDir, "blob", []byte("b\n"))
+	tests := map[string]string{
+		"HEAD":              second,
+		"master":            second,
+		"refs/heads/master": second,
+		second[:7]:          second,
+		"master~1":          first,
+		"HEAD^":             first,
+		"master^1":          first,
+		"HEAD~0":            second,
+		"master^{tree}":     second_commit.tree,
+		"master:dir/b.txt":  hex.EncodeToString(b.Hash()),
+	}
+	for revision, want := range tests {
+		got, err := ResolveRevision(rootDir, revision)
+		if err != nil || got != want {
+			t.Errorf("%s resolved to %s, %v, want %s", revision, got, err, want)
+		}
+	}
+	for _, revision := range []string{"HEAD~2", "HEAD^2", "topic", "master:c.txt", "0000000"} {
+		if got, err := ResolveRevision(rootDir, revision); err == nil {
+			t.Errorf("%s resolved to %s", revision, got)
+		}
+	}
+}
+
+func TestLsTreeAndCatFile(t *testing.T) {
+	isolateHome(t)
+	rootDir, _, second := newTestHistory(t)
+	regit := NewReGit(rootDir)
+	a := hex.EncodeToString(NewGitObject(rootDir, "blob", []byte("a\n")).Hash())
+	b := hex.EncodeToString(NewGitObject(rootDir, "blob", []byte("b\n")).Hash())
+
+	output := captureOutput(t, func() { regit.LsTree("master", true, false, true, nil) })
+	want := "100644 blob " + a + "       2\ta.txt\n" +
+		"100644 blob " + b + "       2\tdir/b.txt\n"
+	if output != want {
+		t.Errorf("ls-tree -r -l printed:\n%s\nwant:\n%s", output, want)
+	}
+	output = captureOutput(t, func() { regit.LsTree("master", false, false, false, []string{"dir/b.txt"}) })
+	if want := "100644 blob " + b + "\tdir/b.txt\n"; output != want {
+		t.Errorf("ls-tree master dir/b.txt printed %q, want %q", output, want)
+	}
+
+	if output := captureOutput(t, func() { regit.CatFile("-t", "master") }); output != "commit\n" {
+		t.Errorf("cat-file -t printed %q", output)
+	}
+	if output := captureOutput(t, func() { regit.CatFile("-s", "master:a.txt") }); output != "2\n" {
+		t.Errorf("cat-file -s printed %q", output)
+	}
+	output = captureOutput(t, func() { regit.CatFile("-p", second) })
+	if !strings.HasPrefix(output, "tree ") || !strings.HasSuffix(output, "\n\nsecond\n") {
+		t.Errorf("cat-file -p printed %q", output)
+	}
+}
+
+func TestWriteTree(t *testing.T) {
+	isolateHome(t)
+	rootDir, _, _ := newTestHistory(t)
+	regit := NewReGit(rootDir)
+	a := NewGitObject(rootDir, "blob", []byte("a\n")).Hash()
+	b := NewGitObject(rootDir, "blob", []byte("b\n")).Hash()
+	index := NewIndex(rootDir)
+	index.WriteEmptyStatEntries([]string{"a.txt", "dir/b.txt"}, [][]byte{a, b}, []uint16{0, 0})
+	for _, entry := range index.Entries() {
+		entry.Mode = 0100644
+	}
+	index.Save()
+
+	output := captureOutput(t, regit.WriteTree)
+	tree := strings.TrimSuffix(output, "\n")
+	listing := captureOutput(t, func() { regit.LsTree(tree, true, false, false, nil) })
+	want := "100644 blob " + hex.EncodeToString(a) + "\ta.txt\n" +
+		"100644 blob " + hex.EncodeToString(b) + "\tdir/b.txt\n"
+	if listing != want {
+		t.Errorf("the tree written has:\n%s\nwant:\n%s", listing, want)
+	}
+	index = NewIndex(rootDir)
+	if err := index.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if cache_tree := index.CacheTree(); cache_tree == nil || hex.EncodeToString(cache_tree.HashedFilename) != tree {
+		t.Errorf("write-tree did not record the tree in the cache tree")
+	}
+}
+
+// withStdin runs f with input on the standard input.
+func withStdin(t *testing.T, input string, f func()) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		writer.WriteString(input)
+		writer.Close()
+	}()
+	stdin := os.Stdin
+	os.Stdin = reader
+	defer func() {
+		os.Stdin = stdin
+		reader.Close()
+	}()
+	f()
+}
+
+func TestCatFileBatch(t *testing.T) {
+	isolateHome(t)
+	rootDir, first, _ := newTestHistory(t)
+	regit := NewReGit(rootDir)
+	defer func() { objectCache = nil }()
+	a := hex.EncodeToString(NewGitObject(rootDir, "blob", []byte("a\n")).Hash())
+
+	output := captureOutput(t, func() {
+		withStdin(t, "master:a.txt\n"+first+"\nnothing\n", func() {
+			regit.CatFileBatch(false, false, false, BatchObjectCacheSize)
+		})
+	})
+	first_commit, _ := ReadObject(rootDir, first)
+	want := a + " blob 2\na\n\n" +
+		first + " commit " + strconv.Itoa(len(first_commit.content)) + "\n" + string(first_commit.content) + "\n" +
+		"nothing missing\n"
+	if output != want {
+		t.Errorf("cat-file --batch printed:\n%s\nwant:\n%s", output, want)
+	}
+
+	output = captureOutput(t, func() { regit.CatFileBatch(true, true, true, BatchObjectCacheSize) })
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	if len(lines) != len(ListLooseObjects(rootDir)) {
+		t.Errorf("cat-file --batch-check --batch-all-objects printed %d lines for %d objects", len(lines), len(ListLooseObjects(rootDir)))
+	}
+	for _, line := range lines {
+		if fields := strings.Fields(line); len(fields) != 3 || !ObjectExists(rootDir, fields[0]) {
+			t.Errorf("cat-file --batch-check printed %q", line)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package core
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+type PrunedObject struct {
+	SHA1 string
+	Type string
+	Size int64 // on-disk size of the loose object
+}
+
+// Prune deletes the loose objects which can not be reached from any ref,
+// reflog or the index and were last modified before expire. With dry_run
+// set, nothing is deleted and the objects which would be removed are
+// returned all the same. Nothing is deleted when the index is corrupt or an
+// object which is reachable is missing or corrupt, as what is reachable
+// would not be known. The objects named by the reflogs and the index which
+// are gone are left out, as nothing is reachable only through them.
+func Prune(rootDir string, expire time.Time, dry_run bool) ([]*PrunedObject, error) {
+	root_objects, err := RootObjects(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	roots := make([]string, 0, len(root_objects))
+	for sha1_name, source := range root_objects {
+		if (source == "index" || strings.HasSuffix(source, "@{reflog}")) && !ObjectExists(rootDir, sha1_name) {
+			continue
+		}
+		roots = append(roots, sha1_name)
+	}
+	reachable, err := ReachableObjects(rootDir, roots)
+	if err != nil {
+		return nil, err
+	}
+
+	pruned_objects := make([]*PrunedObject, 0)
+	for _, sha1_name := range ListLooseObjects(rootDir) {
+		if _, ok := reachable[sha1_name]; ok {
+			continue
+		}
+		dir_path := GitDir(rootDir) + "/objects/" + sha1_name[:2]
+		path := dir_path + "/" + sha1_name[2:]
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().Before(expire) {
+			continue
+		}
+
+		typ := "unknown"
+		if obj, err := ReadObject(rootDir, sha1_name); err == nil {
+			typ = obj.typ
+		}
+		pruned_objects = append(pruned_objects, &PrunedObject{sha1_name, typ, info.Size()})
+		if dry_run {
+			continue
+		}
+		err = os.Remove(path)
+		if err != nil {
+			continue
+		}
+		// remove the fan-out directory once it is empty; this fails harmlessly otherwise
+		os.Remove(dir_path)
+	}
+	return pruned_objects, nil
+}
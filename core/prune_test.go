@@ -0,0 +1,93 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ageObject makes an object look as if it was written a month ago.
+func ageObject(t *testing.T, rootDir string, sha1_name string) {
+	month_ago := time.Now().Add(-30 * 24 * time.Hour)
+	if err := os.Chtimes(rootDir+"/.git/objects/"+sha1_name[:2]+"/"+sha1_name[2:], month_ago, month_ago); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func prunedNames(pruned_objects []*PrunedObject) []string {
+	names := make([]string, 0, len(pruned_objects))
+	for _, obj := range pruned_objects {
+		names = append(names, obj.SHA1+" "+obj.Type)
+	}
+	return names
+}
+
+func TestPrune(t *testing.T) {
+	rootDir := newTestObjectStore(t)
+	blob := writeTestObject(t, rootDir, "blob", "a\n")
+	tree := writeTestObject(t, rootDir, "tree", testTreeEntry(t, "100644", "a.txt", blob))
+	commit := writeTestObject(t, rootDir, "commit", testCommitContent(tree, nil, "first\n"))
+	writeTestRef(t, rootDir, "refs/heads/master", commit)
+	old_garbage := writeTestObject(t, rootDir, "blob", "old garbage\n")
+	new_garbage := writeTestObject(t, rootDir, "blob", "new garbage\n")
+	for _, sha1_name := range []string{blob, tree, commit, old_garbage} {
+		ageObject(t, rootDir, sha1_name)
+	}
+	// a reflog may name objects which are gone already
+	if err := os.MkdirAll(rootDir+"/.git/logs/refs/heads", 0755); err != nil {
+		t.Fatal(err)
+	}
+	gone := strings.Repeat("2", 40)
+	reflog := gone + " " + commit + " A U Thor <author@example.com> 1700000000 +0000\tcommit: first\n"
+	if err := ioutil.WriteFile(rootDir+"/.git/logs/refs/heads/master", []byte(reflog), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	two_weeks_ago := time.Now().Add(-14 * 24 * time.Hour)
+	pruned_objects, err := Prune(rootDir, two_weeks_ago, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{old_garbage + " blob"}; !reflect.DeepEqual(prunedNames(pruned_objects), want) {
+		t.Errorf("dry run would prune %q, want %q", prunedNames(pruned_objects), want)
+	}
+	if !ObjectExists(rootDir, old_garbage) {
+		t.Errorf("dry run removed %s", old_garbage)
+	}
+
+	pruned_objects, err = Prune(rootDir, time.Now().Add(time.Second), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned_objects) != 2 || ObjectExists(rootDir, old_garbage) || ObjectExists(rootDir, new_garbage) {
+		t.Errorf("pruned %q, want both garbage blobs removed", prunedNames(pruned_objects))
+	}
+	for _, sha1_name := range []string{blob, tree, commit} {
+		if !ObjectExists(rootDir, sha1_name) {
+			t.Errorf("reachable object %s was pruned", sha1_name)
+		}
+	}
+}
+
+// TestPruneMissingObject checks that nothing is pruned when an object which
+// is reachable is missing, as what it would have kept is not known.
+func TestPruneMissingObject(t *testing.T) {
+	rootDir := newTestObjectStore(t)
+	blob := writeTestObject(t, rootDir, "blob", "a\n")
+	tree := writeTestObject(t, rootDir, "tree", testTreeEntry(t, "100644", "a.txt", blob))
+	missing := strings.Repeat("1", 40)
+	commit := writeTestObject(t, rootDir, "commit", testCommitContent(tree, []string{missing}, "second\n"))
+	writeTestRef(t, rootDir, "refs/heads/master", commit)
+	garbage := writeTestObject(t, rootDir, "blob", "garbage\n")
+
+	pruned_objects, err := Prune(rootDir, time.Now().Add(time.Second), false)
+	if err == nil || err.Error() != "unable to read "+missing {
+		t.Errorf("Prune returned %q, %v, want it to fail to read %s", prunedNames(pruned_objects), err, missing)
+	}
+	if !ObjectExists(rootDir, garbage) {
+		t.Errorf("%s was pruned", garbage)
+	}
+}
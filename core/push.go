@@ -0,0 +1,149 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// expandLocalRef turns a short ref name given on the command line into the
+// full name of a ref of the repository, or returns "" if it names no ref.
+func (regit *ReGit) expandLocalRef(name string) string {
+	for _, candidate := range []string{name, "refs/heads/" + name, "refs/tags/" + name} {
+		if !strings.HasPrefix(candidate, "refs/") {
+			continue
+		}
+		if _, ok := ReadRef(regit.RootDir, candidate); ok {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// pushUpdate works out which ref of a remote a refspec of push updates, and
+// with what.
+func (regit *ReGit) pushUpdate(remote_refs *RemoteRefs, refspec *Refspec) *refUpdate {
+	update := &refUpdate{Src: refspec.Src, Dst: refspec.Dst, Force: refspec.Force}
+	if refspec.Src != "" {
+		update.Src = regit.expandLocalRef(refspec.Src)
+		if update.Src != "" {
+			update.NewSHA1, _ = ReadRef(regit.RootDir, update.Src)
+		} else if sha1_name, err := ResolveRevision(regit.RootDir, refspec.Src); err == nil && refspec.Dst != "" {
+			update.Src, update.NewSHA1 = refspec.Src, sha1_name
+		} else {
+			fmt.Println("Error: src refspec " + refspec.Src + " does not match any")
+			os.Exit(1)
+		}
+	}
+	if update.Dst == "" {
+		update.Dst = update.Src
+	}
+	if !strings.HasPrefix(update.Dst, "refs/") {
+		if dst, err := expandRemoteRef(remote_refs, update.Dst); err == nil {
+			update.Dst = dst
+		} else if strings.HasPrefix(update.Src, "refs/tags/") {
+			update.Dst = "refs/tags/" + update.Dst
+		} else {
+			update.Dst = "refs/heads/" + update.Dst
+		}
+	}
+	update.OldSHA1, _ = remote_refs.Lookup(update.Dst)
+	return update
+}
+
+// Push sends the objects of local refs that a remote is missing, then
+// updates its refs, as given by refspecs, or the branch of the same name as
+// the current one. ":<dst>" deletes a ref of the remote. An update which is
+// not a fast-forward is rejected unless the refspec or force says otherwise,
+// and the remote may refuse others, such as the branch a non-bare remote has
+// checked out. The remote-tracking refs of what was pushed are updated.
+func (regit *ReGit) Push(name string, refspecs []string, force bool) {
+	remote, transport := regit.remoteRepository(name)
+	if len(refspecs) == 0 {
+		head := NewHEAD(regit.RootDir)
+		head.Read()
+		if !head.PointsToBranch {
+			fmt.Println("Error: You are not currently on a branch.")
+			os.Exit(1)
+		}
+		refspecs = []string{"refs/heads/" + head.Content}
+	}
+	remote_refs, err := transport.Refs(true)
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+
+	updates := make([]*refUpdate, 0, len(refspecs))
+	to_send := make([]*refUpdate, 0, len(refspecs))
+	rejected := false
+	for _, spec := range refspecs {
+		refspec := ParseRefspec(spec)
+		refspec.Force = refspec.Force || force
+		update := regit.pushUpdate(remote_refs, refspec)
+		if update.NewSHA1 == "" && update.OldSHA1 == "" {
+			fmt.Println("Error: unable to delete '" + shortRefName(update.Dst) + "': remote ref does not exist")
+			rejected = true
+			continue
+		}
+		if !regit.classify(update) {
+			rejected = true
+		} else if update.Flag != '=' {
+			to_send = append(to_send, update)
+		}
+		updates = append(updates, update)
+	}
+
+	if len(to_send) != 0 {
+		refused, err := transport.Push(regit.RootDir, to_send)
+		if err != nil {
+			fmt.Println("Error: could not push: " + err.Error())
+			os.Exit(1)
+		}
+		for _, update := range to_send {
+			if reason, ok := refused[update.Dst]; ok {
+				update.Flag, update.Summary, update.Reason = '!', "[remote rejected]", reason
+				rejected = true
+			}
+		}
+	}
+
+	up_to_date := true
+	for _, update := range updates {
+		if update.Flag == '=' {
+			continue
+		}
+		if up_to_date {
+			fmt.Println("To " + remote.URL)
+			up_to_date = false
+		}
+		line := " " + string(update.Flag) + " " + update.Summary + strings.Repeat(" ", refSummaryWidth-len(update.Summary)) + " "
+		if update.NewSHA1 == "" {
+			line += shortRefName(update.Dst)
+		} else {
+			line += shortRefName(update.Src) + " -> " + shortRefName(update.Dst)
+		}
+		if update.Reason != "" {
+			line += " (" + update.Reason + ")"
+		}
+		fmt.Println(line)
+		if update.Flag == '!' || remote.Fetch == nil {
+			continue
+		}
+		if tracking_ref, ok := remote.Fetch.Match(update.Dst); ok {
+			if update.NewSHA1 == "" {
+				DeleteRef(regit.RootDir, tracking_ref)
+			} else if err := WriteRef(regit.RootDir, tracking_ref, update.NewSHA1); err != nil {
+				fmt.Println("Error: " + err.Error())
+				os.Exit(1)
+			}
+		}
+	}
+	if up_to_date && !rejected {
+		fmt.Println("Everything up-to-date")
+	}
+	if rejected {
+		fmt.Println("Error: failed to push some refs to '" + remote.URL + "'")
+		os.Exit(1)
+	}
+}
@@ -1,7 +1,5 @@
 package core
 
-import "log"
-
 type Queue struct {
 	maxSize  int
 	front    int
@@ -21,9 +19,19 @@ func NewQueue(max_size int) *Queue {
 
 func (queue *Queue) Enqueue(element interface{}) {
 	new_rear := (queue.rear + 1) % queue.maxSize
+	// the queue is full, double its size
 	if new_rear == queue.front {
-		log.Fatal("Error: Queue is full")
-		return
+		elements := make([]interface{}, queue.maxSize*2)
+		size := 0
+		for !queue.IsEmpty() {
+			size++
+			elements[size] = queue.Dequeue()
+		}
+		queue.elements = elements
+		queue.maxSize = len(elements)
+		queue.front = 0
+		queue.rear = size
+		new_rear = size + 1
 	}
 	queue.rear = new_rear
 	queue.elements[new_rear] = element
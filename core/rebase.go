@@ -0,0 +1,669 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// a command of the todo list of a rebase; Argument is the subject of the
+// commit, or the shell command of "exec"
+type rebaseCommand struct {
+	Action   string
+	SHA1     string
+	Argument string
+}
+
+func (command *rebaseCommand) String() string {
+	if command.Action == "exec" {
+		return "exec " + command.Argument
+	}
+	return command.Action + " " + abbreviate(command.SHA1) + " " + command.Argument
+}
+
+// the one-letter forms of the commands of a todo list
+var rebaseActions = map[string]string{
+	"p": "pick", "pick": "pick",
+	"r": "reword", "reword": "reword",
+	"e": "edit", "edit": "edit",
+	"s": "squash", "squash": "squash",
+	"f": "fixup", "fixup": "fixup",
+	"x": "exec", "exec": "exec",
+	"d": "drop", "drop": "drop",
+}
+
+const rebaseTodoHelp = `
+# Commands:
+# p, pick <commit> = use commit
+# r, reword <commit> = use commit, but edit the commit message
+# e, edit <commit> = use commit, but stop for amending
+# s, squash <commit> = use commit, but meld into previous commit
+# f, fixup <commit> = like "squash" but keep only the previous
+#                    commit's log message
+# x, exec <command> = run command (the rest of the line) using shell
+# d, drop <commit> = remove commit
+#
+# These lines can be re-ordered; they are executed from top to bottom.
+#
+# If you remove a line here THAT COMMIT WILL BE LOST.
+#
+# However, if you remove everything, the rebase will be aborted.
+#
+`
+
+// Rebase replays commits on top of another commit, one command of its todo
+// list after the other, with HEAD detached until it is done. Its state is
+// kept in .git/rebase-merge, in the same layout as git's:
+//
+//	head-name        the branch being rebased, or "detached HEAD"
+//	onto             the commit the commits are replayed on
+//	orig-head        the commit HEAD pointed to before the rebase
+//	interactive      present for `rebase -i`
+//	git-rebase-todo  the commands left
+//	done             the commands run, the stopped one last
+//	msgnum, end      the number of commands run, and of all commands
+//	stopped-sha      the commit the rebase stopped at
+//	message          the message of the stopped commit
+//	author-script    the author of the stopped commit, as shell variables
+//	amend            the commit made by an "edit", which the user may amend
+//	current-fixups   the squashes and fixups melded into HEAD so far
+//	message-squash   the message of the commits melded into HEAD so far
+type Rebase struct {
+	regit       *ReGit
+	dir         string
+	HeadName    string
+	Onto        string
+	OrigHead    string
+	Interactive bool
+	Todo        []*rebaseCommand
+	Done        []*rebaseCommand
+	Msgnum      int
+	End         int
+}
+
+func NewRebase(regit *ReGit) *Rebase {
+	rebase := new(Rebase)
+	rebase.regit = regit
+	rebase.dir = GitDir(regit.RootDir) + "/rebase-merge"
+	rebase.Todo = make([]*rebaseCommand, 0)
+	rebase.Done = make([]*rebaseCommand, 0)
+	return rebase
+}
+
+func (rebase *Rebase) InProgress() bool {
+	_, err := os.Stat(rebase.dir)
+	return err == nil
+}
+
+func (rebase *Rebase) readFile(name string) string {
+	content, err := ioutil.ReadFile(rebase.dir + "/" + name)
+	if err != nil {
+		return ""
+	}
+	return string(content)
+}
+
+func (rebase *Rebase) writeFile(name string, content string) {
+	if err := ioutil.WriteFile(rebase.dir+"/"+name, []byte(content), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (rebase *Rebase) Read() {
+	if !rebase.InProgress() {
+		fmt.Println("Error: no rebase in progress")
+		os.Exit(1)
+	}
+	rebase.HeadName = strings.TrimSpace(rebase.readFile("head-name"))
+	rebase.Onto = strings.TrimSpace(rebase.readFile("onto"))
+	rebase.OrigHead = strings.TrimSpace(rebase.readFile("orig-head"))
+	_, err := os.Stat(rebase.dir + "/interactive")
+	rebase.Interactive = err == nil
+	rebase.Msgnum, _ = strconv.Atoi(strings.TrimSpace(rebase.readFile("msgnum")))
+	rebase.End, _ = strconv.Atoi(strings.TrimSpace(rebase.readFile("end")))
+
+	if rebase.Todo, err = rebase.parseTodo(rebase.readFile("git-rebase-todo")); err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+	if rebase.Done, err = rebase.parseTodo(rebase.readFile("done")); err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+}
+
+func formatTodo(commands []*rebaseCommand) string {
+	buffer := new(bytes.Buffer)
+	for _, command := range commands {
+		buffer.WriteString(command.String() + "\n")
+	}
+	return buffer.String()
+}
+
+func (rebase *Rebase) Write() {
+	if err := os.MkdirAll(rebase.dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	rebase.writeFile("head-name", rebase.HeadName+"\n")
+	rebase.writeFile("onto", rebase.Onto+"\n")
+	rebase.writeFile("orig-head", rebase.OrigHead+"\n")
+	if rebase.Interactive {
+		rebase.writeFile("interactive", "")
+	}
+	rebase.writeFile("git-rebase-todo", formatTodo(rebase.Todo))
+	rebase.writeFile("done", formatTodo(rebase.Done))
+	rebase.writeFile("msgnum", strconv.Itoa(rebase.Msgnum)+"\n")
+	rebase.writeFile("end", strconv.Itoa(rebase.End)+"\n")
+}
+
+// parseTodo reads a todo list, where lines starting with '#' and blank
+// lines are skipped, and commits may be named by any revision.
+func (rebase *Rebase) parseTodo(content string) ([]*rebaseCommand, error) {
+	commands := make([]*rebaseCommand, 0)
+	for number, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		action, ok := rebaseActions[fields[0]]
+		if !ok || len(fields) == 1 {
+			return nil, errors.New("invalid line " + strconv.Itoa(number+1) + ": " + line)
+		}
+		command := &rebaseCommand{Action: action}
+		if action == "exec" {
+			command.Argument = strings.TrimSpace(fields[1])
+			commands = append(commands, command)
+			continue
+		}
+		fields = strings.SplitN(strings.TrimSpace(fields[1]), " ", 2)
+		sha1_name, err := ResolveRevision(rebase.regit.RootDir, fields[0])
+		if err == nil {
+			sha1_name, err = PeelObject(rebase.regit.RootDir, sha1_name, "commit")
+		}
+		if err != nil {
+			return nil, errors.New("invalid line " + strconv.Itoa(number+1) + ": " + line)
+		}
+		command.SHA1 = sha1_name
+		if len(fields) == 2 {
+			command.Argument = fields[1]
+		}
+		commands = append(commands, command)
+	}
+	return commands, nil
+}
+
+// ancestors returns the names of a commit and of all of its ancestors.
+func (regit *ReGit) ancestors(commit_sha1 string) map[string]bool {
+	seen := make(map[string]bool)
+	pending := []string{commit_sha1}
+	for len(pending) != 0 {
+		sha1_name := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[sha1_name] {
+			continue
+		}
+		seen[sha1_name] = true
+		commit := NewCommitObject(regit.RootDir)
+		commit.ReadFromExistingObject(sha1_name)
+		pending = append(pending, commit.historyParents()...)
+	}
+	return seen
+}
+
+// commitsToReplay lists the commits reachable from head but not excluded,
+// parents first, leaving out merges.
+func (regit *ReGit) commitsToReplay(head string, excluded map[string]bool) []*CommitObject {
+	commits := make([]*CommitObject, 0)
+	visited := make(map[string]bool)
+	var visit func(sha1_name string)
+	visit = func(sha1_name string) {
+		if visited[sha1_name] || excluded[sha1_name] {
+			return
+		}
+		visited[sha1_name] = true
+		commit := NewCommitObject(regit.RootDir)
+		commit.ReadFromExistingObject(sha1_name)
+		for _, parent := range commit.historyParents() {
+			visit(parent)
+		}
+		if len(commit.parents) <= 1 {
+			commits = append(commits, commit)
+		}
+	}
+	visit(head)
+	return commits
+}
+
+// Rebase replays the commits of HEAD which are not in upstream on top of
+// onto, or of upstream if onto is empty. With interactive set, the user gets
+// to edit the list of commands first.
+func (regit *ReGit) Rebase(upstream string, onto string, interactive bool) RebaseStatus {
+	rebase := NewRebase(regit)
+	if rebase.InProgress() {
+		fmt.Println("Error: a rebase is already in progress; use \"regit-go rebase (--continue | --skip | --abort)\"")
+		os.Exit(1)
+	}
+	head_sha1 := regit.headCommit()
+	if head_sha1 == "" {
+		fmt.Println("Error: your current branch does not have any commits yet")
+		os.Exit(1)
+	}
+	regit.checkCleanWorktree("rebase")
+
+	upstream_sha1 := regit.resolveCommitOrExit(upstream)
+	onto_sha1 := upstream_sha1
+	if onto != "" {
+		onto_sha1 = regit.resolveCommitOrExit(onto)
+	}
+
+	head := NewHEAD(regit.RootDir)
+	head.Read()
+	rebase.HeadName = "detached HEAD"
+	if head.PointsToBranch {
+		rebase.HeadName = "refs/heads/" + head.Content
+	}
+	if onto == "" && !interactive && regit.ancestors(head_sha1)[upstream_sha1] {
+		if head.PointsToBranch {
+			fmt.Println("Current branch " + head.Content + " is up to date.")
+		} else {
+			fmt.Println("HEAD is up to date.")
+		}
+		return RebaseDone
+	}
+
+	rebase.Onto = onto_sha1
+	rebase.OrigHead = head_sha1
+	rebase.Interactive = interactive
+	for _, commit := range regit.commitsToReplay(head_sha1, regit.ancestors(upstream_sha1)) {
+		sha1_name := hex.EncodeToString(commit.Obj.HashedFilename)
+		rebase.Todo = append(rebase.Todo, &rebaseCommand{"pick", sha1_name, commitSubject(commit)})
+	}
+	rebase.Write()
+	if interactive {
+		rebase.editTodo(upstream_sha1, head_sha1)
+	}
+	rebase.End = len(rebase.Todo)
+	rebase.Write()
+
+	regit.checkoutTree(regit.commitTree(head_sha1), regit.commitTree(onto_sha1))
+	head.PointsTo(onto_sha1, false)
+	return rebase.Run()
+}
+
+func (regit *ReGit) resolveCommitOrExit(revision string) string {
+	sha1_name, err := PeelObject(regit.RootDir, regit.resolveRevisionOrExit(revision), "commit")
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+	return sha1_name
+}
+
+// editTodo lets the user edit the todo list of `rebase -i`. The rebase is
+// given up if the list is left empty or is invalid.
+func (rebase *Rebase) editTodo(upstream_sha1 string, head_sha1 string) {
+	regit := rebase.regit
+	path := rebase.dir + "/git-rebase-todo"
+	count := strconv.Itoa(len(rebase.Todo)) + " commands"
+	if len(rebase.Todo) == 1 {
+		count = "1 command"
+	}
+	content := formatTodo(rebase.Todo) + "\n# Rebase " + abbreviate(upstream_sha1) + ".." + abbreviate(head_sha1) +
+		" onto " + abbreviate(rebase.Onto) + " (" + count + ")\n#" + rebaseTodoHelp
+	rebase.writeFile("git-rebase-todo", content)
+
+	err := regit.launchEditor(regit.sequenceEditorCommand(), path)
+	if err == nil {
+		rebase.Todo, err = rebase.parseTodo(rebase.readFile("git-rebase-todo"))
+	}
+	if err == nil && len(rebase.Todo) != 0 && (rebase.Todo[0].Action == "squash" || rebase.Todo[0].Action == "fixup") {
+		err = errors.New("cannot '" + rebase.Todo[0].Action + "' without a previous commit")
+	}
+	if err != nil {
+		os.RemoveAll(rebase.dir)
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+	if len(rebase.Todo) == 0 {
+		os.RemoveAll(rebase.dir)
+		fmt.Println("Nothing to do")
+		os.Exit(1)
+	}
+}
+
+// RebaseStatus tells how a rebase, or one of its commands, ended.
+type RebaseStatus int
+
+const (
+	RebaseDone    RebaseStatus = iota // nothing is left to do
+	RebaseStopped                     // a command failed or conflicted, for the user to fix
+	RebaseEditing                     // stopped at an "edit" command, which is no failure
+)
+
+// Run runs the commands left, then moves the rebased branch to HEAD. When a
+// command stops, the state is saved and the reason is returned.
+func (rebase *Rebase) Run() RebaseStatus {
+	for len(rebase.Todo) != 0 {
+		command := rebase.Todo[0]
+		rebase.Todo = rebase.Todo[1:]
+		rebase.Done = append(rebase.Done, command)
+		rebase.Msgnum++
+		rebase.Write()
+		if status := rebase.runCommand(command); status != RebaseDone {
+			return status
+		}
+	}
+	rebase.finish()
+	return RebaseDone
+}
+
+func (rebase *Rebase) finish() {
+	regit := rebase.regit
+	head_sha1 := regit.headCommit()
+	if strings.HasPrefix(rebase.HeadName, "refs/heads/") {
+		branch_name := rebase.HeadName[len("refs/heads/"):]
+		branch := NewBranch(branch_name, regit.RootDir)
+		branch.SetCommit(head_sha1)
+		branch.Write()
+		NewHEAD(regit.RootDir).PointsTo(branch_name, true)
+	}
+	os.RemoveAll(rebase.dir)
+	os.Remove(GitDir(regit.RootDir) + "/MERGE_MSG")
+	fmt.Println("Successfully rebased and updated " + rebase.HeadName + ".")
+}
+
+func (rebase *Rebase) runCommand(command *rebaseCommand) RebaseStatus {
+	regit := rebase.regit
+	switch command.Action {
+	case "drop":
+		return RebaseDone
+	case "exec":
+		fmt.Println("Executing: " + command.Argument)
+		cmd := exec.Command("sh", "-c", command.Argument)
+		cmd.Dir = regit.RootDir
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			fmt.Println("warning: execution failed: " + command.Argument)
+			fmt.Println("You can fix the problem, and then run")
+			fmt.Println()
+			fmt.Println("  regit-go rebase --continue")
+			fmt.Println()
+			return RebaseStopped
+		}
+		return RebaseDone
+	}
+
+	commit := NewCommitObject(regit.RootDir)
+	commit.ReadFromExistingObject(command.SHA1)
+	head_sha1 := regit.headCommit()
+	head_tree := regit.commitTree(head_sha1)
+
+	// a commit whose parent is already HEAD is kept as it is
+	if (command.Action == "pick" || command.Action == "edit") && len(commit.parents) == 1 && commit.parents[0] == head_sha1 {
+		regit.checkoutTree(head_tree, commit.tree)
+		regit.updateHead(command.SHA1)
+		if command.Action == "edit" {
+			return rebase.stopForEdit(command, commit)
+		}
+		return RebaseDone
+	}
+
+	merge := regit.mergeCommitChanges(commit, false, "rebase")
+	if len(merge.Conflicts) != 0 {
+		rebase.stop(command, commit, conflictsComment(merge))
+		fmt.Println("Error: could not apply " + abbreviate(command.SHA1) + "... " + command.Argument)
+		fmt.Println("hint: Resolve all conflicts manually, mark them as resolved with")
+		fmt.Println("hint: \"regit-go add <conflicted_files>\", then run \"regit-go rebase --continue\".")
+		fmt.Println("hint: You can instead skip this commit: run \"regit-go rebase --skip\".")
+		fmt.Println("hint: To abort and get back to the state before \"regit-go rebase\", run \"regit-go rebase --abort\".")
+		return RebaseStopped
+	}
+
+	index := NewIndex(regit.RootDir)
+	regit.readIndexOrExit(index)
+	tree_sha1 := hex.EncodeToString(regit.writeTree(index))
+	return rebase.commit(command, commit, tree_sha1)
+}
+
+// commit records the changes of a command, once they are in the index as
+// tree_sha1.
+func (rebase *Rebase) commit(command *rebaseCommand, commit *CommitObject, tree_sha1 string) RebaseStatus {
+	regit := rebase.regit
+	if command.Action == "squash" || command.Action == "fixup" {
+		rebase.squash(command, commit, tree_sha1)
+		return RebaseDone
+	}
+	head_sha1 := regit.headCommit()
+	if tree_sha1 == regit.commitTree(head_sha1) {
+		fmt.Println("dropping " + command.SHA1 + " " + command.Argument + " -- patch contents already upstream")
+		return RebaseDone
+	}
+	message := commit.message
+	if command.Action == "reword" {
+		message = rebase.editMessage(message)
+	}
+	regit.updateHead(regit.writeCommit(tree_sha1, []string{head_sha1}, commit.author, message))
+	regit.runHook("post-commit", regit.commitHookEnv(false))
+	if command.Action == "edit" {
+		return rebase.stopForEdit(command, commit)
+	}
+	return RebaseDone
+}
+
+func (rebase *Rebase) editMessage(message string) string {
+	message = rebase.regit.prepareCommitMessage(NewCommitOptions(), message, "message", "")
+	if message == "" {
+		fmt.Println("Aborting commit due to empty commit message.")
+		os.Exit(1)
+	}
+	return message
+}
+
+func ordinal(number int) string {
+	switch number {
+	case 1:
+		return "1st"
+	case 2:
+		return "2nd"
+	case 3:
+		return "3rd"
+	}
+	return strconv.Itoa(number) + "th"
+}
+
+// squash melds the changes of a squash or of a fixup into HEAD. The
+// messages of the melded commits add up in message-squash, where those of
+// fixups are commented out; at the end of a run of squashes and fixups, the
+// user gets to edit the message if there was a squash.
+func (rebase *Rebase) squash(command *rebaseCommand, commit *CommitObject, tree_sha1 string) {
+	regit := rebase.regit
+	head := NewCommitObject(regit.RootDir)
+	head.ReadFromExistingObject(regit.headCommit())
+
+	fixups := strings.Fields(rebase.readFile("current-fixups"))
+	message := rebase.readFile("message-squash")
+	if len(fixups) == 0 {
+		message = "# This is a combination of 2 commits.\n# This is the " + ordinal(1) + " commit message:\n\n" + head.message + "\n"
+	}
+	count := len(fixups)/2 + 2
+	message = "# This is a combination of " + strconv.Itoa(count) + " commits." + message[strings.Index(message, "\n"):]
+	if command.Action == "squash" {
+		message += "\n# This is the commit message #" + strconv.Itoa(count) + ":\n\n" + commit.message + "\n"
+	} else {
+		message += "\n# The commit message #" + strconv.Itoa(count) + " will be skipped:\n\n# " +
+			strings.Replace(commit.message, "\n", "\n# ", -1) + "\n"
+	}
+	fixups = append(fixups, command.Action, command.SHA1)
+
+	if len(rebase.Todo) != 0 && (rebase.Todo[0].Action == "squash" || rebase.Todo[0].Action == "fixup") {
+		rebase.writeFile("current-fixups", strings.Join(fixups, " ")+"\n")
+		rebase.writeFile("message-squash", message)
+		message = CleanupMessage(message, true)
+	} else {
+		os.Remove(rebase.dir + "/current-fixups")
+		os.Remove(rebase.dir + "/message-squash")
+		if strings.Contains(" "+strings.Join(fixups, " "), " squash ") {
+			message = rebase.editMessage(message)
+		} else {
+			message = CleanupMessage(message, true)
+		}
+	}
+	regit.updateHead(regit.writeCommit(tree_sha1, head.parents, head.author, message))
+	regit.runHook("post-commit", regit.commitHookEnv(false))
+}
+
+// stop records the commit a command stopped at, for --continue.
+func (rebase *Rebase) stop(command *rebaseCommand, commit *CommitObject, comment string) {
+	rebase.writeFile("stopped-sha", command.SHA1+"\n")
+	rebase.writeFile("message", commit.message+"\n")
+	writeAuthorScript(rebase.dir+"/author-script", commit.author)
+	if err := ioutil.WriteFile(GitDir(rebase.regit.RootDir)+"/MERGE_MSG", []byte(commit.message+"\n"+comment), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// stopForEdit stops at an "edit" command for the commit to be amended.
+func (rebase *Rebase) stopForEdit(command *rebaseCommand, commit *CommitObject) RebaseStatus {
+	rebase.stop(command, commit, "")
+	rebase.writeFile("amend", rebase.regit.headCommit()+"\n")
+	fmt.Println("Stopped at " + abbreviate(command.SHA1) + "...  " + command.Argument)
+	fmt.Println("You can amend the commit now, with")
+	fmt.Println()
+	fmt.Println("  regit-go commit --amend")
+	fmt.Println()
+	fmt.Println("Once you are satisfied with your changes, run")
+	fmt.Println()
+	fmt.Println("  regit-go rebase --continue")
+	return RebaseEditing
+}
+
+func (rebase *Rebase) clearStop() {
+	for _, name := range []string{"stopped-sha", "message", "author-script", "amend"} {
+		os.Remove(rebase.dir + "/" + name)
+	}
+	os.Remove(GitDir(rebase.regit.RootDir) + "/MERGE_MSG")
+}
+
+// writeAuthorScript writes an author as the shell variables git reads.
+func writeAuthorScript(path string, author string) {
+	quote := func(value string) string {
+		return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+	}
+	sig := ParseSignature(author)
+	content := "GIT_AUTHOR_NAME=" + quote(sig.Name) + "\n" +
+		"GIT_AUTHOR_EMAIL=" + quote(sig.Email) + "\n" +
+		"GIT_AUTHOR_DATE=" + quote("@"+sig.FormatDate("raw")) + "\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func readAuthorScript(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	values := make(map[string]string)
+	for _, line := range strings.Split(string(content), "\n") {
+		equal_index := strings.Index(line, "=")
+		if equal_index == -1 {
+			continue
+		}
+		value := strings.Replace(line[equal_index+1:], `'\''`, "'", -1)
+		values[line[:equal_index]] = strings.TrimSuffix(strings.TrimPrefix(value, "'"), "'")
+	}
+	date, err := ParseDate(values["GIT_AUTHOR_DATE"])
+	if err != nil {
+		return "", err
+	}
+	return NewSignature(values["GIT_AUTHOR_NAME"], values["GIT_AUTHOR_EMAIL"], date).String(), nil
+}
+
+// RebaseContinue commits the resolved changes of the stopped command, then
+// runs the commands left.
+func (regit *ReGit) RebaseContinue() RebaseStatus {
+	rebase := NewRebase(regit)
+	rebase.Read()
+	index := NewIndex(regit.RootDir)
+	regit.readIndexOrExit(index)
+	if index.HasUnmergedEntries() {
+		fmt.Println("Error: you must edit all merge conflicts and then mark them as resolved using \"regit-go add\"")
+		os.Exit(1)
+	}
+	head_sha1 := regit.headCommit()
+	tree_sha1 := hex.EncodeToString(regit.writeTree(index))
+	stopped_sha1 := strings.TrimSpace(rebase.readFile("stopped-sha"))
+
+	if rebase.readFile("amend") != "" {
+		// the changes of an edit are committed by the user
+		if tree_sha1 != regit.commitTree(head_sha1) {
+			fmt.Println("Error: you have staged changes in your working tree.")
+			fmt.Println("If these changes are meant to be squashed into the previous commit, run:")
+			fmt.Println()
+			fmt.Println("  regit-go commit --amend")
+			fmt.Println()
+			fmt.Println("If they are meant to go into a new commit, run:")
+			fmt.Println()
+			fmt.Println("  regit-go commit")
+			fmt.Println()
+			fmt.Println("In both cases, once you're done, continue with:")
+			fmt.Println()
+			fmt.Println("  regit-go rebase --continue")
+			os.Exit(1)
+		}
+	} else if stopped_sha1 != "" && len(rebase.Done) != 0 {
+		command := rebase.Done[len(rebase.Done)-1]
+		commit := NewCommitObject(regit.RootDir)
+		commit.ReadFromExistingObject(stopped_sha1)
+		if command.Action == "squash" || command.Action == "fixup" {
+			rebase.squash(command, commit, tree_sha1)
+		} else if tree_sha1 != regit.commitTree(head_sha1) {
+			author, err := readAuthorScript(rebase.dir + "/author-script")
+			if err != nil {
+				fmt.Println("Error: could not read '" + rebase.dir + "/author-script'")
+				os.Exit(1)
+			}
+			message := rebase.editMessage(rebase.readFile("message"))
+			regit.updateHead(regit.writeCommit(tree_sha1, []string{head_sha1}, author, message))
+			regit.runHook("post-commit", regit.commitHookEnv(false))
+		}
+	}
+	rebase.clearStop()
+	return rebase.Run()
+}
+
+// RebaseSkip drops the changes of the stopped command, then runs the
+// commands left.
+func (regit *ReGit) RebaseSkip() RebaseStatus {
+	rebase := NewRebase(regit)
+	rebase.Read()
+	regit.resetToTree(regit.commitTree(regit.headCommit()))
+	rebase.clearStop()
+	return rebase.Run()
+}
+
+// RebaseAbort goes back to the branch and the commit the rebase started
+// from.
+func (regit *ReGit) RebaseAbort() {
+	rebase := NewRebase(regit)
+	rebase.Read()
+	regit.resetToTree(regit.commitTree(rebase.OrigHead))
+	head := NewHEAD(regit.RootDir)
+	if strings.HasPrefix(rebase.HeadName, "refs/heads/") {
+		head.PointsTo(rebase.HeadName[len("refs/heads/"):], true)
+	} else {
+		head.PointsTo(rebase.OrigHead, false)
+	}
+	os.RemoveAll(rebase.dir)
+	os.Remove(GitDir(regit.RootDir) + "/MERGE_MSG")
+}
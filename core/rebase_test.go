@@ -0,0 +1,128 @@
+package core
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// newTestTopic makes the history
+//
+//	c1 (a.txt) - c2 (b.txt)    master
+//	   \
+//	    c3 (c.txt)              topic, checked out
+//
+// and returns the repository and the names of the commits.
+func newTestTopic(t *testing.T) (*ReGit, string, string, string) {
+	isolateHome(t)
+	rootDir := t.TempDir()
+	chdir(t, rootDir)
+	regit := newTestRepository(t, rootDir)
+	first := commitFile(t, rootDir, "a.txt", "a\n")
+	second := commitFile(t, rootDir, "b.txt", "b\n")
+	writeTestRef(t, rootDir, "refs/heads/topic", first)
+	writeTestRef(t, rootDir, "HEAD", "ref: refs/heads/topic")
+	regit.resetToTree(regit.commitTree(first))
+	third := commitFile(t, rootDir, "c.txt", "c\n")
+	return regit, first, second, third
+}
+
+func TestParseTodo(t *testing.T) {
+	regit, first, _, third := newTestTopic(t)
+	rebase := NewRebase(regit)
+	commands, err := rebase.parseTodo("# a comment\n\np " + abbreviate(third) + " subject\nfixup topic~1\nexec make test\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*rebaseCommand{{"pick", third, "subject"}, {"fixup", first, ""}, {"exec", "", "make test"}}
+	if !reflect.DeepEqual(commands, want) {
+		t.Errorf("parsed %+v, want %+v", commands, want)
+	}
+	for _, todo := range []string{"jump " + third, "pick", "pick no-such-commit"} {
+		if _, err := rebase.parseTodo(todo); err == nil {
+			t.Errorf("%q was parsed", todo)
+		}
+	}
+}
+
+func TestRebase(t *testing.T) {
+	regit, _, second, third := newTestTopic(t)
+	var status RebaseStatus
+	captureOutput(t, func() {
+		status = regit.Rebase("master", "", false)
+	})
+	if status != RebaseDone {
+		t.Fatalf("the rebase ended with %v", status)
+	}
+	rebased := newTestCommit(t, regit.RootDir, testRef(t, regit.RootDir, "topic"))
+	if !reflect.DeepEqual(rebased.parents, []string{second}) || rebased.message != "change c.txt" {
+		t.Errorf("the rebased commit has the parents %q and the message %q", rebased.parents, rebased.message)
+	}
+	if testRef(t, regit.RootDir, "HEAD") != testRef(t, regit.RootDir, "topic") || NewRebase(regit).InProgress() {
+		t.Errorf("the rebase did not finish on topic")
+	}
+	if testRef(t, regit.RootDir, "topic") == third {
+		t.Errorf("topic was not moved")
+	}
+}
+
+// TestRebaseEdit checks that a rebase stopped at an "edit" command is no
+// failure, and goes on with --continue.
+func TestRebaseEdit(t *testing.T) {
+	regit, _, second, _ := newTestTopic(t)
+	setEnv(t, "GIT_SEQUENCE_EDITOR", "sed -i.bak -e s/^pick/edit/")
+	var status RebaseStatus
+	captureOutput(t, func() {
+		status = regit.Rebase("master", "", true)
+	})
+	if status != RebaseEditing {
+		t.Fatalf("the rebase ended with %v, want it to stop for the edit", status)
+	}
+	rebase := NewRebase(regit)
+	if !rebase.InProgress() || rebase.readFile("amend") == "" {
+		t.Fatal("the state of the edit is not saved")
+	}
+
+	captureOutput(t, func() {
+		status = regit.RebaseContinue()
+	})
+	if status != RebaseDone {
+		t.Fatalf("rebase --continue ended with %v", status)
+	}
+	if rebased := newTestCommit(t, regit.RootDir, testRef(t, regit.RootDir, "topic")); !reflect.DeepEqual(rebased.parents, []string{second}) {
+		t.Errorf("the rebased commit has the parents %q", rebased.parents)
+	}
+}
+
+func TestRebaseConflict(t *testing.T) {
+	regit, _, _, third := newTestTopic(t)
+	commitFile(t, regit.RootDir, "b.txt", "ours\n")
+	on_topic := testRef(t, regit.RootDir, "topic")
+	writeTestRef(t, regit.RootDir, "HEAD", "ref: refs/heads/master")
+	regit.resetToTree(regit.commitTree(testRef(t, regit.RootDir, "master")))
+	commitFile(t, regit.RootDir, "b.txt", "theirs\n")
+	writeTestRef(t, regit.RootDir, "HEAD", "ref: refs/heads/topic")
+	regit.resetToTree(regit.commitTree(on_topic))
+
+	var status RebaseStatus
+	captureOutput(t, func() {
+		status = regit.Rebase("master", "", false)
+	})
+	if status != RebaseStopped {
+		t.Fatalf("the conflicting rebase ended with %v", status)
+	}
+	if content, _ := ioutil.ReadFile(regit.RootDir + "/b.txt"); len(content) == 0 {
+		t.Errorf("b.txt was not written with the conflict")
+	}
+
+	captureOutput(t, regit.RebaseAbort)
+	if NewRebase(regit).InProgress() || testRef(t, regit.RootDir, "HEAD") != on_topic {
+		t.Errorf("rebase --abort did not go back to topic")
+	}
+	if content, _ := ioutil.ReadFile(regit.RootDir + "/c.txt"); string(content) != "c\n" {
+		t.Errorf("c.txt is %q after the abort", content)
+	}
+	if third == on_topic {
+		t.Errorf("the history of the test is wrong")
+	}
+}
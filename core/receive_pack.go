@@ -0,0 +1,203 @@
+package core
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// isConnected tells whether every object reachable from sha1_name is in the
+// repository at rootDir. Like git's, it walks down to the commits the refs
+// already reach, whose history is taken to be complete.
+func isConnected(rootDir string, sha1_name string, known_commits map[string]bool) bool {
+	seen := make(map[string]bool)
+	pending := []string{sha1_name}
+	for len(pending) != 0 {
+		current := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[current] || known_commits[current] {
+			continue
+		}
+		seen[current] = true
+		obj, err := ReadObject(rootDir, current)
+		if err != nil {
+			return false
+		}
+		switch obj.typ {
+		case "commit":
+			commit := NewCommitObject(rootDir)
+			if commit.Load(current) != nil {
+				return false
+			}
+			pending = append(pending, commit.tree)
+			pending = append(pending, commit.historyParents()...)
+		case "tree":
+			tree := NewTreeObject(rootDir)
+			if tree.Load(current) != nil {
+				return false
+			}
+			for _, entry := range tree.Entries {
+				// gitlinks name commits of other repositories
+				if entry.Type() != "commit" {
+					pending = append(pending, hex.EncodeToString(entry.HashedFilename))
+				}
+			}
+		case "tag":
+			tag := NewTagObject(rootDir)
+			if tag.Load(current) != nil {
+				return false
+			}
+			pending = append(pending, tag.object)
+		}
+	}
+	return true
+}
+
+// receiveUpdates makes the ref updates of a push to the repository at
+// rootDir, whose objects are there already. It returns why it refused an
+// update, by ref name: the ref has moved since the client read it, the
+// pushed history is not whole, or the update is of the branch checked out in
+// a repository which is not bare.
+func receiveUpdates(rootDir string, updates []*refUpdate) (map[string]string, error) {
+	known_commits := make(map[string]bool)
+	regit := NewReGit(rootDir)
+	for _, ref := range ListRefs(rootDir) {
+		if commit_sha1, err := PeelObject(rootDir, ref.SHA1, "commit"); err == nil {
+			for ancestor := range regit.ancestors(commit_sha1) {
+				known_commits[ancestor] = true
+			}
+		}
+	}
+
+	refused := make(map[string]string)
+	checked_out := ""
+	if !IsBareRepository(rootDir) {
+		checked_out = "refs/heads/" + headBranch(rootDir)
+	}
+	for _, update := range updates {
+		current_sha1, _ := ReadRef(rootDir, update.Dst)
+		switch {
+		case !strings.HasPrefix(update.Dst, "refs/") || strings.Contains(update.Dst, ".."):
+			refused[update.Dst] = "funny refname"
+		case update.Dst == checked_out && update.NewSHA1 == "":
+			refused[update.Dst] = "deletion of the current branch prohibited"
+		case update.Dst == checked_out:
+			refused[update.Dst] = "branch is currently checked out"
+		case current_sha1 != update.OldSHA1:
+			refused[update.Dst] = "failed to update ref"
+		case update.NewSHA1 != "" && !isConnected(rootDir, update.NewSHA1, known_commits):
+			refused[update.Dst] = "missing necessary objects"
+		}
+	}
+	for _, update := range updates {
+		if _, ok := refused[update.Dst]; ok {
+			continue
+		}
+		if update.NewSHA1 == "" {
+			DeleteRef(rootDir, update.Dst)
+		} else if err := WriteRef(rootDir, update.Dst, update.NewSHA1); err != nil {
+			return nil, err
+		}
+	}
+	return refused, nil
+}
+
+// ReceivePack serves a push to the repository at rootDir on in and out, as
+// git-receive-pack does: it shows the refs, reads the commands which update
+// them and the pack of the objects they need, and reports what it did. With
+// advertise_refs it only shows the refs; with stateless_rpc, as over HTTP,
+// the client has read them beforehand.
+func ReceivePack(rootDir string, in io.Reader, out io.Writer, stateless_rpc bool, advertise_refs bool) error {
+	if advertise_refs || !stateless_rpc {
+		capabilities := []string{"report-status", "delete-refs", "side-band-64k", "ofs-delta", "agent=" + userAgent}
+		if err := advertiseRefs(out, rootDir, capabilities, true); err != nil || advertise_refs {
+			return err
+		}
+	}
+
+	pkt_reader := NewPktReader(in)
+	updates := make([]*refUpdate, 0)
+	capabilities := make(map[string]bool)
+	zero_sha1 := strings.Repeat("0", 40)
+	has_pack := false
+	for {
+		line, err := pkt_reader.ReadLine()
+		if err == errPktFlush {
+			break
+		}
+		if err == io.ErrUnexpectedEOF && len(updates) == 0 {
+			// the client only wanted to see the refs
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if nul_index := strings.IndexByte(line, 0); nul_index != -1 {
+			for _, capability := range strings.Fields(line[nul_index+1:]) {
+				capabilities[capability] = true
+			}
+			line = line[:nul_index]
+		}
+		fields := strings.Split(line, " ")
+		if len(fields) != 3 || !isValidSHA1Name(fields[0]) || !isValidSHA1Name(fields[1]) {
+			return errors.New("protocol error: expected old/new/ref, got " + strconv.Quote(line))
+		}
+		update := &refUpdate{Dst: fields[2], OldSHA1: fields[0], NewSHA1: fields[1]}
+		if update.OldSHA1 == zero_sha1 {
+			update.OldSHA1 = ""
+		}
+		if update.NewSHA1 == zero_sha1 {
+			update.NewSHA1 = ""
+		} else {
+			has_pack = true
+		}
+		updates = append(updates, update)
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+
+	unpack_status := "ok"
+	if has_pack {
+		if _, err := UnpackObjects(rootDir, pkt_reader.reader); err != nil {
+			unpack_status = err.Error()
+		}
+	}
+	refused := make(map[string]string)
+	if unpack_status == "ok" {
+		var err error
+		if refused, err = receiveUpdates(rootDir, updates); err != nil {
+			return err
+		}
+	} else {
+		for _, update := range updates {
+			refused[update.Dst] = "unpacker error"
+		}
+	}
+	if !capabilities["report-status"] {
+		return nil
+	}
+
+	report := PktLine("unpack " + unpack_status + "\n")
+	for _, update := range updates {
+		if reason, ok := refused[update.Dst]; ok {
+			report += PktLine("ng " + update.Dst + " " + reason + "\n")
+		} else {
+			report += PktLine("ok " + update.Dst + "\n")
+		}
+	}
+	report += pktFlush
+	if !capabilities["side-band-64k"] {
+		_, err := io.WriteString(out, report)
+		return err
+	}
+	writer := bufio.NewWriter(out)
+	if _, err := newSideBandWriter(writer, 1).Write([]byte(report)); err != nil {
+		return err
+	}
+	writer.WriteString(pktFlush)
+	return writer.Flush()
+}
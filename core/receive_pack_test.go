@@ -0,0 +1,43 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReceiveUpdates(t *testing.T) {
+	isolateHome(t)
+	rootDir, first, second := newTestHistory(t)
+	writeTestRef(t, rootDir, "refs/heads/master", first)
+	// master is not checked out, so that it may be updated
+	writeTestRef(t, rootDir, "HEAD", "ref: refs/heads/other")
+
+	tree := writeTestObject(t, rootDir, "tree", "")
+	// a commit whose parent is missing
+	orphan := writeTestObject(t, rootDir, "commit", testCommitContent(tree, []string{"1111111111111111111111111111111111111111"}, "orphan\n"))
+	refused, err := receiveUpdates(rootDir, []*refUpdate{
+		{Dst: "refs/heads/master", OldSHA1: first, NewSHA1: second},
+		{Dst: "refs/heads/stale", OldSHA1: first, NewSHA1: second},
+		{Dst: "refs/heads/orphan", NewSHA1: orphan},
+		{Dst: "refs/heads/missing", NewSHA1: "2222222222222222222222222222222222222222"},
+		{Dst: "refs/../config", NewSHA1: second},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"refs/heads/stale":   "failed to update ref",
+		"refs/heads/orphan":  "missing necessary objects",
+		"refs/heads/missing": "missing necessary objects",
+		"refs/../config":     "funny refname",
+	}
+	if !reflect.DeepEqual(refused, want) {
+		t.Errorf("refused %q, want %q", refused, want)
+	}
+	if sha1_name := testRef(t, rootDir, "refs/heads/master"); sha1_name != second {
+		t.Errorf("master is %s, want %s", sha1_name, second)
+	}
+	if _, ok := ReadRef(rootDir, "refs/heads/orphan"); ok {
+		t.Error("the disconnected history was taken")
+	}
+}
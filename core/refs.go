@@ -0,0 +1,199 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type Ref struct {
+	Name string // full name, e.g. "refs/heads/master"
+	SHA1 string
+}
+
+// read .git/packed-refs, which stores one "<sha1> <ref name>" per line.
+// Lines starting with '^' hold the peeled value of the annotated tag above
+// them and are skipped.
+func readPackedRefs(rootDir string) map[string]string {
+	refs := make(map[string]string)
+	content, err := ioutil.ReadFile(GitDir(rootDir) + "/packed-refs")
+	if err != nil {
+		return refs
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		refs[fields[1]] = fields[0]
+	}
+	return refs
+}
+
+// ReadRef returns the SHA-1 a ref points to, following symbolic refs such as
+// "ref: refs/heads/master". Loose refs take precedence over packed refs.
+func ReadRef(rootDir string, name string) (string, bool) {
+	for depth := 0; depth < 5; depth++ {
+		content, err := ioutil.ReadFile(GitDir(rootDir) + "/" + name)
+		if err != nil {
+			sha1, ok := readPackedRefs(rootDir)[name]
+			return sha1, ok
+		}
+		value := strings.TrimSpace(string(content))
+		if !strings.HasPrefix(value, "ref:") {
+			return value, value != ""
+		}
+		name = strings.TrimSpace(value[len("ref:"):])
+	}
+	return "", false
+}
+
+// ListRefs returns every ref under .git/refs and in .git/packed-refs, sorted by name.
+func ListRefs(rootDir string) []*Ref {
+	values := readPackedRefs(rootDir)
+	refs_dir := GitDir(rootDir) + "/refs"
+	filepath.Walk(refs_dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		name := "refs/" + filepath.ToSlash(path[len(refs_dir)+1:])
+		if sha1, ok := ReadRef(rootDir, name); ok {
+			values[name] = sha1
+		}
+		return nil
+	})
+
+	refs := make([]*Ref, 0, len(values))
+	for name, sha1 := range values {
+		refs = append(refs, &Ref{name, sha1})
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Name < refs[j].Name
+	})
+	return refs
+}
+
+// A reflog line is "<old sha1> <new sha1> <identity> <timestamp> <timezone>\t<message>".
+type ReflogEntry struct {
+	OldSHA1  string
+	NewSHA1  string
+	Identity string
+	Message  string
+}
+
+func ReadReflog(rootDir string, name string) []*ReflogEntry {
+	entries := make([]*ReflogEntry, 0)
+	content, err := ioutil.ReadFile(GitDir(rootDir) + "/logs/" + name)
+	if err != nil {
+		return entries
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if len(line) < 82 {
+			continue
+		}
+		entry := new(ReflogEntry)
+		entry.OldSHA1 = line[:40]
+		entry.NewSHA1 = line[41:81]
+		rest := line[82:]
+		if tab_index := strings.Index(rest, "\t"); tab_index != -1 {
+			entry.Identity = rest[:tab_index]
+			entry.Message = rest[tab_index+1:]
+		} else {
+			entry.Identity = rest
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// ListReflogs returns the names of all refs which have a reflog.
+func ListReflogs(rootDir string) []string {
+	names := make([]string, 0)
+	logs_dir := GitDir(rootDir) + "/logs"
+	filepath.Walk(logs_dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		names = append(names, filepath.ToSlash(path[len(logs_dir)+1:]))
+		return nil
+	})
+	sort.Strings(names)
+	return names
+}
+
+func (entry *ReflogEntry) String() string {
+	return entry.OldSHA1 + " " + entry.NewSHA1 + " " + entry.Identity + "\t" + entry.Message
+}
+
+// WriteReflog replaces the reflog of a ref, oldest entry first.
+func WriteReflog(rootDir string, name string, entries []*ReflogEntry) error {
+	path := GitDir(rootDir) + "/logs/" + name
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	buffer := new(bytes.Buffer)
+	for _, entry := range entries {
+		buffer.WriteString(entry.String() + "\n")
+	}
+	return ioutil.WriteFile(path, buffer.Bytes(), 0644)
+}
+
+// AppendReflog adds an entry to the reflog of a ref.
+func AppendReflog(rootDir string, name string, entry *ReflogEntry) error {
+	path := GitDir(rootDir) + "/logs/" + name
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(entry.String() + "\n")
+	return err
+}
+
+// WriteRef points a loose ref, e.g. "refs/stash", to an object.
+func WriteRef(rootDir string, name string, sha1 string) error {
+	path := GitDir(rootDir) + "/" + name
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(sha1+"\n"), 0644)
+}
+
+// DeleteRef removes a ref, loose or packed, along with its reflog.
+func DeleteRef(rootDir string, name string) {
+	os.Remove(GitDir(rootDir) + "/" + name)
+	os.Remove(GitDir(rootDir) + "/logs/" + name)
+
+	path := GitDir(rootDir) + "/packed-refs"
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	kept := make([]string, 0, len(lines))
+	deleted := false
+	for _, line := range lines {
+		// the peeled value of a deleted tag goes with it
+		if strings.HasSuffix(strings.TrimSpace(line), " "+name) || deleted && strings.HasPrefix(line, "^") {
+			deleted = true
+			continue
+		}
+		deleted = false
+		kept = append(kept, line)
+	}
+	if len(kept) != len(lines) {
+		ioutil.WriteFile(path, []byte(strings.Join(kept, "")), 0644)
+	}
+}
@@ -3,6 +3,7 @@ package core
 import (
 	"bytes"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -16,50 +17,55 @@ import (
 type ReGit struct {
 	RootDir string
 	Config  map[string]string
+	NoPager bool // set by --no-pager
 }
 
 func NewReGit(rootDir string) *ReGit {
 	regit := new(ReGit)
 	regit.RootDir = rootDir
 	regit.Config = make(map[string]string)
-	regit.loadUserConfig()
+	regit.loadConfig()
 	return regit
 }
 
-func (regit *ReGit) loadUserConfig() {
+// Load ~/.gitconfig first, so that the repository's own config can override
+// it.
+func (regit *ReGit) loadConfig() {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		log.Fatal(err)
 	}
-	content, err := ioutil.ReadFile(home + "/.gitconfig")
-	if err != nil {
-		log.Fatal(err)
+	for _, path := range []string{home + "/.gitconfig", GitDir(regit.RootDir) + "/config"} {
+		err = ReadConfigFile(path, regit.Config)
+		if err != nil {
+			log.Fatal(path + ": " + err.Error())
+		}
 	}
-	content = bytes.ReplaceAll(content, []byte(" "), []byte(""))
-	content = bytes.ReplaceAll(content, []byte("\t"), []byte(""))
-	user_section_index := bytes.Index(content, []byte("[user]"))
-	if user_section_index == -1 {
-		log.Fatal("Error: 'user' section is not present in the .gitconfig file")
+	VerifyObjects = IsConfigTrue(regit.Config["core.verifyobjects"])
+}
+
+// GitDir returns the directory of the repository at rootDir: its .git
+// directory, or rootDir itself if it is a bare repository, which has no
+// working tree.
+func GitDir(rootDir string) string {
+	if IsBareRepository(rootDir) {
+		return rootDir
 	}
-	first_char_after_user_section_header_index := user_section_index + len([]byte("[user]"))
-	next_section_start_index := bytes.Index(content[first_char_after_user_section_header_index:], []byte("["))
+	return rootDir + "/.git"
+}
 
-	if next_section_start_index == -1 {
-		// read until end
-		content = content[first_char_after_user_section_header_index:]
-	} else {
-		next_section_start_index = first_char_after_user_section_header_index + next_section_start_index
-		content = content[first_char_after_user_section_header_index:next_section_start_index]
-	}
-	content = bytes.Trim(content, "\n")
-	lines := bytes.Split(content, []byte("\n"))
-	for _, line := range lines {
-		key_index := bytes.Index(line, []byte("="))
-		if key_index == -1 {
-			log.Fatal(".gitconfig file: syntax error")
+// IsBareRepository tells whether dir is a repository itself rather than a
+// working tree with a .git directory.
+func IsBareRepository(dir string) bool {
+	if _, err := os.Stat(dir + "/.git"); err == nil {
+		return false
+	}
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(dir + "/" + name); err != nil {
+			return false
 		}
-		regit.Config["user."+string(line[:key_index])] = string(line[key_index+1:])
 	}
+	return true
 }
 
 func (regit *ReGit) Init() {
@@ -76,7 +82,7 @@ func (regit *ReGit) Init() {
 
 func (regit *ReGit) Add(path_names []string) {
 	index := NewIndex(regit.RootDir)
-	index.Read()
+	regit.readIndexOrExit(index)
 
 	blob_path_names := make([]string, 0)
 	blob_obj_ids := make([][]byte, 0)
@@ -92,57 +98,199 @@ func (regit *ReGit) Add(path_names []string) {
 	index.Save()
 }
 
-func (regit *ReGit) Commmit(message string) {
-	index := NewIndex(regit.RootDir)
-	index.Read()
+// the name of the tree without any entries
+const EmptyTreeSHA1 = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
 
-	tg := NewTreeGraph()
-	for _, entry := range index.Entries() {
-		// path is nul-terminated
-		path := entry.Path[:len(entry.Path)-1]
-		tg.AddEntry(string(path), entry.Obj_name)
-	}
+// Commmit records the index as a new commit on top of HEAD, or as a
+// replacement of HEAD with options.Amend.
+func (regit *ReGit) Commmit(options *CommitOptions) {
+	regit.checkIdentity()
 
-	root_tree_id := tg.ConstructTreeObjects(regit.RootDir)
+	// the hook may change the index, so it runs before the index is read
+	if !options.NoVerify {
+		if err := regit.runHook("pre-commit", regit.commitHookEnv(!options.HasMessage)); err != nil {
+			fmt.Println("Error: " + err.Error())
+			os.Exit(1)
+		}
+	}
 
-	now := time.Now()
+	index := NewIndex(regit.RootDir)
+	regit.readIndexOrExit(index)
 
-	commit := NewCommitObject(regit.RootDir)
-	commit.SetTree(hex.EncodeToString(root_tree_id[:]))
-	commit.SetAuthor(regit.Config["user.name"] + " <" + regit.Config["user.email"] + "> " + strconv.FormatInt(now.Unix(), 10) + " " + now.Format("-0700"))
-	commit.SetCommitter(regit.Config["user.name"] + " <" + regit.Config["user.email"] + "> " + strconv.FormatInt(now.Unix(), 10) + " " + now.Format("-0700"))
+	if index.HasUnmergedEntries() {
+		fmt.Println("Error: committing is not possible because you have unmerged files.")
+		os.Exit(1)
+	}
 
 	head := NewHEAD(regit.RootDir)
 	head.Read()
 	var branch *Branch
+	head_sha1 := head.Content
 	if head.PointsToBranch {
 		branch = NewBranch(head.Content, regit.RootDir)
 		branch.Read()
+		head_sha1 = branch.Commit()
+	}
 
-		if len(branch.Commit()) != 0 {
-			commit.SetParents([]string{branch.Commit()})
+	author := regit.signature("GIT_AUTHOR_DATE").String()
+	if options.Author != "" {
+		name, email, err := ParseAuthor(options.Author)
+		if err != nil {
+			fmt.Println("Error: " + err.Error())
+			os.Exit(1)
 		}
+		signature := regit.signature("GIT_AUTHOR_DATE")
+		signature.Name, signature.Email = name, email
+		author = signature.String()
+	}
+	parents := make([]string, 0)
+	history_parents := parents
+	initial_message := ""
+	source, source_sha1 := "", ""
+	if options.Amend {
+		if head_sha1 == "" {
+			fmt.Println("Error: you have nothing to amend.")
+			os.Exit(1)
+		}
+		// the amended commit keeps the parents, author and message of HEAD
+		head_commit := NewCommitObject(regit.RootDir)
+		head_commit.ReadFromExistingObject(head_sha1)
+		parents = head_commit.parents
+		// the parent of a shallow commit is not in the repository
+		history_parents = head_commit.historyParents()
+		if options.Author == "" {
+			author = head_commit.author
+		}
+		initial_message = head_commit.message
 	} else {
-		if len(head.Content) != 0 {
-			commit.SetParents([]string{head.Content})
+		if head_sha1 != "" {
+			parents = append(parents, head_sha1)
+		}
+		history_parents = parents
+		// a stopped cherry-pick or revert is committed with its author and message
+		if pending_author, pending_message, ok := regit.pendingSequencerCommit(); ok {
+			if options.Author == "" {
+				author = pending_author
+			}
+			initial_message = pending_message
+			source = "merge"
 		}
 	}
 
+	root_tree_id := regit.writeTree(index)
+	tree_sha1 := hex.EncodeToString(root_tree_id[:])
+	// merges are never empty, as they record that histories were joined
+	if !options.AllowEmpty && len(parents) <= 1 {
+		parent_tree := EmptyTreeSHA1
+		if len(history_parents) == 1 {
+			parent_tree = regit.commitTree(history_parents[0])
+		}
+		if tree_sha1 == parent_tree {
+			if options.Amend {
+				fmt.Println("Error: you asked to amend the most recent commit, but doing so would make it empty. Use --allow-empty to amend it anyway.")
+			} else {
+				fmt.Println("Error: nothing to commit. Use --allow-empty to create a commit without any changes.")
+			}
+			os.Exit(1)
+		}
+	}
+
+	if options.Amend {
+		source, source_sha1 = "commit", "HEAD"
+	}
+	message := regit.prepareCommitMessage(options, initial_message, source, source_sha1)
+	if message == "" {
+		fmt.Println("Aborting commit due to empty commit message.")
+		os.Exit(1)
+	}
+
+	commit := NewCommitObject(regit.RootDir)
+	commit.SetTree(tree_sha1)
+	commit.SetParents(parents)
+	commit.SetAuthor(author)
+	commit.SetCommitter(regit.signature("GIT_COMMITTER_DATE").String())
 	commit.SetMessage(message)
 	commit.GenerateContent()
 	commit.Obj.WriteToFile()
-	if head.PointsToBranch {
-		branch.SetCommit(hex.EncodeToString(commit.Obj.HashedFilename))
-		branch.Write()
+	commit_sha1 := hex.EncodeToString(commit.Obj.HashedFilename)
+	regit.updateHead(commit_sha1)
+	NewSequencer(regit).removeCommandState()
+
+	subject, _ := splitCommitMessage(message)
+	if options.Amend {
+		fmt.Println("[commit (" + commit_sha1 + ") amended] " + subject)
 	} else {
-		head.PointsTo(hex.EncodeToString(commit.Obj.HashedFilename), false)
+		fmt.Println("[commit (" + commit_sha1 + ") created] " + subject)
+	}
+	regit.runHook("post-commit", regit.commitHookEnv(false))
+}
+
+// writeTree writes the tree objects for the content of the index and returns
+// the name of the root tree. Subtrees which are still valid in the cache tree
+// are reused, and the cache tree is updated with the trees built.
+func (regit *ReGit) writeTree(index *Index) []byte {
+	cache_tree := index.CacheTree()
+	if cache_tree != nil && cache_tree.IsValid() {
+		return cache_tree.HashedFilename
+	}
+
+	tg := NewTreeGraph()
+	for _, entry := range index.Entries() {
+		// path is nul-terminated
+		path := string(entry.Path[:len(entry.Path)-1])
+		// reuse the subtrees which did not change since they were cached
+		if cache_tree != nil {
+			if dir_path, tree_id, ok := cache_tree.ValidAncestor(path); ok {
+				tg.AddTreeEntry(dir_path, tree_id)
+				continue
+			}
+		}
+		tg.AddEntry(path, entry.Obj_name)
+	}
+
+	root_tree_id := tg.ConstructTreeObjects(regit.RootDir)
+	index.SetCacheTree(tg.CacheTree(cache_tree))
+	index.Save()
+	return root_tree_id
+}
+
+func (regit *ReGit) checkIdentity() {
+	if regit.Config["user.name"] == "" || regit.Config["user.email"] == "" {
+		fmt.Println("Error: please set 'user.name' and 'user.email' in your .gitconfig file")
+		os.Exit(1)
 	}
-	fmt.Println("[commit (" + hex.EncodeToString(commit.Obj.HashedFilename) + ") created] " + message)
 }
 
+// signature returns the configured identity dated now, unless the date is
+// overridden by the environment variable date_variable, e.g. GIT_AUTHOR_DATE.
+func (regit *ReGit) signature(date_variable string) *Signature {
+	date := time.Now()
+	if value := os.Getenv(date_variable); value != "" {
+		var err error
+		date, err = ParseDate(value)
+		if err != nil {
+			fmt.Println("Error: " + err.Error())
+			os.Exit(1)
+		}
+	}
+	return NewSignature(regit.Config["user.name"], regit.Config["user.email"], date)
+}
+
+// Checkout restores files from the index and runs the post-checkout hook.
 func (regit *ReGit) Checkout(path_names []string) {
+	regit.checkoutPaths(path_names)
+
+	// for a checkout of files, both commits are HEAD and the flag is 0
+	head_sha1, _ := ReadRef(regit.RootDir, "HEAD")
+	if head_sha1 == "" {
+		head_sha1 = strings.Repeat("0", 40)
+	}
+	regit.runHook("post-checkout", nil, head_sha1, head_sha1, "0")
+}
+
+func (regit *ReGit) checkoutPaths(path_names []string) {
 	index := NewIndex(regit.RootDir)
-	index.Read()
+	regit.readIndexOrExit(index)
 
 	path_to_entry_index_map := make(map[string]int)
 	entries := index.Entries()
@@ -157,6 +305,11 @@ func (regit *ReGit) Checkout(path_names []string) {
 		}
 		path_to_entry_index_map[path_name] = entry_index
 	}
+	blobs := make([]string, 0, len(path_names))
+	for _, path_name := range path_names {
+		blobs = append(blobs, hex.EncodeToString(entries[path_to_entry_index_map[path_name]].Obj_name))
+	}
+	regit.fetchPromisedObjectsOrExit(blobs)
 
 	for _, path_name := range path_names {
 		entry_index := path_to_entry_index_map[path_name]
@@ -178,6 +331,38 @@ func (regit *ReGit) Checkout(path_names []string) {
 	fmt.Println("Updated " + strconv.Itoa(len(path_names)) + " path from the index")
 }
 
+// readIndexOrExit reads the index for a command, which can not go on when it
+// is corrupt.
+func (regit *ReGit) readIndexOrExit(index *Index) {
+	if err := index.Read(); err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+}
+
+func (regit *ReGit) Unresolve(path_names []string) {
+	index := NewIndex(regit.RootDir)
+	regit.readIndexOrExit(index)
+
+	unresolved_path_names := index.Unresolve(path_names)
+	index.Save()
+	if len(unresolved_path_names) != len(path_names) {
+		for _, path_name := range path_names {
+			found := false
+			for _, unresolved_path_name := range unresolved_path_names {
+				if path_name == unresolved_path_name {
+					found = true
+					break
+				}
+			}
+			if !found {
+				fmt.Println("Error: '" + path_name + "' has no resolved conflict to re-open")
+			}
+		}
+		os.Exit(1)
+	}
+}
+
 func (regit *ReGit) CreateBranch(name string) {
 	head := NewHEAD(regit.RootDir)
 	head.Read()
@@ -199,7 +384,8 @@ func (regit *ReGit) CreateBranch(name string) {
 	new_branch.Write()
 }
 
-func (regit *ReGit) Log() {
+// Log prints the history of revision, or of HEAD if revision is empty.
+func (regit *ReGit) Log(revision string, options *LogOptions) {
 	head := NewHEAD(regit.RootDir)
 	head.Read()
 
@@ -208,8 +394,15 @@ func (regit *ReGit) Log() {
 		os.Exit(1)
 	}
 
+	if options.All {
+		regit.logAll(options)
+		return
+	}
+
 	root_commit := NewCommitObject(regit.RootDir)
-	if head.PointsToBranch {
+	if revision != "" {
+		root_commit.ReadFromExistingObject(regit.resolveRevisionOrExit(revision + "^{commit}"))
+	} else if head.PointsToBranch {
 		current_branch := NewBranch(head.Content, regit.RootDir)
 		current_branch.Read()
 		if len(current_branch.Commit()) == 0 {
@@ -221,7 +414,103 @@ func (regit *ReGit) Log() {
 		root_commit.ReadFromExistingObject(head.Content)
 	}
 	cg := NewCommitGraph(root_commit, regit.RootDir)
-	cg.PrintCommitLogs()
+	regit.printCommitLogs(cg, options)
+}
+
+// logAll prints the commits reachable from HEAD or any ref. Refs which do not
+// point to a commit, e.g. tags of a blob, are skipped.
+func (regit *ReGit) logAll(options *LogOptions) {
+	var cg *CommitGraph
+	add_root := func(sha1_name string) {
+		commit_sha1, err := PeelObject(regit.RootDir, sha1_name, "commit")
+		if err != nil {
+			return
+		}
+		commit := NewCommitObject(regit.RootDir)
+		commit.ReadFromExistingObject(commit_sha1)
+		if cg == nil {
+			cg = NewCommitGraph(commit, regit.RootDir)
+		} else {
+			cg.AddRootCommit(commit)
+		}
+	}
+
+	if head_sha1, ok := ReadRef(regit.RootDir, "HEAD"); ok {
+		add_root(head_sha1)
+	}
+	for _, ref := range ListRefs(regit.RootDir) {
+		add_root(ref.SHA1)
+	}
+	if cg == nil {
+		return
+	}
+	regit.printCommitLogs(cg, options)
+}
+
+func (regit *ReGit) printCommitLogs(cg *CommitGraph, options *LogOptions) {
+	options.Color = regit.UseColor()
+	pager := regit.startPager()
+	cg.PrintCommitLogs(pager, options)
+	pager.Close()
+}
+
+// Fsck prints the problems found in the repository, either one per line or
+// as a JSON document, and exits with a non-zero status if any of them is an error.
+func (regit *ReGit) Fsck(show_unreachable bool, json_output bool) {
+	FetchMissingObjects = false
+	report := NewFsck(regit.RootDir).Run(show_unreachable)
+
+	if json_output {
+		content, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(content))
+	} else {
+		for _, problem := range report.Problems {
+			fmt.Println(problem.String())
+		}
+	}
+
+	if report.HasErrors() {
+		os.Exit(1)
+	}
+}
+
+// Prune removes unreachable loose objects older than expire, which is a date
+// accepted by ParseExpiryDate. An empty expire falls back to gc.pruneExpire,
+// or two weeks ago if it is not set either.
+func (regit *ReGit) Prune(expire string, dry_run bool, verbose bool) {
+	if expire == "" {
+		expire = regit.Config["gc.pruneexpire"]
+	}
+	if expire == "" {
+		expire = "2.weeks.ago"
+	}
+	expire_date, err := ParseExpiryDate(expire, time.Now())
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+
+	FetchMissingObjects = false
+	pruned_objects, err := Prune(regit.RootDir, expire_date, dry_run)
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+	var freed_size int64
+	for _, obj := range pruned_objects {
+		if dry_run || verbose {
+			fmt.Println(obj.SHA1 + " " + obj.Type)
+		}
+		freed_size += obj.Size
+	}
+	if dry_run {
+		fmt.Printf("Would remove %d objects, freeing %d bytes\n", len(pruned_objects), freed_size)
+	} else {
+		fmt.Printf("Removed %d objects, freed %d bytes\n", len(pruned_objects), freed_size)
+	}
 }
 
 func (regit *ReGit) Merge(target_branch_name string) {
@@ -304,13 +593,15 @@ func (regit *ReGit) Merge(target_branch_name string) {
 		}
 		index.WriteEmptyStatEntries(path_names, object_ids, stages)
 		index.Save()
-		regit.Checkout(path_names)
+		regit.checkoutPaths(path_names)
 
 		index.ClearEntries()
 		index.WriteEntries(path_names, object_ids)
 		index.Save()
 
 		fmt.Println("Fast-forward merge")
+		// the argument tells whether the merge was a squash
+		regit.runHook("post-merge", nil, "0")
 	} else {
 		fmt.Println("Error: only fast-forward merge is supported")
 	}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// isolateHome points HOME at an empty directory for the length of a test, so
+// that the ~/.gitconfig of whoever runs it is not read.
+func isolateHome(t *testing.T) {
+	setEnv(t, "HOME", t.TempDir())
+}
+
+// setEnv sets an environment variable for the length of a test.
+func setEnv(t *testing.T, name string, value string) {
+	old_value, had_value := os.LookupEnv(name)
+	os.Setenv(name, value)
+	t.Cleanup(func() {
+		if had_value {
+			os.Setenv(name, old_value)
+		} else {
+			os.Unsetenv(name)
+		}
+	})
+}
+
+// captureOutput runs f and returns what it printed on the standard output.
+func captureOutput(t *testing.T, f func()) string {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	output := make(chan []byte)
+	go func() {
+		content, _ := ioutil.ReadAll(reader)
+		output <- content
+	}()
+	defer func() {
+		os.Stdout = stdout
+	}()
+	f()
+	writer.Close()
+	return string(<-output)
+}
+
+// newTestRepository makes an empty repository at rootDir.
+func newTestRepository(t *testing.T, rootDir string) *ReGit {
+	if err := os.MkdirAll(rootDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	regit := NewReGit(rootDir)
+	captureOutput(t, regit.Init)
+	regit.Config["user.name"] = "A U Thor"
+	regit.Config["user.email"] = "author@example.com"
+	return regit
+}
+
+// stageFile puts content into the index of rootDir as path, replacing what
+// was staged there, without touching the working tree.
+func stageFile(t *testing.T, rootDir string, path string, content string) {
+	sha1_name, _ := hex.DecodeString(writeTestObject(t, rootDir, "blob", content))
+	index := NewIndex(rootDir)
+	if err := index.Read(); err != nil {
+		t.Fatal(err)
+	}
+	path_names := []string{path}
+	object_ids := [][]byte{sha1_name}
+	for _, entry := range index.Entries() {
+		if entry_path := string(entry.Path[:len(entry.Path)-1]); entry_path != path {
+			path_names = append(path_names, entry_path)
+			object_ids = append(object_ids, entry.Obj_name)
+		}
+	}
+	index.ClearEntries()
+	index.WriteEmptyStatEntries(path_names, object_ids, make([]uint16, len(path_names)))
+	for _, entry := range index.Entries() {
+		entry.Mode = 0100644
+	}
+	index.Save()
+}
+
+// testRef returns what a ref points to, failing the test if it does not
+// exist.
+func testRef(t *testing.T, rootDir string, name string) string {
+	sha1_name, err := ResolveRevision(rootDir, name)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return sha1_name
+}
+
+// commitFile writes a file of the working tree and commits it on the
+// current branch, whose new commit it returns.
+func commitFile(t *testing.T, rootDir string, name string, content string) string {
+	if err := ioutil.WriteFile(rootDir+"/"+name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stageFile(t, rootDir, name, content)
+	regit := NewReGit(rootDir)
+	regit.Config["user.name"] = "A U Thor"
+	regit.Config["user.email"] = "author@example.com"
+	captureOutput(t, func() {
+		regit.Commmit(&CommitOptions{Message: "change " + name + "\n", HasMessage: true})
+	})
+	return testRef(t, rootDir, "HEAD")
+}
+
+// chdir changes the working directory for the length of a test, for the
+// commands which take paths relative to it.
+func chdir(t *testing.T, dir string) {
+	old_dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(old_dir)
+	})
+}
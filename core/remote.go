@@ -0,0 +1,166 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Refspec maps refs of one repository to refs of another, e.g.
+// "+refs/heads/*:refs/remotes/origin/*". A "*" in Src matches any part of a
+// name, which is put in place of the "*" of Dst. With Force, refs may be
+// updated to commits which do not descend from their current ones.
+type Refspec struct {
+	Force bool
+	Src   string
+	Dst   string
+}
+
+func ParseRefspec(spec string) *Refspec {
+	refspec := new(Refspec)
+	if strings.HasPrefix(spec, "+") {
+		refspec.Force = true
+		spec = spec[1:]
+	}
+	refspec.Src = spec
+	if colon_index := strings.Index(spec, ":"); colon_index != -1 {
+		refspec.Src = spec[:colon_index]
+		refspec.Dst = spec[colon_index+1:]
+	}
+	return refspec
+}
+
+// Match returns the name a ref is mapped to, if it matches the source of the
+// refspec.
+func (refspec *Refspec) Match(name string) (string, bool) {
+	star_index := strings.Index(refspec.Src, "*")
+	if star_index == -1 {
+		return refspec.Dst, name == refspec.Src
+	}
+	prefix, suffix := refspec.Src[:star_index], refspec.Src[star_index+1:]
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) || len(name) < len(prefix)+len(suffix) {
+		return "", false
+	}
+	return strings.Replace(refspec.Dst, "*", name[len(prefix):len(name)-len(suffix)], 1), true
+}
+
+// Remote is a repository named in the config, with a [remote "<name>"]
+// section.
+type Remote struct {
+	Name  string
+	URL   string
+	Fetch *Refspec
+}
+
+// Remote reads the config of a remote, exiting if there is none.
+func (regit *ReGit) Remote(name string) *Remote {
+	url, ok := regit.Config["remote."+name+".url"]
+	if !ok {
+		fmt.Println("Error: '" + name + "' does not appear to be a git repository")
+		os.Exit(1)
+	}
+	remote := &Remote{Name: name, URL: url}
+	if fetch, ok := regit.Config["remote."+name+".fetch"]; ok {
+		remote.Fetch = ParseRefspec(fetch)
+	}
+	return remote
+}
+
+// RemoteNames lists the remotes of the config, sorted by name.
+func (regit *ReGit) RemoteNames() []string {
+	names := make([]string, 0)
+	for key := range regit.Config {
+		if strings.HasPrefix(key, "remote.") && strings.HasSuffix(key, ".url") {
+			names = append(names, key[len("remote."):len(key)-len(".url")])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultRemote returns the remote of the current branch, or "origin".
+func (regit *ReGit) DefaultRemote() string {
+	head := NewHEAD(regit.RootDir)
+	head.Read()
+	if remote, ok := regit.Config["branch."+head.Content+".remote"]; ok && head.PointsToBranch {
+		return remote
+	}
+	return "origin"
+}
+
+// shortRefName drops the "refs/heads/", "refs/tags/" or "refs/remotes/"
+// prefix of a ref name, as it is shown by fetch and push.
+func shortRefName(name string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/"} {
+		if strings.HasPrefix(name, prefix) {
+			return name[len(prefix):]
+		}
+	}
+	return name
+}
+
+var remoteNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// RemoteAdd records a remote in the config, fetching all of its branches to
+// refs/remotes/<name>/*.
+func (regit *ReGit) RemoteAdd(name string, url string) {
+	if !remoteNamePattern.MatchString(name) || strings.HasPrefix(name, ".") {
+		fmt.Println("Error: '" + name + "' is not a valid remote name")
+		os.Exit(1)
+	}
+	if _, ok := regit.Config["remote."+name+".url"]; ok {
+		fmt.Println("Error: remote " + name + " already exists.")
+		os.Exit(1)
+	}
+	err := AddConfigSection(GitDir(regit.RootDir)+"/config", "remote."+name, [][2]string{
+		{"url", url},
+		{"fetch", "+refs/heads/*:refs/remotes/" + name + "/*"},
+	})
+	if err != nil {
+		fmt.Println("Error: could not write the config: " + err.Error())
+		os.Exit(1)
+	}
+}
+
+// RemoteRemove deletes a remote from the config, along with its
+// remote-tracking refs and the settings of the branches which track it.
+func (regit *ReGit) RemoteRemove(name string) {
+	if _, ok := regit.Config["remote."+name+".url"]; !ok {
+		fmt.Println("Error: No such remote: '" + name + "'")
+		os.Exit(1)
+	}
+	sections := []string{"remote." + name}
+	for key, value := range regit.Config {
+		if strings.HasPrefix(key, "branch.") && strings.HasSuffix(key, ".remote") && value == name {
+			sections = append(sections, key[:len(key)-len(".remote")])
+		}
+	}
+	for _, section := range sections {
+		if err := RemoveConfigSection(GitDir(regit.RootDir)+"/config", section); err != nil {
+			fmt.Println("Error: could not write the config: " + err.Error())
+			os.Exit(1)
+		}
+	}
+	for _, ref := range ListRefs(regit.RootDir) {
+		if strings.HasPrefix(ref.Name, "refs/remotes/"+name+"/") {
+			DeleteRef(regit.RootDir, ref.Name)
+		}
+	}
+	os.Remove(GitDir(regit.RootDir) + "/refs/remotes/" + name)
+}
+
+// RemoteList prints the names of the remotes, with their URLs if verbose is
+// set.
+func (regit *ReGit) RemoteList(verbose bool) {
+	for _, name := range regit.RemoteNames() {
+		if verbose {
+			url := regit.Config["remote."+name+".url"]
+			fmt.Println(name + "\t" + url + " (fetch)")
+			fmt.Println(name + "\t" + url + " (push)")
+		} else {
+			fmt.Println(name)
+		}
+	}
+}
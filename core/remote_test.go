@@ -0,0 +1,148 @@
+package core
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRefspec(t *testing.T) {
+	refspec := ParseRefspec("+refs/heads/*:refs/remotes/origin/*")
+	if !reflect.DeepEqual(refspec, &Refspec{true, "refs/heads/*", "refs/remotes/origin/*"}) {
+		t.Fatalf("parsed %+v", refspec)
+	}
+	if dst, ok := refspec.Match("refs/heads/topic/a"); !ok || dst != "refs/remotes/origin/topic/a" {
+		t.Errorf("refs/heads/topic/a is mapped to %q, %v", dst, ok)
+	}
+	if _, ok := refspec.Match("refs/tags/v1.0"); ok {
+		t.Error("refs/tags/v1.0 matched")
+	}
+	refspec = ParseRefspec("master")
+	if dst, ok := refspec.Match("master"); refspec.Force || !ok || dst != "" {
+		t.Errorf("master is mapped to %q, %v", dst, ok)
+	}
+}
+
+func TestRemoteAddAndRemove(t *testing.T) {
+	isolateHome(t)
+	rootDir := t.TempDir()
+	newTestRepository(t, rootDir)
+	NewReGit(rootDir).RemoteAdd("origin", "/srv/repo.git")
+	writeTestRef(t, rootDir, "HEAD", "ref: refs/heads/master")
+	WriteRef(rootDir, "refs/remotes/origin/master", strings.Repeat("1", 40))
+	AddConfigSection(rootDir+"/.git/config", "branch.master", [][2]string{{"remote", "origin"}, {"merge", "refs/heads/master"}})
+
+	regit := NewReGit(rootDir)
+	remote := regit.Remote("origin")
+	if remote.URL != "/srv/repo.git" || remote.Fetch.Dst != "refs/remotes/origin/*" {
+		t.Errorf("the remote is %+v", remote)
+	}
+	if output := captureOutput(t, func() { regit.RemoteList(false) }); output != "origin\n" {
+		t.Errorf("the remotes are listed as %q", output)
+	}
+	if name := regit.DefaultRemote(); name != "origin" {
+		t.Errorf("the default remote is %q", name)
+	}
+
+	regit.RemoteRemove("origin")
+	regit = NewReGit(rootDir)
+	if len(regit.RemoteNames()) != 0 || regit.Config["branch.master.remote"] != "" {
+		t.Errorf("the config still has the remote: %q", regit.Config)
+	}
+	if _, ok := ReadRef(rootDir, "refs/remotes/origin/master"); ok {
+		t.Error("the remote-tracking ref was kept")
+	}
+}
+
+// newTestClone makes a repository with one commit and a clone of it, and
+// returns their root directories.
+func newTestClone(t *testing.T) (string, string) {
+	isolateHome(t)
+	source := t.TempDir()
+	chdir(t, source)
+	newTestRepository(t, source)
+	commitFile(t, source, "a.txt", "a\n")
+	parent := t.TempDir()
+	captureOutput(t, func() {
+		NewReGit(parent).Clone(source, "clone", false, "", nil, nil)
+	})
+	return source, parent + "/clone"
+}
+
+func TestFetch(t *testing.T) {
+	source, clone := newTestClone(t)
+	WriteRef(source, "refs/tags/v1.0", testRef(t, source, "HEAD"))
+	second := commitFile(t, source, "b.txt", "b\n")
+
+	regit := NewReGit(clone)
+	output := captureOutput(t, func() {
+		regit.Fetch("origin", nil, nil)
+	})
+	if !strings.Contains(output, "master     -> origin/master") || !strings.Contains(output, "[new tag]") {
+		t.Errorf("fetch printed %q", output)
+	}
+	if sha1_name := testRef(t, clone, "refs/remotes/origin/master"); sha1_name != second {
+		t.Errorf("origin/master is %s, want %s", sha1_name, second)
+	}
+	if sha1_name := testRef(t, clone, "master"); sha1_name == second {
+		t.Error("master was updated by the fetch")
+	}
+	if !ObjectExists(clone, regit.commitTree(second)) {
+		t.Error("the tree of the fetched commit was not copied")
+	}
+	fetch_head, _ := ioutil.ReadFile(clone + "/.git/FETCH_HEAD")
+	if !strings.HasPrefix(string(fetch_head), second+"\t\tbranch 'master' of ") {
+		t.Errorf("FETCH_HEAD is %q", fetch_head)
+	}
+}
+
+func TestPush(t *testing.T) {
+	source, clone := newTestClone(t)
+	chdir(t, clone)
+	second := commitFile(t, clone, "b.txt", "b\n")
+
+	regit := NewReGit(clone)
+	captureOutput(t, func() {
+		regit.Push("origin", []string{"master:topic"}, false)
+	})
+	if sha1_name := testRef(t, source, "refs/heads/topic"); sha1_name != second {
+		t.Errorf("topic is %s in the remote, want %s", sha1_name, second)
+	}
+	if !ObjectExists(source, second) {
+		t.Error("the pushed commit was not copied")
+	}
+	if sha1_name := testRef(t, clone, "refs/remotes/origin/topic"); sha1_name != second {
+		t.Errorf("origin/topic is %s, want %s", sha1_name, second)
+	}
+
+	captureOutput(t, func() {
+		regit.Push("origin", []string{":topic"}, false)
+	})
+	if _, ok := ReadRef(source, "refs/heads/topic"); ok {
+		t.Error("topic was not deleted from the remote")
+	}
+}
+
+func TestClassify(t *testing.T) {
+	isolateHome(t)
+	rootDir, first, second := newTestHistory(t)
+	regit := NewReGit(rootDir)
+	for _, test := range []struct {
+		update *refUpdate
+		flag   byte
+	}{
+		{&refUpdate{Dst: "refs/heads/a", NewSHA1: second}, '*'},
+		{&refUpdate{Dst: "refs/heads/a", OldSHA1: first, NewSHA1: second}, ' '},
+		{&refUpdate{Dst: "refs/heads/a", OldSHA1: second, NewSHA1: second}, '='},
+		{&refUpdate{Dst: "refs/heads/a", OldSHA1: second, NewSHA1: first}, '!'},
+		{&refUpdate{Dst: "refs/heads/a", OldSHA1: second, NewSHA1: first, Force: true}, '+'},
+		{&refUpdate{Dst: "refs/heads/a", OldSHA1: strings.Repeat("1", 40), NewSHA1: first}, '!'},
+		{&refUpdate{Dst: "refs/heads/a", OldSHA1: first}, '-'},
+	} {
+		regit.classify(test.update)
+		if test.update.Flag != test.flag {
+			t.Errorf("%s..%s is classified as %q, want %q", test.update.OldSHA1, test.update.NewSHA1, test.update.Flag, test.flag)
+		}
+	}
+}
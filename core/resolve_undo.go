@@ -0,0 +1,80 @@
+package core
+
+import (
+	"bytes"
+	"log"
+	"strconv"
+)
+
+// The resolve-undo extension ("REUC") remembers the higher-stage entries of a
+// path when a conflict is resolved, so that the conflict can be re-opened.
+//
+// Every entry is stored as:
+//   - NUL-terminated path name
+//   - three NUL-terminated ASCII octal modes for stage 1 to 3, "0" if the
+//     stage is absent
+//   - 20-byte object names for the stages whose mode is not 0
+type ResolveUndoEntry struct {
+	Path      string
+	Modes     [3]uint32
+	Obj_names [3][]byte
+}
+
+func NewResolveUndoEntry(path string) *ResolveUndoEntry {
+	undo := new(ResolveUndoEntry)
+	undo.Path = path
+	return undo
+}
+
+func ParseResolveUndo(data []byte) []*ResolveUndoEntry {
+	entries := make([]*ResolveUndoEntry, 0)
+	for len(data) > 0 {
+		path_end_index := bytes.IndexByte(data, byte(0))
+		if path_end_index == -1 {
+			log.Fatal("Error: resolve-undo extension is broken")
+		}
+		undo := NewResolveUndoEntry(string(data[:path_end_index]))
+		data = data[path_end_index+1:]
+
+		for stage := 0; stage < 3; stage++ {
+			mode_end_index := bytes.IndexByte(data, byte(0))
+			if mode_end_index == -1 {
+				log.Fatal("Error: resolve-undo extension is broken")
+			}
+			mode, err := strconv.ParseUint(string(data[:mode_end_index]), 8, 32)
+			if err != nil {
+				log.Fatal("Error: resolve-undo extension is broken")
+			}
+			undo.Modes[stage] = uint32(mode)
+			data = data[mode_end_index+1:]
+		}
+		for stage := 0; stage < 3; stage++ {
+			if undo.Modes[stage] == 0 {
+				continue
+			}
+			if len(data) < 20 {
+				log.Fatal("Error: resolve-undo extension is broken")
+			}
+			undo.Obj_names[stage] = data[:20]
+			data = data[20:]
+		}
+		entries = append(entries, undo)
+	}
+	return entries
+}
+
+func SerializeResolveUndo(entries []*ResolveUndoEntry) []byte {
+	var buf bytes.Buffer
+	for _, undo := range entries {
+		buf.WriteString(undo.Path + "\000")
+		for stage := 0; stage < 3; stage++ {
+			buf.WriteString(strconv.FormatUint(uint64(undo.Modes[stage]), 8) + "\000")
+		}
+		for stage := 0; stage < 3; stage++ {
+			if undo.Modes[stage] != 0 {
+				buf.Write(undo.Obj_names[stage])
+			}
+		}
+	}
+	return buf.Bytes()
+}
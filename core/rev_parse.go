@@ -0,0 +1,243 @@
+package core
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+func unknownRevision(revision string) error {
+	return errors.New("ambiguous argument '" + revision + "': unknown revision or path not in the working tree")
+}
+
+// ResolveRevision turns a revision into a full object name. It understands
+// ref names ("HEAD", "master", "refs/tags/v1.0"), full and abbreviated
+// SHA-1s, the "~<n>", "^<n>" and "^{<type>}" suffixes, and "<rev>:<path>" for
+// the object at path in the tree of rev (":<path>" names the index entry).
+func ResolveRevision(rootDir string, revision string) (string, error) {
+	if colon_index := strings.Index(revision, ":"); colon_index != -1 {
+		tree_ish := revision[:colon_index]
+		path := strings.Trim(revision[colon_index+1:], "/")
+		if tree_ish == "" {
+			return resolveIndexPath(rootDir, path, revision)
+		}
+		tree_sha1, err := ResolveRevision(rootDir, tree_ish+"^{tree}")
+		if err != nil {
+			return "", err
+		}
+		return resolveTreePath(rootDir, tree_sha1, path, revision)
+	}
+
+	base_end_index := strings.IndexAny(revision, "~^")
+	if base_end_index == -1 {
+		base_end_index = len(revision)
+	}
+	sha1_name, err := resolveRevisionBase(rootDir, revision[:base_end_index])
+	if err != nil {
+		return "", unknownRevision(revision)
+	}
+
+	suffixes := revision[base_end_index:]
+	for len(suffixes) != 0 {
+		operator := suffixes[0]
+		suffixes = suffixes[1:]
+
+		if operator == '^' && strings.HasPrefix(suffixes, "{") {
+			type_end_index := strings.Index(suffixes, "}")
+			if type_end_index == -1 {
+				return "", unknownRevision(revision)
+			}
+			sha1_name, err = PeelObject(rootDir, sha1_name, suffixes[1:type_end_index])
+			if err != nil {
+				return "", err
+			}
+			suffixes = suffixes[type_end_index+1:]
+			continue
+		}
+
+		digits_end_index := 0
+		for digits_end_index < len(suffixes) && suffixes[digits_end_index] >= '0' && suffixes[digits_end_index] <= '9' {
+			digits_end_index++
+		}
+		n := 1
+		if digits_end_index != 0 {
+			n, _ = strconv.Atoi(suffixes[:digits_end_index])
+		}
+		suffixes = suffixes[digits_end_index:]
+
+		commit_sha1, err := PeelObject(rootDir, sha1_name, "commit")
+		if err != nil {
+			return "", err
+		}
+		if operator == '^' {
+			// "^0" is the commit itself, "^2" is its second parent
+			if n == 0 {
+				sha1_name = commit_sha1
+				continue
+			}
+			commit := NewCommitObject(rootDir)
+			if err := commit.Load(commit_sha1); err != nil {
+				return "", err
+			}
+			parents := commit.historyParents()
+			if n > len(parents) {
+				return "", unknownRevision(revision)
+			}
+			sha1_name = parents[n-1]
+			continue
+		}
+		// "~3" follows the first parent three times
+		sha1_name = commit_sha1
+		for i := 0; i < n; i++ {
+			commit := NewCommitObject(rootDir)
+			if err := commit.Load(sha1_name); err != nil {
+				return "", err
+			}
+			parents := commit.historyParents()
+			if len(parents) == 0 {
+				return "", unknownRevision(revision)
+			}
+			sha1_name = parents[0]
+		}
+	}
+	return sha1_name, nil
+}
+
+// refs are looked up in the same order as git does
+func resolveRevisionBase(rootDir string, name string) (string, error) {
+	if name == "" || name == "@" {
+		name = "HEAD"
+	}
+	// "<ref>@{<n>}" is what the ref pointed to n changes ago, from its reflog
+	if at_index := strings.Index(name, "@{"); at_index != -1 && strings.HasSuffix(name, "}") {
+		return resolveReflogEntry(rootDir, name[:at_index], name[at_index+2:len(name)-1])
+	}
+	for _, candidate := range []string{name, "refs/" + name, "refs/tags/" + name, "refs/heads/" + name, "refs/remotes/" + name, "refs/remotes/" + name + "/HEAD"} {
+		if sha1_name, ok := ReadRef(rootDir, candidate); ok {
+			return sha1_name, nil
+		}
+	}
+
+	name = strings.ToLower(name)
+	if len(name) < 4 || len(name) > 40 {
+		return "", unknownRevision(name)
+	}
+	if _, err := hex.DecodeString(name + strings.Repeat("0", len(name)%2)); err != nil {
+		return "", unknownRevision(name)
+	}
+	if len(name) == 40 {
+		if ObjectExists(rootDir, name) {
+			return name, nil
+		}
+		return "", unknownRevision(name)
+	}
+
+	matches := make([]string, 0)
+	for _, sha1_name := range ListObjects(rootDir) {
+		if strings.HasPrefix(sha1_name, name) {
+			matches = append(matches, sha1_name)
+		}
+	}
+	if len(matches) > 1 {
+		return "", errors.New("short SHA1 " + name + " is ambiguous")
+	}
+	if len(matches) == 0 {
+		return "", unknownRevision(name)
+	}
+	return matches[0], nil
+}
+
+func resolveReflogEntry(rootDir string, name string, number string) (string, error) {
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 0 {
+		return "", unknownRevision(name + "@{" + number + "}")
+	}
+	if name == "" {
+		name = "HEAD"
+	}
+	for _, candidate := range []string{name, "refs/" + name, "refs/tags/" + name, "refs/heads/" + name, "refs/remotes/" + name} {
+		if _, ok := ReadRef(rootDir, candidate); !ok {
+			continue
+		}
+		entries := ReadReflog(rootDir, candidate)
+		if n >= len(entries) {
+			return "", errors.New("log for '" + name + "' only has " + strconv.Itoa(len(entries)) + " entries")
+		}
+		return entries[len(entries)-1-n].NewSHA1, nil
+	}
+	return "", unknownRevision(name)
+}
+
+// PeelObject dereferences tags, and commits into their trees, until an object
+// of the requested type is found. An empty type only dereferences tags.
+func PeelObject(rootDir string, sha1Name string, typ string) (string, error) {
+	for {
+		obj, err := ReadObject(rootDir, sha1Name)
+		if err != nil {
+			return "", err
+		}
+		if obj.typ == typ || (typ == "" && obj.typ != "tag") || typ == "object" {
+			return sha1Name, nil
+		}
+		switch obj.typ {
+		case "tag":
+			tag := NewTagObject(rootDir)
+			tag.Obj = *obj
+			if err := tag.parse(); err != nil {
+				return "", err
+			}
+			sha1Name = tag.object
+		case "commit":
+			if typ != "tree" {
+				return "", errors.New(sha1Name + " is a commit, not a " + typ)
+			}
+			commit := NewCommitObject(rootDir)
+			commit.Obj = *obj
+			if err := commit.parse(); err != nil {
+				return "", err
+			}
+			sha1Name = commit.tree
+		default:
+			return "", errors.New(sha1Name + " is a " + obj.typ + ", not a " + typ)
+		}
+	}
+}
+
+func resolveTreePath(rootDir string, tree_sha1 string, path string, revision string) (string, error) {
+	if path == "" {
+		return tree_sha1, nil
+	}
+	sha1_name := tree_sha1
+	for _, name := range strings.Split(path, "/") {
+		tree := NewTreeObject(rootDir)
+		if err := tree.Load(sha1_name); err != nil {
+			return "", errors.New("path '" + path + "' does not exist in '" + revision + "'")
+		}
+		found := false
+		for _, entry := range tree.Entries {
+			if entry.FileName == name {
+				sha1_name = hex.EncodeToString(entry.HashedFilename)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", errors.New("path '" + path + "' does not exist in '" + revision + "'")
+		}
+	}
+	return sha1_name, nil
+}
+
+func resolveIndexPath(rootDir string, path string, revision string) (string, error) {
+	index := NewIndex(rootDir)
+	if err := index.Read(); err != nil {
+		return "", err
+	}
+	for _, entry := range index.Entries() {
+		if string(entry.Path[:len(entry.Path)-1]) == path && entry.Stage() == 0 {
+			return hex.EncodeToString(entry.Obj_name), nil
+		}
+	}
+	return "", errors.New("path '" + path + "' is not in the index")
+}
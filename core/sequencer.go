@@ -0,0 +1,427 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// a command of the sequencer: "pick" applies the changes of a commit,
+// "revert" undoes them
+type sequencerCommand struct {
+	Action  string
+	SHA1    string
+	Subject string
+}
+
+// the command a sequencer action is run with, for messages
+func sequencerCommandName(action string) string {
+	if action == "revert" {
+		return "revert"
+	}
+	return "cherry-pick"
+}
+
+// Sequencer applies or reverts commits one after the other. When one of them
+// conflicts, its state is saved in .git/sequencer so that the user can
+// resolve the conflicts and carry on with --continue, or give up with --skip
+// or --abort:
+//
+//	head  the commit HEAD pointed to before the first command
+//	todo  the commands left, the stopped one first
+//	opts  the options, in the config file format
+type Sequencer struct {
+	regit        *ReGit
+	dir          string
+	Head         string
+	Todo         []*sequencerCommand
+	RecordOrigin bool // add "(cherry picked from commit ...)" to the messages of picks
+}
+
+func NewSequencer(regit *ReGit) *Sequencer {
+	seq := new(Sequencer)
+	seq.regit = regit
+	seq.dir = GitDir(regit.RootDir) + "/sequencer"
+	seq.Todo = make([]*sequencerCommand, 0)
+	return seq
+}
+
+func (seq *Sequencer) InProgress() bool {
+	_, err := os.Stat(seq.dir)
+	return err == nil
+}
+
+func (seq *Sequencer) Read() {
+	head, err := ioutil.ReadFile(seq.dir + "/head")
+	if err != nil {
+		fmt.Println("Error: no cherry-pick or revert in progress")
+		os.Exit(1)
+	}
+	seq.Head = strings.TrimSpace(string(head))
+
+	todo, err := ioutil.ReadFile(seq.dir + "/todo")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, line := range strings.Split(string(todo), "\n") {
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 2 {
+			continue
+		}
+		command := &sequencerCommand{Action: fields[0], SHA1: fields[1]}
+		if len(fields) == 3 {
+			command.Subject = fields[2]
+		}
+		seq.Todo = append(seq.Todo, command)
+	}
+
+	opts := make(map[string]string)
+	if err := ReadConfigFile(seq.dir+"/opts", opts); err == nil {
+		seq.RecordOrigin = IsConfigTrue(opts["options.record-origin"])
+	}
+}
+
+func (seq *Sequencer) Write() {
+	if err := os.MkdirAll(seq.dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	todo := new(bytes.Buffer)
+	for _, command := range seq.Todo {
+		todo.WriteString(command.Action + " " + command.SHA1 + " " + command.Subject + "\n")
+	}
+	opts := ""
+	if seq.RecordOrigin {
+		opts = "[options]\n\trecord-origin = true\n"
+	}
+	files := map[string][]byte{
+		"head": []byte(seq.Head + "\n"),
+		"todo": todo.Bytes(),
+		"opts": []byte(opts),
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(seq.dir+"/"+name, content, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// Remove deletes the state of the sequencer, and of the stopped command.
+func (seq *Sequencer) Remove() {
+	os.RemoveAll(seq.dir)
+	seq.removeCommandState()
+}
+
+func (seq *Sequencer) removeCommandState() {
+	for _, name := range []string{"CHERRY_PICK_HEAD", "REVERT_HEAD", "MERGE_MSG"} {
+		os.Remove(GitDir(seq.regit.RootDir) + "/" + name)
+	}
+}
+
+// Run runs the commands left. On a conflict, the state is saved and the
+// process exits.
+func (seq *Sequencer) Run() {
+	for len(seq.Todo) != 0 {
+		if !seq.apply(seq.Todo[0]) {
+			seq.Write()
+			os.Exit(1)
+		}
+		seq.Todo = seq.Todo[1:]
+	}
+	seq.Remove()
+}
+
+// apply merges the changes of a pick or of a revert into HEAD, and commits
+// them if nothing got in the way. It returns false if the command stopped.
+func (seq *Sequencer) apply(command *sequencerCommand) bool {
+	regit := seq.regit
+	commit := NewCommitObject(regit.RootDir)
+	commit.ReadFromExistingObject(command.SHA1)
+	head_sha1 := regit.headCommit()
+	head_tree := regit.commitTree(head_sha1)
+
+	var message, author string
+	if command.Action == "revert" {
+		message = "Revert \"" + command.Subject + "\"\n\nThis reverts commit " + command.SHA1 + "."
+		author = regit.signature("GIT_AUTHOR_DATE").String()
+	} else {
+		message = commit.message
+		if seq.RecordOrigin {
+			message = appendCherryPickedFrom(message, command.SHA1)
+		}
+		author = commit.author
+	}
+	merge := regit.mergeCommitChanges(commit, command.Action == "revert", sequencerCommandName(command.Action))
+
+	if len(merge.Conflicts) != 0 {
+		seq.writeCommandState(command, message+"\n"+conflictsComment(merge))
+		name := sequencerCommandName(command.Action)
+		verb := "apply"
+		if command.Action == "revert" {
+			verb = "revert"
+		}
+		fmt.Println("Error: could not " + verb + " " + abbreviate(command.SHA1) + "... " + command.Subject)
+		fmt.Println("hint: After resolving the conflicts, mark them with")
+		fmt.Println("hint: \"regit-go add <pathspec>\", then run")
+		fmt.Println("hint: \"regit-go " + name + " --continue\".")
+		fmt.Println("hint: You can instead skip this commit with \"regit-go " + name + " --skip\".")
+		fmt.Println("hint: To abort and get back to the state before \"regit-go " + name + "\",")
+		fmt.Println("hint: run \"regit-go " + name + " --abort\".")
+		return false
+	}
+
+	index := NewIndex(regit.RootDir)
+	regit.readIndexOrExit(index)
+	tree_sha1 := hex.EncodeToString(regit.writeTree(index))
+	if tree_sha1 == head_tree {
+		seq.writeCommandState(command, message+"\n")
+		printEmptyCommandError(command.Action)
+		return false
+	}
+	regit.commitSequencerChange(tree_sha1, head_sha1, author, message)
+	return true
+}
+
+// mergeCommitChanges merges the changes a commit made to its first parent,
+// or their reverse, into HEAD, and writes the result to the index and the
+// working tree. action names the command for the errors about local changes.
+func (regit *ReGit) mergeCommitChanges(commit *CommitObject, reverse bool, action string) *treeMerge {
+	parent_tree := EmptyTreeSHA1
+	if parents := commit.historyParents(); len(parents) != 0 {
+		parent_tree = regit.commitTree(parents[0])
+	}
+	head_tree := regit.commitTree(regit.headCommit())
+	label := abbreviate(hex.EncodeToString(commit.Obj.HashedFilename)) + " (" + commitSubject(commit) + ")"
+
+	var merge *treeMerge
+	if reverse {
+		merge = regit.mergeTrees(commit.tree, head_tree, parent_tree, "HEAD", "parent of "+label)
+	} else {
+		merge = regit.mergeTrees(parent_tree, head_tree, commit.tree, "HEAD", label)
+	}
+	head_files := regit.flattenTree(head_tree)
+	regit.checkMergeable(head_files, merge, action)
+	regit.applyMerge(head_files, merge)
+	for _, line := range merge.Messages {
+		fmt.Println(line)
+	}
+	return merge
+}
+
+// the comment listing the conflicted files, added to MERGE_MSG
+func conflictsComment(merge *treeMerge) string {
+	paths := make([]string, 0, len(merge.Conflicts))
+	for path := range merge.Conflicts {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return "\n# Conflicts:\n#\t" + strings.Join(paths, "\n#\t") + "\n"
+}
+
+// writeCommandState records the stopped command for --continue, and for
+// commits made meanwhile: CHERRY_PICK_HEAD or REVERT_HEAD name its commit,
+// and MERGE_MSG holds its message.
+func (seq *Sequencer) writeCommandState(command *sequencerCommand, message string) {
+	head_name := "CHERRY_PICK_HEAD"
+	if command.Action == "revert" {
+		head_name = "REVERT_HEAD"
+	}
+	if err := ioutil.WriteFile(GitDir(seq.regit.RootDir)+"/"+head_name, []byte(command.SHA1+"\n"), 0644); err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(GitDir(seq.regit.RootDir)+"/MERGE_MSG", []byte(message), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func printEmptyCommandError(action string) {
+	name := sequencerCommandName(action)
+	fmt.Println("The previous " + name + " is now empty, possibly due to conflict resolution.")
+	fmt.Println("If you wish to commit it anyway, use:")
+	fmt.Println()
+	fmt.Println("    regit-go commit --allow-empty")
+	fmt.Println()
+	fmt.Println("Otherwise, please use \"regit-go " + name + " --skip\"")
+}
+
+// writeCommit writes a commit made now, and returns its name.
+func (regit *ReGit) writeCommit(tree_sha1 string, parents []string, author string, message string) string {
+	commit := NewCommitObject(regit.RootDir)
+	commit.SetTree(tree_sha1)
+	commit.SetParents(parents)
+	commit.SetAuthor(author)
+	commit.SetCommitter(regit.signature("GIT_COMMITTER_DATE").String())
+	commit.SetMessage(message)
+	commit.GenerateContent()
+	commit.Obj.WriteToFile()
+	return hex.EncodeToString(commit.Obj.HashedFilename)
+}
+
+// commitSequencerChange commits a tree on top of HEAD.
+func (regit *ReGit) commitSequencerChange(tree_sha1 string, head_sha1 string, author string, message string) {
+	commit_sha1 := regit.writeCommit(tree_sha1, []string{head_sha1}, author, message)
+	regit.updateHead(commit_sha1)
+
+	subject, _ := splitCommitMessage(message)
+	fmt.Println("[commit (" + commit_sha1 + ") created] " + subject)
+	regit.runHook("post-commit", regit.commitHookEnv(false))
+}
+
+var trailerPattern = regexp.MustCompile(`^[A-Za-z0-9-]+: |^\(cherry picked from commit [0-9a-f]+\)$`)
+
+// appendCherryPickedFrom adds the line `git cherry-pick -x` adds to a
+// message: in the trailers of the message if its last paragraph is made of
+// them, or in a paragraph of its own.
+func appendCherryPickedFrom(message string, sha1_name string) string {
+	message = strings.TrimRight(message, "\n")
+	line := "(cherry picked from commit " + sha1_name + ")"
+	paragraph_start_index := strings.LastIndex(message, "\n\n")
+	if paragraph_start_index == -1 {
+		return message + "\n\n" + line
+	}
+	for _, trailer := range strings.Split(message[paragraph_start_index+2:], "\n") {
+		if !trailerPattern.MatchString(trailer) {
+			return message + "\n\n" + line
+		}
+	}
+	return message + "\n" + line
+}
+
+// startSequencer runs the commands for the given commits.
+func (regit *ReGit) startSequencer(action string, revisions []string, record_origin bool) {
+	seq := NewSequencer(regit)
+	if seq.InProgress() {
+		fmt.Println("Error: a cherry-pick or revert is already in progress")
+		fmt.Println("hint: try \"regit-go " + sequencerCommandName(action) + " (--continue | --skip | --abort)\"")
+		os.Exit(1)
+	}
+	seq.Head = regit.headCommit()
+	if seq.Head == "" {
+		fmt.Println("Error: your current branch does not have any commits yet")
+		os.Exit(1)
+	}
+	seq.RecordOrigin = record_origin
+	for _, revision := range revisions {
+		sha1_name, err := PeelObject(regit.RootDir, regit.resolveRevisionOrExit(revision), "commit")
+		if err != nil {
+			fmt.Println("Error: " + err.Error())
+			os.Exit(1)
+		}
+		commit := NewCommitObject(regit.RootDir)
+		commit.ReadFromExistingObject(sha1_name)
+		// which parent's changes to take from a merge is not known
+		if len(commit.parents) > 1 {
+			fmt.Println("Error: commit " + sha1_name + " is a merge, which can not be " + sequencerCommandName(action) + "ed")
+			os.Exit(1)
+		}
+		seq.Todo = append(seq.Todo, &sequencerCommand{action, sha1_name, commitSubject(commit)})
+	}
+	seq.Run()
+}
+
+// CherryPick applies the changes of commits on top of HEAD, one commit after
+// the other, keeping their authors and messages.
+func (regit *ReGit) CherryPick(revisions []string, record_origin bool) {
+	regit.startSequencer("pick", revisions, record_origin)
+}
+
+// Revert commits the reverse of the changes of commits, one after the
+// other.
+func (regit *ReGit) Revert(revisions []string) {
+	regit.startSequencer("revert", revisions, false)
+}
+
+// SequencerContinue commits the resolved changes of the stopped command,
+// then runs the commands left.
+func (regit *ReGit) SequencerContinue() {
+	seq := NewSequencer(regit)
+	seq.Read()
+	index := NewIndex(regit.RootDir)
+	regit.readIndexOrExit(index)
+	if index.HasUnmergedEntries() {
+		fmt.Println("Error: committing is not possible because you have unmerged files.")
+		fmt.Println("hint: Fix them up in the work tree, and then use \"regit-go add <file>\"")
+		fmt.Println("hint: as appropriate to mark resolution, then run \"regit-go " +
+			sequencerCommandName(seq.Todo[0].Action) + " --continue\".")
+		os.Exit(1)
+	}
+	if author, message, ok := regit.pendingSequencerCommit(); ok {
+		if !regit.commitPending(author, message) {
+			printEmptyCommandError(seq.Todo[0].Action)
+			os.Exit(1)
+		}
+		seq.removeCommandState()
+	}
+	seq.Todo = seq.Todo[1:]
+	seq.Run()
+}
+
+// SequencerSkip drops the changes of the stopped command, then runs the
+// commands left.
+func (regit *ReGit) SequencerSkip() {
+	seq := NewSequencer(regit)
+	seq.Read()
+	regit.resetToTree(regit.commitTree(regit.headCommit()))
+	seq.removeCommandState()
+	seq.Todo = seq.Todo[1:]
+	seq.Run()
+}
+
+// SequencerAbort goes back to the commit HEAD pointed to before the first
+// command, and forgets about the commands left.
+func (regit *ReGit) SequencerAbort() {
+	seq := NewSequencer(regit)
+	seq.Read()
+	regit.resetToTree(regit.commitTree(seq.Head))
+	regit.updateHead(seq.Head)
+	seq.Remove()
+}
+
+// pendingSequencerCommit returns the author and the message of the commit of
+// a stopped pick or revert, if there is one.
+func (regit *ReGit) pendingSequencerCommit() (string, string, bool) {
+	message, err := ioutil.ReadFile(GitDir(regit.RootDir) + "/MERGE_MSG")
+	if err != nil {
+		return "", "", false
+	}
+	content, err := ioutil.ReadFile(GitDir(regit.RootDir) + "/CHERRY_PICK_HEAD")
+	if err != nil {
+		if _, err := os.Stat(GitDir(regit.RootDir) + "/REVERT_HEAD"); err != nil {
+			return "", "", false
+		}
+		return regit.signature("GIT_AUTHOR_DATE").String(), string(message), true
+	}
+	commit := NewCommitObject(regit.RootDir)
+	commit.ReadFromExistingObject(strings.TrimSpace(string(content)))
+	return commit.author, string(message), true
+}
+
+// commitPending commits the index with the author and message of a stopped
+// pick or revert, letting the user edit the message. It returns false if the
+// commit would be empty.
+func (regit *ReGit) commitPending(author string, message string) bool {
+	regit.checkIdentity()
+	if err := regit.runHook("pre-commit", regit.commitHookEnv(true)); err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+	index := NewIndex(regit.RootDir)
+	regit.readIndexOrExit(index)
+	head_sha1 := regit.headCommit()
+	tree_sha1 := hex.EncodeToString(regit.writeTree(index))
+	if tree_sha1 == regit.commitTree(head_sha1) {
+		return false
+	}
+	message = regit.prepareCommitMessage(NewCommitOptions(), message, "merge", "")
+	if message == "" {
+		fmt.Println("Aborting commit due to empty commit message.")
+		os.Exit(1)
+	}
+	regit.commitSequencerChange(tree_sha1, head_sha1, author, message)
+	return true
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAppendCherryPickedFrom(t *testing.T) {
+	sha1_name := strings.Repeat("1", 40)
+	line := "(cherry picked from commit " + sha1_name + ")"
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"subject\n", "subject\n\n" + line},
+		{"subject\n\nbody\n", "subject\n\nbody\n\n" + line},
+		{"subject\n\nSigned-off-by: A U Thor <author@example.com>\n", "subject\n\nSigned-off-by: A U Thor <author@example.com>\n" + line},
+	}
+	for _, test := range tests {
+		if message := appendCherryPickedFrom(test.message, sha1_name); message != test.want {
+			t.Errorf("%q became %q, want %q", test.message, message, test.want)
+		}
+	}
+}
+
+func TestSequencerState(t *testing.T) {
+	isolateHome(t)
+	regit := newTestRepository(t, t.TempDir())
+	seq := NewSequencer(regit)
+	seq.Head = strings.Repeat("1", 40)
+	seq.RecordOrigin = true
+	seq.Todo = append(seq.Todo, &sequencerCommand{"pick", strings.Repeat("2", 40), "a subject"}, &sequencerCommand{"revert", strings.Repeat("3", 40), "another one"})
+	seq.Write()
+	if !seq.InProgress() {
+		t.Fatal("the sequencer is not in progress after Write")
+	}
+
+	read_seq := NewSequencer(regit)
+	read_seq.Read()
+	if read_seq.Head != seq.Head || !read_seq.RecordOrigin || !reflect.DeepEqual(read_seq.Todo, seq.Todo) {
+		t.Errorf("read %+v, want %+v", read_seq, seq)
+	}
+	seq.Remove()
+	if seq.InProgress() {
+		t.Errorf("the sequencer is still in progress after Remove")
+	}
+}
+
+func TestCherryPickAndRevert(t *testing.T) {
+	isolateHome(t)
+	rootDir := t.TempDir()
+	chdir(t, rootDir)
+	regit := newTestRepository(t, rootDir)
+	first := commitFile(t, rootDir, "a.txt", "a\n")
+	second := commitFile(t, rootDir, "b.txt", "b\n")
+
+	regit.updateHead(first)
+	regit.resetToTree(regit.commitTree(first))
+	captureOutput(t, func() {
+		regit.CherryPick([]string{second}, true)
+	})
+	picked := newTestCommit(t, rootDir, testRef(t, rootDir, "HEAD"))
+	if !reflect.DeepEqual(picked.parents, []string{first}) || picked.tree != regit.commitTree(second) {
+		t.Errorf("the pick has the parents %q and the tree %s", picked.parents, picked.tree)
+	}
+	if picked.message != "change b.txt\n\n(cherry picked from commit "+second+")" {
+		t.Errorf("the pick has the message %q", picked.message)
+	}
+
+	captureOutput(t, func() {
+		regit.Revert([]string{"HEAD"})
+	})
+	reverted := newTestCommit(t, rootDir, testRef(t, rootDir, "HEAD"))
+	if reverted.tree != regit.commitTree(first) {
+		t.Errorf("the revert has the tree %s, want the one of %s", reverted.tree, first)
+	}
+	if !strings.HasPrefix(reverted.message, "Revert \"change b.txt\"") {
+		t.Errorf("the revert has the message %q", reverted.message)
+	}
+	if NewSequencer(regit).InProgress() {
+		t.Errorf("the sequencer is still in progress")
+	}
+}
+
+// TestCherryPickConflict checks that a conflicting pick stops with the
+// conflict recorded in the index, the working tree and the sequencer state.
+func TestCherryPickConflict(t *testing.T) {
+	isolateHome(t)
+	rootDir := t.TempDir()
+	chdir(t, rootDir)
+	regit := newTestRepository(t, rootDir)
+	first := commitFile(t, rootDir, "a.txt", "a\n")
+	theirs := commitFile(t, rootDir, "a.txt", "theirs\n")
+	regit.updateHead(first)
+	regit.resetToTree(regit.commitTree(first))
+	commitFile(t, rootDir, "a.txt", "ours\n")
+
+	seq := NewSequencer(regit)
+	command := &sequencerCommand{"pick", theirs, "change a.txt"}
+	var applied bool
+	captureOutput(t, func() {
+		applied = seq.apply(command)
+	})
+	if applied {
+		t.Fatal("the conflicting pick was committed")
+	}
+	index := NewIndex(rootDir)
+	if err := index.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if !index.HasUnmergedEntries() {
+		t.Errorf("the index has no conflict")
+	}
+	content, _ := ioutil.ReadFile(rootDir + "/a.txt")
+	if want := "<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> " + abbreviate(theirs) + " (change a.txt)\n"; string(content) != want {
+		t.Errorf("a.txt is %q, want %q", content, want)
+	}
+	if cherry_pick_head, _ := ioutil.ReadFile(rootDir + "/.git/CHERRY_PICK_HEAD"); strings.TrimSpace(string(cherry_pick_head)) != theirs {
+		t.Errorf("CHERRY_PICK_HEAD is %q", cherry_pick_head)
+	}
+	if message, _ := ioutil.ReadFile(rootDir + "/.git/MERGE_MSG"); !strings.Contains(string(message), "# Conflicts:\n#\ta.txt\n") {
+		t.Errorf("MERGE_MSG is %q", message)
+	}
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"fmt"
+	"os"
+)
+
+// ServePack runs git-upload-pack or git-receive-pack, as service names it,
+// for the repository at directory on the standard input and output, which
+// carry the protocol; errors go to the standard error.
+func (regit *ReGit) ServePack(service string, directory string, stateless_rpc bool, advertise_refs bool) {
+	rootDir, err := regit.localRepository(directory)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal: '"+directory+"' does not appear to be a git repository")
+		os.Exit(128)
+	}
+	serve := UploadPack
+	if service == "receive-pack" {
+		serve = ReceivePack
+	}
+	if err := serve(rootDir, os.Stdin, os.Stdout, stateless_rpc, advertise_refs); err != nil {
+		fmt.Fprintln(os.Stderr, "fatal: "+err.Error())
+		os.Exit(128)
+	}
+}
+
+// Serve serves the repositories under directory with the smart HTTP
+// protocol at address, e.g. ":8080".
+func (regit *ReGit) Serve(address string, directory string) {
+	if info, err := os.Stat(directory); err != nil || !info.IsDir() {
+		fmt.Println("Error: " + directory + " is not a directory")
+		os.Exit(1)
+	}
+	if err := ServeHTTP(address, directory); err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+}
+
+// Daemon serves the exported repositories under directory with the git://
+// protocol at address, e.g. ":9418".
+func (regit *ReGit) Daemon(address string, directory string, export_all bool) {
+	if info, err := os.Stat(directory); err != nil || !info.IsDir() {
+		fmt.Println("Error: " + directory + " is not a directory")
+		os.Exit(1)
+	}
+	if err := ServeDaemon(address, directory, export_all); err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+}
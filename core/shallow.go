@@ -0,0 +1,190 @@
+package core
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A shallow repository has only part of the history: .git/shallow lists
+// the commits whose parents it does not have, one per line. The commits
+// keep their parents, but walking the history stops at them.
+
+// InfiniteDepth is the depth git asks for to fetch the whole history.
+const InfiniteDepth = 0x7fffffff
+
+// Deepen tells a fetch how much history to fetch.
+type Deepen struct {
+	// the number of commits to fetch from each tip, or with Relative, from
+	// the current shallow commits
+	Depth    int
+	Relative bool
+	// or the commits made since
+	Since time.Time
+}
+
+// the shallow commits of the repositories read so far, by path of
+// .git/shallow, which are read again when the file changes
+var (
+	shallowCache      = make(map[string]*shallowFile)
+	shallowCacheMutex sync.Mutex
+)
+
+type shallowFile struct {
+	modTime time.Time
+	size    int64
+	commits map[string]bool
+}
+
+// ReadShallow returns the shallow commits of the repository at rootDir,
+// which are none unless it is shallow.
+func ReadShallow(rootDir string) map[string]bool {
+	path := GitDir(rootDir) + "/shallow"
+	info, err := os.Stat(path)
+	if err != nil {
+		return map[string]bool{}
+	}
+	shallowCacheMutex.Lock()
+	defer shallowCacheMutex.Unlock()
+	if cached, ok := shallowCache[path]; ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.commits
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return map[string]bool{}
+	}
+	commits := make(map[string]bool)
+	for _, line := range strings.Split(string(content), "\n") {
+		if isValidSHA1Name(line) {
+			commits[line] = true
+		}
+	}
+	shallowCache[path] = &shallowFile{info.ModTime(), info.Size(), commits}
+	return commits
+}
+
+// IsShallowRepository tells whether a repository has only part of the
+// history.
+func IsShallowRepository(rootDir string) bool {
+	return len(ReadShallow(rootDir)) != 0
+}
+
+// historyParents returns the parents a walk of the history goes on to, which
+// are none for a shallow commit of the repository.
+func (commit *CommitObject) historyParents() []string {
+	if len(commit.parents) != 0 && ReadShallow(commit.Obj.rootDir)[hex.EncodeToString(commit.Obj.HashedFilename)] {
+		return nil
+	}
+	return commit.parents
+}
+
+// UpdateShallow adds commits to .git/shallow and removes others from it,
+// deleting the file once no commit is shallow.
+func UpdateShallow(rootDir string, shallow []string, unshallow []string) error {
+	commits := make(map[string]bool)
+	for sha1_name := range ReadShallow(rootDir) {
+		commits[sha1_name] = true
+	}
+	for _, sha1_name := range shallow {
+		commits[sha1_name] = true
+	}
+	for _, sha1_name := range unshallow {
+		delete(commits, sha1_name)
+	}
+
+	path := GitDir(rootDir) + "/shallow"
+	shallowCacheMutex.Lock()
+	delete(shallowCache, path)
+	shallowCacheMutex.Unlock()
+	if len(commits) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	lines := make([]string, 0, len(commits))
+	for sha1_name := range commits {
+		lines = append(lines, sha1_name)
+	}
+	sort.Strings(lines)
+	return ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// shallowCommits walks the history from roots as far as deepen says, as the
+// server of a shallow fetch does. It returns the commits which the client is
+// to take as shallow, which have parents that are not sent, and those of
+// its shallow commits which no longer are, whose parents are to be sent. It
+// also returns the parents which are not sent.
+func shallowCommits(rootDir string, roots []string, deepen *Deepen, client_shallow map[string]bool) ([]string, []string, map[string]bool, error) {
+	levels := make(map[string]int)
+	queue := make([]string, 0)
+	for _, root := range roots {
+		commit_sha1, err := PeelObject(rootDir, root, "commit")
+		if err != nil {
+			// what tags of trees and blobs point to has no history
+			continue
+		}
+		if _, ok := levels[commit_sha1]; !ok {
+			levels[commit_sha1] = 1
+			queue = append(queue, commit_sha1)
+		}
+	}
+
+	shallow := make([]string, 0)
+	unshallow := make([]string, 0)
+	boundary := make(map[string]bool)
+	for len(queue) != 0 {
+		sha1_name := queue[0]
+		queue = queue[1:]
+		commit := NewCommitObject(rootDir)
+		if err := commit.Load(sha1_name); err != nil {
+			return nil, nil, nil, err
+		}
+		parents := commit.historyParents()
+		if len(parents) == 0 {
+			continue
+		}
+
+		is_boundary := false
+		if deepen.Since.IsZero() {
+			is_boundary = levels[sha1_name] >= deepen.Depth
+		} else {
+			for _, parent_sha1 := range parents {
+				parent := NewCommitObject(rootDir)
+				if err := parent.Load(parent_sha1); err != nil {
+					return nil, nil, nil, err
+				}
+				if commitDate(parent).Before(deepen.Since) {
+					is_boundary = true
+				}
+			}
+		}
+		if is_boundary {
+			if !client_shallow[sha1_name] {
+				shallow = append(shallow, sha1_name)
+			}
+			for _, parent_sha1 := range parents {
+				boundary[parent_sha1] = true
+			}
+			continue
+		}
+		if client_shallow[sha1_name] {
+			unshallow = append(unshallow, sha1_name)
+		}
+		for _, parent_sha1 := range parents {
+			if _, ok := levels[parent_sha1]; !ok {
+				levels[parent_sha1] = levels[sha1_name] + 1
+				queue = append(queue, parent_sha1)
+			}
+		}
+	}
+	// a parent of a shallow commit may be sent as the parent of another
+	for sha1_name := range levels {
+		delete(boundary, sha1_name)
+	}
+	return shallow, unshallow, boundary, nil
+}
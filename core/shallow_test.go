@@ -0,0 +1,139 @@
+package core
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdateShallow(t *testing.T) {
+	rootDir := newTestObjectStore(t)
+	first := strings.Repeat("1", 40)
+	second := strings.Repeat("2", 40)
+	if IsShallowRepository(rootDir) {
+		t.Fatal("a new repository is shallow")
+	}
+	if err := UpdateShallow(rootDir, []string{second, first}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if shallow := ReadShallow(rootDir); !reflect.DeepEqual(shallow, map[string]bool{first: true, second: true}) {
+		t.Errorf("the shallow commits are %v", shallow)
+	}
+	if err := UpdateShallow(rootDir, nil, []string{first, second}); err != nil {
+		t.Fatal(err)
+	}
+	if IsShallowRepository(rootDir) {
+		t.Errorf("the repository is still shallow with %v", ReadShallow(rootDir))
+	}
+}
+
+func TestParseDeepenLine(t *testing.T) {
+	deepen := new(Deepen)
+	for _, line := range []string{"deepen 3", "deepen-relative", "deepen-since 1500000000"} {
+		if err := parseDeepenLine(line, deepen); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+	}
+	if deepen.Depth != 3 || !deepen.Relative || !deepen.Since.Equal(time.Unix(1500000000, 0)) {
+		t.Errorf("the lines gave %+v", deepen)
+	}
+	for _, line := range []string{"deepen 0", "deepen x", "deepen-since", "deepen-relative 1"} {
+		if err := parseDeepenLine(line, new(Deepen)); err == nil {
+			t.Errorf("%q was taken", line)
+		}
+	}
+}
+
+// newTestShallowClone clones a repository of three commits with only the
+// last of them.
+func newTestShallowClone(t *testing.T) (string, string, []string) {
+	isolateHome(t)
+	source := t.TempDir()
+	chdir(t, source)
+	newTestRepository(t, source)
+	commits := []string{
+		commitFile(t, source, "a.txt", "a\n"),
+		commitFile(t, source, "b.txt", "b\n"),
+		commitFile(t, source, "c.txt", "c\n"),
+	}
+	parent := t.TempDir()
+	captureOutput(t, func() {
+		NewReGit(parent).Clone("file://"+source, "clone", false, "", &Deepen{Depth: 1}, nil)
+	})
+	return source, parent + "/clone", commits
+}
+
+func TestShallowClone(t *testing.T) {
+	_, clone, commits := newTestShallowClone(t)
+	if shallow := ReadShallow(clone); !reflect.DeepEqual(shallow, map[string]bool{commits[2]: true}) {
+		t.Fatalf("the shallow commits are %v", shallow)
+	}
+	if ObjectExists(clone, commits[1]) {
+		t.Error("the parent of the shallow commit was fetched")
+	}
+
+	// the commit keeps its parent, but the history ends with it
+	commit := NewCommitObject(clone)
+	if err := commit.Load(commits[2]); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(commit.parents, []string{commits[1]}) || len(commit.historyParents()) != 0 {
+		t.Errorf("the parents are %v, and %v in the history", commit.parents, commit.historyParents())
+	}
+	cg := NewCommitGraph(commit, clone)
+	if loaded := cg.LoadAllCommits(); len(loaded) != 1 {
+		t.Errorf("the history has %d commits", len(loaded))
+	}
+	if report := NewFsck(clone).Run(false); report.HasErrors() {
+		t.Errorf("fsck found %v", report.Problems)
+	}
+}
+
+// TestShallowAmend checks that amending a shallow commit keeps its parent,
+// which the repository does not have.
+func TestShallowAmend(t *testing.T) {
+	_, clone, commits := newTestShallowClone(t)
+	chdir(t, clone)
+	regit := NewReGit(clone)
+	regit.Config["user.name"] = "A U Thor"
+	regit.Config["user.email"] = "author@example.com"
+	captureOutput(t, func() {
+		regit.Commmit(&CommitOptions{Message: "amended\n", HasMessage: true, Amend: true})
+	})
+	amended := NewCommitObject(clone)
+	if err := amended.Load(testRef(t, clone, "HEAD")); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(amended.parents, []string{commits[1]}) {
+		t.Errorf("the amended commit has the parents %v, want %v", amended.parents, commits[1:2])
+	}
+}
+
+func TestUploadPackShallow(t *testing.T) {
+	source, clone, commits := newTestShallowClone(t)
+
+	// the clone asks for one more commit of history
+	request := PktLine("want "+commits[2]+" deepen-relative\n") + PktLine("shallow "+commits[2]+"\n") + PktLine("deepen 1\n") + pktFlush + PktLine("have "+commits[2]+"\n") + PktLine("done\n")
+	out := new(bytes.Buffer)
+	if err := UploadPack(source, strings.NewReader(request), out, true, false); err != nil {
+		t.Fatal(err)
+	}
+	pkt_reader := NewPktReader(out)
+	lines, err := pkt_reader.ReadLines()
+	if want := []string{"shallow " + commits[1], "unshallow " + commits[2]}; err != nil || !reflect.DeepEqual(lines, want) {
+		t.Fatalf("the shallow-info is %q, %v, want %q", lines, err, want)
+	}
+	if line, err := pkt_reader.ReadLine(); err != nil || line != "ACK "+commits[2] {
+		t.Fatalf("the server answered %q, %v", line, err)
+	}
+	count, err := UnpackObjects(clone, pkt_reader.reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the second commit and its tree, whose blobs the clone has
+	if count != 2 || !ObjectExists(clone, commits[1]) || ObjectExists(clone, commits[0]) {
+		t.Errorf("the pack of %d objects does not hold the commit deepened to", count)
+	}
+}
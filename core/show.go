@@ -0,0 +1,114 @@
+package core
+
+import (
+	"io"
+	"strings"
+)
+
+// Show prints objects for people to read: a commit with its patch against
+// its first parent, or its combined patch if it is a merge; a tree as the
+// list of its entries; a blob as its content; and an annotated tag as its
+// header followed by the object it points to. Without revisions, HEAD is
+// shown.
+func (regit *ReGit) Show(revisions []string, options *LogOptions) {
+	if len(revisions) == 0 {
+		revisions = []string{"HEAD"}
+	}
+	sha1_names := make([]string, len(revisions))
+	for i, revision := range revisions {
+		sha1_names[i] = regit.resolveRevisionOrExit(revision)
+	}
+
+	options.Color = regit.UseColor()
+	colors := NewDiffColors(options.Color)
+	pager := regit.startPager()
+	defer pager.Close()
+	for i, revision := range revisions {
+		io.WriteString(pager, regit.showObject(revision, sha1_names[i], options, colors))
+	}
+}
+
+func (regit *ReGit) showObject(name string, sha1_name string, options *LogOptions, colors *DiffColors) string {
+	builder := new(strings.Builder)
+	obj := regit.readObjectOrExit(sha1_name)
+	switch obj.typ {
+	case "commit":
+		commit := NewCommitObject(regit.RootDir)
+		commit.ReadFromExistingObject(sha1_name)
+		builder.WriteString(formatCommitLog(commit, options))
+		patch := regit.commitPatch(commit, colors)
+		// like git, a blank line separates formatted commits from their patch
+		if patch != "" && options.Format != "" && !options.OneLine {
+			builder.WriteString("\n")
+		}
+		builder.WriteString(patch)
+	case "tree":
+		tree := NewTreeObject(regit.RootDir)
+		tree.ReadFromExistingObject(sha1_name)
+		builder.WriteString(colors.Meta + "tree " + name + colors.Reset + "\n\n")
+		for _, entry := range tree.Entries {
+			if entry.Type() == "tree" {
+				builder.WriteString(entry.FileName + "/\n")
+			} else {
+				builder.WriteString(entry.FileName + "\n")
+			}
+		}
+	case "tag":
+		tag := NewTagObject(regit.RootDir)
+		tag.ReadFromExistingObject(sha1_name)
+		builder.WriteString(options.color("yellow") + "tag " + tag.name + options.color("reset") + "\n")
+		if tag.tagger != "" {
+			tagger := ParseSignature(tag.tagger)
+			builder.WriteString("Tagger: " + tagger.Identity() + "\n")
+			builder.WriteString("Date:   " + tagger.FormatDate(options.DateMode) + "\n")
+		}
+		builder.WriteString("\n" + tag.message + "\n\n")
+		builder.WriteString(regit.showObject(tag.object, tag.object, options, colors))
+	default:
+		builder.Write(obj.content)
+	}
+	return builder.String()
+}
+
+// commitPatch returns the patch of a commit against its parent, against the
+// empty tree for a root commit, or the combined patch of a merge.
+func (regit *ReGit) commitPatch(commit *CommitObject, colors *DiffColors) string {
+	builder := new(strings.Builder)
+	parents := commit.historyParents()
+	if len(parents) <= 1 {
+		parent_tree := ""
+		if len(parents) == 1 {
+			parent_tree = regit.commitTree(parents[0])
+		}
+		for _, change := range regit.DiffTrees(parent_tree, commit.tree) {
+			regit.WritePatch(builder, change, colors)
+		}
+		return builder.String()
+	}
+
+	// only the files which differ from every parent are shown
+	changes_by_path := make(map[string][]*TreeChange)
+	paths := make([]string, 0)
+	for i, parent := range parents {
+		for _, change := range regit.DiffTrees(regit.commitTree(parent), commit.tree) {
+			if i == 0 {
+				paths = append(paths, change.Path)
+			}
+			if len(changes_by_path[change.Path]) == i {
+				changes_by_path[change.Path] = append(changes_by_path[change.Path], change)
+			}
+		}
+	}
+	for _, path := range paths {
+		if len(changes_by_path[path]) == len(parents) {
+			regit.WriteCombinedPatch(builder, changes_by_path[path], colors)
+		}
+	}
+	return builder.String()
+}
+
+func (regit *ReGit) commitTree(commit_sha1 string) string {
+	commit := NewCommitObject(regit.RootDir)
+	commit.ReadFromExistingObject(commit_sha1)
+	return commit.tree
+}
@@ -0,0 +1,231 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signature is the identity recorded in the author, committer and tagger
+// lines of objects: "name <email> timestamp timezone".
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time // in the timezone recorded in the signature
+}
+
+func NewSignature(name string, email string, when time.Time) *Signature {
+	return &Signature{name, email, when}
+}
+
+// ParseSignature splits a signature line. Like git, it is lenient: the date
+// is left zero if it is missing or malformed.
+func ParseSignature(line string) *Signature {
+	sig := new(Signature)
+	email_start_index := strings.Index(line, "<")
+	email_end_index := strings.LastIndex(line, ">")
+	if email_start_index == -1 || email_end_index < email_start_index {
+		sig.Name = line
+		return sig
+	}
+	sig.Name = strings.TrimSpace(line[:email_start_index])
+	sig.Email = line[email_start_index+1 : email_end_index]
+
+	fields := strings.Fields(line[email_end_index+1:])
+	if len(fields) == 0 {
+		return sig
+	}
+	timestamp, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return sig
+	}
+	sig.When = time.Unix(timestamp, 0).UTC()
+	if len(fields) > 1 {
+		if zone, ok := parseTimezone(fields[1]); ok {
+			sig.When = sig.When.In(zone)
+		}
+	}
+	return sig
+}
+
+// parses a timezone offset such as "+0800" or "-07:00"
+func parseTimezone(value string) (*time.Location, bool) {
+	value = strings.Replace(value, ":", "", 1)
+	if len(value) != 5 || (value[0] != '+' && value[0] != '-') {
+		return nil, false
+	}
+	hours, err := strconv.Atoi(value[1:3])
+	if err != nil {
+		return nil, false
+	}
+	minutes, err := strconv.Atoi(value[3:5])
+	if err != nil {
+		return nil, false
+	}
+	offset := (hours*60 + minutes) * 60
+	if value[0] == '-' {
+		offset = -offset
+	}
+	return time.FixedZone("", offset), true
+}
+
+// String returns the signature as it is recorded in objects.
+func (sig *Signature) String() string {
+	return sig.Name + " <" + sig.Email + "> " + strconv.FormatInt(sig.When.Unix(), 10) + " " + sig.When.Format("-0700")
+}
+
+// Identity returns "name <email>".
+func (sig *Signature) Identity() string {
+	return sig.Name + " <" + sig.Email + ">"
+}
+
+// FormatDate formats the date of the signature in one of the modes of
+// `log --date`: "default", "relative", "local", "iso", "iso-strict", "rfc",
+// "short", "raw", "unix" or "format:<strftime format>".
+func (sig *Signature) FormatDate(mode string) string {
+	return FormatDate(sig.When, mode, time.Now())
+}
+
+// ValidateDateMode returns an error if mode is not a mode of `log --date`.
+func ValidateDateMode(mode string) error {
+	switch mode {
+	case "", "default", "relative", "local", "iso", "iso8601", "iso-strict", "iso8601-strict", "rfc", "rfc2822", "short", "raw", "unix":
+		return nil
+	}
+	if strings.HasPrefix(mode, "format:") {
+		return nil
+	}
+	return errors.New("unknown date format " + mode)
+}
+
+func FormatDate(date time.Time, mode string, now time.Time) string {
+	switch mode {
+	case "relative":
+		return formatRelativeDate(date, now)
+	case "local":
+		return date.Local().Format("Mon Jan 2 15:04:05 2006")
+	case "iso", "iso8601":
+		return date.Format("2006-01-02 15:04:05 -0700")
+	case "iso-strict", "iso8601-strict":
+		return date.Format("2006-01-02T15:04:05-07:00")
+	case "rfc", "rfc2822":
+		return date.Format("Mon, 2 Jan 2006 15:04:05 -0700")
+	case "short":
+		return date.Format("2006-01-02")
+	case "raw":
+		return strconv.FormatInt(date.Unix(), 10) + " " + date.Format("-0700")
+	case "unix":
+		return strconv.FormatInt(date.Unix(), 10)
+	}
+	if strings.HasPrefix(mode, "format:") {
+		return strftime(mode[len("format:"):], date)
+	}
+	return date.Format("Mon Jan 2 15:04:05 2006 -0700")
+}
+
+func plural(count int64, unit string) string {
+	if count == 1 {
+		return "1 " + unit
+	}
+	return strconv.FormatInt(count, 10) + " " + unit + "s"
+}
+
+// formatRelativeDate rounds the way git does, e.g. "3 hours ago" or
+// "2 years, 5 months ago".
+func formatRelativeDate(date time.Time, now time.Time) string {
+	diff := int64(now.Sub(date) / time.Second)
+	if diff < 0 {
+		return "in the future"
+	}
+	if diff < 90 {
+		return plural(diff, "second") + " ago"
+	}
+	diff = (diff + 30) / 60
+	if diff < 90 {
+		return plural(diff, "minute") + " ago"
+	}
+	diff = (diff + 30) / 60
+	if diff < 36 {
+		return plural(diff, "hour") + " ago"
+	}
+	diff = (diff + 12) / 24
+	if diff < 14 {
+		return plural(diff, "day") + " ago"
+	}
+	if diff < 70 {
+		return plural((diff+3)/7, "week") + " ago"
+	}
+	if diff < 365 {
+		return plural((diff+15)/30, "month") + " ago"
+	}
+	if diff < 1825 {
+		total_months := (diff*12*2 + 365) / (365 * 2)
+		years := total_months / 12
+		months := total_months % 12
+		if months != 0 {
+			return plural(years, "year") + ", " + plural(months, "month") + " ago"
+		}
+		return plural(years, "year") + " ago"
+	}
+	return plural((diff+183)/365, "year") + " ago"
+}
+
+var strftimeLayouts = map[byte]string{
+	'a': "Mon",
+	'A': "Monday",
+	'b': "Jan",
+	'h': "Jan",
+	'B': "January",
+	'd': "02",
+	'e': "_2",
+	'H': "15",
+	'I': "03",
+	'm': "01",
+	'M': "04",
+	'p': "PM",
+	'S': "05",
+	'y': "06",
+	'Y': "2006",
+	'z': "-0700",
+	'F': "2006-01-02",
+	'T': "15:04:05",
+	'R': "15:04",
+	'D': "01/02/06",
+	'c': "Mon Jan _2 15:04:05 2006",
+}
+
+// strftime expands the conversions of strftime(3) commonly used with
+// `--date=format:`. Unknown conversions are printed as they are.
+func strftime(format string, date time.Time) string {
+	builder := new(strings.Builder)
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			builder.WriteByte(format[i])
+			continue
+		}
+		i++
+		if layout, ok := strftimeLayouts[format[i]]; ok {
+			builder.WriteString(date.Format(layout))
+			continue
+		}
+		switch format[i] {
+		case 'j':
+			builder.WriteString(fmt.Sprintf("%03d", date.YearDay()))
+		case 's':
+			builder.WriteString(strconv.FormatInt(date.Unix(), 10))
+		case 'Z':
+			name, _ := date.Zone()
+			builder.WriteString(name)
+		case 'n':
+			builder.WriteByte('\n')
+		case '%':
+			builder.WriteByte('%')
+		default:
+			builder.WriteByte('%')
+			builder.WriteByte(format[i])
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,49 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSignature(t *testing.T) {
+	sig := ParseSignature("A U Thor <author@example.com> 1700000000 -0130")
+	if sig.Name != "A U Thor" || sig.Email != "author@example.com" || sig.When.Unix() != 1700000000 {
+		t.Errorf("parsed %+v", sig)
+	}
+	if sig.String() != "A U Thor <author@example.com> 1700000000 -0130" {
+		t.Errorf("String() = %q", sig.String())
+	}
+	if sig := ParseSignature("A U Thor <author@example.com> broken"); !sig.When.IsZero() || sig.Identity() != "A U Thor <author@example.com>" {
+		t.Errorf("parsed a malformed date into %+v", sig)
+	}
+}
+
+func TestFormatDate(t *testing.T) {
+	date := time.Unix(1700000000, 0).In(time.FixedZone("", 2*60*60))
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{"default", "Wed Nov 15 00:13:20 2023 +0200"},
+		{"iso", "2023-11-15 00:13:20 +0200"},
+		{"iso-strict", "2023-11-15T00:13:20+02:00"},
+		{"rfc", "Wed, 15 Nov 2023 00:13:20 +0200"},
+		{"short", "2023-11-15"},
+		{"raw", "1700000000 +0200"},
+		{"unix", "1700000000"},
+		{"format:%Y/%m/%d %H:%M %%", "2023/11/15 00:13 %"},
+		{"relative", "3 hours ago"},
+	}
+	now := date.Add(3 * time.Hour)
+	for _, test := range tests {
+		if formatted := FormatDate(date, test.mode, now); formatted != test.want {
+			t.Errorf("--date=%s gives %q, want %q", test.mode, formatted, test.want)
+		}
+	}
+	if formatted := FormatDate(date, "relative", date.AddDate(2, 5, 0)); formatted != "2 years, 5 months ago" {
+		t.Errorf("a relative date of 2 years and 5 months is %q", formatted)
+	}
+	if err := ValidateDateMode("fancy"); err == nil {
+		t.Errorf("the date mode fancy was accepted")
+	}
+}
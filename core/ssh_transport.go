@@ -0,0 +1,114 @@
+package core
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// parseSSHURL splits an ssh:// URL, e.g. "ssh://git@host:2222/org/repo.git",
+// or an scp-style one, e.g. "git@host:org/repo.git", into the user and host
+// to connect to, the port, if any, and the path of the repository, which is
+// relative to the home directory of the user when scp-style.
+func parseSSHURL(repository_url string) (host string, port string, path string, ok bool) {
+	for _, scheme := range []string{"ssh://", "git+ssh://", "ssh+git://"} {
+		if !strings.HasPrefix(repository_url, scheme) {
+			continue
+		}
+		parsed, err := url.Parse("ssh://" + repository_url[len(scheme):])
+		if err != nil || parsed.Hostname() == "" {
+			return "", "", "", false
+		}
+		host = parsed.Hostname()
+		if parsed.User != nil {
+			host = parsed.User.Username() + "@" + host
+		}
+		return host, parsed.Port(), parsed.Path, true
+	}
+	if strings.Contains(repository_url, "://") {
+		return "", "", "", false
+	}
+	// a colon before any slash makes it scp-style rather than a path
+	colon_index := strings.Index(repository_url, ":")
+	if colon_index <= 0 || strings.Contains(repository_url[:colon_index], "/") {
+		return "", "", "", false
+	}
+	return repository_url[:colon_index], "", repository_url[colon_index+1:], true
+}
+
+// sshCommand returns the command that runs ssh: GIT_SSH_COMMAND, or
+// core.sshCommand, or ssh. It is run by the shell, so it may carry options.
+func (regit *ReGit) sshCommand() string {
+	if command := os.Getenv("GIT_SSH_COMMAND"); command != "" {
+		return command
+	}
+	if command, ok := regit.Config["core.sshcommand"]; ok && command != "" {
+		return command
+	}
+	return "ssh"
+}
+
+// shellQuote quotes an argument for the shell of the remote host.
+func shellQuote(argument string) string {
+	return "'" + strings.Replace(argument, "'", `'\''`, -1) + "'"
+}
+
+// sshConnection is the standard input and output of ssh, which runs a
+// service on the remote host.
+type sshConnection struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+}
+
+func (conn *sshConnection) Read(buffer []byte) (int, error) {
+	return conn.stdout.Read(buffer)
+}
+
+func (conn *sshConnection) Write(data []byte) (int, error) {
+	return conn.stdin.Write(data)
+}
+
+// Close tells the service that there is nothing more to read, and waits for
+// ssh to exit.
+func (conn *sshConnection) Close() error {
+	conn.stdin.Close()
+	return conn.cmd.Wait()
+}
+
+// newSSHTransport returns the transport for an ssh:// or scp-style URL,
+// which runs git-upload-pack or git-receive-pack on the remote host with
+// command, and speaks protocol v0 to it.
+func newSSHTransport(command string, repository_url string) (*streamTransport, error) {
+	host, port, path, ok := parseSSHURL(repository_url)
+	if !ok {
+		return nil, errors.New("invalid URL '" + repository_url + "'")
+	}
+	transport := new(streamTransport)
+	transport.connect = func(service string) (io.ReadWriteCloser, error) {
+		arguments := make([]string, 0)
+		if port != "" {
+			arguments = append(arguments, "-p", port)
+		}
+		arguments = append(arguments, host, service+" "+shellQuote(path))
+		// like git's, the command gets the arguments through the shell
+		cmd := exec.Command("sh", append([]string{"-c", command + ` "$@"`, command}, arguments...)...)
+		cmd.Stderr = os.Stderr
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+		return &sshConnection{cmd, stdin, stdout}, nil
+	}
+	return transport, nil
+}
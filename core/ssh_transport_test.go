@@ -0,0 +1,134 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSSH writes a stand-in for ssh which records its arguments, one per
+// line, in the returned log, and runs the command meant for the remote host
+// with a shell, as sshd would. The git-upload-pack and git-receive-pack it
+// finds there are the test binary, which serves the repository in place of
+// the remote. GIT_SSH_COMMAND is set to run it with an option of its own.
+func fakeSSH(t *testing.T) string {
+	dir := t.TempDir()
+	log_path := dir + "/ssh.log"
+	scripts := map[string]string{
+		"ssh": "#!/bin/sh\n" +
+			"for argument in \"$@\"; do printf '%s\\n' \"$argument\"; done >> '" + log_path + "'\n" +
+			"eval \"remote_command=\\${$#}\"\n" +
+			"PATH='" + dir + "':$PATH exec sh -c \"$remote_command\"\n",
+	}
+	for _, service := range []string{"upload-pack", "receive-pack"} {
+		scripts["git-"+service] = "#!/bin/sh\n" +
+			"REGIT_TEST_SERVICE=" + service + " REGIT_TEST_SERVICE_DIR=\"$1\" exec '" + os.Args[0] + "' -test.run='^TestSSHCloneFetch$'\n"
+	}
+	for name, script := range scripts {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setEnv(t, "GIT_SSH_COMMAND", "'"+dir+"/ssh' -o BatchMode=yes")
+	return log_path
+}
+
+// sshCalls reads the arguments ssh was run with since the last call, one
+// slice for each time it was run.
+func sshCalls(t *testing.T, log_path string) [][]string {
+	content, err := ioutil.ReadFile(log_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(log_path)
+	calls := make([][]string, 0)
+	for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+		// every run starts with the option given in GIT_SSH_COMMAND
+		if line == "-o" {
+			calls = append(calls, nil)
+		}
+		calls[len(calls)-1] = append(calls[len(calls)-1], line)
+	}
+	return calls
+}
+
+func checkSSHCalls(t *testing.T, what string, calls [][]string, want []string) {
+	if len(calls) == 0 {
+		t.Errorf("%s did not run ssh", what)
+	}
+	for _, call := range calls {
+		if strings.Join(call, "\n") != strings.Join(want, "\n") {
+			t.Errorf("%s ran ssh with %q, want %q", what, call, want)
+		}
+	}
+}
+
+func TestParseSSHURL(t *testing.T) {
+	for _, test := range []struct {
+		url              string
+		host, port, path string
+		ok               bool
+	}{
+		{"ssh://git@example.com:2222/org/repo.git", "git@example.com", "2222", "/org/repo.git", true},
+		{"git+ssh://example.com/repo", "example.com", "", "/repo", true},
+		{"git@example.com:org/repo.git", "git@example.com", "", "org/repo.git", true},
+		{"./dir:with/colon", "", "", "", false},
+		{"/srv/repo.git", "", "", "", false},
+		{"https://example.com/repo", "", "", "", false},
+	} {
+		host, port, path, ok := parseSSHURL(test.url)
+		if host != test.host || port != test.port || path != test.path || ok != test.ok {
+			t.Errorf("%s was parsed as %q, %q, %q, %v", test.url, host, port, path, ok)
+		}
+	}
+	if quoted := shellQuote("it's"); quoted != `'it'\''s'` {
+		t.Errorf("quoted as %s", quoted)
+	}
+}
+
+func TestSSHCloneFetch(t *testing.T) {
+	if service := os.Getenv("REGIT_TEST_SERVICE"); service != "" {
+		NewReGit(".").ServePack(service, os.Getenv("REGIT_TEST_SERVICE_DIR"), false, false)
+		os.Exit(0)
+	}
+	isolateHome(t)
+	log_path := fakeSSH(t)
+	dir := t.TempDir()
+	// the path has to be quoted for the shell of the remote host
+	origin_dir := dir + "/it's a repo"
+	newTestRepository(t, origin_dir)
+	first := commitFile(t, origin_dir, "a.txt", "a\n")
+	quoted_command := "git-upload-pack '" + dir + "/it'\\''s a repo'"
+
+	captureOutput(t, func() {
+		NewReGit(dir).Clone("ssh://git@example.com:2222"+origin_dir, "clone", false, "", nil, nil)
+	})
+	clone_dir := dir + "/clone"
+	checkSSHCalls(t, "clone", sshCalls(t, log_path), []string{"-o", "BatchMode=yes", "-p", "2222", "git@example.com", quoted_command})
+	if sha1_name := testRef(t, clone_dir, "refs/remotes/origin/master"); sha1_name != first {
+		t.Errorf("the cloned origin/master is %s, want %s", sha1_name, first)
+	}
+	if content, err := ioutil.ReadFile(clone_dir + "/a.txt"); err != nil || string(content) != "a\n" {
+		t.Errorf("a.txt was not checked out: %q, %v", content, err)
+	}
+
+	second := commitFile(t, origin_dir, "b.txt", "b\n")
+	captureOutput(t, func() {
+		NewReGit(clone_dir).Fetch("origin", nil, nil)
+	})
+	checkSSHCalls(t, "fetch", sshCalls(t, log_path), []string{"-o", "BatchMode=yes", "-p", "2222", "git@example.com", quoted_command})
+	if sha1_name := testRef(t, clone_dir, "refs/remotes/origin/master"); sha1_name != second {
+		t.Errorf("the fetched origin/master is %s, want %s", sha1_name, second)
+	}
+
+	// an scp-style URL has no port
+	captureOutput(t, func() {
+		NewReGit(dir).Clone("example.com:"+origin_dir, "scp-clone", false, "", nil, nil)
+	})
+	checkSSHCalls(t, "scp-style clone", sshCalls(t, log_path), []string{"-o", "BatchMode=yes", "example.com", quoted_command})
+	if sha1_name := testRef(t, dir+"/scp-clone", "HEAD"); sha1_name != second {
+		t.Errorf("the scp-style clone has HEAD at %s, want %s", sha1_name, second)
+	}
+}
@@ -0,0 +1,355 @@
+package core
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A stash is a commit whose tree is the working tree, with HEAD as its first
+// parent, a commit of the index as its second, and, when untracked files are
+// stashed, a root commit of them as its third, like git's. refs/stash points
+// to the latest stash, and its reflog lists them all.
+const stashRef = "refs/stash"
+
+// writeFilesTree writes the tree objects for a set of files, by path, and
+// returns the name of the root tree.
+func (regit *ReGit) writeFilesTree(files map[string]*treeFile) string {
+	tg := NewTreeGraph()
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		sha1_name, _ := hex.DecodeString(files[path].SHA1)
+		tg.AddFileEntry(path, files[path].Mode, sha1_name)
+	}
+	return hex.EncodeToString(tg.ConstructTreeObjects(regit.RootDir))
+}
+
+// stashFile writes the blob of a file of the working tree and returns it
+// with its mode. Symbolic links are not followed; their blob is the path
+// they point to.
+func (regit *ReGit) stashFile(path string) (*treeFile, error) {
+	full_path := regit.RootDir + "/" + path
+	info, err := os.Lstat(full_path)
+	if err != nil {
+		return nil, err
+	}
+	mode := "100644"
+	var content []byte
+	if info.Mode()&os.ModeSymlink != 0 {
+		mode = "120000"
+		target, err := os.Readlink(full_path)
+		if err != nil {
+			return nil, err
+		}
+		content = []byte(target)
+	} else {
+		if info.Mode()&0111 != 0 {
+			mode = "100755"
+		}
+		if content, err = ioutil.ReadFile(full_path); err != nil {
+			return nil, err
+		}
+	}
+	blob := NewGitObject(regit.RootDir, "blob", content)
+	blob.WriteToFile()
+	return &treeFile{mode, hex.EncodeToString(blob.HashedFilename)}, nil
+}
+
+// untrackedFiles lists the files of the working tree which are not in the
+// index.
+func (regit *ReGit) untrackedFiles(index *Index) []string {
+	tracked := make(map[string]bool)
+	for _, entry := range index.Entries() {
+		tracked[string(entry.Path[:len(entry.Path)-1])] = true
+	}
+	paths := make([]string, 0)
+	filepath.Walk(regit.RootDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == regit.RootDir {
+			return nil
+		}
+		relative_path := filepath.ToSlash(path[len(regit.RootDir)+1:])
+		if info.IsDir() {
+			if relative_path == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !tracked[relative_path] {
+			paths = append(paths, relative_path)
+		}
+		return nil
+	})
+	return paths
+}
+
+// the part of the messages of stashes naming where they were made, e.g.
+// "master: 1234abc subject"
+func (regit *ReGit) stashBase(head_sha1 string) string {
+	head := NewHEAD(regit.RootDir)
+	head.Read()
+	branch_name := "(no branch)"
+	if head.PointsToBranch {
+		branch_name = head.Content
+	}
+	commit := NewCommitObject(regit.RootDir)
+	commit.ReadFromExistingObject(head_sha1)
+	return branch_name + ": " + abbreviate(head_sha1) + " " + commitSubject(commit)
+}
+
+// StashPush saves the changes of the index and of the working tree in a new
+// stash, along with the untracked files if include_untracked is set, then
+// takes them out of the working tree.
+func (regit *ReGit) StashPush(message string, include_untracked bool) {
+	regit.checkIdentity()
+	head_sha1 := regit.headCommit()
+	if head_sha1 == "" {
+		fmt.Println("Error: you do not have the initial commit yet")
+		os.Exit(1)
+	}
+	head_tree := regit.commitTree(head_sha1)
+	index := NewIndex(regit.RootDir)
+	regit.readIndexOrExit(index)
+	if index.HasUnmergedEntries() {
+		fmt.Println("Error: you need to resolve your current index first")
+		os.Exit(1)
+	}
+	index_tree := hex.EncodeToString(regit.writeTree(index))
+
+	worktree_files := make(map[string]*treeFile)
+	for _, entry := range index.Entries() {
+		path := string(entry.Path[:len(entry.Path)-1])
+		file, err := regit.stashFile(path)
+		if err != nil {
+			// deleted files are left out
+			continue
+		}
+		worktree_files[path] = file
+	}
+	worktree_tree := regit.writeFilesTree(worktree_files)
+
+	untracked_files := make(map[string]*treeFile)
+	if include_untracked {
+		for _, path := range regit.untrackedFiles(index) {
+			file, err := regit.stashFile(path)
+			if err != nil {
+				continue
+			}
+			untracked_files[path] = file
+		}
+	}
+	if index_tree == head_tree && worktree_tree == head_tree && len(untracked_files) == 0 {
+		fmt.Println("No local changes to save")
+		return
+	}
+
+	base := regit.stashBase(head_sha1)
+	author := regit.signature("GIT_AUTHOR_DATE").String()
+	parents := []string{head_sha1, regit.writeCommit(index_tree, []string{head_sha1}, author, "index on "+base)}
+	if len(untracked_files) != 0 {
+		untracked_tree := regit.writeFilesTree(untracked_files)
+		parents = append(parents, regit.writeCommit(untracked_tree, nil, author, "untracked files on "+base))
+	}
+	stash_message := "WIP on " + base
+	if message != "" {
+		stash_message = "On " + base[:strings.Index(base, ": ")] + ": " + message
+	}
+	stash := NewCommitObject(regit.RootDir)
+	stash.SetTree(worktree_tree)
+	stash.SetParents(parents)
+	stash.SetAuthor(author)
+	stash.SetCommitter(regit.signature("GIT_COMMITTER_DATE").String())
+	stash.SetMessage(stash_message)
+	stash.GenerateContent()
+	if message != "" {
+		// like git's, a message given with -m is stored without a newline
+		stash.Obj.content = stash.Obj.content[:len(stash.Obj.content)-1]
+	}
+	stash.Obj.WriteToFile()
+	stash_sha1 := hex.EncodeToString(stash.Obj.HashedFilename)
+
+	old_sha1, ok := ReadRef(regit.RootDir, stashRef)
+	if !ok {
+		old_sha1 = strings.Repeat("0", 40)
+	}
+	err := WriteRef(regit.RootDir, stashRef, stash_sha1)
+	if err == nil {
+		err = AppendReflog(regit.RootDir, stashRef, &ReflogEntry{old_sha1, stash_sha1, regit.signature("GIT_COMMITTER_DATE").String(), stash_message})
+	}
+	if err != nil {
+		fmt.Println("Error: cannot save the current status: " + err.Error())
+		os.Exit(1)
+	}
+	fmt.Println("Saved working directory and index state " + stash_message)
+
+	regit.resetToTree(head_tree)
+	for path := range untracked_files {
+		regit.removeWorktreeFile(path)
+	}
+}
+
+// resolveStash finds a stash given as "stash@{<n>}" or as "<n>", the latest
+// one by default, and returns its position in the list and its name.
+func (regit *ReGit) resolveStash(name string) (int, string, error) {
+	entries := ReadReflog(regit.RootDir, stashRef)
+	if len(entries) == 0 {
+		return 0, "", errors.New("no stash entries found.")
+	}
+	if name == "" {
+		return 0, entries[len(entries)-1].NewSHA1, nil
+	}
+	number := name
+	if strings.HasPrefix(name, "stash@{") || strings.HasPrefix(name, "refs/stash@{") {
+		number = strings.TrimSuffix(name[strings.Index(name, "@{")+2:], "}")
+	}
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 0 || n >= len(entries) {
+		return 0, "", errors.New(name + " is not a valid reference")
+	}
+	return n, entries[len(entries)-1-n].NewSHA1, nil
+}
+
+func (regit *ReGit) resolveStashOrExit(name string) (int, string) {
+	n, sha1_name, err := regit.resolveStash(name)
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+	return n, sha1_name
+}
+
+// StashList prints the stashes, the latest first.
+func (regit *ReGit) StashList() {
+	entries := ReadReflog(regit.RootDir, stashRef)
+	pager := regit.startPager()
+	defer pager.Close()
+	for i := range entries {
+		io.WriteString(pager, "stash@{"+strconv.Itoa(i)+"}: "+entries[len(entries)-1-i].Message+"\n")
+	}
+}
+
+// StashShow prints the changes of a stash to the commit it was made on, as
+// a diffstat, or as a patch with show_patch set.
+func (regit *ReGit) StashShow(name string, show_patch bool) {
+	_, stash_sha1 := regit.resolveStashOrExit(name)
+	stash := NewCommitObject(regit.RootDir)
+	stash.ReadFromExistingObject(stash_sha1)
+	changes := regit.DiffTrees(regit.commitTree(stash.parents[0]), stash.tree)
+
+	colors := NewDiffColors(regit.UseColor())
+	builder := new(strings.Builder)
+	if show_patch {
+		for _, change := range changes {
+			regit.WritePatch(builder, change, colors)
+		}
+	} else if len(changes) != 0 {
+		regit.WriteDiffStat(builder, changes, colors)
+	}
+	pager := regit.startPager()
+	defer pager.Close()
+	io.WriteString(pager, builder.String())
+}
+
+// StashApply merges the changes of a stash into the working tree. Like git,
+// the index is left as HEAD but for the files the stash adds, and untracked
+// files of the stash are restored. It returns false if there are conflicts.
+func (regit *ReGit) StashApply(name string) bool {
+	_, stash_sha1 := regit.resolveStashOrExit(name)
+	stash := NewCommitObject(regit.RootDir)
+	stash.ReadFromExistingObject(stash_sha1)
+	head_tree := regit.commitTree(regit.headCommit())
+
+	var untracked_files map[string]*treeFile
+	if len(stash.parents) > 2 {
+		untracked_files = regit.flattenTree(regit.commitTree(stash.parents[2]))
+		for path := range untracked_files {
+			if _, err := os.Lstat(regit.RootDir + "/" + path); err == nil {
+				fmt.Println("Error: " + path + " already exists, no checkout")
+				fmt.Println("Error: could not restore untracked files from stash")
+				os.Exit(1)
+			}
+		}
+	}
+
+	merge := regit.mergeTrees(regit.commitTree(stash.parents[0]), head_tree, stash.tree, "Updated upstream", "Stashed changes")
+	head_files := regit.flattenTree(head_tree)
+	regit.checkMergeable(head_files, merge, "merge")
+	regit.applyMerge(head_files, merge)
+	for _, line := range merge.Messages {
+		fmt.Println(line)
+	}
+	for path, file := range untracked_files {
+		regit.writeWorktreeFile(path, file.Mode, regit.readBlobOrExit(file.SHA1))
+	}
+	if len(merge.Conflicts) != 0 {
+		return false
+	}
+
+	// the changes are left unstaged, except for new files
+	index := NewIndex(regit.RootDir)
+	regit.readIndexOrExit(index)
+	index.ClearEntries()
+	for path, file := range merge.Files {
+		if head_files[path] != nil {
+			file = head_files[path]
+		}
+		mode, _ := strconv.ParseUint(file.Mode, 8, 32)
+		object_id, _ := hex.DecodeString(file.SHA1)
+		index.WriteStageEntry(path, uint32(mode), object_id, 0)
+	}
+	for path, file := range head_files {
+		if merge.Files[path] == nil {
+			mode, _ := strconv.ParseUint(file.Mode, 8, 32)
+			object_id, _ := hex.DecodeString(file.SHA1)
+			index.WriteStageEntry(path, uint32(mode), object_id, 0)
+		}
+	}
+	index.Save()
+	return true
+}
+
+// StashPop applies a stash, then drops it unless there were conflicts.
+func (regit *ReGit) StashPop(name string) {
+	if !regit.StashApply(name) {
+		fmt.Println("The stash entry is kept in case you need it again.")
+		os.Exit(1)
+	}
+	regit.StashDrop(name)
+}
+
+// StashDrop removes a stash from the list.
+func (regit *ReGit) StashDrop(name string) {
+	n, stash_sha1 := regit.resolveStashOrExit(name)
+	entries := ReadReflog(regit.RootDir, stashRef)
+	position := len(entries) - 1 - n
+	// the next stash now follows the previous one
+	if position+1 < len(entries) {
+		entries[position+1].OldSHA1 = entries[position].OldSHA1
+	}
+	entries = append(entries[:position], entries[position+1:]...)
+
+	var err error
+	if len(entries) == 0 {
+		DeleteRef(regit.RootDir, stashRef)
+	} else if err = WriteReflog(regit.RootDir, stashRef, entries); err == nil {
+		err = WriteRef(regit.RootDir, stashRef, entries[len(entries)-1].NewSHA1)
+	}
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+	if !strings.HasPrefix(name, "stash@{") {
+		name = "refs/stash@{" + strconv.Itoa(n) + "}"
+	}
+	fmt.Println("Dropped " + name + " (" + stash_sha1 + ")")
+}
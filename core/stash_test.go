@@ -0,0 +1,100 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestResolveStash(t *testing.T) {
+	isolateHome(t)
+	rootDir := t.TempDir()
+	regit := newTestRepository(t, rootDir)
+	if _, _, err := regit.resolveStash(""); err == nil {
+		t.Error("a stash was found in a new repository")
+	}
+	for _, sha1_name := range []string{"1111111111111111111111111111111111111111", "2222222222222222222222222222222222222222"} {
+		AppendReflog(rootDir, stashRef, &ReflogEntry{strings.Repeat("0", 40), sha1_name, "A U Thor <author@example.com> 1700000000 +0000", "WIP"})
+	}
+	for name, want := range map[string]string{
+		"":               "2222222222222222222222222222222222222222",
+		"stash@{0}":      "2222222222222222222222222222222222222222",
+		"1":              "1111111111111111111111111111111111111111",
+		"refs/stash@{1}": "1111111111111111111111111111111111111111",
+	} {
+		if _, sha1_name, err := regit.resolveStash(name); err != nil || sha1_name != want {
+			t.Errorf("%q resolved to %s, %v, want %s", name, sha1_name, err, want)
+		}
+	}
+	for _, name := range []string{"2", "stash@{x}"} {
+		if _, _, err := regit.resolveStash(name); err == nil {
+			t.Errorf("%q was resolved", name)
+		}
+	}
+}
+
+// TestStashModes checks that a stash keeps executables and symbolic links,
+// tracked or not, as they are.
+func TestStashModes(t *testing.T) {
+	isolateHome(t)
+	rootDir := t.TempDir()
+	chdir(t, rootDir)
+	regit := newTestRepository(t, rootDir)
+	commitFile(t, rootDir, "run.sh", "true\n")
+	head_tree := regit.commitTree(regit.headCommit())
+
+	if err := ioutil.WriteFile(rootDir+"/run.sh", []byte("exit 0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(rootDir+"/run.sh", 0755)
+	if err := ioutil.WriteFile(rootDir+"/tool.sh", []byte("true\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("run.sh", rootDir+"/link"); err != nil {
+		t.Fatal(err)
+	}
+	captureOutput(t, func() {
+		regit.StashPush("", true)
+	})
+
+	stash := newTestCommit(t, rootDir, testRef(t, rootDir, stashRef))
+	if file := regit.flattenTree(stash.tree)["run.sh"]; file == nil || file.Mode != "100755" {
+		t.Errorf("run.sh was stashed as %+v", file)
+	}
+	untracked_files := regit.flattenTree(regit.commitTree(stash.parents[2]))
+	if file := untracked_files["tool.sh"]; file == nil || file.Mode != "100755" {
+		t.Errorf("tool.sh was stashed as %+v", file)
+	}
+	if file := untracked_files["link"]; file == nil || file.Mode != "120000" || string(regit.readBlobOrExit(file.SHA1)) != "run.sh" {
+		t.Errorf("link was stashed as %+v", file)
+	}
+	if regit.commitTree(stash.parents[1]) != head_tree {
+		t.Errorf("the index was stashed with changes")
+	}
+	if _, err := os.Lstat(rootDir + "/link"); err == nil {
+		t.Errorf("link was left in the working tree")
+	}
+	if info, err := os.Stat(rootDir + "/run.sh"); err != nil || info.Mode()&0111 != 0 {
+		t.Errorf("run.sh was not reset")
+	}
+
+	captureOutput(t, func() {
+		regit.StashPop("")
+	})
+	if info, err := os.Stat(rootDir + "/run.sh"); err != nil || info.Mode()&0111 == 0 {
+		t.Errorf("run.sh is not executable after the pop")
+	}
+	if content, _ := ioutil.ReadFile(rootDir + "/run.sh"); string(content) != "exit 0\n" {
+		t.Errorf("run.sh is %q after the pop", content)
+	}
+	if info, err := os.Stat(rootDir + "/tool.sh"); err != nil || info.Mode()&0111 == 0 {
+		t.Errorf("tool.sh is not executable after the pop")
+	}
+	if target, err := os.Readlink(rootDir + "/link"); err != nil || target != "run.sh" {
+		t.Errorf("link points to %q, %v after the pop", target, err)
+	}
+	if _, ok := ReadRef(rootDir, stashRef); ok {
+		t.Errorf("the stash was not dropped")
+	}
+}
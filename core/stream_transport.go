@@ -0,0 +1,161 @@
+package core
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// the port git:// servers listen on
+const gitDaemonPort = "9418"
+
+// streamTransport speaks protocol v0 over a connection which stays open for
+// a whole exchange, as git:// does: the server shows its refs first, then
+// the request is sent and answered on the same connection, which is closed
+// once done.
+type streamTransport struct {
+	// connect opens a connection to git-upload-pack or git-receive-pack
+	connect func(service string) (io.ReadWriteCloser, error)
+
+	conn         io.ReadWriteCloser
+	pkt_reader   *PktReader
+	service      string // of the open connection
+	capabilities map[string]string
+	remote_refs  *RemoteRefs
+}
+
+// newGitTransport returns the transport for a git:// URL, e.g.
+// "git://host[:port]/path/to/repo.git".
+func newGitTransport(repository_url string) (*streamTransport, error) {
+	parsed, err := url.Parse(repository_url)
+	if err != nil || parsed.Host == "" {
+		return nil, errors.New("invalid URL '" + repository_url + "'")
+	}
+	address := parsed.Host
+	if parsed.Port() == "" {
+		address = net.JoinHostPort(parsed.Hostname(), gitDaemonPort)
+	}
+	path := parsed.Path
+	if path == "" {
+		path = "/"
+	}
+	transport := new(streamTransport)
+	transport.connect = func(service string) (io.ReadWriteCloser, error) {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		// the daemon is told which service to run, on what, first
+		request := PktLine(service + " " + path + "\000host=" + parsed.Host + "\000")
+		if _, err := io.WriteString(conn, request); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+	return transport, nil
+}
+
+// open connects to the service and reads the refs it shows.
+func (transport *streamTransport) open(service string) error {
+	transport.close()
+	conn, err := transport.connect(service)
+	if err != nil {
+		return err
+	}
+	transport.conn = conn
+	transport.pkt_reader = NewPktReader(conn)
+	transport.service = service
+	transport.capabilities = make(map[string]string)
+	// a server which refuses the request says why in an ERR line instead
+	lines := make([]string, 0)
+	for {
+		line, err := transport.pkt_reader.ReadLine()
+		if err == errPktFlush {
+			break
+		}
+		if err == io.ErrUnexpectedEOF && len(lines) == 0 {
+			err = errors.New("the remote end hung up unexpectedly")
+		}
+		if err != nil {
+			transport.close()
+			return err
+		}
+		lines = append(lines, line)
+		if strings.HasPrefix(line, "ERR ") {
+			break
+		}
+	}
+	transport.remote_refs, err = parseAdvertisement(lines, transport.capabilities)
+	if err != nil {
+		transport.close()
+		return err
+	}
+	return nil
+}
+
+func (transport *streamTransport) close() {
+	if transport.conn != nil {
+		transport.conn.Close()
+		transport.conn = nil
+	}
+}
+
+func (transport *streamTransport) Refs(for_push bool) (*RemoteRefs, error) {
+	service := "git-upload-pack"
+	if for_push {
+		service = "git-receive-pack"
+	}
+	if err := transport.open(service); err != nil {
+		return nil, err
+	}
+	return transport.remote_refs, nil
+}
+
+func (transport *streamTransport) Fetch(rootDir string, wants []string, deepen *Deepen, filter *ObjectFilter) error {
+	wants = fetchWants(rootDir, wants, deepen)
+	if len(wants) == 0 {
+		if transport.conn != nil && transport.service == "git-upload-pack" {
+			// a flush tells the server that nothing is wanted
+			io.WriteString(transport.conn, pktFlush)
+			transport.close()
+		}
+		return nil
+	}
+	if transport.conn == nil || transport.service != "git-upload-pack" {
+		if err := transport.open("git-upload-pack"); err != nil {
+			return err
+		}
+	}
+	defer transport.close()
+	_, shallow_supported := transport.capabilities["shallow"]
+	if err := checkShallowSupport(rootDir, deepen, shallow_supported); err != nil {
+		return err
+	}
+	_, filter_supported := transport.capabilities["filter"]
+	filter = checkFilterSupport(filter, filter_supported)
+	request := fetchRequest(rootDir, transport.capabilities, wants, haveCommits(rootDir, maxHaves), deepen, filter)
+	if _, err := io.WriteString(transport.conn, request); err != nil {
+		return err
+	}
+	return readPack(rootDir, transport.pkt_reader, transport.capabilities, deepen)
+}
+
+func (transport *streamTransport) Push(rootDir string, updates []*refUpdate) (map[string]string, error) {
+	if transport.conn == nil || transport.service != "git-receive-pack" {
+		if err := transport.open("git-receive-pack"); err != nil {
+			return nil, err
+		}
+	}
+	defer transport.close()
+	request, err := pushRequest(rootDir, transport.remote_refs, transport.capabilities, updates)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := transport.conn.Write(request); err != nil {
+		return nil, err
+	}
+	return readPushReport(transport.pkt_reader.reader, transport.capabilities)
+}
@@ -0,0 +1,545 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RemoteRefs are the refs a remote repository shows to fetch or push.
+type RemoteRefs struct {
+	Refs     []*Ref
+	Peeled   map[string]string // what annotated tags point to, by ref name
+	Head     string            // the branch HEAD points to, if any
+	HeadSHA1 string            // what HEAD points to; empty in an empty repository
+}
+
+// Lookup returns the object a ref, or HEAD, points to.
+func (remote_refs *RemoteRefs) Lookup(name string) (string, bool) {
+	if name == "HEAD" {
+		return remote_refs.HeadSHA1, remote_refs.HeadSHA1 != ""
+	}
+	for _, ref := range remote_refs.Refs {
+		if ref.Name == name {
+			return ref.SHA1, true
+		}
+	}
+	return "", false
+}
+
+// Transport talks to a remote repository, be it on the file system or
+// behind a server.
+type Transport interface {
+	// Refs lists the refs of the remote, as it shows them to fetch, or to
+	// push with for_push.
+	Refs(for_push bool) (*RemoteRefs, error)
+	// Fetch copies the objects reachable from wants into the repository at
+	// rootDir, which may leave out those it already has. With deepen, the
+	// history is cut or deepened as it says, and .git/shallow updated. With
+	// filter, the objects it omits are left out.
+	Fetch(rootDir string, wants []string, deepen *Deepen, filter *ObjectFilter) error
+	// Push sends the objects of the repository at rootDir that updates need
+	// and updates the refs of the remote. It returns why the remote refused
+	// an update, by ref name.
+	Push(rootDir string, updates []*refUpdate) (map[string]string, error)
+}
+
+// openTransport returns the transport for a URL: smart HTTP for http:// and
+// https:// URLs, the git protocol for git:// URLs, ssh for ssh:// and
+// scp-style URLs, and the file system for paths and file:// URLs, which may
+// name a bundle.
+func (regit *ReGit) openTransport(url string) (Transport, error) {
+	if strings.HasPrefix(url, "git://") {
+		return newGitTransport(url)
+	}
+	if _, _, _, ok := parseSSHURL(url); ok {
+		return newSSHTransport(regit.sshCommand(), url)
+	}
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		version := 2
+		if regit.Config["protocol.version"] == "0" || regit.Config["protocol.version"] == "1" {
+			version = 0
+		}
+		return newHTTPTransport(url, version), nil
+	}
+	if path, ok := regit.bundlePath(url); ok {
+		return newBundleTransport(path)
+	}
+	path, err := regit.localRepository(url)
+	if err != nil {
+		return nil, err
+	}
+	return &localTransport{path}, nil
+}
+
+// localTransport reaches a repository on the file system.
+type localTransport struct {
+	path string
+}
+
+func (transport *localTransport) Refs(for_push bool) (*RemoteRefs, error) {
+	remote_refs := &RemoteRefs{Refs: ListRefs(transport.path), Peeled: make(map[string]string)}
+	for _, ref := range remote_refs.Refs {
+		if !strings.HasPrefix(ref.Name, "refs/tags/") {
+			continue
+		}
+		if peeled, err := PeelObject(transport.path, ref.SHA1, ""); err == nil && peeled != ref.SHA1 {
+			remote_refs.Peeled[ref.Name] = peeled
+		}
+	}
+	remote_refs.Head = headBranch(transport.path)
+	remote_refs.HeadSHA1, _ = ReadRef(transport.path, "HEAD")
+	return remote_refs, nil
+}
+
+// Fetch copies the objects directly, but runs upload-pack in process for a
+// shallow or a filtered fetch, which it works out the history or the
+// objects of.
+func (transport *localTransport) Fetch(rootDir string, wants []string, deepen *Deepen, filter *ObjectFilter) error {
+	if deepen == nil && filter == nil {
+		return copyMissingObjects(transport.path, rootDir, wants)
+	}
+	stream := new(streamTransport)
+	stream.connect = func(service string) (io.ReadWriteCloser, error) {
+		return newPipeConnection(func(in io.Reader, out io.Writer) error {
+			return UploadPack(transport.path, in, out, false, false)
+		}), nil
+	}
+	return stream.Fetch(rootDir, wants, deepen, filter)
+}
+
+// pipeConnection connects to a service run in process.
+type pipeConnection struct {
+	io.Reader
+	io.WriteCloser
+	done chan error
+}
+
+func newPipeConnection(serve func(in io.Reader, out io.Writer) error) *pipeConnection {
+	request_reader, request_writer := io.Pipe()
+	response_reader, response_writer := io.Pipe()
+	conn := &pipeConnection{response_reader, request_writer, make(chan error, 1)}
+	go func() {
+		err := serve(request_reader, response_writer)
+		response_writer.CloseWithError(err)
+		request_reader.Close()
+		conn.done <- err
+	}()
+	return conn
+}
+
+// Close tells the service that there is nothing more to read, and waits for
+// it to end.
+func (conn *pipeConnection) Close() error {
+	conn.WriteCloser.Close()
+	return <-conn.done
+}
+
+func (transport *localTransport) Push(rootDir string, updates []*refUpdate) (map[string]string, error) {
+	tips := make([]string, 0, len(updates))
+	for _, update := range updates {
+		if update.NewSHA1 != "" {
+			tips = append(tips, update.NewSHA1)
+		}
+	}
+	if err := copyMissingObjects(rootDir, transport.path, tips); err != nil {
+		return nil, err
+	}
+	return receiveUpdates(transport.path, updates)
+}
+
+// objectsToSend lists the objects reachable from tips which another
+// repository that has remote_tips is missing. Like git's, it leaves out the
+// history of the remote tips which are in the repository, which ends at the
+// shallow commits of the other repository, and the files and trees they
+// have, but not those which only older commits have. The commits of
+// boundary are left out, as the parents of the commits a shallow fetch
+// cuts the history at.
+func objectsToSend(rootDir string, tips []string, remote_tips []string, remote_shallow map[string]bool, boundary map[string]bool) ([]*GitObject, error) {
+	remote_objects := make(map[string]bool)
+	for sha1_name := range boundary {
+		remote_objects[sha1_name] = true
+	}
+	pending := make([]string, 0, len(remote_tips))
+	for _, sha1_name := range remote_tips {
+		commit_sha1, err := PeelObject(rootDir, sha1_name, "commit")
+		if err != nil {
+			continue
+		}
+		pending = append(pending, commit_sha1)
+		commit := NewCommitObject(rootDir)
+		if commit.Load(commit_sha1) == nil {
+			markTreeObjects(rootDir, commit.tree, remote_objects)
+		}
+	}
+	seen := make(map[string]bool)
+	for len(pending) != 0 {
+		commit_sha1 := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[commit_sha1] {
+			continue
+		}
+		seen[commit_sha1] = true
+		commit := NewCommitObject(rootDir)
+		if commit.Load(commit_sha1) != nil {
+			continue
+		}
+		remote_objects[commit_sha1] = true
+		if !remote_shallow[commit_sha1] {
+			pending = append(pending, commit.historyParents()...)
+		}
+	}
+	objects := make([]*GitObject, 0)
+	err := walkMissingObjects(rootDir, tips, func(sha1_name string) bool {
+		return remote_objects[sha1_name]
+	}, func(obj *GitObject) {
+		remote_objects[hex.EncodeToString(obj.Hash())] = true
+		objects = append(objects, obj)
+	})
+	return objects, err
+}
+
+// markTreeObjects adds a tree, and the trees and blobs in it, to a set.
+func markTreeObjects(rootDir string, tree_sha1 string, objects map[string]bool) {
+	if objects[tree_sha1] {
+		return
+	}
+	objects[tree_sha1] = true
+	tree := NewTreeObject(rootDir)
+	if tree.Load(tree_sha1) != nil {
+		return
+	}
+	for _, entry := range tree.Entries {
+		sha1_name := hex.EncodeToString(entry.HashedFilename)
+		switch entry.Type() {
+		case "tree":
+			markTreeObjects(rootDir, sha1_name, objects)
+		case "blob":
+			objects[sha1_name] = true
+		}
+	}
+}
+
+// haveCommits lists commits of the repository for the negotiation of a
+// fetch: the tips of its refs, and their history, the most recent first, up
+// to a limit. There are none when a partial clone fetches what it is
+// missing, as the server would take it to have every object they point to.
+func haveCommits(rootDir string, limit int) []string {
+	haves := make([]string, 0)
+	if fetchingPromisedObjects(rootDir) {
+		return haves
+	}
+	seen := make(map[string]bool)
+	pending := make([]string, 0)
+	for _, ref := range ListRefs(rootDir) {
+		if commit_sha1, err := PeelObject(rootDir, ref.SHA1, "commit"); err == nil {
+			pending = append(pending, commit_sha1)
+		}
+	}
+	for len(pending) != 0 && len(haves) < limit {
+		sha1_name := pending[0]
+		pending = pending[1:]
+		if seen[sha1_name] {
+			continue
+		}
+		seen[sha1_name] = true
+		commit := NewCommitObject(rootDir)
+		if commit.Load(sha1_name) != nil {
+			continue
+		}
+		haves = append(haves, sha1_name)
+		pending = append(pending, commit.historyParents()...)
+	}
+	return haves
+}
+
+// missingObjects lists the wants which the repository does not have, once
+// each.
+func missingObjects(rootDir string, wants []string) []string {
+	missing := make([]string, 0, len(wants))
+	seen := make(map[string]bool)
+	for _, want := range wants {
+		if !seen[want] && !ObjectExists(rootDir, want) {
+			missing = append(missing, want)
+		}
+		seen[want] = true
+	}
+	return missing
+}
+
+// parseAdvertisement reads the refs a server shows in protocol v0, and the
+// capabilities which follow the first of them.
+func parseAdvertisement(lines []string, capabilities map[string]string) (*RemoteRefs, error) {
+	remote_refs := &RemoteRefs{Peeled: make(map[string]string)}
+	for i, line := range lines {
+		if strings.HasPrefix(line, "ERR ") {
+			return nil, errors.New("remote error: " + line[len("ERR "):])
+		}
+		if i == 0 {
+			if nul_index := strings.IndexByte(line, 0); nul_index != -1 {
+				parseCapabilities(capabilities, line[nul_index+1:])
+				line = line[:nul_index]
+			}
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 || !isValidSHA1Name(fields[0]) {
+			return nil, errors.New("protocol error: unexpected ref line " + strconv.Quote(line))
+		}
+		switch {
+		case fields[1] == "capabilities^{}":
+			// the repository is empty
+		case fields[1] == "HEAD":
+			remote_refs.HeadSHA1 = fields[0]
+		case strings.HasSuffix(fields[1], "^{}"):
+			remote_refs.Peeled[strings.TrimSuffix(fields[1], "^{}")] = fields[0]
+		default:
+			remote_refs.Refs = append(remote_refs.Refs, &Ref{fields[1], fields[0]})
+		}
+	}
+	remote_refs.Head = strings.TrimPrefix(strings.TrimPrefix(capabilities["symref"], "HEAD:"), "refs/heads/")
+	return remote_refs, nil
+}
+
+// fetchWants lists the wants of a fetch which the repository does not have,
+// or all of them for a shallow fetch, which may deepen the history of what
+// it has.
+func fetchWants(rootDir string, wants []string, deepen *Deepen) []string {
+	if deepen == nil {
+		return missingObjects(rootDir, wants)
+	}
+	unique := make([]string, 0, len(wants))
+	seen := make(map[string]bool)
+	for _, want := range wants {
+		if !seen[want] {
+			unique = append(unique, want)
+		}
+		seen[want] = true
+	}
+	return unique
+}
+
+// shallowArguments tells a server which commits of a shallow repository
+// have no parents, and how far to deepen the history. They are the same in
+// protocol v0 and v2, but that v0 asks for deepen-relative as a capability.
+func shallowArguments(rootDir string, deepen *Deepen) []string {
+	arguments := make([]string, 0)
+	for sha1_name := range ReadShallow(rootDir) {
+		arguments = append(arguments, "shallow "+sha1_name)
+	}
+	sort.Strings(arguments)
+	switch {
+	case deepen == nil:
+	case !deepen.Since.IsZero():
+		arguments = append(arguments, "deepen-since "+strconv.FormatInt(deepen.Since.Unix(), 10))
+	default:
+		arguments = append(arguments, "deepen "+strconv.Itoa(deepen.Depth))
+		if deepen.Relative {
+			arguments = append(arguments, "deepen-relative")
+		}
+	}
+	return arguments
+}
+
+// checkShallowSupport fails a shallow fetch from a server which does not
+// know about shallow repositories.
+func checkShallowSupport(rootDir string, deepen *Deepen, supported bool) error {
+	if !supported && (deepen != nil || IsShallowRepository(rootDir)) {
+		return errors.New("Server does not support shallow clients")
+	}
+	return nil
+}
+
+// fetchRequest asks a server for wants in protocol v0, with the
+// capabilities of those it has which are used, telling it about haves and
+// that there is nothing more to negotiate.
+func fetchRequest(rootDir string, capabilities map[string]string, wants []string, haves []string, deepen *Deepen, filter *ObjectFilter) string {
+	used := make([]string, 0)
+	for _, capability := range []string{"side-band-64k", "ofs-delta", "no-progress", "include-tag"} {
+		if _, ok := capabilities[capability]; ok {
+			used = append(used, capability)
+		}
+	}
+	if deepen != nil && deepen.Relative {
+		used = append(used, "deepen-relative")
+	}
+	if deepen != nil && !deepen.Since.IsZero() {
+		used = append(used, "deepen-since")
+	}
+	if filter != nil {
+		used = append(used, "filter")
+	}
+	used = append(used, "agent="+userAgent)
+	request := ""
+	for i, want := range wants {
+		if i == 0 {
+			request += PktLine("want " + want + " " + strings.Join(used, " ") + "\n")
+		} else {
+			request += PktLine("want " + want + "\n")
+		}
+	}
+	for _, argument := range shallowArguments(rootDir, deepen) {
+		if argument != "deepen-relative" {
+			request += PktLine(argument + "\n")
+		}
+	}
+	if filter != nil {
+		request += PktLine("filter " + filter.Spec + "\n")
+	}
+	request += pktFlush
+	for _, have := range haves {
+		request += PktLine("have " + have + "\n")
+	}
+	return request + PktLine("done\n")
+}
+
+// readShallowInfo reads which commits a shallow fetch makes shallow and
+// which it no longer does, which are recorded in .git/shallow once the pack
+// is in.
+func readShallowInfo(pkt_reader *PktReader) ([]string, []string, error) {
+	lines, err := pkt_reader.ReadLines()
+	if err != nil {
+		return nil, nil, err
+	}
+	shallow := make([]string, 0)
+	unshallow := make([]string, 0)
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "shallow "):
+			shallow = append(shallow, line[len("shallow "):])
+		case strings.HasPrefix(line, "unshallow "):
+			unshallow = append(unshallow, line[len("unshallow "):])
+		case strings.HasPrefix(line, "ERR "):
+			return nil, nil, errors.New("remote error: " + line[len("ERR "):])
+		default:
+			return nil, nil, errors.New("protocol error: expected shallow/unshallow, got " + strconv.Quote(line))
+		}
+	}
+	return shallow, unshallow, nil
+}
+
+// readPack reads the answer to a fetchRequest: the shallow commits if it
+// deepens, then a single ACK of a common commit, or NAK, followed by the
+// pack, which is unpacked.
+func readPack(rootDir string, pkt_reader *PktReader, capabilities map[string]string, deepen *Deepen) error {
+	var shallow, unshallow []string
+	if deepen != nil {
+		var err error
+		if shallow, unshallow, err = readShallowInfo(pkt_reader); err != nil {
+			return err
+		}
+	}
+	line, err := pkt_reader.ReadLine()
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(line, "ERR ") {
+		return errors.New("remote error: " + line[len("ERR "):])
+	}
+	if line != "NAK" && !strings.HasPrefix(line, "ACK ") {
+		return errors.New("protocol error: expected ACK/NAK, got " + strconv.Quote(line))
+	}
+	// git may acknowledge more than the first common commit
+	for {
+		next, err := pkt_reader.reader.Peek(len("0000ACK "))
+		if err != nil || string(next[4:]) != "ACK " {
+			break
+		}
+		if _, err := pkt_reader.ReadLine(); err != nil {
+			return err
+		}
+	}
+	var pack io.Reader = pkt_reader.reader
+	if _, ok := capabilities["side-band-64k"]; ok {
+		pack = newSideBandReader(pkt_reader, os.Stderr)
+	}
+	if err = storePack(rootDir, pack); err != nil {
+		return err
+	}
+	return UpdateShallow(rootDir, shallow, unshallow)
+}
+
+// pushRequest tells a server in protocol v0 which refs to update, followed
+// by a pack of the objects it is missing.
+func pushRequest(rootDir string, remote_refs *RemoteRefs, capabilities map[string]string, updates []*refUpdate) ([]byte, error) {
+	used := make([]string, 0)
+	for _, capability := range []string{"report-status", "side-band-64k", "delete-refs", "ofs-delta"} {
+		if _, ok := capabilities[capability]; ok {
+			used = append(used, capability)
+		}
+	}
+	used = append(used, "agent="+userAgent)
+
+	zero_sha1 := strings.Repeat("0", 40)
+	request := new(bytes.Buffer)
+	tips := make([]string, 0, len(updates))
+	for i, update := range updates {
+		old_sha1, new_sha1 := update.OldSHA1, update.NewSHA1
+		if old_sha1 == "" {
+			old_sha1 = zero_sha1
+		}
+		if new_sha1 == "" {
+			new_sha1 = zero_sha1
+		} else {
+			tips = append(tips, new_sha1)
+		}
+		command := old_sha1 + " " + new_sha1 + " " + update.Dst
+		if i == 0 {
+			command += "\000" + strings.Join(used, " ")
+		}
+		request.WriteString(PktLine(command + "\n"))
+	}
+	request.WriteString(pktFlush)
+	if len(tips) == 0 {
+		return request.Bytes(), nil
+	}
+	remote_tips := make([]string, 0, len(remote_refs.Refs))
+	for _, ref := range remote_refs.Refs {
+		if ObjectExists(rootDir, ref.SHA1) {
+			remote_tips = append(remote_tips, ref.SHA1)
+		}
+	}
+	objects, err := objectsToSend(rootDir, tips, remote_tips, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := WritePack(request, objects); err != nil {
+		return nil, err
+	}
+	return request.Bytes(), nil
+}
+
+// readPushReport reads the report of a server on a pushRequest, and returns
+// why it refused updates, by ref name.
+func readPushReport(reader io.Reader, capabilities map[string]string) (map[string]string, error) {
+	refused := make(map[string]string)
+	if _, ok := capabilities["report-status"]; !ok {
+		return refused, nil
+	}
+	report := NewPktReader(reader)
+	if _, ok := capabilities["side-band-64k"]; ok {
+		report = NewPktReader(newSideBandReader(report, os.Stderr))
+	}
+	lines, err := report.ReadLines()
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "unpack ") && line != "unpack ok":
+			return nil, errors.New("remote unpack failed: " + line[len("unpack "):])
+		case strings.HasPrefix(line, "ng "):
+			fields := strings.SplitN(line[len("ng "):], " ", 2)
+			reason := "failed"
+			if len(fields) == 2 {
+				reason = fields[1]
+			}
+			refused[fields[0]] = reason
+		}
+	}
+	return refused, nil
+}
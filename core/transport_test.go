@@ -0,0 +1,69 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseAdvertisement(t *testing.T) {
+	head := strings.Repeat("1", 40)
+	tag := strings.Repeat("2", 40)
+	peeled := strings.Repeat("3", 40)
+	capabilities := make(map[string]string)
+	remote_refs, err := parseAdvertisement([]string{
+		head + " HEAD\000side-band-64k symref=HEAD:refs/heads/master agent=git/2.39",
+		head + " refs/heads/master",
+		tag + " refs/tags/v1.0",
+		peeled + " refs/tags/v1.0^{}",
+	}, capabilities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote_refs.Head != "master" || remote_refs.HeadSHA1 != head {
+		t.Errorf("HEAD is %q at %q", remote_refs.Head, remote_refs.HeadSHA1)
+	}
+	if want := []*Ref{{"refs/heads/master", head}, {"refs/tags/v1.0", tag}}; !reflect.DeepEqual(remote_refs.Refs, want) {
+		t.Errorf("the refs are %v", remote_refs.Refs)
+	}
+	if remote_refs.Peeled["refs/tags/v1.0"] != peeled {
+		t.Errorf("the peeled refs are %v", remote_refs.Peeled)
+	}
+	if _, ok := capabilities["side-band-64k"]; !ok || capabilities["agent"] != "git/2.39" {
+		t.Errorf("the capabilities are %q", capabilities)
+	}
+
+	remote_refs, err = parseAdvertisement([]string{strings.Repeat("0", 40) + " capabilities^{}\000ofs-delta"}, make(map[string]string))
+	if err != nil || len(remote_refs.Refs) != 0 {
+		t.Errorf("the empty repository shows %v, %v", remote_refs, err)
+	}
+	if _, err := parseAdvertisement([]string{"ERR access denied"}, make(map[string]string)); err == nil || err.Error() != "remote error: access denied" {
+		t.Errorf("the ERR line gave %v", err)
+	}
+}
+
+func TestFetchRequest(t *testing.T) {
+	rootDir := newTestObjectStore(t)
+	want := strings.Repeat("1", 40)
+	have := strings.Repeat("2", 40)
+	request := fetchRequest(rootDir, map[string]string{"ofs-delta": "", "include-tag": ""}, []string{want}, []string{have}, nil, nil)
+	lines, err := NewPktReader(strings.NewReader(request)).ReadLines()
+	if err != nil || !reflect.DeepEqual(lines, []string{"want " + want + " ofs-delta include-tag agent=" + userAgent}) {
+		t.Errorf("the wants are %q, %v", lines, err)
+	}
+	if !strings.HasSuffix(request, pktFlush+PktLine("have "+have+"\n")+PktLine("done\n")) {
+		t.Errorf("the request is %q", request)
+	}
+
+	// a shallow repository tells where its history ends
+	shallow := strings.Repeat("3", 40)
+	if err := UpdateShallow(rootDir, []string{shallow}, nil); err != nil {
+		t.Fatal(err)
+	}
+	request = fetchRequest(rootDir, map[string]string{}, []string{want}, nil, &Deepen{Depth: 2, Relative: true}, nil)
+	lines, err = NewPktReader(strings.NewReader(request)).ReadLines()
+	want_lines := []string{"want " + want + " deepen-relative agent=" + userAgent, "shallow " + shallow, "deepen 2"}
+	if err != nil || !reflect.DeepEqual(lines, want_lines) {
+		t.Errorf("the request of the shallow fetch is %q, %v, want %q", lines, err, want_lines)
+	}
+}
@@ -0,0 +1,90 @@
+package core
+
+import (
+	"encoding/hex"
+	"sort"
+)
+
+// TreeChange is a file which differs between two trees. The old side is
+// empty for an added file, and the new side for a deleted one.
+type TreeChange struct {
+	Path    string
+	OldMode string
+	OldSHA1 string
+	NewMode string
+	NewSHA1 string
+}
+
+func (regit *ReGit) readTreeEntries(tree_sha1 string) map[string]*TreeEntry {
+	entries := make(map[string]*TreeEntry)
+	if tree_sha1 == "" {
+		return entries
+	}
+	tree := NewTreeObject(regit.RootDir)
+	tree.ReadFromExistingObject(tree_sha1)
+	for _, entry := range tree.Entries {
+		entries[entry.FileName] = entry
+	}
+	return entries
+}
+
+// DiffTrees returns the files which differ between two trees, sorted by
+// path. An empty tree name stands for the empty tree, e.g. for the parent of
+// a root commit. Subtrees with the same name on both sides are skipped.
+func (regit *ReGit) DiffTrees(old_tree_sha1 string, new_tree_sha1 string) []*TreeChange {
+	changes := make([]*TreeChange, 0)
+	regit.diffTrees(old_tree_sha1, new_tree_sha1, "", &changes)
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+func (regit *ReGit) diffTrees(old_tree_sha1 string, new_tree_sha1 string, prefix string, changes *[]*TreeChange) {
+	if old_tree_sha1 == new_tree_sha1 {
+		return
+	}
+	old_entries := regit.readTreeEntries(old_tree_sha1)
+	new_entries := regit.readTreeEntries(new_tree_sha1)
+
+	names := make(map[string]bool)
+	for name := range old_entries {
+		names[name] = true
+	}
+	for name := range new_entries {
+		names[name] = true
+	}
+	for name := range names {
+		path := prefix + name
+		old_entry, new_entry := old_entries[name], new_entries[name]
+
+		// a file may become a directory and the other way round, in which
+		// case the directory's files are all added or deleted
+		old_subtree, new_subtree := "", ""
+		if old_entry != nil && old_entry.Type() == "tree" {
+			old_subtree = hex.EncodeToString(old_entry.HashedFilename)
+			old_entry = nil
+		}
+		if new_entry != nil && new_entry.Type() == "tree" {
+			new_subtree = hex.EncodeToString(new_entry.HashedFilename)
+			new_entry = nil
+		}
+		if old_subtree != "" || new_subtree != "" {
+			regit.diffTrees(old_subtree, new_subtree, path+"/", changes)
+		}
+
+		change := &TreeChange{Path: path}
+		if old_entry != nil {
+			change.OldMode = old_entry.FileType
+			change.OldSHA1 = hex.EncodeToString(old_entry.HashedFilename)
+		}
+		if new_entry != nil {
+			change.NewMode = new_entry.FileType
+			change.NewSHA1 = hex.EncodeToString(new_entry.HashedFilename)
+		}
+		if change.OldSHA1 == change.NewSHA1 && change.OldMode == change.NewMode {
+			continue
+		}
+		*changes = append(*changes, change)
+	}
+}
@@ -8,6 +8,7 @@ import (
 type TreeGraph struct {
 	graph   *Graph
 	objects map[string]*TreeObject
+	modes   map[string]string
 }
 
 func NewTreeGraph() *TreeGraph {
@@ -15,6 +16,7 @@ func NewTreeGraph() *TreeGraph {
 	tg.graph = NewGraph()
 	tg.graph.AddNode("tree", "/", nil) // root tree
 	tg.objects = make(map[string]*TreeObject)
+	tg.modes = make(map[string]string)
 	return tg
 }
 
@@ -48,9 +50,28 @@ func (tg *TreeGraph) AddEntry(path string, sha1Name []byte) {
 	}
 }
 
+// AddFileEntry adds a file with its mode, e.g. "100755" for an executable
+// or "120000" for a symbolic link. Files added by AddEntry are regular ones.
+func (tg *TreeGraph) AddFileEntry(path string, mode string, sha1Name []byte) {
+	tg.modes[path] = mode
+	tg.AddEntry(path, sha1Name)
+}
+
+// AddTreeEntry adds a directory whose tree object already exists, e.g. a
+// still valid subtree of the index cache tree. Its entries are not rebuilt.
+func (tg *TreeGraph) AddTreeEntry(path string, sha1Name []byte) {
+	if _, ok := tg.graph.LookUpNode(path); ok {
+		return
+	}
+	tg.AddEntry(path, sha1Name)
+	node, _ := tg.graph.LookUpNode(path)
+	node.typ = "tree"
+}
+
 func (tg *TreeGraph) ConstructTreeObjects(rootDir string) []byte {
 	tg.graph.DFS(func(node *GraphNode) {
-		if node.typ == "tree" {
+		// trees added by AddTreeEntry already have an object name
+		if node.typ == "tree" && node.sha1Name == nil {
 			tree := NewTreeObject(rootDir)
 			tg.objects[node.name] = tree
 		}
@@ -58,7 +79,10 @@ func (tg *TreeGraph) ConstructTreeObjects(rootDir string) []byte {
 		if node.typ != "tree" {
 			return
 		}
-		tree := tg.objects[node.name]
+		tree, ok := tg.objects[node.name]
+		if !ok {
+			return
+		}
 		list := node.list
 		for list != nil {
 			child_node, _ := tg.graph.LookUpNode(list.name)
@@ -66,7 +90,9 @@ func (tg *TreeGraph) ConstructTreeObjects(rootDir string) []byte {
 			path := strings.Split(child_node.name, "/")
 			entry := new(TreeEntry)
 			if child_node.typ == "tree" {
-				entry.FileType = "040000"
+				entry.FileType = "40000"
+			} else if mode, ok := tg.modes[child_node.name]; ok {
+				entry.FileType = mode
 			} else {
 				entry.FileType = "100644"
 			}
@@ -83,3 +109,56 @@ func (tg *TreeGraph) ConstructTreeObjects(rootDir string) []byte {
 	root_tree, _ := tg.graph.LookUpNode("/")
 	return root_tree.sha1Name
 }
+
+// CacheTree describes the trees built by ConstructTreeObjects in the form of
+// the index cache tree extension. Subtrees added by AddTreeEntry are copied
+// from the previous cache tree.
+func (tg *TreeGraph) CacheTree(previous *CacheTree) *CacheTree {
+	root_tree, _ := tg.graph.LookUpNode("/")
+	return tg.construct_cache_tree(root_tree, "", previous)
+}
+
+func (tg *TreeGraph) construct_cache_tree(node *GraphNode, name string, previous *CacheTree) *CacheTree {
+	cache_tree := NewCacheTree(name)
+	entry_count := 0
+	is_valid := true
+
+	list := node.list
+	for list != nil {
+		child_node, _ := tg.graph.LookUpNode(list.name)
+		list = list.next
+		if child_node.typ != "tree" {
+			entry_count++
+			continue
+		}
+
+		path := strings.Split(child_node.name, "/")
+		child_name := path[len(path)-1]
+		var previous_subtree *CacheTree
+		if previous != nil {
+			previous_subtree = previous.Subtree(child_name)
+		}
+
+		var subtree *CacheTree
+		if _, built := tg.objects[child_node.name]; built {
+			subtree = tg.construct_cache_tree(child_node, child_name, previous_subtree)
+		} else if previous_subtree != nil {
+			subtree = previous_subtree
+		} else {
+			// the entry count of a reused tree is unknown without its cache tree
+			subtree = NewCacheTree(child_name)
+		}
+		if subtree.IsValid() {
+			entry_count += subtree.EntryCount
+		} else {
+			is_valid = false
+		}
+		cache_tree.Subtrees = append(cache_tree.Subtrees, subtree)
+	}
+	if is_valid {
+		cache_tree.EntryCount = entry_count
+		cache_tree.HashedFilename = node.sha1Name
+	}
+	cache_tree.sortSubtrees()
+	return cache_tree
+}
@@ -0,0 +1,310 @@
+package core
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The v0 protocol starts with the refs of the repository, the first of them
+// followed by a NUL and the capabilities of the server. An empty repository
+// shows the capabilities on a "capabilities^{}" line instead.
+
+// advertiseRefs writes the refs of the repository at rootDir for fetch, or
+// for push with for_push, which leaves out HEAD and the peeled tags.
+func advertiseRefs(out io.Writer, rootDir string, capabilities []string, for_push bool) error {
+	lines := make([]string, 0)
+	if head_sha1, ok := ReadRef(rootDir, "HEAD"); ok && !for_push {
+		lines = append(lines, head_sha1+" HEAD")
+	}
+	for _, ref := range ListRefs(rootDir) {
+		lines = append(lines, ref.SHA1+" "+ref.Name)
+		if for_push || !strings.HasPrefix(ref.Name, "refs/tags/") {
+			continue
+		}
+		if peeled, err := PeelObject(rootDir, ref.SHA1, ""); err == nil && peeled != ref.SHA1 {
+			lines = append(lines, peeled+" "+ref.Name+"^{}")
+		}
+	}
+	if len(lines) == 0 {
+		lines = append(lines, strings.Repeat("0", 40)+" capabilities^{}")
+	}
+
+	advertisement := ""
+	for i, line := range lines {
+		if i == 0 {
+			line += "\000" + strings.Join(capabilities, " ")
+		}
+		advertisement += PktLine(line + "\n")
+	}
+	_, err := io.WriteString(out, advertisement+pktFlush)
+	return err
+}
+
+// ourRefs returns the objects the refs of the repository at rootDir point
+// to, as advertiseRefs shows them for fetch, peeled tags included.
+func ourRefs(rootDir string) map[string]bool {
+	our_refs := make(map[string]bool)
+	if head_sha1, ok := ReadRef(rootDir, "HEAD"); ok {
+		our_refs[head_sha1] = true
+	}
+	for _, ref := range ListRefs(rootDir) {
+		our_refs[ref.SHA1] = true
+		if peeled, err := PeelObject(rootDir, ref.SHA1, ""); err == nil {
+			our_refs[peeled] = true
+		}
+	}
+	return our_refs
+}
+
+// isReachableObject tells whether the refs of the repository at rootDir
+// reach an object, which a client may then ask for by name, as
+// allow-reachable-sha1-in-want says: a partial clone asks so for the blobs
+// it is missing. Like git's, upload-pack sends no other objects.
+func isReachableObject(rootDir string, sha1_name string, our_refs map[string]bool) bool {
+	if our_refs[sha1_name] {
+		return true
+	}
+	if !ObjectExists(rootDir, sha1_name) {
+		return false
+	}
+	roots := make([]string, 0, len(our_refs))
+	for ref_sha1 := range our_refs {
+		roots = append(roots, ref_sha1)
+	}
+	reachable, err := ReachableObjects(rootDir, roots)
+	if err != nil {
+		return false
+	}
+	_, ok := reachable[sha1_name]
+	return ok
+}
+
+// UploadPack serves a fetch from the repository at rootDir on in and out,
+// as git-upload-pack does: it shows the refs, reads which objects the client
+// wants and which commits it has, and sends a pack of what it is missing,
+// leaving out what the filter it may give omits. With advertise_refs it only
+// shows the refs. With stateless_rpc, as over HTTP, the client has read the
+// refs beforehand, and a request which does not end with "done" is answered
+// with ACK or NAK only.
+func UploadPack(rootDir string, in io.Reader, out io.Writer, stateless_rpc bool, advertise_refs bool) error {
+	if advertise_refs || !stateless_rpc {
+		capabilities := []string{"side-band-64k", "ofs-delta", "shallow", "deepen-since", "deepen-relative", "filter", "allow-reachable-sha1-in-want", "no-progress", "include-tag"}
+		if branch := headBranch(rootDir); branch != "" {
+			if _, ok := ReadRef(rootDir, "refs/heads/"+branch); ok {
+				capabilities = append(capabilities, "symref=HEAD:refs/heads/"+branch)
+			}
+		}
+		capabilities = append(capabilities, "agent="+userAgent)
+		if err := advertiseRefs(out, rootDir, capabilities, false); err != nil || advertise_refs {
+			return err
+		}
+	}
+
+	our_refs := ourRefs(rootDir)
+	pkt_reader := NewPktReader(in)
+	wants := make([]string, 0)
+	capabilities := make(map[string]bool)
+	client_shallow := make(map[string]bool)
+	var deepen *Deepen
+	var filter *ObjectFilter
+	for {
+		line, err := pkt_reader.ReadLine()
+		if err == errPktFlush {
+			break
+		}
+		if err == io.ErrUnexpectedEOF && len(wants) == 0 {
+			// the client only wanted to see the refs
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// the capabilities the client uses follow the first want
+		if len(wants) == 0 && len(line) > len("want ")+40 {
+			for _, capability := range strings.Fields(line[len("want ")+40:]) {
+				capabilities[capability] = true
+			}
+			line = line[:len("want ")+40]
+		}
+		if strings.HasPrefix(line, "shallow ") {
+			if sha1_name := line[len("shallow "):]; ObjectExists(rootDir, sha1_name) {
+				client_shallow[sha1_name] = true
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "deepen") {
+			if deepen == nil {
+				deepen = new(Deepen)
+			}
+			if err := parseDeepenLine(line, deepen); err != nil {
+				io.WriteString(out, PktLine("ERR "+err.Error()))
+				return err
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "filter ") {
+			if filter, err = ParseObjectFilter(line[len("filter "):]); err != nil {
+				io.WriteString(out, PktLine("ERR "+err.Error()))
+				return err
+			}
+			continue
+		}
+		if !strings.HasPrefix(line, "want ") {
+			return errors.New("protocol error: expected want, got " + strconv.Quote(line))
+		}
+		want := line[len("want "):]
+		if !isReachableObject(rootDir, want, our_refs) {
+			io.WriteString(out, PktLine("ERR upload-pack: not our ref "+want))
+			return errors.New("not our ref " + want)
+		}
+		wants = append(wants, want)
+	}
+	if len(wants) == 0 {
+		return nil
+	}
+
+	// a shallow fetch is told first where its history is cut
+	tips := wants
+	var boundary map[string]bool
+	if deepen != nil {
+		// which v0 asks for as a capability
+		deepen.Relative = deepen.Relative || capabilities["deepen-relative"]
+		roots := wants
+		if deepen.Relative {
+			roots = make([]string, 0, len(client_shallow))
+			for sha1_name := range client_shallow {
+				roots = append(roots, sha1_name)
+			}
+			deepen.Depth++
+		}
+		shallow, unshallow, not_sent, err := shallowCommits(rootDir, roots, deepen, client_shallow)
+		if err != nil {
+			return err
+		}
+		boundary = not_sent
+		shallow_info := ""
+		for _, sha1_name := range shallow {
+			shallow_info += PktLine("shallow " + sha1_name + "\n")
+		}
+		for _, sha1_name := range unshallow {
+			shallow_info += PktLine("unshallow " + sha1_name + "\n")
+			// the parents of the commit are sent, now that it has them
+			commit := NewCommitObject(rootDir)
+			if err := commit.Load(sha1_name); err != nil {
+				return err
+			}
+			tips = append(tips, commit.historyParents()...)
+		}
+		if _, err := io.WriteString(out, shallow_info+pktFlush); err != nil {
+			return err
+		}
+	}
+
+	// like git's without multi_ack: the first common commit is acknowledged
+	// as soon as it is seen, and NAK tells that none has been seen yet
+	common := make([]string, 0)
+	for {
+		line, err := pkt_reader.ReadLine()
+		if err == errPktFlush {
+			if len(common) == 0 {
+				io.WriteString(out, PktLine("NAK\n"))
+			}
+			if stateless_rpc {
+				return nil
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if line == "done" {
+			if len(common) == 0 {
+				io.WriteString(out, PktLine("NAK\n"))
+			}
+			break
+		}
+		if !strings.HasPrefix(line, "have ") {
+			return errors.New("protocol error: expected have, got " + strconv.Quote(line))
+		}
+		have := line[len("have "):]
+		if isValidSHA1Name(have) && ObjectExists(rootDir, have) {
+			common = append(common, have)
+			if len(common) == 1 {
+				io.WriteString(out, PktLine("ACK "+have+"\n"))
+			}
+		}
+	}
+
+	objects, err := objectsToSend(rootDir, tips, common, client_shallow, boundary)
+	if err != nil {
+		return err
+	}
+	if filter != nil {
+		objects = filterObjects(objects, filter, wants)
+	}
+	if capabilities["include-tag"] {
+		objects = includeTags(rootDir, objects)
+	}
+	writer := bufio.NewWriterSize(out, pktMaxData)
+	var pack io.Writer = writer
+	if capabilities["side-band-64k"] {
+		pack = newSideBandWriter(writer, 1)
+	}
+	if err := WritePack(pack, objects); err != nil {
+		return err
+	}
+	if capabilities["side-band-64k"] {
+		writer.WriteString(pktFlush)
+	}
+	return writer.Flush()
+}
+
+// includeTags adds the annotated tags which point to objects being sent.
+func includeTags(rootDir string, objects []*GitObject) []*GitObject {
+	sent := make(map[string]bool)
+	for _, obj := range objects {
+		sent[hex.EncodeToString(obj.Hash())] = true
+	}
+	for _, ref := range ListRefs(rootDir) {
+		if !strings.HasPrefix(ref.Name, "refs/tags/") || sent[ref.SHA1] {
+			continue
+		}
+		tag := NewTagObject(rootDir)
+		if tag.Load(ref.SHA1) != nil || !sent[tag.object] {
+			continue
+		}
+		objects = append(objects, &tag.Obj)
+		sent[ref.SHA1] = true
+	}
+	return objects
+}
+
+// parseDeepenLine reads a line of a request which asks for a shallow fetch:
+// "deepen <depth>", "deepen-since <timestamp>" or "deepen-relative".
+func parseDeepenLine(line string, deepen *Deepen) error {
+	fields := strings.Fields(line)
+	switch {
+	case fields[0] == "deepen-relative" && len(fields) == 1:
+		deepen.Relative = true
+	case fields[0] == "deepen" && len(fields) == 2:
+		depth, err := strconv.Atoi(fields[1])
+		if err != nil || depth <= 0 {
+			return errors.New("invalid deepen: " + fields[1])
+		}
+		deepen.Depth = depth
+	case fields[0] == "deepen-since" && len(fields) == 2:
+		timestamp, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return errors.New("invalid deepen-since: " + fields[1])
+		}
+		deepen.Since = time.Unix(timestamp, 0)
+	default:
+		return errors.New("upload-pack: " + fields[0] + " is not supported")
+	}
+	return nil
+}
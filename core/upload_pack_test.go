@@ -0,0 +1,61 @@
+package core
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestUploadPack(t *testing.T) {
+	isolateHome(t)
+	rootDir, first, second := newTestHistory(t)
+
+	// the client has the first commit and wants the second
+	request := PktLine("want "+second+" ofs-delta\n") + pktFlush + PktLine("have "+first+"\n") + PktLine("done\n")
+	out := new(bytes.Buffer)
+	if err := UploadPack(rootDir, strings.NewReader(request), out, true, false); err != nil {
+		t.Fatal(err)
+	}
+	pkt_reader := NewPktReader(out)
+	if line, err := pkt_reader.ReadLine(); err != nil || line != "ACK "+first {
+		t.Fatalf("the server answered %q, %v", line, err)
+	}
+	destination := newTestObjectStore(t)
+	count, err := UnpackObjects(destination, pkt_reader.reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the second commit, its trees and dir/b.txt, but not a.txt, which the
+	// first commit has
+	if count != 4 || !ObjectExists(destination, second) || ObjectExists(destination, first) {
+		t.Errorf("the pack of %d objects does not hold what the client misses", count)
+	}
+}
+
+// TestUploadPackNotOurRef checks that only the objects the refs reach can be
+// asked for, even if the repository has others.
+func TestUploadPackNotOurRef(t *testing.T) {
+	isolateHome(t)
+	rootDir, first, _ := newTestHistory(t)
+	dangling := writeTestObject(t, rootDir, "blob", "dangling\n")
+	for _, want := range []string{dangling, strings.Repeat("1", 40)} {
+		out := new(bytes.Buffer)
+		request := PktLine("want "+want+"\n") + pktFlush + PktLine("done\n")
+		if err := UploadPack(rootDir, strings.NewReader(request), out, true, false); err == nil {
+			t.Errorf("%s was sent", want)
+		}
+		if !strings.Contains(out.String(), "ERR upload-pack: not our ref "+want) {
+			t.Errorf("the server answered %q for %s", out, want)
+		}
+	}
+
+	// a commit of the history, or a blob of it, is reachable
+	a_blob := writeTestObject(t, rootDir, "blob", "a\n")
+	for _, want := range []string{first, a_blob} {
+		out := new(bytes.Buffer)
+		request := PktLine("want "+want+"\n") + pktFlush + PktLine("done\n")
+		if err := UploadPack(rootDir, strings.NewReader(request), out, true, false); err != nil {
+			t.Errorf("%s was not sent: %v", want, err)
+		}
+	}
+}
@@ -3,15 +3,330 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/WithGJR/regit-go/core"
 )
 
+// a flag which may be given several times, e.g. `-p <parent>`
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+// parseInterspersed parses flags that may appear before and after the
+// positional arguments, e.g. `commit-tree <tree> -p <parent>`.
+func parseInterspersed(flagSet *flag.FlagSet, args []string) []string {
+	positional := make([]string, 0)
+	for {
+		flagSet.Parse(args)
+		args = flagSet.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		if args[0] == "--" {
+			return append(positional, args[1:]...)
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// parseLogFlags parses the options shared by `log` and `show`, and returns
+// the revisions given along with them.
+func parseLogFlags(name string, args []string) ([]string, *core.LogOptions) {
+	logCmd := flag.NewFlagSet(name, flag.ExitOnError)
+	var oneLine, graph, topoOrder, dateOrder, all bool
+	var pretty, since, until, author, grep, date string
+	var maxCount int
+	logCmd.BoolVar(&oneLine, "oneline", false, "Print every commit on a single line")
+	logCmd.StringVar(&pretty, "pretty", "", "oneline, medium, or format:<format>")
+	logCmd.StringVar(&pretty, "format", "", "Same as --pretty=tformat:<format>")
+	logCmd.IntVar(&maxCount, "n", -1, "Limit the number of commits to show")
+	logCmd.IntVar(&maxCount, "max-count", -1, "Limit the number of commits to show")
+	logCmd.StringVar(&since, "since", "", "Show commits more recent than a date")
+	logCmd.StringVar(&since, "after", "", "Show commits more recent than a date")
+	logCmd.StringVar(&until, "until", "", "Show commits older than a date")
+	logCmd.StringVar(&until, "before", "", "Show commits older than a date")
+	logCmd.StringVar(&author, "author", "", "Show commits whose author matches a regular expression")
+	logCmd.StringVar(&grep, "grep", "", "Show commits whose message matches a regular expression")
+	logCmd.StringVar(&date, "date", "", "Show dates as relative, local, iso, iso-strict, rfc, short, raw, unix or format:<format>")
+	logCmd.BoolVar(&graph, "graph", false, "Draw the history next to the commits")
+	logCmd.BoolVar(&topoOrder, "topo-order", false, "Show no parents before all of their children, keeping lines of history together")
+	logCmd.BoolVar(&dateOrder, "date-order", false, "Show no parents before all of their children, otherwise by commit date")
+	logCmd.BoolVar(&all, "all", false, "Show the commits reachable from every ref and HEAD")
+
+	options := core.NewLogOptions()
+	for i, arg := range args {
+		if arg == "--" {
+			options.Paths = args[i+1:]
+			args = args[:i]
+			break
+		}
+	}
+	// like git, accept -n<number> and -<number> for -n <number>
+	countPattern := regexp.MustCompile(`^-n?([0-9]+)$`)
+	for i, arg := range args {
+		if match := countPattern.FindStringSubmatch(arg); match != nil {
+			args[i] = "-n=" + match[1]
+		}
+	}
+	revisions := parseInterspersed(logCmd, args)
+	if len(revisions) != 0 && all {
+		fmt.Println("Error: `log --all` does not accept a revision")
+		os.Exit(1)
+	}
+
+	options.OneLine = oneLine
+	options.MaxCount = maxCount
+	options.Graph = graph
+	options.All = all
+	if topoOrder {
+		options.Order = "topo"
+	} else if dateOrder {
+		options.Order = "date"
+	}
+	switch {
+	case pretty == "" || pretty == "medium":
+	case pretty == "oneline":
+		options.Format = "%C(yellow)%H%Creset %s"
+	case strings.HasPrefix(pretty, "format:"):
+		options.Format = pretty[len("format:"):]
+	case strings.HasPrefix(pretty, "tformat:"):
+		options.Format = pretty[len("tformat:"):]
+	default:
+		options.Format = pretty
+	}
+
+	err := core.ValidateDateMode(date)
+	options.DateMode = date
+	if err == nil && since != "" {
+		options.Since, err = core.ParseExpiryDate(since, time.Now())
+	}
+	if err == nil && until != "" {
+		options.Until, err = core.ParseExpiryDate(until, time.Now())
+	}
+	if err == nil && author != "" {
+		options.Author, err = regexp.Compile(author)
+	}
+	if err == nil && grep != "" {
+		options.Grep, err = regexp.Compile(grep)
+	}
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+	return revisions, options
+}
+
+// parseLogArgs parses `log [options] [<revision>] [-- <path>...]`.
+func parseLogArgs(args []string) (string, *core.LogOptions) {
+	revisions, options := parseLogFlags("log", args)
+	if len(revisions) > 1 {
+		fmt.Println("Error: `log` accepts only one revision")
+		os.Exit(1)
+	}
+	revision := ""
+	if len(revisions) == 1 {
+		revision = revisions[0]
+	}
+	return revision, options
+}
+
+// shallowDeepen turns the options of clone and fetch which make a shallow
+// history into how far to fetch it, which is nil if none is given.
+func shallowDeepen(depth int, deepen int, since string, unshallow bool) *core.Deepen {
+	given := 0
+	for _, ok := range []bool{depth != 0, deepen != 0, since != "", unshallow} {
+		if ok {
+			given++
+		}
+	}
+	if given > 1 {
+		fmt.Println("Error: --depth, --deepen, --shallow-since and --unshallow cannot be used together")
+		os.Exit(1)
+	}
+	switch {
+	case depth < 0 || deepen < 0:
+		fmt.Println("Error: depth " + strconv.Itoa(depth+deepen) + " is not a positive number")
+		os.Exit(1)
+	case depth != 0:
+		return &core.Deepen{Depth: depth}
+	case deepen != 0:
+		return &core.Deepen{Depth: deepen, Relative: true}
+	case since != "":
+		date, err := core.ParseExpiryDate(since, time.Now())
+		if err != nil {
+			fmt.Println("Error: " + err.Error())
+			os.Exit(1)
+		}
+		return &core.Deepen{Since: date}
+	case unshallow:
+		return &core.Deepen{Depth: core.InfiniteDepth}
+	}
+	return nil
+}
+
 func main() {
 	commitCmd := flag.NewFlagSet("commit", flag.ExitOnError)
-	var commitMessage string
-	commitCmd.StringVar(&commitMessage, "m", "", "A commmit message")
+	var commitMessages stringList
+	var commitMessageFile, commitAuthor string
+	var commitAmend, commitAllowEmpty bool
+	commitCmd.Var(&commitMessages, "m", "A commmit message; several are joined as paragraphs")
+	commitCmd.StringVar(&commitMessageFile, "F", "", "Read the commit message from a file, or from stdin if it is '-'")
+	commitCmd.BoolVar(&commitAmend, "amend", false, "Replace the tip of the current branch with a new commit")
+	commitCmd.BoolVar(&commitAllowEmpty, "allow-empty", false, "Allow a commit without any changes")
+	commitCmd.StringVar(&commitAuthor, "author", "", "Override the author, given as 'Name <email>'")
+	var commitNoVerify bool
+	commitCmd.BoolVar(&commitNoVerify, "no-verify", false, "Skip the pre-commit and commit-msg hooks")
+	commitCmd.BoolVar(&commitNoVerify, "n", false, "Same as --no-verify")
+
+	fsckCmd := flag.NewFlagSet("fsck", flag.ExitOnError)
+	var fsckUnreachable, fsckJSON bool
+	fsckCmd.BoolVar(&fsckUnreachable, "unreachable", false, "Show all unreachable objects, not only dangling ones")
+	fsckCmd.BoolVar(&fsckJSON, "json", false, "Print the report as JSON")
+
+	pruneCmd := flag.NewFlagSet("prune", flag.ExitOnError)
+	var pruneExpire string
+	var pruneDryRun, pruneVerbose bool
+	pruneCmd.StringVar(&pruneExpire, "expire", "", "Only prune unreachable objects older than the date")
+	pruneCmd.BoolVar(&pruneDryRun, "n", false, "Only list the objects that would be removed")
+	pruneCmd.BoolVar(&pruneDryRun, "dry-run", false, "Only list the objects that would be removed")
+	pruneCmd.BoolVar(&pruneVerbose, "v", false, "List the removed objects")
+
+	catFileCmd := flag.NewFlagSet("cat-file", flag.ExitOnError)
+	var catFileShowType, catFileShowSize, catFilePrettyPrint, catFileExists bool
+	var catFileBatch, catFileBatchCheck, catFileAllObjects, catFileBuffer bool
+	var catFileCacheSize int
+	catFileCmd.BoolVar(&catFileShowType, "t", false, "Show the type of the object")
+	catFileCmd.BoolVar(&catFileShowSize, "s", false, "Show the size of the object")
+	catFileCmd.BoolVar(&catFilePrettyPrint, "p", false, "Pretty-print the content of the object")
+	catFileCmd.BoolVar(&catFileExists, "e", false, "Exit with a zero status if the object exists")
+	catFileCmd.BoolVar(&catFileBatch, "batch", false, "Print the type, size and content of the objects named on standard input")
+	catFileCmd.BoolVar(&catFileBatchCheck, "batch-check", false, "Print the type and size of the objects named on standard input")
+	catFileCmd.BoolVar(&catFileAllObjects, "batch-all-objects", false, "Print every object in the repository instead of reading standard input")
+	catFileCmd.BoolVar(&catFileBuffer, "buffer", false, "Do not flush the output after every object")
+	catFileCmd.IntVar(&catFileCacheSize, "cache-size", core.BatchObjectCacheSize, "The size in bytes of the cache of decompressed objects")
+
+	hashObjectCmd := flag.NewFlagSet("hash-object", flag.ExitOnError)
+	var hashObjectType string
+	var hashObjectWrite, hashObjectStdin bool
+	hashObjectCmd.StringVar(&hashObjectType, "t", "blob", "The type of the object")
+	hashObjectCmd.BoolVar(&hashObjectWrite, "w", false, "Write the object into the object database")
+	hashObjectCmd.BoolVar(&hashObjectStdin, "stdin", false, "Read the object from standard input")
+
+	lsTreeCmd := flag.NewFlagSet("ls-tree", flag.ExitOnError)
+	var lsTreeRecursive, lsTreeShowTrees, lsTreeLong bool
+	lsTreeCmd.BoolVar(&lsTreeRecursive, "r", false, "Recurse into subtrees")
+	lsTreeCmd.BoolVar(&lsTreeShowTrees, "t", false, "Show tree entries even when recursing")
+	lsTreeCmd.BoolVar(&lsTreeLong, "l", false, "Show the size of blobs")
+	lsTreeCmd.BoolVar(&lsTreeLong, "long", false, "Show the size of blobs")
+
+	lsFilesCmd := flag.NewFlagSet("ls-files", flag.ExitOnError)
+	var lsFilesStage bool
+	lsFilesCmd.BoolVar(&lsFilesStage, "s", false, "Show the mode, object name and stage of every entry")
+	lsFilesCmd.BoolVar(&lsFilesStage, "stage", false, "Show the mode, object name and stage of every entry")
+
+	commitTreeCmd := flag.NewFlagSet("commit-tree", flag.ExitOnError)
+	var commitTreeParents stringList
+	var commitTreeMessage, commitTreeFile string
+	commitTreeCmd.Var(&commitTreeParents, "p", "A parent commit, may be given several times")
+	commitTreeCmd.StringVar(&commitTreeMessage, "m", "", "A commit message")
+	commitTreeCmd.StringVar(&commitTreeFile, "F", "", "Read the commit message from a file")
+
+	cherryPickCmd := flag.NewFlagSet("cherry-pick", flag.ExitOnError)
+	revertCmd := flag.NewFlagSet("revert", flag.ExitOnError)
+	var cherryPickRecordOrigin bool
+	var sequencerContinue, sequencerSkip, sequencerAbort bool
+	cherryPickCmd.BoolVar(&cherryPickRecordOrigin, "x", false, "Add a line saying which commit was cherry-picked to the message")
+	for _, sequencerCmd := range []*flag.FlagSet{cherryPickCmd, revertCmd} {
+		sequencerCmd.BoolVar(&sequencerContinue, "continue", false, "Carry on after resolving the conflicts of the stopped commit")
+		sequencerCmd.BoolVar(&sequencerSkip, "skip", false, "Skip the stopped commit and carry on with the others")
+		sequencerCmd.BoolVar(&sequencerAbort, "abort", false, "Give up and go back to the state before the first commit")
+	}
+
+	rebaseCmd := flag.NewFlagSet("rebase", flag.ExitOnError)
+	var rebaseOnto string
+	var rebaseInteractive, rebaseContinue, rebaseSkip, rebaseAbort bool
+	rebaseCmd.StringVar(&rebaseOnto, "onto", "", "Replay the commits on this commit instead of on the upstream")
+	rebaseCmd.BoolVar(&rebaseInteractive, "i", false, "Edit the list of commands before they are run")
+	rebaseCmd.BoolVar(&rebaseInteractive, "interactive", false, "Same as -i")
+	rebaseCmd.BoolVar(&rebaseContinue, "continue", false, "Carry on after resolving the conflicts of the stopped commit")
+	rebaseCmd.BoolVar(&rebaseSkip, "skip", false, "Skip the stopped commit and carry on with the others")
+	rebaseCmd.BoolVar(&rebaseAbort, "abort", false, "Give up and go back to the branch as it was before the rebase")
+
+	stashPushCmd := flag.NewFlagSet("stash push", flag.ExitOnError)
+	var stashMessage string
+	var stashUntracked bool
+	stashPushCmd.StringVar(&stashMessage, "m", "", "Describe the stash with a message")
+	stashPushCmd.StringVar(&stashMessage, "message", "", "Same as -m")
+	stashPushCmd.BoolVar(&stashUntracked, "u", false, "Stash the untracked files too")
+	stashPushCmd.BoolVar(&stashUntracked, "include-untracked", false, "Same as -u")
+	stashShowCmd := flag.NewFlagSet("stash show", flag.ExitOnError)
+	var stashShowPatch bool
+	stashShowCmd.BoolVar(&stashShowPatch, "p", false, "Show the changes as a patch")
+	stashShowCmd.BoolVar(&stashShowPatch, "patch", false, "Same as -p")
+
+	cloneCmd := flag.NewFlagSet("clone", flag.ExitOnError)
+	var cloneBare bool
+	var cloneBranch string
+	cloneCmd.BoolVar(&cloneBare, "bare", false, "Make a bare repository, without a working tree")
+	cloneCmd.StringVar(&cloneBranch, "b", "", "Check out this branch instead of the one HEAD of the repository points to")
+	cloneCmd.StringVar(&cloneBranch, "branch", "", "Same as -b")
+	var cloneDepth int
+	var cloneShallowSince string
+	cloneCmd.IntVar(&cloneDepth, "depth", 0, "Clone only this many commits of the history of the branch")
+	cloneCmd.StringVar(&cloneShallowSince, "shallow-since", "", "Clone only the commits of the branch made since a date")
+	var cloneFilter string
+	cloneCmd.StringVar(&cloneFilter, "filter", "", "Make a partial clone, leaving out the blobs of blob:none or blob:limit=<size>")
+
+	remoteCmd := flag.NewFlagSet("remote", flag.ExitOnError)
+	var remoteVerbose bool
+	remoteCmd.BoolVar(&remoteVerbose, "v", false, "Show the URLs of the remotes")
+	remoteCmd.BoolVar(&remoteVerbose, "verbose", false, "Same as -v")
+	fetchCmd := flag.NewFlagSet("fetch", flag.ExitOnError)
+	var fetchDepth, fetchDeepen int
+	var fetchShallowSince string
+	var fetchUnshallow bool
+	fetchCmd.IntVar(&fetchDepth, "depth", 0, "Fetch only this many commits of the history of each ref")
+	fetchCmd.IntVar(&fetchDeepen, "deepen", 0, "Fetch this many more commits of the history of a shallow repository")
+	fetchCmd.StringVar(&fetchShallowSince, "shallow-since", "", "Fetch only the commits made since a date")
+	fetchCmd.BoolVar(&fetchUnshallow, "unshallow", false, "Fetch the rest of the history of a shallow repository")
+	pushCmd := flag.NewFlagSet("push", flag.ExitOnError)
+	var pushForce bool
+	pushCmd.BoolVar(&pushForce, "f", false, "Update the refs of the remote even if it loses commits")
+	pushCmd.BoolVar(&pushForce, "force", false, "Same as -f")
+
+	uploadPackCmd := flag.NewFlagSet("upload-pack", flag.ExitOnError)
+	receivePackCmd := flag.NewFlagSet("receive-pack", flag.ExitOnError)
+	var packStatelessRPC, packAdvertiseRefs bool
+	for _, packCmd := range []*flag.FlagSet{uploadPackCmd, receivePackCmd} {
+		packCmd.BoolVar(&packStatelessRPC, "stateless-rpc", false, "Read a single request after the refs were shown by --advertise-refs")
+		packCmd.BoolVar(&packAdvertiseRefs, "advertise-refs", false, "Only show the refs")
+	}
+	serveCmd := flag.NewFlagSet("serve", flag.ExitOnError)
+	var serveHTTP string
+	serveCmd.StringVar(&serveHTTP, "http", "", "Serve with the smart HTTP protocol at this address, e.g. :8080")
+
+	daemonCmd := flag.NewFlagSet("daemon", flag.ExitOnError)
+	var daemonListen string
+	var daemonPort int
+	var daemonExportAll bool
+	daemonCmd.StringVar(&daemonListen, "listen", "", "Listen on this host name or address only")
+	daemonCmd.IntVar(&daemonPort, "port", 9418, "Listen on this port")
+	daemonCmd.BoolVar(&daemonExportAll, "export-all", false, "Serve repositories without a git-daemon-export-ok file too")
 
 	workingDir, err := os.Getwd()
 	if err != nil {
@@ -19,16 +334,42 @@ func main() {
 		os.Exit(1)
 	}
 
+	noPager := false
+	if len(os.Args) >= 2 && os.Args[1] == "--no-pager" {
+		noPager = true
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
 	if len(os.Args) < 2 {
 		fmt.Println("command too short")
 		os.Exit(1)
 	}
 
 	regit := core.NewReGit(workingDir)
+	regit.NoPager = noPager
 
 	switch os.Args[1] {
 	case "init":
 		regit.Init()
+	case "clone":
+		args := parseInterspersed(cloneCmd, os.Args[2:])
+		if len(args) == 0 || len(args) > 2 {
+			fmt.Println("usage: regit-go clone [--bare] [--branch <name>] [--depth <depth>] [--shallow-since <date>] [--filter <filter-spec>] <repository> [<directory>]")
+			os.Exit(1)
+		}
+		directory := ""
+		if len(args) == 2 {
+			directory = args[1]
+		}
+		var filter *core.ObjectFilter
+		if cloneFilter != "" {
+			var err error
+			if filter, err = core.ParseObjectFilter(cloneFilter); err != nil {
+				fmt.Println("Error: " + err.Error())
+				os.Exit(1)
+			}
+		}
+		regit.Clone(args[0], directory, cloneBare, cloneBranch, shallowDeepen(cloneDepth, 0, cloneShallowSince, false), filter)
 	case "add":
 		if len(os.Args) == 2 {
 			fmt.Println("Nothing specified, nothing added.")
@@ -37,17 +378,46 @@ func main() {
 		regit.Add(os.Args[2:])
 	case "commit":
 		commitCmd.Parse(os.Args[2:])
-		if commitMessage == "" {
-			fmt.Println("-m option is required.")
+		options := core.NewCommitOptions()
+		options.Amend = commitAmend
+		options.AllowEmpty = commitAllowEmpty
+		options.Author = commitAuthor
+		options.NoVerify = commitNoVerify
+		if len(commitMessages) != 0 && commitMessageFile != "" {
+			fmt.Println("Error: options '-m' and '-F' cannot be used together")
 			os.Exit(1)
 		}
-		regit.Commmit(commitMessage)
+		if len(commitMessages) != 0 {
+			options.Message = strings.Join(commitMessages, "\n\n")
+			options.HasMessage = true
+		}
+		if commitMessageFile != "" {
+			var content []byte
+			if commitMessageFile == "-" {
+				content, err = ioutil.ReadAll(os.Stdin)
+			} else {
+				content, err = ioutil.ReadFile(commitMessageFile)
+			}
+			if err != nil {
+				fmt.Println("Error: could not read log file '" + commitMessageFile + "'")
+				os.Exit(1)
+			}
+			options.Message = string(content)
+			options.HasMessage = true
+		}
+		regit.Commmit(options)
 	case "checkout":
 		if len(os.Args) == 2 {
 			fmt.Println("Error: you need to specify path names")
 			os.Exit(1)
 		}
 		regit.Checkout(os.Args[2:])
+	case "update-index":
+		if len(os.Args) < 4 || os.Args[2] != "--unresolve" {
+			fmt.Println("usage: regit-go update-index --unresolve [path names]")
+			os.Exit(1)
+		}
+		regit.Unresolve(os.Args[3:])
 	case "branch":
 		if len(os.Args) == 2 {
 			fmt.Println("Error: you need to specify the branch name")
@@ -59,17 +429,246 @@ func main() {
 		}
 		regit.CreateBranch(os.Args[2])
 	case "log":
-		if len(os.Args) > 3 {
-			fmt.Println("Error: `log` command does not accept arguments")
-			os.Exit(1)
-		}
-		regit.Log()
+		revision, options := parseLogArgs(os.Args[2:])
+		regit.Log(revision, options)
+	case "show":
+		revisions, options := parseLogFlags("show", os.Args[2:])
+		regit.Show(revisions, options)
 	case "merge":
 		if len(os.Args) > 3 {
 			fmt.Println("Error: you can only supply one branch name")
 			os.Exit(1)
 		}
 		regit.Merge(os.Args[2])
+	case "cherry-pick", "revert":
+		sequencerCmd := cherryPickCmd
+		if os.Args[1] == "revert" {
+			sequencerCmd = revertCmd
+		}
+		args := parseInterspersed(sequencerCmd, os.Args[2:])
+		switch {
+		case sequencerContinue:
+			regit.SequencerContinue()
+		case sequencerSkip:
+			regit.SequencerSkip()
+		case sequencerAbort:
+			regit.SequencerAbort()
+		case len(args) == 0:
+			fmt.Println("usage: regit-go " + os.Args[1] + " <commit>...")
+			fmt.Println("   or: regit-go " + os.Args[1] + " (--continue | --skip | --abort)")
+			os.Exit(1)
+		case os.Args[1] == "revert":
+			regit.Revert(args)
+		default:
+			regit.CherryPick(args, cherryPickRecordOrigin)
+		}
+	case "rebase":
+		args := parseInterspersed(rebaseCmd, os.Args[2:])
+		status := core.RebaseDone
+		switch {
+		case rebaseContinue:
+			status = regit.RebaseContinue()
+		case rebaseSkip:
+			status = regit.RebaseSkip()
+		case rebaseAbort:
+			regit.RebaseAbort()
+		case len(args) != 1:
+			fmt.Println("usage: regit-go rebase [-i] [--onto <newbase>] <upstream>")
+			fmt.Println("   or: regit-go rebase (--continue | --skip | --abort)")
+			os.Exit(1)
+		default:
+			status = regit.Rebase(args[0], rebaseOnto, rebaseInteractive)
+		}
+		// like git, stopping at an "edit" command is no failure
+		if status == core.RebaseStopped {
+			os.Exit(1)
+		}
+	case "stash":
+		// without a subcommand, or with only options, changes are pushed
+		subcommand, args := "push", os.Args[2:]
+		if len(args) != 0 && !strings.HasPrefix(args[0], "-") {
+			subcommand, args = args[0], args[1:]
+		}
+		stash := ""
+		switch subcommand {
+		case "push":
+			stashPushCmd.Parse(args)
+			regit.StashPush(stashMessage, stashUntracked)
+			return
+		case "list":
+			regit.StashList()
+			return
+		case "show":
+			stashShowCmd.Parse(args)
+			args = stashShowCmd.Args()
+		}
+		if len(args) > 1 {
+			fmt.Println("Error: too many arguments")
+			os.Exit(1)
+		}
+		if len(args) == 1 {
+			stash = args[0]
+		}
+		switch subcommand {
+		case "show":
+			regit.StashShow(stash, stashShowPatch)
+		case "apply":
+			if !regit.StashApply(stash) {
+				os.Exit(1)
+			}
+		case "pop":
+			regit.StashPop(stash)
+		case "drop":
+			regit.StashDrop(stash)
+		default:
+			fmt.Println("usage: regit-go stash [push [-m <message>] [-u]]")
+			fmt.Println("   or: regit-go stash list")
+			fmt.Println("   or: regit-go stash (show [-p] | apply | pop | drop) [<stash>]")
+			os.Exit(1)
+		}
+	case "remote":
+		args := parseInterspersed(remoteCmd, os.Args[2:])
+		switch {
+		case len(args) == 0 || len(args) == 1 && args[0] == "list":
+			regit.RemoteList(remoteVerbose)
+		case args[0] == "add" && len(args) == 3:
+			regit.RemoteAdd(args[1], args[2])
+		case (args[0] == "remove" || args[0] == "rm") && len(args) == 2:
+			regit.RemoteRemove(args[1])
+		default:
+			fmt.Println("usage: regit-go remote [list] [-v]")
+			fmt.Println("   or: regit-go remote add <name> <url>")
+			fmt.Println("   or: regit-go remote remove <name>")
+			os.Exit(1)
+		}
+	case "fetch", "push":
+		var args []string
+		if os.Args[1] == "push" {
+			args = parseInterspersed(pushCmd, os.Args[2:])
+		} else {
+			args = parseInterspersed(fetchCmd, os.Args[2:])
+		}
+		remote := regit.DefaultRemote()
+		if len(args) != 0 {
+			remote, args = args[0], args[1:]
+		}
+		if os.Args[1] == "push" {
+			regit.Push(remote, args, pushForce)
+		} else {
+			regit.Fetch(remote, args, shallowDeepen(fetchDepth, fetchDeepen, fetchShallowSince, fetchUnshallow))
+		}
+	case "upload-pack", "receive-pack":
+		packCmd := uploadPackCmd
+		if os.Args[1] == "receive-pack" {
+			packCmd = receivePackCmd
+		}
+		args := parseInterspersed(packCmd, os.Args[2:])
+		if len(args) != 1 {
+			fmt.Fprintln(os.Stderr, "usage: regit-go "+os.Args[1]+" [--stateless-rpc] [--advertise-refs] <directory>")
+			os.Exit(129)
+		}
+		regit.ServePack(os.Args[1], args[0], packStatelessRPC, packAdvertiseRefs)
+	case "serve":
+		args := parseInterspersed(serveCmd, os.Args[2:])
+		if len(args) != 1 || serveHTTP == "" {
+			fmt.Println("usage: regit-go serve --http <address> <directory>")
+			os.Exit(1)
+		}
+		regit.Serve(serveHTTP, args[0])
+	case "daemon":
+		args := parseInterspersed(daemonCmd, os.Args[2:])
+		if len(args) != 1 {
+			fmt.Println("usage: regit-go daemon [--listen <host>] [--port <port>] [--export-all] <directory>")
+			os.Exit(1)
+		}
+		regit.Daemon(net.JoinHostPort(daemonListen, strconv.Itoa(daemonPort)), args[0], daemonExportAll)
+	case "bundle":
+		subcommand, args := "", os.Args[2:]
+		if len(args) != 0 {
+			subcommand, args = args[0], args[1:]
+		}
+		// the arguments of create are those of rev-list, which are not parsed as flags
+		version := 2
+		if subcommand == "create" && len(args) != 0 && strings.HasPrefix(args[0], "--version=") {
+			version, _ = strconv.Atoi(strings.TrimPrefix(args[0], "--version="))
+			args = args[1:]
+		}
+		switch {
+		case subcommand == "create" && len(args) >= 2:
+			regit.BundleCreate(args[0], version, args[1:])
+		case subcommand == "verify" && len(args) == 1:
+			regit.BundleVerify(args[0])
+		case subcommand == "list-heads" && len(args) >= 1:
+			regit.BundleListHeads(args[0], args[1:])
+		case subcommand == "unbundle" && len(args) >= 1:
+			regit.BundleUnbundle(args[0], args[1:])
+		default:
+			fmt.Println("usage: regit-go bundle create [--version=<version>] <file> <rev-list-args>")
+			fmt.Println("   or: regit-go bundle verify <file>")
+			fmt.Println("   or: regit-go bundle list-heads <file> [<refname>...]")
+			fmt.Println("   or: regit-go bundle unbundle <file> [<refname>...]")
+			os.Exit(1)
+		}
+	case "fsck":
+		fsckCmd.Parse(os.Args[2:])
+		regit.Fsck(fsckUnreachable, fsckJSON)
+	case "prune":
+		pruneCmd.Parse(os.Args[2:])
+		regit.Prune(pruneExpire, pruneDryRun, pruneVerbose)
+	case "cat-file":
+		catFileCmd.Parse(os.Args[2:])
+		if catFileBatch || catFileBatchCheck {
+			regit.CatFileBatch(catFileBatchCheck, catFileAllObjects, catFileBuffer, catFileCacheSize)
+			break
+		}
+		options := make([]string, 0)
+		for option, given := range map[string]bool{"-t": catFileShowType, "-s": catFileShowSize, "-p": catFilePrettyPrint, "-e": catFileExists} {
+			if given {
+				options = append(options, option)
+			}
+		}
+		if len(options) != 1 || catFileCmd.NArg() != 1 {
+			fmt.Println("usage: regit-go cat-file (-t | -s | -p | -e) <object>")
+			fmt.Println("   or: regit-go cat-file (--batch | --batch-check) [--batch-all-objects] [--buffer]")
+			os.Exit(1)
+		}
+		regit.CatFile(options[0], catFileCmd.Arg(0))
+	case "hash-object":
+		path_names := parseInterspersed(hashObjectCmd, os.Args[2:])
+		regit.HashObject(hashObjectType, hashObjectWrite, hashObjectStdin, path_names)
+	case "ls-tree":
+		args := parseInterspersed(lsTreeCmd, os.Args[2:])
+		if len(args) == 0 {
+			fmt.Println("usage: regit-go ls-tree [-r] [-t] [-l] <tree-ish> [path names]")
+			os.Exit(1)
+		}
+		regit.LsTree(args[0], lsTreeRecursive, lsTreeShowTrees, lsTreeLong, args[1:])
+	case "ls-files":
+		lsFilesCmd.Parse(os.Args[2:])
+		regit.LsFiles(lsFilesStage)
+	case "write-tree":
+		regit.WriteTree()
+	case "commit-tree":
+		args := parseInterspersed(commitTreeCmd, os.Args[2:])
+		if len(args) != 1 {
+			fmt.Println("usage: regit-go commit-tree <tree> [-p <parent>]... [-m <message>] [-F <file>]")
+			os.Exit(1)
+		}
+		message := commitTreeMessage
+		if message == "" {
+			var content []byte
+			if commitTreeFile != "" {
+				content, err = ioutil.ReadFile(commitTreeFile)
+			} else {
+				content, err = ioutil.ReadAll(os.Stdin)
+			}
+			if err != nil {
+				fmt.Println("Error: " + err.Error())
+				os.Exit(1)
+			}
+			message = strings.TrimSuffix(string(content), "\n")
+		}
+		regit.CommitTree(args[0], commitTreeParents, message)
 	default:
 		fmt.Println("'" + os.Args[1] + "' is not a ReGit command.")
 	}